@@ -0,0 +1,454 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+/*
+Package agent implements a saltpack key agent: a long-lived process that
+holds secret keys in memory and uses them on behalf of clients that connect
+to it over a Unix socket, so that the keys never enter the short-lived
+processes that encrypt, decrypt and sign. It also implements the client
+side, a saltpack.Keyring and saltpack.SigKeyring whose secret keys forward
+each operation to the agent.
+*/
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+)
+
+// SocketEnvVar is the environment variable that holds the path of the
+// agent's socket, like SSH_AUTH_SOCK for ssh-agent.
+const SocketEnvVar = "SALTPACK_AGENT_SOCK"
+
+// ConfirmFunc asks whether the key with the given ID may be used for op,
+// for instance by asking the user. It may block while it waits for an
+// answer.
+type ConfirmFunc func(op Op, kid []byte) bool
+
+// KeyOptions are the options for a key held by the agent.
+type KeyOptions struct {
+	// Confirm, if set, is called before the key is first used on each
+	// client connection, and the request is refused with ErrDenied if
+	// it returns false. A tool usually makes one connection for each
+	// message it handles, so that's one confirmation per message, and not
+	// one for each of the several operations it takes.
+	Confirm ConfirmFunc
+}
+
+// Options are the options for New. The zero value never locks the agent.
+type Options struct {
+	// IdleTimeout, if nonzero, locks the agent once it's gone that long
+	// without using a secret key.
+	IdleTimeout time.Duration
+
+	// Reauthenticate, if set, is called when a client asks a locked
+	// agent to use a secret key, for instance to ask the user for a
+	// passphrase. The agent is unlocked if it returns true. If it's nil,
+	// a locked agent stays locked until Unlock is called.
+	Reauthenticate func() bool
+}
+
+type boxKeyEntry struct {
+	key  saltpack.BoxSecretKey
+	opts KeyOptions
+}
+
+type sigKeyEntry struct {
+	key  saltpack.SigningSecretKey
+	opts KeyOptions
+}
+
+// Agent holds secret keys, and serves requests to use them. It's safe
+// for concurrent use.
+type Agent struct {
+	opts Options
+	now  func() time.Time
+
+	// authMu is held while calling Reauthenticate, so that clients
+	// waiting on a locked agent only prompt once.
+	authMu sync.Mutex
+
+	mu      sync.Mutex
+	boxKeys map[string]boxKeyEntry
+	sigKeys map[string]sigKeyEntry
+	lastUse time.Time
+	locked  bool
+}
+
+// New makes an agent with no keys. opts may be nil.
+func New(opts *Options) *Agent {
+	a := &Agent{
+		now:     time.Now,
+		boxKeys: make(map[string]boxKeyEntry),
+		sigKeys: make(map[string]sigKeyEntry),
+	}
+	if opts != nil {
+		a.opts = *opts
+	}
+	a.lastUse = a.now()
+	return a
+}
+
+// AddBoxKey adds a box secret key to the agent. opts may be nil.
+func (a *Agent) AddBoxKey(k saltpack.BoxSecretKey, opts *KeyOptions) {
+	e := boxKeyEntry{key: k}
+	if opts != nil {
+		e.opts = *opts
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.boxKeys[string(k.GetPublicKey().ToKID())] = e
+}
+
+// AddSigningKey adds a signing secret key to the agent. opts may be nil.
+func (a *Agent) AddSigningKey(k saltpack.SigningSecretKey, opts *KeyOptions) {
+	e := sigKeyEntry{key: k}
+	if opts != nil {
+		e.opts = *opts
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sigKeys[string(k.GetPublicKey().ToKID())] = e
+}
+
+// AddKeyring adds copies of all the secret keys in kr to the agent, all
+// with the same options. The copies are wiped by Wipe.
+func (a *Agent) AddKeyring(kr *basic.Keyring, opts *KeyOptions) {
+	for _, k := range kr.GetAllBoxSecretKeys() {
+		switch sk := k.(type) {
+		case basic.SecretKey:
+			a.AddBoxKey(&sk, opts)
+		case basic.HybridSecretKey:
+			a.AddBoxKey(&sk, opts)
+		}
+	}
+	for _, k := range kr.GetAllSigningSecretKeys() {
+		if sk, ok := k.(basic.SigningSecretKey); ok {
+			a.AddSigningKey(&sk, opts)
+		}
+	}
+}
+
+// Wipe removes all the keys from the agent, and wipes the ones that
+// implement saltpack.Wiper.
+func (a *Agent) Wipe() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for kid, e := range a.boxKeys {
+		if w, ok := e.key.(saltpack.Wiper); ok {
+			w.Wipe()
+		}
+		delete(a.boxKeys, kid)
+	}
+	for kid, e := range a.sigKeys {
+		if w, ok := e.key.(saltpack.Wiper); ok {
+			w.Wipe()
+		}
+		delete(a.sigKeys, kid)
+	}
+}
+
+// Lock locks the agent, so that it refuses to use its secret keys until
+// it's unlocked. Lookups still work.
+func (a *Agent) Lock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.locked = true
+}
+
+// Unlock unlocks the agent, and restarts its idle timer.
+func (a *Agent) Unlock() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.locked = false
+	a.lastUse = a.now()
+}
+
+// Locked returns whether the agent is locked, either by Lock or because
+// it's been idle for too long.
+func (a *Agent) Locked() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lockedLocked()
+}
+
+func (a *Agent) lockedLocked() bool {
+	if !a.locked && a.opts.IdleTimeout > 0 && a.now().Sub(a.lastUse) >= a.opts.IdleTimeout {
+		a.locked = true
+	}
+	return a.locked
+}
+
+// use checks that the agent isn't locked, or gets it unlocked, before a
+// secret key is used, and restarts the idle timer.
+func (a *Agent) use() error {
+	a.mu.Lock()
+	locked := a.lockedLocked()
+	if !locked {
+		a.lastUse = a.now()
+	}
+	a.mu.Unlock()
+	if !locked {
+		return nil
+	}
+	if a.opts.Reauthenticate == nil {
+		return ErrLocked
+	}
+
+	a.authMu.Lock()
+	defer a.authMu.Unlock()
+	// Someone else may have reauthenticated while we waited.
+	if !a.Locked() {
+		return nil
+	}
+	if !a.opts.Reauthenticate() {
+		return ErrLocked
+	}
+	a.Unlock()
+	return nil
+}
+
+// conn is the per-connection state: the keys that the client has been
+// allowed to use.
+type conn struct {
+	confirmed map[string]bool
+}
+
+func (a *Agent) confirm(c *conn, op Op, kid []byte, opts KeyOptions) error {
+	if opts.Confirm == nil || c.confirmed[string(kid)] {
+		return nil
+	}
+	if !opts.Confirm(op, kid) {
+		return ErrDenied
+	}
+	c.confirmed[string(kid)] = true
+	return nil
+}
+
+func (a *Agent) boxKey(kids [][]byte) (boxKeyEntry, error) {
+	if len(kids) != 1 {
+		return boxKeyEntry{}, ErrBadRequest
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.boxKeys[string(kids[0])]
+	if !ok {
+		return boxKeyEntry{}, ErrKeyNotFound
+	}
+	return e, nil
+}
+
+func (a *Agent) sigKey(kids [][]byte) (sigKeyEntry, error) {
+	if len(kids) != 1 {
+		return sigKeyEntry{}, ErrBadRequest
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	e, ok := a.sigKeys[string(kids[0])]
+	if !ok {
+		return sigKeyEntry{}, ErrKeyNotFound
+	}
+	return e, nil
+}
+
+// encapsulationKeysLocked returns the ML-KEM-768 encapsulation keys of
+// the box keys with the given IDs, for response.EncapsulationKeys, or nil
+// if none of them are hybrid keys.
+func (a *Agent) encapsulationKeysLocked(kids [][]byte) [][]byte {
+	var ret [][]byte
+	for i, kid := range kids {
+		pub, ok := a.boxKeys[string(kid)].key.GetPublicKey().(saltpack.HybridBoxPublicKey)
+		if !ok {
+			continue
+		}
+		if ret == nil {
+			ret = make([][]byte, len(kids))
+		}
+		ret[i] = pub.MLKEMEncapsulationKey()
+	}
+	return ret
+}
+
+func sortedKIDs[V any](m map[string]V) [][]byte {
+	kids := make([][]byte, 0, len(m))
+	for kid := range m {
+		kids = append(kids, []byte(kid))
+	}
+	sort.Slice(kids, func(i, j int) bool { return bytes.Compare(kids[i], kids[j]) < 0 })
+	return kids
+}
+
+func (a *Agent) handle(c *conn, req request) (response, error) {
+	if req.Op.usesSecretKey() {
+		if err := a.use(); err != nil {
+			return response{}, err
+		}
+	}
+
+	switch req.Op {
+	case OpLookupBoxSecretKey:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for i, kid := range req.KIDs {
+			if _, ok := a.boxKeys[string(kid)]; ok {
+				kids := [][]byte{kid}
+				return response{Index: i, KIDs: kids, EncapsulationKeys: a.encapsulationKeysLocked(kids)}, nil
+			}
+		}
+		return response{Index: -1}, nil
+
+	case OpGetAllBoxSecretKeys:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		kids := sortedKIDs(a.boxKeys)
+		return response{KIDs: kids, EncapsulationKeys: a.encapsulationKeysLocked(kids)}, nil
+
+	case OpLookupSigningPublicKey:
+		if len(req.KIDs) != 1 {
+			return response{}, ErrBadRequest
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if _, ok := a.sigKeys[string(req.KIDs[0])]; ok {
+			return response{KIDs: req.KIDs}, nil
+		}
+		return response{}, nil
+
+	case OpGetAllSigningKeys:
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		return response{KIDs: sortedKIDs(a.sigKeys)}, nil
+
+	case OpBox, OpUnbox:
+		e, err := a.boxKey(req.KIDs)
+		if err != nil {
+			return response{}, err
+		}
+		if len(req.Peer) != len(saltpack.RawBoxKey{}) || len(req.Nonce) != len(saltpack.Nonce{}) {
+			return response{}, ErrBadRequest
+		}
+		if err = a.confirm(c, req.Op, req.KIDs[0], e.opts); err != nil {
+			return response{}, err
+		}
+		var peer basic.PublicKey
+		copy(peer.RawBoxKey[:], req.Peer)
+		nonce := saltpack.Nonce(req.Nonce)
+		if req.Op == OpBox {
+			return response{Data: e.key.Box(peer, nonce, req.Msg)}, nil
+		}
+		out, err := e.key.Unbox(peer, nonce, req.Msg)
+		if err != nil {
+			return response{}, err
+		}
+		return response{Data: out}, nil
+
+	case OpDecapsulateMLKEM:
+		e, err := a.boxKey(req.KIDs)
+		if err != nil {
+			return response{}, err
+		}
+		hybrid, ok := e.key.(saltpack.HybridBoxSecretKey)
+		if !ok {
+			return response{}, saltpack.ErrNotHybridKey
+		}
+		if err = a.confirm(c, req.Op, req.KIDs[0], e.opts); err != nil {
+			return response{}, err
+		}
+		shared, err := hybrid.DecapsulateMLKEM(req.Msg)
+		if err != nil {
+			return response{}, err
+		}
+		return response{Data: shared}, nil
+
+	case OpSign:
+		e, err := a.sigKey(req.KIDs)
+		if err != nil {
+			return response{}, err
+		}
+		if err = a.confirm(c, req.Op, req.KIDs[0], e.opts); err != nil {
+			return response{}, err
+		}
+		sig, err := e.key.Sign(req.Msg)
+		if err != nil {
+			return response{}, err
+		}
+		return response{Data: sig}, nil
+
+	default:
+		return response{}, ErrBadRequest
+	}
+}
+
+// ServeConn serves requests from a single client until the connection is
+// closed.
+func (a *Agent) ServeConn(nc net.Conn) {
+	defer nc.Close()
+	c := &conn{confirmed: make(map[string]bool)}
+	dec := codec.NewDecoder(nc, codecHandle())
+	enc := codec.NewEncoder(nc, codecHandle())
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		resp, err := a.handle(c, req)
+		if err != nil {
+			resp = errorResponse(err)
+		}
+		err = enc.Encode(resp)
+		clear(resp.Data)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Serve accepts connections on l, and serves each one in its own
+// goroutine. It returns when l is closed.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go a.ServeConn(nc)
+	}
+}
+
+// Listen listens on a Unix socket at path, which only its owner can
+// connect to, creating its directory if needed. A stale socket left at
+// path by an agent that's no longer running is replaced.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if nc, err := net.Dial("unix", path); err == nil {
+		nc.Close()
+		return nil, errors.New("a saltpack agent is already listening on " + path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,270 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package agent
+
+import (
+	"bytes"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+)
+
+func serveAgent(t *testing.T, a *Agent) *Client {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := Listen(sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() { _ = a.Serve(l) }()
+
+	t.Setenv(SocketEnvVar, sock)
+	c, err := Dial("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func newTestAgent(t *testing.T, opts *Options, keyOpts *KeyOptions) (*Agent, *basic.SecretKey, *basic.SigningSecretKey) {
+	kr := basic.NewKeyring()
+	boxKey, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigKey, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(opts)
+	a.AddKeyring(kr, keyOpts)
+	return a, boxKey, sigKey
+}
+
+func TestAgentBox(t *testing.T) {
+	a, boxKey, _ := newTestAgent(t, nil, nil)
+	c := serveAgent(t, a)
+	msg := []byte("hello from the agent")
+
+	keys := c.GetAllBoxSecretKeys()
+	if len(keys) != 1 || !saltpack.PublicKeyEqual(keys[0].GetPublicKey(), boxKey.GetPublicKey()) {
+		t.Fatalf("bad keys %v", keys)
+	}
+	sender := keys[0].(*BoxKey)
+
+	for _, version := range saltpack.KnownVersions() {
+		vv := saltpack.SingleVersionValidator(version)
+		ciphertext, err := saltpack.Seal(version, msg, sender, []saltpack.BoxPublicKey{boxKey.GetPublicKey()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = sender.Err(); err != nil {
+			t.Fatal(err)
+		}
+		mki, plaintext, err := saltpack.Open(vv, ciphertext, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, msg) || !saltpack.PublicKeyEqual(mki.SenderKey, boxKey.GetPublicKey()) {
+			t.Fatal("bad decryption")
+		}
+	}
+
+	idx, key := c.LookupBoxSecretKey([][]byte{{1, 2, 3}, boxKey.GetPublicKey().ToKID()})
+	if idx != 1 || key == nil {
+		t.Fatalf("bad lookup %d %v", idx, key)
+	}
+	if idx, _ = c.LookupBoxSecretKey([][]byte{{1, 2, 3}}); idx != -1 {
+		t.Fatalf("found missing key at %d", idx)
+	}
+}
+
+func TestAgentHybridBoxKey(t *testing.T) {
+	kr := basic.NewKeyring()
+	hybridKey, err := kr.GenerateHybridBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(nil)
+	a.AddKeyring(kr, nil)
+	c := serveAgent(t, a)
+	msg := []byte("hello from the hybrid agent")
+
+	keys := c.GetAllBoxSecretKeys()
+	if len(keys) != 1 {
+		t.Fatalf("bad keys %v", keys)
+	}
+	if _, ok := keys[0].(*HybridBoxKey); !ok {
+		t.Fatalf("wanted a *HybridBoxKey, got %T", keys[0])
+	}
+
+	for _, version := range []saltpack.Version{saltpack.Version2(), saltpack.Version3()} {
+		ciphertext, err := saltpack.Seal(version, msg, nil, []saltpack.BoxPublicKey{hybridKey.GetPublicKey()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, plaintext, err := saltpack.Open(saltpack.CheckKnownOrExperimentalMajorVersion, ciphertext, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, msg) {
+			t.Fatalf("%s: bad decryption", version)
+		}
+	}
+
+	// Plain box keys can't decapsulate.
+	boxKey, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AddBoxKey(boxKey, nil)
+	_, err = c.call(request{Op: OpDecapsulateMLKEM, KIDs: [][]byte{boxKey.GetPublicKey().ToKID()}})
+	if err != saltpack.ErrNotHybridKey {
+		t.Fatalf("wanted ErrNotHybridKey, got %v", err)
+	}
+}
+
+func TestAgentSign(t *testing.T) {
+	a, _, sigKey := newTestAgent(t, nil, nil)
+	c := serveAgent(t, a)
+	msg := []byte("signed by the agent")
+
+	signer, err := c.SigningKey(sigKey.GetPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := saltpack.Sign(saltpack.Version2(), msg, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vv := saltpack.SingleVersionValidator(saltpack.Version2())
+	signerPub, verified, err := saltpack.Verify(vv, sig, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(verified, msg) || !saltpack.PublicKeyEqual(signerPub, sigKey.GetPublicKey()) {
+		t.Fatal("bad verification")
+	}
+
+	other := basic.NewKeyring()
+	otherKey, err := other.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.SigningKey(otherKey.GetPublicKey()); err != ErrKeyNotFound {
+		t.Fatalf("wanted ErrKeyNotFound, got %v", err)
+	}
+	keys, err := c.SigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !saltpack.PublicKeyEqual(keys[0].GetPublicKey(), sigKey.GetPublicKey()) {
+		t.Fatalf("bad keys %v", keys)
+	}
+}
+
+func TestAgentConfirm(t *testing.T) {
+	var mu sync.Mutex
+	allow, asked := false, 0
+	confirm := func(op Op, kid []byte) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		asked++
+		return allow
+	}
+	a, _, sigKey := newTestAgent(t, nil, &KeyOptions{Confirm: confirm})
+	c := serveAgent(t, a)
+	signer, err := c.SigningKey(sigKey.GetPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = signer.Sign([]byte("no")); err != ErrDenied {
+		t.Fatalf("wanted ErrDenied, got %v", err)
+	}
+
+	mu.Lock()
+	allow = true
+	mu.Unlock()
+	for range 3 {
+		if _, err = signer.Sign([]byte("yes")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Once allowed, a key isn't confirmed again on the same connection.
+	mu.Lock()
+	defer mu.Unlock()
+	if asked != 2 {
+		t.Fatalf("asked %d times", asked)
+	}
+}
+
+func TestAgentIdleLock(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	reauth := false
+	a, _, sigKey := newTestAgent(t, &Options{
+		IdleTimeout: time.Minute,
+		Reauthenticate: func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return reauth
+		},
+	}, nil)
+	a.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(d)
+	}
+	c := serveAgent(t, a)
+	signer, err := c.SigningKey(sigKey.GetPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	advance(30 * time.Second)
+	if _, err = signer.Sign([]byte("awake")); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Minute)
+	if !a.Locked() {
+		t.Fatal("agent not locked after being idle")
+	}
+	if _, err = signer.Sign([]byte("asleep")); err != ErrLocked {
+		t.Fatalf("wanted ErrLocked, got %v", err)
+	}
+	// Lookups still work while locked.
+	if c.LookupSigningPublicKey(sigKey.GetPublicKey().ToKID()) == nil {
+		t.Fatal("lookup failed while locked")
+	}
+
+	mu.Lock()
+	reauth = true
+	mu.Unlock()
+	if _, err = signer.Sign([]byte("unlocked")); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Lock()
+	mu.Lock()
+	reauth = false
+	mu.Unlock()
+	if _, err = signer.Sign([]byte("locked")); err != ErrLocked {
+		t.Fatalf("wanted ErrLocked, got %v", err)
+	}
+	a.Unlock()
+	if _, err = signer.Sign([]byte("unlocked")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package agent
+
+import (
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// ErrNoAgent is returned by Dial when no socket path is given and
+// SALTPACK_AGENT_SOCK isn't set.
+var ErrNoAgent = errors.New(SocketEnvVar + " not set")
+
+// Client is a connection to an agent. It's a saltpack.Keyring and
+// saltpack.SigKeyring whose secret keys are BoxKeys, HybridBoxKeys and
+// SigningKeys, which ask the agent to use the keys it holds. Public key
+// lookups and ephemeral keys are handled locally. It's safe for concurrent
+// use, but requests are sent one at a time.
+//
+// Lookups can't return errors, so they return nothing if the agent can't
+// be reached.
+type Client struct {
+	basic.EphemeralKeyCreator
+
+	mu  sync.Mutex
+	nc  net.Conn
+	enc *codec.Encoder
+	dec *codec.Decoder
+}
+
+var (
+	_ saltpack.Keyring    = (*Client)(nil)
+	_ saltpack.SigKeyring = (*Client)(nil)
+)
+
+// Dial connects to the agent listening on the Unix socket at path, or
+// on SALTPACK_AGENT_SOCK if path is empty.
+func Dial(path string) (*Client, error) {
+	if path == "" {
+		path = os.Getenv(SocketEnvVar)
+		if path == "" {
+			return nil, ErrNoAgent
+		}
+	}
+	nc, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(nc), nil
+}
+
+// NewClient makes a client that talks to an agent over nc.
+func NewClient(nc net.Conn) *Client {
+	return &Client{
+		nc:  nc,
+		enc: codec.NewEncoder(nc, codecHandle()),
+		dec: codec.NewDecoder(nc, codecHandle()),
+	}
+}
+
+// Close closes the connection to the agent.
+func (c *Client) Close() error {
+	return c.nc.Close()
+}
+
+func (c *Client) call(req request) (response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(req); err != nil {
+		return response{}, err
+	}
+	var resp response
+	if err := c.dec.Decode(&resp); err != nil {
+		return response{}, err
+	}
+	if err := resp.err(); err != nil {
+		return response{}, err
+	}
+	return resp, nil
+}
+
+func kidToPublicKey(kid []byte) (basic.PublicKey, bool) {
+	var pub basic.PublicKey
+	if len(kid) != len(pub.RawBoxKey) {
+		return pub, false
+	}
+	copy(pub.RawBoxKey[:], kid)
+	return pub, true
+}
+
+func kidToSigningPublicKey(kid []byte) (basic.SigningPublicKey, bool) {
+	var pub basic.SigningPublicKey
+	if len(kid) != len(pub) {
+		return pub, false
+	}
+	copy(pub[:], kid)
+	return pub, true
+}
+
+// boxKeys returns the keys with the given IDs, which are HybridBoxKeys if
+// the agent gave an encapsulation key for them.
+func (c *Client) boxKeys(kids, encapsulationKeys [][]byte) []saltpack.BoxSecretKey {
+	var out []saltpack.BoxSecretKey
+	for i, kid := range kids {
+		pub, ok := kidToPublicKey(kid)
+		if !ok {
+			continue
+		}
+		k := &BoxKey{c: c, pub: pub}
+		if i < len(encapsulationKeys) && len(encapsulationKeys[i]) > 0 {
+			out = append(out, &HybridBoxKey{BoxKey: k, ek: encapsulationKeys[i]})
+		} else {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// LookupBoxSecretKey asks the agent for one of the box keys with the
+// given IDs. It returns the index and the key, if found, and -1 and nil
+// otherwise.
+func (c *Client) LookupBoxSecretKey(kids [][]byte) (int, saltpack.BoxSecretKey) {
+	resp, err := c.call(request{Op: OpLookupBoxSecretKey, KIDs: kids})
+	if err != nil || resp.Index < 0 || resp.Index >= len(kids) || len(resp.KIDs) != 1 {
+		return -1, nil
+	}
+	keys := c.boxKeys(resp.KIDs, resp.EncapsulationKeys)
+	if len(keys) != 1 {
+		return -1, nil
+	}
+	return resp.Index, keys[0]
+}
+
+// LookupBoxPublicKey returns the public key that corresponds to the given
+// key ID.
+func (c *Client) LookupBoxPublicKey(kid []byte) saltpack.BoxPublicKey {
+	if pub, ok := kidToPublicKey(kid); ok {
+		return pub
+	}
+	return nil
+}
+
+// GetAllBoxSecretKeys returns all the box keys held by the agent.
+func (c *Client) GetAllBoxSecretKeys() []saltpack.BoxSecretKey {
+	resp, err := c.call(request{Op: OpGetAllBoxSecretKeys})
+	if err != nil {
+		return nil
+	}
+	return c.boxKeys(resp.KIDs, resp.EncapsulationKeys)
+}
+
+// ImportBoxEphemeralKey takes a key ID and returns a public key useful
+// for encryption/decryption.
+func (c *Client) ImportBoxEphemeralKey(kid []byte) saltpack.BoxPublicKey {
+	return c.LookupBoxPublicKey(kid)
+}
+
+// LookupSigningPublicKey returns the public key with the given ID if the
+// agent holds its secret key, and nil otherwise. Chain the client with
+// another SigKeyring, using saltpack.ChainSigKeyrings, to verify messages
+// from others too.
+func (c *Client) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
+	resp, err := c.call(request{Op: OpLookupSigningPublicKey, KIDs: [][]byte{kid}})
+	if err != nil || len(resp.KIDs) != 1 {
+		return nil
+	}
+	if pub, ok := kidToSigningPublicKey(resp.KIDs[0]); ok {
+		return pub
+	}
+	return nil
+}
+
+// SigningKey returns the signing key with the given public key, if the
+// agent holds it, or ErrKeyNotFound otherwise.
+func (c *Client) SigningKey(pub saltpack.SigningPublicKey) (*SigningKey, error) {
+	if c.LookupSigningPublicKey(pub.ToKID()) == nil {
+		return nil, ErrKeyNotFound
+	}
+	spub, _ := kidToSigningPublicKey(pub.ToKID())
+	return &SigningKey{c: c, pub: spub}, nil
+}
+
+// SigningKeys returns all the signing keys held by the agent.
+func (c *Client) SigningKeys() ([]*SigningKey, error) {
+	resp, err := c.call(request{Op: OpGetAllSigningKeys})
+	if err != nil {
+		return nil, err
+	}
+	var out []*SigningKey
+	for _, kid := range resp.KIDs {
+		if pub, ok := kidToSigningPublicKey(kid); ok {
+			out = append(out, &SigningKey{c: c, pub: pub})
+		}
+	}
+	return out, nil
+}
+
+// BoxKey is a saltpack.BoxSecretKey for a box key held by the agent.
+//
+// Box and Precompute can't return errors, so if the agent refuses, or
+// can't be reached, Box returns random bytes, which will fail to open, and
+// the error is kept for Err to return. Check Err after encrypting with a
+// BoxKey as the sender. Decryption needs no such check, since it fails by
+// itself.
+type BoxKey struct {
+	c   *Client
+	pub basic.PublicKey
+
+	mu  sync.Mutex
+	err error
+}
+
+var _ saltpack.BoxSecretKey = (*BoxKey)(nil)
+
+// Err returns the first error from a Box call, if any.
+func (k *BoxKey) Err() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+func (k *BoxKey) setErr(err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.err == nil {
+		k.err = err
+	}
+}
+
+// Box asks the agent to box msg from k to receiver.
+func (k *BoxKey) Box(receiver saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) []byte {
+	resp, err := k.c.call(request{
+		Op: OpBox, KIDs: [][]byte{k.pub.ToKID()},
+		Peer: receiver.ToRawBoxKeyPointer()[:], Nonce: nonce[:], Msg: msg,
+	})
+	if err != nil {
+		k.setErr(err)
+		// Random bytes, and not zeros, since the caller may use them as
+		// a key.
+		ret := make([]byte, len(msg)+box.Overhead)
+		if _, err := rand.Read(ret); err != nil {
+			panic(err)
+		}
+		return ret
+	}
+	return resp.Data
+}
+
+// Unbox asks the agent to open a box from sender to k.
+func (k *BoxKey) Unbox(sender saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) ([]byte, error) {
+	resp, err := k.c.call(request{
+		Op: OpUnbox, KIDs: [][]byte{k.pub.ToKID()},
+		Peer: sender.ToRawBoxKeyPointer()[:], Nonce: nonce[:], Msg: msg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// GetPublicKey returns the public key of k.
+func (k *BoxKey) GetPublicKey() saltpack.BoxPublicKey {
+	return k.pub
+}
+
+// Precompute returns a shared key that boxes and unboxes through the
+// agent, like Box and Unbox with peer, so the shared key itself never
+// leaves the agent.
+func (k *BoxKey) Precompute(peer saltpack.BoxPublicKey) saltpack.BoxPrecomputedSharedKey {
+	return sharedKey{k: k, peer: peer}
+}
+
+type sharedKey struct {
+	k    *BoxKey
+	peer saltpack.BoxPublicKey
+}
+
+func (s sharedKey) Box(nonce saltpack.Nonce, msg []byte) []byte {
+	return s.k.Box(s.peer, nonce, msg)
+}
+
+func (s sharedKey) Unbox(nonce saltpack.Nonce, msg []byte) ([]byte, error) {
+	return s.k.Unbox(s.peer, nonce, msg)
+}
+
+// HybridBoxKey is a BoxKey for a hybrid key held by the agent, which can
+// open saltpack.Version3 messages. The ML-KEM-768 half is also used
+// through the agent.
+type HybridBoxKey struct {
+	*BoxKey
+	ek []byte
+}
+
+var _ saltpack.HybridBoxSecretKey = (*HybridBoxKey)(nil)
+
+// GetPublicKey returns the hybrid public key of k.
+func (k *HybridBoxKey) GetPublicKey() saltpack.BoxPublicKey {
+	return basic.HybridPublicKey{PublicKey: k.pub, EncapsulationKey: k.ek}
+}
+
+// DecapsulateMLKEM asks the agent for the ML-KEM-768 shared secret of
+// ciphertext.
+func (k *HybridBoxKey) DecapsulateMLKEM(ciphertext []byte) ([]byte, error) {
+	resp, err := k.c.call(request{Op: OpDecapsulateMLKEM, KIDs: [][]byte{k.pub.ToKID()}, Msg: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// SigningKey is a saltpack.SigningSecretKey for a signing key held by
+// the agent.
+type SigningKey struct {
+	c   *Client
+	pub basic.SigningPublicKey
+}
+
+var _ saltpack.SigningSecretKey = (*SigningKey)(nil)
+
+// Sign asks the agent to sign message.
+func (k *SigningKey) Sign(message []byte) ([]byte, error) {
+	resp, err := k.c.call(request{Op: OpSign, KIDs: [][]byte{k.pub.ToKID()}, Msg: message})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data, nil
+}
+
+// GetPublicKey returns the public key of k.
+func (k *SigningKey) GetPublicKey() saltpack.SigningPublicKey {
+	return k.pub
+}
@@ -0,0 +1,167 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package agent
+
+import (
+	"errors"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+)
+
+// Op is an operation that a client asks the agent to do.
+type Op int
+
+// The operations that clients can ask for. OpBox, OpUnbox, OpSign and
+// OpDecapsulateMLKEM use a secret key, so they're the ones that need
+// confirmation, and that are refused while the agent is locked. There's no
+// operation to precompute a shared key, since that would hand the client a
+// key that it could keep using without the agent; see BoxKey.Precompute.
+// An ML-KEM shared secret is only good for the one ciphertext it was
+// decapsulated from, so OpDecapsulateMLKEM doesn't have that problem.
+const (
+	OpLookupBoxSecretKey     Op = 1
+	OpGetAllBoxSecretKeys    Op = 2
+	OpLookupSigningPublicKey Op = 3
+	OpGetAllSigningKeys      Op = 4
+	OpBox                    Op = 5
+	OpUnbox                  Op = 6
+	OpSign                   Op = 7
+	OpDecapsulateMLKEM       Op = 8
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpLookupBoxSecretKey:
+		return "look up box secret key"
+	case OpGetAllBoxSecretKeys:
+		return "list box secret keys"
+	case OpLookupSigningPublicKey:
+		return "look up signing public key"
+	case OpGetAllSigningKeys:
+		return "list signing keys"
+	case OpBox:
+		return "box"
+	case OpUnbox:
+		return "unbox"
+	case OpSign:
+		return "sign"
+	case OpDecapsulateMLKEM:
+		return "decapsulate ML-KEM"
+	default:
+		return "unknown operation"
+	}
+}
+
+func (op Op) usesSecretKey() bool {
+	return op == OpBox || op == OpUnbox || op == OpSign || op == OpDecapsulateMLKEM
+}
+
+var (
+	// ErrLocked is returned for operations that use a secret key while
+	// the agent is locked.
+	ErrLocked = errors.New("saltpack agent is locked")
+
+	// ErrDenied is returned when the confirmation callback of a key
+	// refuses to let it be used.
+	ErrDenied = errors.New("use of key denied by saltpack agent")
+
+	// ErrKeyNotFound is returned when the agent doesn't hold the requested
+	// key.
+	ErrKeyNotFound = errors.New("key not held by saltpack agent")
+
+	// ErrBadRequest is returned for a request that the agent doesn't
+	// understand.
+	ErrBadRequest = errors.New("bad saltpack agent request")
+)
+
+// RemoteError is an error from the agent that has no counterpart on the
+// client side.
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return "saltpack agent: " + string(e)
+}
+
+// errorCode is how errors are sent over the wire.
+type errorCode int
+
+const (
+	codeOK               errorCode = 0
+	codeOther            errorCode = 1
+	codeLocked           errorCode = 2
+	codeDenied           errorCode = 3
+	codeKeyNotFound      errorCode = 4
+	codeBadRequest       errorCode = 5
+	codeDecryptionFailed errorCode = 6
+	codeNotHybridKey     errorCode = 7
+)
+
+var errorCodes = []struct {
+	code errorCode
+	err  error
+}{
+	{codeLocked, ErrLocked},
+	{codeDenied, ErrDenied},
+	{codeKeyNotFound, ErrKeyNotFound},
+	{codeBadRequest, ErrBadRequest},
+	{codeDecryptionFailed, saltpack.ErrDecryptionFailed},
+	{codeNotHybridKey, saltpack.ErrNotHybridKey},
+}
+
+// request is a call from a client. Which fields are used depends on Op:
+// KIDs holds the candidate key IDs for OpLookupBoxSecretKey, and the ID
+// of the key to use, or look up, as its only element otherwise. Msg holds
+// the ciphertext for OpDecapsulateMLKEM.
+type request struct {
+	_struct bool     `codec:",toarray"` //nolint
+	Op      Op       `codec:"op"`
+	KIDs    [][]byte `codec:"kids"`
+	Peer    []byte   `codec:"peer"`
+	Nonce   []byte   `codec:"nonce"`
+	Msg     []byte   `codec:"msg"`
+}
+
+// response is the agent's reply to a request. Index is the index of the
+// key found by OpLookupBoxSecretKey, KIDs are the keys found by lookups,
+// and Data is the output of the other operations. For box key lookups,
+// EncapsulationKeys has the ML-KEM-768 encapsulation key of each hybrid
+// key in KIDs, and is empty for the others. It's last, so that clients
+// and agents that don't know about it ignore it.
+type response struct {
+	_struct           bool      `codec:",toarray"` //nolint
+	Code              errorCode `codec:"code"`
+	Error             string    `codec:"error"`
+	Index             int       `codec:"index"`
+	KIDs              [][]byte  `codec:"kids"`
+	Data              []byte    `codec:"data"`
+	EncapsulationKeys [][]byte  `codec:"encapsulation_keys"`
+}
+
+func errorResponse(err error) response {
+	for _, e := range errorCodes {
+		if err == e.err {
+			return response{Code: e.code, Error: err.Error()}
+		}
+	}
+	return response{Code: codeOther, Error: err.Error()}
+}
+
+func (r response) err() error {
+	if r.Code == codeOK {
+		return nil
+	}
+	for _, e := range errorCodes {
+		if r.Code == e.code {
+			return e.err
+		}
+	}
+	return RemoteError(r.Error)
+}
+
+func codecHandle() *codec.MsgpackHandle {
+	var mh codec.MsgpackHandle
+	mh.WriteExt = true
+	return &mh
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/keybase/saltpack/encoding/basex"
@@ -26,6 +27,21 @@ type armorParams struct {
 	Punctuation byte
 	// Encoding is the basex encoding to use, including strictness parameters
 	Encoding *basex.Encoding
+	// Compact turns off all whitespace, so the output fits on a single
+	// line. BytesPerWord and WordsPerLine are ignored, and the footer
+	// is optional.
+	Compact bool
+}
+
+// secret returns a copy of p that encodes in constant time, for secret
+// payloads.
+func (p armorParams) secret() armorParams {
+	p.Encoding = p.Encoding.ConstantTime()
+	return p
+}
+
+func (p armorParams) punctuationBytes() []byte {
+	return []byte{p.Punctuation}
 }
 
 type armorEncoderStream struct {
@@ -49,6 +65,10 @@ func (s *armorEncoderStream) Write(b []byte) (n int, err error) {
 }
 
 func (s *armorEncoderStream) spaceAndOutputBuffer() error {
+	if s.params.Compact {
+		_, err := s.buf.WriteTo(s.encoded)
+		return err
+	}
 	for s.buf.Len() > s.params.BytesPerWord {
 		buf := s.buf.Next(s.params.BytesPerWord)
 		s.nWords++
@@ -73,6 +93,9 @@ func (s *armorEncoderStream) Close() (err error) {
 	if err := s.spaceAndOutputBuffer(); err != nil {
 		return err
 	}
+	if s.params.Compact {
+		return s.closeCompact()
+	}
 	lst := s.buf.Bytes()
 	if _, err := s.encoded.Write(lst); err != nil {
 		return err
@@ -92,6 +115,15 @@ func (s *armorEncoderStream) Close() (err error) {
 	return nil
 }
 
+func (s *armorEncoderStream) closeCompact() error {
+	if len(s.footer) == 0 {
+		_, err := fmt.Fprintf(s.encoded, "%c", s.params.Punctuation)
+		return err
+	}
+	_, err := fmt.Fprintf(s.encoded, "%c%s%c", s.params.Punctuation, s.footer, s.params.Punctuation)
+	return err
+}
+
 // newArmorEncoderStream makes a new Armor encoding stream, using the given encoding
 // Pass it an `encoded` stream writer to write the
 // encoded stream to.  Also pass a header, and a footer string.  It will
@@ -108,7 +140,11 @@ func newArmorEncoderStream(encoded io.Writer, header string, footer string, para
 		params:  params,
 	}
 	ret.encoder = basex.NewEncoder(params.Encoding, ret.buf)
-	if _, err := fmt.Fprintf(encoded, "%s%c ", header, params.Punctuation); err != nil {
+	format := "%s%c "
+	if params.Compact {
+		format = "%s%c"
+	}
+	if _, err := fmt.Fprintf(encoded, format, header, params.Punctuation); err != nil {
 		return nil, err
 	}
 	return ret, nil
@@ -142,6 +178,80 @@ type Frame interface {
 	GetBrand() (string, error)
 }
 
+// ArmorSection names a section of an armored message, for error reporting.
+type ArmorSection int
+
+const (
+	// ArmorSectionUnknown is used when the section isn't known.
+	ArmorSectionUnknown ArmorSection = iota
+	// ArmorSectionHeader is the "BEGIN ..." frame.
+	ArmorSectionHeader
+	// ArmorSectionBody is the encoded data between the frames.
+	ArmorSectionBody
+	// ArmorSectionFooter is the "END ..." frame.
+	ArmorSectionFooter
+	// ArmorSectionTrailer is anything after the footer.
+	ArmorSectionTrailer
+)
+
+func (s ArmorSection) String() string {
+	switch s {
+	case ArmorSectionHeader:
+		return "header"
+	case ArmorSectionBody:
+		return "body"
+	case ArmorSectionFooter:
+		return "footer"
+	case ArmorSectionTrailer:
+		return "trailer"
+	default:
+		return "unknown section"
+	}
+}
+
+// ArmorPosition is a position in armored input. Lines and columns start at
+// 1, and columns count bytes, not characters. Offset is the 0-based byte
+// offset from the start of the input.
+type ArmorPosition struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p ArmorPosition) isSet() bool {
+	return p.Line > 0
+}
+
+func (p ArmorPosition) String() string {
+	return fmt.Sprintf("line %d, column %d (byte %d)", p.Line, p.Column, p.Offset)
+}
+
+// armorPositionTracker keeps track of how much of the input we've consumed,
+// and where the line breaks were, so that we can turn an offset back into
+// a line and column.
+type armorPositionTracker struct {
+	offset   int
+	newlines []int
+}
+
+func (t *armorPositionTracker) advance(p []byte) {
+	for i, b := range p {
+		if b == '\n' {
+			t.newlines = append(t.newlines, t.offset+i)
+		}
+	}
+	t.offset += len(p)
+}
+
+func (t *armorPositionTracker) position(offset int) ArmorPosition {
+	line := sort.SearchInts(t.newlines, offset)
+	column := offset + 1
+	if line > 0 {
+		column = offset - t.newlines[line-1]
+	}
+	return ArmorPosition{Offset: offset, Line: line + 1, Column: column}
+}
+
 type fdsState int
 
 const (
@@ -172,22 +282,71 @@ type framedDecoderStream struct {
 	headerChecker HeaderChecker
 	frameChecker  FrameChecker
 	frameLim      int // The largest frame we'll accept before we show an overflow.
+
+	pos         armorPositionTracker
+	headerStart int
+	bodyStart   int
+	footerStart int
+}
+
+// sectionError annotates err with the section it was found in and the
+// offset in the input where it was found. ErrBadFrame errors keep their
+// type, and ErrBadFrame errors that know their own section are positioned
+// at the start of that frame. Errors that are already annotated are
+// returned as is.
+func (s *framedDecoderStream) sectionError(err error, section ArmorSection, offset int) error {
+	var badArmor ErrBadArmor
+	if errors.As(err, &badArmor) {
+		return err
+	}
+	var badFrame ErrBadFrame
+	if errors.As(err, &badFrame) {
+		if badFrame.Position.isSet() {
+			return err
+		}
+		switch badFrame.Section {
+		case ArmorSectionHeader:
+			offset = s.headerStart
+		case ArmorSectionFooter:
+			offset = s.footerStart
+		case ArmorSectionUnknown:
+			badFrame.Section = section
+		}
+		badFrame.Position = s.pos.position(offset)
+		return badFrame
+	}
+	return ErrBadArmor{Section: section, Position: s.pos.position(offset), Err: err}
+}
+
+// readFrame reads a header or footer, keeping track of where it started,
+// which is at its first non-space character.
+func (s *framedDecoderStream) readFrame() (frame []byte, start int, err error) {
+	begin := s.pos.offset
+	frame, err = s.r.ReadUntilPunctuation(s.frameLim)
+	s.pos.advance(frame)
+	start = begin + len(frame) - len(bytes.TrimLeft(frame, " \t\r\n>"))
+	if err != nil {
+		return nil, start, err
+	}
+	s.pos.advance(s.params.punctuationBytes())
+	return frame, start, nil
 }
 
 func (s *framedDecoderStream) loadHeader() (err error) {
 	if s.state == fdsHeader {
-		s.header, err = s.r.ReadUntilPunctuation(s.frameLim)
+		s.header, s.headerStart, err = s.readFrame()
 		if err != nil {
-			return err
+			return s.sectionError(err, ArmorSectionHeader, s.pos.offset)
 		}
+		s.bodyStart = s.pos.offset
 		if s.headerChecker != nil {
-			headerStr, err := s.toASCII(s.header)
+			headerStr, err := s.toASCII(s.header, s.headerStart)
 			if err != nil {
-				return err
+				return s.sectionError(err, ArmorSectionHeader, s.headerStart)
 			}
 			s.frameBrand, err = s.headerChecker(headerStr)
 			if err != nil {
-				return err
+				return s.sectionError(err, ArmorSectionHeader, s.headerStart)
 			}
 		}
 		s.state = fdsBody
@@ -207,34 +366,36 @@ func (s *framedDecoderStream) Read(p []byte) (n int, err error) {
 
 	if s.state == fdsBody {
 		n, err = s.r.Read(p)
+		s.pos.advance(p[:n])
 		if errors.Is(err, ErrPunctuated) {
 			err = nil
+			s.pos.advance(s.params.punctuationBytes())
 			s.state = fdsFooter
 		}
 		if errors.Is(err, io.EOF) {
 			err = io.ErrUnexpectedEOF
 		}
 		if err != nil {
-			return 0, err
+			return 0, s.sectionError(err, ArmorSectionBody, s.pos.offset)
 		}
 	}
 
 	if s.state == fdsFooter {
-		s.footer, err = s.r.ReadUntilPunctuation(s.frameLim)
+		s.footer, s.footerStart, err = s.readFrame()
 		if err != nil {
-			return 0, err
+			return 0, s.sectionError(err, ArmorSectionFooter, s.pos.offset)
 		}
 		if s.frameChecker != nil {
-			headerStr, err := s.toASCII(s.header)
+			headerStr, err := s.toASCII(s.header, s.headerStart)
 			if err != nil {
-				return 0, err
+				return 0, s.sectionError(err, ArmorSectionHeader, s.headerStart)
 			}
-			footerStr, err := s.toASCII(s.footer)
+			footerStr, err := s.toASCII(s.footer, s.footerStart)
 			if err != nil {
-				return 0, err
+				return 0, s.sectionError(err, ArmorSectionFooter, s.footerStart)
 			}
 			if _, err = s.frameChecker(headerStr, footerStr); err != nil {
-				return 0, err
+				return 0, s.sectionError(err, ArmorSectionFooter, s.footerStart)
 			}
 		}
 		s.state = fdsEndOfStream
@@ -259,31 +420,41 @@ func (s *framedDecoderStream) consumeUntilEOF() error {
 	for {
 		n, err := s.r.Read(buf[:])
 		if err != nil {
-			return err
+			if errors.Is(err, io.EOF) {
+				return err
+			}
+			return s.sectionError(err, ArmorSectionTrailer, s.pos.offset)
 		}
 		if n == 0 {
 			return io.EOF
 		}
-		if !s.isValidByteSequence(buf[0:n]) {
-			return ErrTrailingGarbage
+		if i := s.firstInvalidByte(buf[0:n]); i >= 0 {
+			s.pos.advance(buf[0:i])
+			return s.sectionError(ErrTrailingGarbage, ArmorSectionTrailer, s.pos.offset)
 		}
+		s.pos.advance(buf[0:n])
 	}
 }
 
-// isValidByteSequence checks if the byte sequence is valid as far as our
-// underlying encoder is concerned.
-func (s *framedDecoderStream) isValidByteSequence(p []byte) bool {
-	for _, b := range p {
+// firstInvalidByte returns the index of the first byte in p that's not
+// valid as far as our underlying encoder is concerned, or -1 if they're
+// all valid.
+func (s *framedDecoderStream) firstInvalidByte(p []byte) int {
+	for i, b := range p {
 		if !s.params.Encoding.IsValidByte(b) {
-			return false
+			return i
 		}
 	}
-	return true
+	return -1
 }
 
-func (s *framedDecoderStream) toASCII(buf []byte) (string, error) {
-	if !s.isValidByteSequence(buf) {
-		return "", makeErrBadFrame("invalid ASCII sequence")
+// toASCII checks and trims a frame that starts at the given offset in
+// the input.
+func (s *framedDecoderStream) toASCII(buf []byte, start int) (string, error) {
+	if i := s.firstInvalidByte(buf); i >= 0 {
+		// Point at the offending byte, rather than the start of the frame.
+		offset := start + i - (len(buf) - len(bytes.TrimLeft(buf, " \t\r\n>")))
+		return "", ErrBadFrame{msg: "invalid ASCII sequence", Position: s.pos.position(offset)}
 	}
 	return strings.TrimSpace(string(buf)), nil
 }
@@ -292,7 +463,11 @@ func (s *framedDecoderStream) GetFooter() (string, error) {
 	if s.state < fdsFooter {
 		return "", fmt.Errorf("the footer can be retrieved only after the stream has been exhausted")
 	}
-	return s.toASCII(s.footer)
+	ret, err := s.toASCII(s.footer, s.footerStart)
+	if err != nil {
+		return "", s.sectionError(err, ArmorSectionFooter, s.footerStart)
+	}
+	return ret, nil
 }
 
 func (s *framedDecoderStream) GetHeader() (string, error) {
@@ -301,7 +476,11 @@ func (s *framedDecoderStream) GetHeader() (string, error) {
 			return "", err
 		}
 	}
-	return s.toASCII(s.header)
+	ret, err := s.toASCII(s.header, s.headerStart)
+	if err != nil {
+		return "", s.sectionError(err, ArmorSectionHeader, s.headerStart)
+	}
+	return ret, nil
 }
 
 func (s *framedDecoderStream) GetBrand() (string, error) {
@@ -319,10 +498,30 @@ func (s *framedDecoderStream) GetBrand() (string, error) {
 // reader has been exhausted.
 func newArmorDecoderStream(r io.Reader, params armorParams, headerChecker HeaderChecker, frameChecker FrameChecker) (io.Reader, Frame, error) {
 	fds := &framedDecoderStream{r: newPunctuatedReader(r, params.Punctuation), params: params, headerChecker: headerChecker, frameChecker: frameChecker, frameLim: 8192}
-	ret := basex.NewDecoder(params.Encoding, fds)
+	ret := &armorBodyDecoder{r: basex.NewDecoder(params.Encoding, fds), fds: fds}
 	return ret, fds, nil
 }
 
+// armorBodyDecoder positions the errors that the basex decoder finds in
+// the body. The basex decoder counts offsets from the start of the body,
+// which is as much as it ever sees.
+type armorBodyDecoder struct {
+	r   io.Reader
+	fds *framedDecoderStream
+}
+
+func (d *armorBodyDecoder) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err == nil || errors.Is(err, io.EOF) {
+		return n, err
+	}
+	var corrupt basex.CorruptInputError
+	if errors.As(err, &corrupt) {
+		return n, d.fds.sectionError(err, ArmorSectionBody, d.fds.bodyStart+int(corrupt))
+	}
+	return n, d.fds.sectionError(err, ArmorSectionBody, d.fds.pos.offset)
+}
+
 // armorOpen runs armor stream decoding, but on a string, and it outputs a string.
 func armorOpen(msg string, params armorParams, headerChecker HeaderChecker, frameChecker FrameChecker) (body []byte, brand string, header string, footer string, err error) {
 	var dec io.Reader
@@ -4,6 +4,7 @@
 package saltpack
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/keybase/saltpack/encoding/basex"
@@ -19,6 +20,20 @@ var Armor62Params = armorParams{
 	Encoding:     basex.Base62StdEncoding,
 }
 
+// armor62CompactParams are the armoring parameters for single-line,
+// URL-safe armor. There is no whitespace at all, so the strict encoding
+// suffices, and '.' is one of the unreserved URL characters.
+var armor62CompactParams = armorParams{
+	Punctuation: byte('.'),
+	Encoding:    basex.Base62StdEncodingStrict,
+	Compact:     true,
+}
+
+// armor62SecretParams are Armor62Params with a constant-time encoding,
+// for payloads like secret keys, whose armoring shouldn't leak anything
+// through timing.
+var armor62SecretParams = Armor62Params.secret()
+
 // NewArmor62EncoderStream makes a new Armor 62 encoding stream, using the base62-alphabet
 // and a 32/43 encoding rate strategy. Pass it an `encoded` stream writer to write the
 // encoded stream to.  Also pass an optional "brand" .  It will
@@ -42,6 +57,15 @@ func Armor62Seal(plaintext []byte, typ MessageType, brand string) (string, error
 	return armorSeal(plaintext, hdr, ftr, Armor62Params)
 }
 
+// Armor62SealSecret is Armor62Seal for secret payloads, like exported
+// secret keys. The output is the same, but the encoding runs in constant
+// time.
+func Armor62SealSecret(plaintext []byte, typ MessageType, brand string) (string, error) {
+	hdr := makeFrame(headerMarker, typ, brand)
+	ftr := makeFrame(footerMarker, typ, brand)
+	return armorSeal(plaintext, hdr, ftr, armor62SecretParams)
+}
+
 // NewArmor62DecoderStream is used to decode input base62-armoring format. It returns
 // a stream you can read from, and also a Frame you can query to see what the open/close
 // frame markers were. hc and fc are optional and can be nil.
@@ -64,6 +88,14 @@ func Armor62OpenWithValidation(msg string, hc HeaderChecker, fc FrameChecker) (b
 	return armorOpen(msg, Armor62Params, hc, fc)
 }
 
+// Armor62OpenSecretWithValidation is Armor62OpenWithValidation for secret
+// payloads, decoding the body in constant time. Inputs that the constant-time
+// decoder can't handle in constant time are rejected; see
+// basex.Encoding.ConstantTime.
+func Armor62OpenSecretWithValidation(msg string, hc HeaderChecker, fc FrameChecker) (body []byte, brand string, header string, footer string, err error) {
+	return armorOpen(msg, armor62SecretParams, hc, fc)
+}
+
 // CheckArmor62Frame checks that the frame matches our standard
 // begin/end frame
 func CheckArmor62Frame(frame Frame, typ MessageType) (brand string, err error) {
@@ -91,7 +123,12 @@ func CheckArmor62(hdr string, ftr string, typ MessageType) (brand string, err er
 	}
 
 	if b2 != brand {
-		return "", makeErrBadFrame("brand mismatch: %q != %q", brand, b2)
+		return "", ErrBadFrame{
+			msg:      fmt.Sprintf("brand mismatch: %q != %q", brand, b2),
+			Section:  ArmorSectionFooter,
+			Expected: makeFrame(footerMarker, typ, brand),
+			Found:    makeFrame(footerMarker, typ, b2),
+		}
 	}
 	return brand, nil
 }
@@ -4,6 +4,7 @@
 package saltpack
 
 import (
+	"errors"
 	"io"
 	"strings"
 	"testing"
@@ -93,7 +94,15 @@ func testBadArmor62(t *testing.T, version Version) {
 	half := l >> 1
 	bad6 := ciphertext[0:half] + "䁕" + ciphertext[(half+1):]
 	_, _, _, err = Armor62Open(bad6)
-	require.IsType(t, basex.CorruptInputError(0), err)
+	// Armor decoding errors used to be returned bare. They now come
+	// wrapped in an ErrBadArmor that says where they were found, which is
+	// a breaking change for callers that type-switch on them (see
+	// CHANGELOG.md).
+	require.IsType(t, ErrBadArmor{}, err)
+	require.IsType(t, basex.CorruptInputError(0), errors.Unwrap(err))
+	badArmor := err.(ErrBadArmor)
+	require.Equal(t, ArmorSectionBody, badArmor.Section)
+	require.Equal(t, half, badArmor.Position.Offset)
 }
 
 func TestArmor62Encrypt(t *testing.T) {
@@ -20,7 +20,7 @@ func newSigncryptArmor62SealStream(ciphertext io.Writer, sender SigningSecretKey
 	if err != nil {
 		return nil, err
 	}
-	out, err := newSigncryptSealStream(enc, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, rng)
+	out, err := newSigncryptSealStream(Version2(), enc, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, rng)
 	if err != nil {
 		return nil, err
 	}
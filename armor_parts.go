@@ -0,0 +1,485 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/saltpack/encoding/basex"
+)
+
+// A multi-part armored message is a binary message cut into consecutive
+// chunks, each of which is armored on its own, with a frame that says
+// which part it is, and a random ID shared by all the parts of a message:
+//
+//	BEGIN ACME SALTPACK ENCRYPTED MESSAGE PART 2 OF 5 ID 3b5R0pDgLxW. ... END ACME SALTPACK ENCRYPTED MESSAGE PART 2 OF 5 ID 3b5R0pDgLxW.
+//
+// The compact variant has no whitespace at all and a much shorter frame,
+// so that it fits in a URL:
+//
+//	ACME-SALTPACK-E-2OF5-3b5R0pDgLxW.<body>.
+//
+// Since each part is decoded independently, the parts can be reassembled
+// in any order. The ID keeps parts of different messages with the same
+// type, brand and part count from being spliced together.
+
+const (
+	armorPartMarker        = "PART"
+	armorPartOfMarker      = "OF"
+	armorPartIDMarker      = "ID"
+	compactFrameSeparator  = "-"
+	compactPartOfSeparator = "OF"
+
+	// armorPartIDLen is the number of random bytes in a message ID.
+	armorPartIDLen = 8
+)
+
+// armorPartTypes are the message types we might find in a part frame.
+// Signcrypted messages use the same frame as encrypted ones.
+var armorPartTypes = []MessageType{
+	MessageTypeEncryption,
+	MessageTypeAttachedSignature,
+	MessageTypeDetachedSignature,
+}
+
+// armorPartFrame is what we learn from the frame of a single part. A total
+// of 0 means the message wasn't split, in which case there's no id.
+type armorPartFrame struct {
+	typ   MessageType
+	brand string
+	index int
+	total int
+	id    string
+}
+
+type armorPart struct {
+	armorPartFrame
+	body []byte
+}
+
+func makePartFrame(which headerOrFooterMarker, typ MessageType, brand string, index, total int, id string) string {
+	return fmt.Sprintf("%s %s %d %s %d %s %s", makeFrame(which, typ, brand), armorPartMarker, index, armorPartOfMarker, total, armorPartIDMarker, id)
+}
+
+// newArmorPartID makes a random message ID for the parts of a message.
+func newArmorPartID() (string, error) {
+	var b [armorPartIDLen]byte
+	if err := csprngRead(b[:]); err != nil {
+		return "", err
+	}
+	return basex.Base62StdEncodingStrict.EncodeToString(b[:]), nil
+}
+
+// checkArmorPartID checks that id could have come from newArmorPartID.
+func checkArmorPartID(id string) error {
+	b, err := basex.Base62StdEncodingStrict.DecodeString(id)
+	if err != nil || len(b) != armorPartIDLen {
+		return makeErrBadFrame("bad message ID %q", id)
+	}
+	return nil
+}
+
+// parseCanonicalInt parses a positive decimal integer, rejecting signs,
+// leading zeros and anything else that wouldn't round-trip.
+func parseCanonicalInt(s string) (int, bool) {
+	i, err := strconv.Atoi(s)
+	if err != nil || i <= 0 || strconv.Itoa(i) != s {
+		return 0, false
+	}
+	return i, true
+}
+
+func checkPartNumbers(index, total int) error {
+	if index > total {
+		return makeErrBadFrame("part %d of only %d", index, total)
+	}
+	return nil
+}
+
+// partFrameSpaceRegexp matches the runs of whitespace and quoting that
+// separate the words of a part frame.
+var partFrameSpaceRegexp = regexp.MustCompile("[>\n\r\t ]+")
+
+func parsePartFrame(m string, hof headerOrFooterMarker) (f armorPartFrame, err error) {
+	if len(m) > maxFrameLength {
+		return f, makeErrBadFrame("Frame is too long")
+	}
+	v := strings.Split(strings.TrimSpace(partFrameSpaceRegexp.ReplaceAllString(m, " ")), " ")
+	if len(v) < 6 {
+		return f, makeErrBadFrame("wrong number of words (%d)", len(v))
+	}
+	tail := pop(&v, 6)
+	if tail[0] != armorPartMarker || tail[2] != armorPartOfMarker || tail[4] != armorPartIDMarker {
+		return f, makeErrBadFrame("missing part number in %q", m)
+	}
+	var ok bool
+	if f.index, ok = parseCanonicalInt(tail[1]); !ok {
+		return f, makeErrBadFrame("bad part number %q", tail[1])
+	}
+	if f.total, ok = parseCanonicalInt(tail[3]); !ok {
+		return f, makeErrBadFrame("bad part count %q", tail[3])
+	}
+	if err = checkPartNumbers(f.index, f.total); err != nil {
+		return f, err
+	}
+	f.id = tail[5]
+	if err = checkArmorPartID(f.id); err != nil {
+		return f, err
+	}
+	rest := strings.Join(v, " ")
+	for _, typ := range armorPartTypes {
+		if f.brand, err = parseFrame(rest, typ, hof); err == nil {
+			f.typ = typ
+			return f, nil
+		}
+	}
+	return f, makeErrBadFrame("not a %s frame of a known message type: %q", hof, rest)
+}
+
+func compactCodeForType(typ MessageType) string {
+	switch typ {
+	case MessageTypeEncryption:
+		return "E"
+	case MessageTypeAttachedSignature:
+		return "S"
+	case MessageTypeDetachedSignature:
+		return "D"
+	default:
+		return ""
+	}
+}
+
+func makeCompactFrame(typ MessageType, brand string, index, total int, id string) string {
+	var words []string
+	if len(brand) > 0 {
+		words = append(words, brand)
+	}
+	words = append(words, strings.ToUpper(FormatName), compactCodeForType(typ))
+	if total > 0 {
+		words = append(words, fmt.Sprintf("%d%s%d", index, compactPartOfSeparator, total), id)
+	}
+	return strings.Join(words, compactFrameSeparator)
+}
+
+func parseCompactFrame(m string) (f armorPartFrame, err error) {
+	if len(m) > maxFrameLength {
+		return f, makeErrBadFrame("Frame is too long")
+	}
+	v := strings.Split(m, compactFrameSeparator)
+	if len(v) > 0 && v[0] != strings.ToUpper(FormatName) {
+		f.brand = shift(&v, 1)[0]
+		if len(f.brand) > maxBrandLength {
+			return f, makeErrBadFrame("Brand is too long")
+		}
+	}
+	if len(v) != 2 && len(v) != 4 {
+		return f, makeErrBadFrame("wrong number of words in %q", m)
+	}
+	if v[0] != strings.ToUpper(FormatName) {
+		return f, makeErrBadFrame("bad format name (%s)", v[0])
+	}
+	f.typ = MessageTypeUnknown
+	for _, typ := range armorPartTypes {
+		if v[1] == compactCodeForType(typ) {
+			f.typ = typ
+		}
+	}
+	if f.typ == MessageTypeUnknown {
+		return f, makeErrBadFrame("unknown message type code %q", v[1])
+	}
+	if len(v) == 4 {
+		nums := strings.Split(v[2], compactPartOfSeparator)
+		var ok1, ok2 bool
+		if len(nums) == 2 {
+			f.index, ok1 = parseCanonicalInt(nums[0])
+			f.total, ok2 = parseCanonicalInt(nums[1])
+		}
+		if !ok1 || !ok2 {
+			return f, makeErrBadFrame("bad part number %q", v[2])
+		}
+		if err = checkPartNumbers(f.index, f.total); err != nil {
+			return f, err
+		}
+		f.id = v[3]
+		if err = checkArmorPartID(f.id); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// isURLSafeBrand checks that the brand only uses characters we can put
+// into a compact frame without quoting.
+func isURLSafeBrand(brand string) bool {
+	for _, c := range []byte(brand) {
+		if !basex.Base62StdEncodingStrict.IsValidByte(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// armoredLen returns the length of the output of armorSeal, given n bytes
+// of input. See armorEncoderStream for where all the punctuation goes.
+func armoredLen(params armorParams, header, footer string, n int) int {
+	l := params.Encoding.EncodedLen(n)
+	if params.Compact {
+		ret := len(header) + 1 + l + 1
+		if len(footer) > 0 {
+			ret += len(footer) + 1
+		}
+		return ret
+	}
+	return len(header) + 2 + l + l/params.BytesPerWord + 4 + len(footer)
+}
+
+// maxArmoredInput returns the largest input that armors to at most
+// maxLen characters, or -1 if even the empty input doesn't fit.
+func maxArmoredInput(params armorParams, header, footer string, maxLen int) int {
+	if armoredLen(params, header, footer, 0) > maxLen {
+		return -1
+	}
+	lo, hi := 0, maxLen
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if armoredLen(params, header, footer, mid) <= maxLen {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo
+}
+
+type partFramer func(index, total int) (header, footer string)
+
+func sealArmorParts(plaintext []byte, maxPartLen int, params armorParams, framer partFramer) ([]string, error) {
+	// The frame gets wider as the number of parts grows, which can in turn
+	// increase the number of parts, so search for the smallest part count
+	// that works. The widest frame is the one with index == total.
+	var total, capacity int
+	for total = 1; ; {
+		hdr, ftr := framer(total, total)
+		capacity = maxArmoredInput(params, hdr, ftr, maxPartLen)
+		if capacity < 0 || (capacity == 0 && len(plaintext) > 0) {
+			return nil, ErrInvalidParameter{message: fmt.Sprintf("maximum part length %d is too short for the armor frame", maxPartLen)}
+		}
+		needed := 1
+		if len(plaintext) > 0 {
+			needed = (len(plaintext) + capacity - 1) / capacity
+		}
+		if needed <= total {
+			break
+		}
+		total = needed
+	}
+
+	parts := make([]string, 0, total)
+	for i := 1; i <= total; i++ {
+		chunk := plaintext[min((i-1)*capacity, len(plaintext)):min(i*capacity, len(plaintext))]
+		hdr, ftr := framer(i, total)
+		part, err := armorSeal(chunk, hdr, ftr, params)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// checkArmorPartType checks that typ is one of armorPartTypes. Keys can
+// be armored, but not split into parts.
+func checkArmorPartType(typ MessageType) error {
+	if len(compactCodeForType(typ)) == 0 {
+		return ErrInvalidParameter{message: fmt.Sprintf("can't armor %s", typ)}
+	}
+	return nil
+}
+
+func checkCompactBrand(brand string) error {
+	if !isURLSafeBrand(brand) {
+		return ErrInvalidParameter{message: fmt.Sprintf("brand %q can't be used in compact armor", brand)}
+	}
+	return nil
+}
+
+// Armor62SealParts armors plaintext, like Armor62Seal, but splits the
+// output into as few parts as possible such that each part, frame and
+// trailing newline included, is at most maxPartLen characters long. The
+// parts can be given to Armor62OpenParts or JoinArmor62Parts in any order.
+func Armor62SealParts(plaintext []byte, typ MessageType, brand string, maxPartLen int) ([]string, error) {
+	if err := checkArmorPartType(typ); err != nil {
+		return nil, err
+	}
+	id, err := newArmorPartID()
+	if err != nil {
+		return nil, err
+	}
+	return sealArmorParts(plaintext, maxPartLen, Armor62Params, func(index, total int) (string, string) {
+		return makePartFrame(headerMarker, typ, brand, index, total, id), makePartFrame(footerMarker, typ, brand, index, total, id)
+	})
+}
+
+// Armor62SealCompactParts is like Armor62SealParts, but each part is in the
+// compact, single-line format of Armor62SealCompact.
+func Armor62SealCompactParts(plaintext []byte, typ MessageType, brand string, maxPartLen int) ([]string, error) {
+	if err := checkArmorPartType(typ); err != nil {
+		return nil, err
+	}
+	if err := checkCompactBrand(brand); err != nil {
+		return nil, err
+	}
+	id, err := newArmorPartID()
+	if err != nil {
+		return nil, err
+	}
+	return sealArmorParts(plaintext, maxPartLen, armor62CompactParams, func(index, total int) (string, string) {
+		return makeCompactFrame(typ, brand, index, total, id), ""
+	})
+}
+
+// Armor62SealCompact armors plaintext into a single line with no spaces
+// and a minimal frame, e.g. "ACME-SALTPACK-E.<body>.", so that it can be
+// used in a URL without escaping. The brand must be alphanumeric.
+func Armor62SealCompact(plaintext []byte, typ MessageType, brand string) (string, error) {
+	if err := checkArmorPartType(typ); err != nil {
+		return "", err
+	}
+	if err := checkCompactBrand(brand); err != nil {
+		return "", err
+	}
+	return armorSeal(plaintext, makeCompactFrame(typ, brand, 0, 0, ""), "", armor62CompactParams)
+}
+
+func openCompactArmor62(msg string) (p armorPart, err error) {
+	msg = strings.TrimSpace(msg)
+	errForm := makeErrBadFrame("compact armor must be of the form <frame>.<body>.")
+	r := newPunctuatedReader(strings.NewReader(msg), armor62CompactParams.Punctuation)
+	frame, err := r.ReadUntilPunctuation(maxFrameLength + 1)
+	switch {
+	case errors.Is(err, ErrOverflow):
+		return p, makeErrBadFrame("Frame is too long")
+	case err != nil:
+		return p, errForm
+	}
+	if p.armorPartFrame, err = parseCompactFrame(string(frame)); err != nil {
+		return p, err
+	}
+	body, err := r.ReadUntilPunctuation(len(msg))
+	if err != nil {
+		return p, errForm
+	}
+	// Nothing may follow the body's punctuation.
+	var rest [1]byte
+	if n, err := r.Read(rest[:]); n > 0 || !errors.Is(err, io.EOF) {
+		return p, errForm
+	}
+	p.body, err = armor62CompactParams.Encoding.DecodeString(string(body))
+	return p, err
+}
+
+// Armor62OpenCompact decodes a message made by Armor62SealCompact,
+// returning the body, and the message type and brand from the frame.
+func Armor62OpenCompact(msg string) (body []byte, typ MessageType, brand string, err error) {
+	p, err := openCompactArmor62(msg)
+	if err != nil {
+		return nil, MessageTypeUnknown, "", err
+	}
+	if p.total != 0 {
+		return nil, MessageTypeUnknown, "", makeErrBadFrame("part %d of %d of a multi-part message", p.index, p.total)
+	}
+	return p.body, p.typ, p.brand, nil
+}
+
+// isCompactArmor tells the two formats apart; the regular frame always
+// has spaces in it, while the compact one never does.
+func isCompactArmor(msg string) bool {
+	return !strings.ContainsAny(strings.TrimSpace(msg), " \t\r\n")
+}
+
+func openArmor62Part(msg string) (p armorPart, err error) {
+	if isCompactArmor(msg) {
+		p, err = openCompactArmor62(msg)
+		if err == nil && p.total == 0 {
+			err = makeErrBadFrame("missing part number")
+		}
+		return p, err
+	}
+
+	hc := func(header string) (string, error) {
+		var err error
+		p.armorPartFrame, err = parsePartFrame(header, headerMarker)
+		return p.brand, err
+	}
+	fc := func(header, footer string) (string, error) {
+		f, err := parsePartFrame(footer, footerMarker)
+		if err != nil {
+			return "", err
+		}
+		if f != p.armorPartFrame {
+			return "", makeErrBadFrame("header %q doesn't match footer %q", header, footer)
+		}
+		return p.brand, nil
+	}
+	p.body, _, _, _, err = armorOpen(msg, Armor62Params, hc, fc)
+	return p, err
+}
+
+// Armor62OpenParts decodes and reassembles the parts of a message made
+// by Armor62SealParts or Armor62SealCompactParts, given in any order. It
+// returns ErrDuplicateArmorPart or ErrMissingArmorPart if the parts don't
+// make up exactly one whole message, and ErrBadFrame if they come from
+// different messages.
+func Armor62OpenParts(parts []string) (body []byte, typ MessageType, brand string, err error) {
+	if len(parts) == 0 {
+		return nil, MessageTypeUnknown, "", ErrInvalidParameter{message: "no armor parts given"}
+	}
+	// The part count comes from the input, so don't allocate by it.
+	byIndex := make(map[int]*armorPart, len(parts))
+	var first armorPartFrame
+	for i, msg := range parts {
+		p, err := openArmor62Part(msg)
+		if err != nil {
+			return nil, MessageTypeUnknown, "", err
+		}
+		if i == 0 {
+			first = p.armorPartFrame
+		}
+		if p.typ != first.typ || p.brand != first.brand || p.total != first.total || p.id != first.id {
+			return nil, MessageTypeUnknown, "", makeErrBadFrame("part %d of %d (%s, brand %q, ID %s) doesn't belong with part %d of %d (%s, brand %q, ID %s)",
+				p.index, p.total, p.typ, p.brand, p.id, first.index, first.total, first.typ, first.brand, first.id)
+		}
+		if byIndex[p.index] != nil {
+			return nil, MessageTypeUnknown, "", ErrDuplicateArmorPart(p.index)
+		}
+		byIndex[p.index] = &p
+	}
+	// There are only len(parts) distinct parts, so if the count is any
+	// higher, this finds a missing one within len(parts)+1 steps.
+	body = []byte{}
+	for i := 1; i <= first.total; i++ {
+		p := byIndex[i]
+		if p == nil {
+			return nil, MessageTypeUnknown, "", ErrMissingArmorPart(i)
+		}
+		body = append(body, p.body...)
+	}
+	return body, first.typ, first.brand, nil
+}
+
+// JoinArmor62Parts reassembles the given parts with Armor62OpenParts, and
+// re-armors the result as a single message, suitable for
+// NewDearmor62DecryptStream, NewDearmor62VerifyStream and friends.
+func JoinArmor62Parts(parts []string) (string, error) {
+	body, typ, brand, err := Armor62OpenParts(parts)
+	if err != nil {
+		return "", err
+	}
+	return Armor62Seal(body, typ, brand)
+}
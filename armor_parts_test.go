@@ -0,0 +1,166 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestArmoredLen(t *testing.T) {
+	for _, params := range []armorParams{Armor62Params, armor62CompactParams} {
+		for n := 0; n < 2000; n += 7 {
+			a, err := armorSeal(msg(n), hdr, ftr, params)
+			require.NoError(t, err)
+			require.Equal(t, len(a), armoredLen(params, hdr, ftr, n), "n=%d", n)
+		}
+	}
+}
+
+func testArmor62Parts(t *testing.T, compact bool, sz, maxPartLen int) {
+	m := msg(sz)
+	seal := Armor62SealParts
+	if compact {
+		seal = Armor62SealCompactParts
+	}
+	parts, err := seal(m, MessageTypeEncryption, ourBrand, maxPartLen)
+	require.NoError(t, err)
+	for _, p := range parts {
+		require.LessOrEqual(t, len(p), maxPartLen)
+		if compact {
+			require.NotContains(t, p, " ")
+			require.NotContains(t, p, "\n")
+		}
+	}
+
+	// Reverse the order to make sure it doesn't matter.
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	m2, typ, brand, err := Armor62OpenParts(parts)
+	require.NoError(t, err)
+	require.Equal(t, m, m2)
+	require.Equal(t, MessageTypeEncryption, typ)
+	brandCheck(t, brand)
+
+	if len(parts) > 1 {
+		_, _, _, err = Armor62OpenParts(parts[1:])
+		require.IsType(t, ErrMissingArmorPart(0), err)
+		_, _, _, err = Armor62OpenParts(append(parts, parts[0]))
+		require.IsType(t, ErrDuplicateArmorPart(0), err)
+	}
+}
+
+func TestArmor62Parts(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		testArmor62Parts(t, compact, 0, 160)
+		testArmor62Parts(t, compact, 100, 160)
+		testArmor62Parts(t, compact, 5000, 160)
+		testArmor62Parts(t, compact, 5000, 2953)
+	}
+}
+
+func TestArmor62PartsTooShort(t *testing.T) {
+	_, err := Armor62SealParts(msg(10), MessageTypeEncryption, ourBrand, 50)
+	require.IsType(t, ErrInvalidParameter{}, err)
+}
+
+func TestArmor62PartsMismatch(t *testing.T) {
+	p1, err := Armor62SealParts(msg(1000), MessageTypeEncryption, ourBrand, 500)
+	require.NoError(t, err)
+	p2, err := Armor62SealParts(msg(1000), MessageTypeAttachedSignature, ourBrand, 500)
+	require.NoError(t, err)
+	_, _, _, err = Armor62OpenParts([]string{p1[0], p2[1]})
+	require.IsType(t, ErrBadFrame{}, err)
+
+	// Parts of two messages with the same type, brand and part count
+	// differ in their message IDs.
+	for _, seal := range []func([]byte, MessageType, string, int) ([]string, error){Armor62SealParts, Armor62SealCompactParts} {
+		p1, err = seal(msg(1000), MessageTypeEncryption, ourBrand, 500)
+		require.NoError(t, err)
+		p2, err = seal(msg(1000), MessageTypeEncryption, ourBrand, 500)
+		require.NoError(t, err)
+		require.Equal(t, len(p1), len(p2))
+		_, _, _, err = Armor62OpenParts(append([]string{p1[0]}, p2[1:]...))
+		require.IsType(t, ErrBadFrame{}, err)
+	}
+}
+
+func TestArmor62PartsHugeCount(t *testing.T) {
+	id, err := newArmorPartID()
+	require.NoError(t, err)
+	for _, total := range []int{1000, 999999999999} {
+		compact, err := armorSeal(msg(10), makeCompactFrame(MessageTypeEncryption, ourBrand, 1, total, id), "", armor62CompactParams)
+		require.NoError(t, err)
+		hdr := makePartFrame(headerMarker, MessageTypeEncryption, ourBrand, 1, total, id)
+		ftr := makePartFrame(footerMarker, MessageTypeEncryption, ourBrand, 1, total, id)
+		regular, err := armorSeal(msg(10), hdr, ftr, Armor62Params)
+		require.NoError(t, err)
+		for _, part := range []string{compact, regular} {
+			_, _, _, err = Armor62OpenParts([]string{part})
+			require.Equal(t, ErrMissingArmorPart(2), err)
+		}
+	}
+}
+
+func TestArmor62PartsBadID(t *testing.T) {
+	parts, err := Armor62SealCompactParts(msg(100), MessageTypeEncryption, ourBrand, 500)
+	require.NoError(t, err)
+	require.Len(t, parts, 1)
+	frame, body, _ := strings.Cut(parts[0], ".")
+	i := strings.LastIndex(frame, compactFrameSeparator)
+	for _, id := range []string{"", "x", frame[i+1:] + "0", "AAAAAAAAAAAAAAA"} {
+		_, _, _, err = Armor62OpenParts([]string{frame[:i+1] + id + "." + body})
+		require.IsType(t, ErrBadFrame{}, err, "id=%q", id)
+	}
+	_, _, _, err = Armor62OpenParts([]string{frame[:i] + "." + body})
+	require.IsType(t, ErrBadFrame{}, err)
+}
+
+func testArmor62PartsDecrypt(t *testing.T, version Version) {
+	plaintext, ciphertext := encryptArmor62RandomData(t, version, 1024)
+	body, _, _, _, err := Armor62OpenWithValidation(ciphertext, nil, nil)
+	require.NoError(t, err)
+	parts, err := Armor62SealParts(body, MessageTypeEncryption, ourBrand, 300)
+	require.NoError(t, err)
+	joined, err := JoinArmor62Parts(parts)
+	require.NoError(t, err)
+	_, plaintext2, brand, err := Dearmor62DecryptOpen(SingleVersionValidator(version), joined, kr)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, plaintext2)
+	brandCheck(t, brand)
+}
+
+func TestArmor62PartsDecrypt(t *testing.T) {
+	tests := []func(*testing.T, Version){
+		testArmor62PartsDecrypt,
+	}
+	runTestsOverVersions(t, "test", tests)
+}
+
+func TestArmor62Compact(t *testing.T) {
+	m := msg(300)
+	a, err := Armor62SealCompact(m, MessageTypeAttachedSignature, ourBrand)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(a, "ACME-SALTPACK-S."))
+	require.False(t, strings.ContainsAny(a, " \n"))
+	m2, typ, brand, err := Armor62OpenCompact(a)
+	require.NoError(t, err)
+	require.Equal(t, m, m2)
+	require.Equal(t, MessageTypeAttachedSignature, typ)
+	brandCheck(t, brand)
+
+	_, err = Armor62SealCompact(m, MessageTypeAttachedSignature, "AC ME")
+	require.IsType(t, ErrInvalidParameter{}, err)
+	_, _, _, err = Armor62OpenCompact(a[:len(a)-1])
+	require.IsType(t, ErrBadFrame{}, err)
+	_, _, _, err = Armor62OpenCompact(a + "abc")
+	require.IsType(t, ErrBadFrame{}, err)
+	_, _, _, err = Armor62OpenCompact(a + ".")
+	require.IsType(t, ErrBadFrame{}, err)
+	_, _, _, err = Armor62OpenCompact(strings.Repeat("A", maxFrameLength+10) + a)
+	require.IsType(t, ErrBadFrame{}, err)
+}
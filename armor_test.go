@@ -6,9 +6,11 @@ package saltpack
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"io"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -138,6 +140,82 @@ func TestBinaryInput(t *testing.T) {
 	}
 
 	// Armor62Open should try to find the punctuation for the
-	// header and hit EOF.
-	require.Equal(t, io.ErrUnexpectedEOF, err, "Armor62Open didn't return io.ErrUnexpectedEOF: m == %v, hdr == %q, ftr == %q, err == %v", m, hdr, ftr, err)
+	// header and hit EOF. The error comes wrapped in an ErrBadArmor,
+	// which is a breaking change (see CHANGELOG.md).
+	require.IsType(t, ErrBadArmor{}, err)
+	require.Equal(t, io.ErrUnexpectedEOF, errors.Unwrap(err), "Armor62Open didn't return io.ErrUnexpectedEOF: m == %v, hdr == %q, ftr == %q, err == %v", m, hdr, ftr, err)
+}
+
+func TestArmorErrorPositions(t *testing.T) {
+	a, err := Armor62Seal(msg(16384), MessageTypeEncryption, ourBrand)
+	require.NoError(t, err)
+
+	// Corrupt the first character of the third line of the body.
+	lines := strings.SplitAfter(a, "\n")
+	require.True(t, len(lines) > 3)
+	offset := len(lines[0]) + len(lines[1])
+	bad := a[:offset] + "!" + a[offset+1:]
+	_, _, _, err = Armor62Open(bad)
+	var badArmor ErrBadArmor
+	require.ErrorAs(t, err, &badArmor)
+	require.Equal(t, ArmorSectionBody, badArmor.Section)
+	require.Equal(t, ArmorPosition{Offset: offset, Line: 3, Column: 1}, badArmor.Position)
+
+	// Trailing garbage is positioned after the footer.
+	_, _, _, err = Armor62Open(a + "\n!")
+	require.ErrorAs(t, err, &badArmor)
+	require.ErrorIs(t, err, ErrTrailingGarbage)
+	require.Equal(t, ArmorSectionTrailer, badArmor.Section)
+	require.Equal(t, ArmorPosition{Offset: len(a) + 1, Line: len(lines) + 1, Column: 1}, badArmor.Position)
+
+	// A header that never ends overflows.
+	_, _, _, err = Armor62Open(strings.Repeat("A", 10000))
+	require.ErrorAs(t, err, &badArmor)
+	require.ErrorIs(t, err, ErrOverflow)
+	require.Equal(t, ArmorSectionHeader, badArmor.Section)
+}
+
+func TestArmorFrameMismatch(t *testing.T) {
+	a, err := Armor62Seal(msg(100), MessageTypeEncryption, ourBrand)
+	require.NoError(t, err)
+	i := strings.LastIndex(a, ftr)
+	bad := a[:i] + "END ACNE SALTPACK ENCRYPTED MESSAGE."
+	_, _, _, _, err = Armor62OpenWithValidation(bad, nil, armor62EncryptionFrameChecker)
+	var badFrame ErrBadFrame
+	require.ErrorAs(t, err, &badFrame)
+	require.Equal(t, ArmorSectionFooter, badFrame.Section)
+	require.Equal(t, ftr, badFrame.Expected)
+	require.Equal(t, "END ACNE SALTPACK ENCRYPTED MESSAGE", badFrame.Found)
+	require.Equal(t, i, badFrame.Position.Offset)
+
+	signed := strings.Replace(a, hdr, "BEGIN ACME SALTPACK SIGNED MESSAGE", 1)
+	_, _, _, _, err = Armor62OpenWithValidation(signed, armor62EncryptionHeaderChecker, nil)
+	require.ErrorAs(t, err, &badFrame)
+	require.Equal(t, ArmorSectionHeader, badFrame.Section)
+	require.Equal(t, hdr, badFrame.Expected)
+	require.Equal(t, "BEGIN ACME SALTPACK SIGNED MESSAGE", badFrame.Found)
+	require.Equal(t, ArmorPosition{Offset: 0, Line: 1, Column: 1}, badFrame.Position)
+}
+
+func TestArmor62Secret(t *testing.T) {
+	m := msg(1000)
+	a, err := Armor62SealSecret(m, MessageTypeEncryption, ourBrand)
+	require.NoError(t, err)
+	a2, err := Armor62Seal(m, MessageTypeEncryption, ourBrand)
+	require.NoError(t, err)
+	require.Equal(t, a2, a)
+
+	m2, _, hdr2, ftr2, err := Armor62OpenSecretWithValidation(a, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, m, m2)
+	require.Equal(t, hdr, hdr2)
+	require.Equal(t, ftr, ftr2)
+
+	// Corruption is still reported in the body.
+	i := strings.Index(a, ".") + 5
+	_, _, _, _, err = Armor62OpenSecretWithValidation(a[:i]+"!"+a[i+1:], nil, nil)
+	var armorErr ErrBadArmor
+	require.ErrorAs(t, err, &armorErr)
+	require.Equal(t, ArmorSectionBody, armorErr.Section)
+	require.Equal(t, i, armorErr.Position.Offset)
 }
@@ -0,0 +1,105 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrBadAgeKey is returned when parsing something that isn't an age
+// X25519 identity or recipient.
+var ErrBadAgeKey = errors.New("malformed age key")
+
+const (
+	ageIdentityHRP  = "age-secret-key-"
+	ageRecipientHRP = "age"
+)
+
+// ParseAgeIdentity parses an age X25519 identity, "AGE-SECRET-KEY-1...",
+// as a box secret key. age identities and saltpack box keys are both
+// X25519 keys, so the same key works for both.
+func ParseAgeIdentity(s string) (*SecretKey, error) {
+	hrp, data, err := bech32Decode(strings.TrimSpace(s))
+	defer clear(data)
+	if err != nil || hrp != ageIdentityHRP || len(data) != 32 {
+		return nil, ErrBadAgeKey
+	}
+	sk := secretKeyFromScalar((*[32]byte)(data))
+	return &sk, nil
+}
+
+// FormatAgeIdentity formats k as an age X25519 identity.
+func FormatAgeIdentity(k SecretKey) string {
+	s, err := bech32Encode(ageIdentityHRP, k.sec[:])
+	if err != nil {
+		panic(err)
+	}
+	return strings.ToUpper(s)
+}
+
+// ParseAgeRecipient parses an age X25519 recipient, "age1...", as a box
+// public key.
+func ParseAgeRecipient(s string) (PublicKey, error) {
+	hrp, data, err := bech32Decode(strings.TrimSpace(s))
+	if err != nil || hrp != ageRecipientHRP || len(data) != 32 {
+		return PublicKey{}, ErrBadAgeKey
+	}
+	var pk PublicKey
+	copy(pk.RawBoxKey[:], data)
+	return pk, nil
+}
+
+// FormatAgeRecipient formats k as an age X25519 recipient.
+func FormatAgeRecipient(k PublicKey) string {
+	s, err := bech32Encode(ageRecipientHRP, k.RawBoxKey[:])
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// ImportAgeIdentities imports the identities in an age identity file, like
+// the output of age-keygen, as box keys. Blank lines and lines starting
+// with '#' are skipped.
+func (k *Keyring) ImportAgeIdentities(r io.Reader) ([]*SecretKey, error) {
+	var keys []*SecretKey
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sk, err := ParseAgeIdentity(line)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, sk)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, sk := range keys {
+		k.encKeys[sk.pub] = *sk
+	}
+	return keys, nil
+}
+
+// ExportAgeIdentity returns the box key for pub as an age identity file,
+// which age can decrypt with.
+func (k *Keyring) ExportAgeIdentity(pub PublicKey) (string, error) {
+	k.mu.RLock()
+	sk, ok := k.encKeys[pub]
+	k.mu.RUnlock()
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	defer sk.Wipe()
+	return "# public key: " + FormatAgeRecipient(pub) + "\n" + FormatAgeIdentity(sk) + "\n", nil
+}
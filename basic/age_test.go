@@ -0,0 +1,92 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+// From age's cmd/age/testdata/x25519.txt.
+const (
+	testAgeIdentity  = "AGE-SECRET-KEY-1EGTZVFFV20835NWYV6270LXYVK2VKNX2MMDKWYKLMGR48UAWX40Q2P2LM0"
+	testAgeRecipient = "age1xmwwc06ly3ee5rytxm9mflaz2u56jjj36s0mypdrwsvlul66mv4q47ryef"
+)
+
+func TestAgeKeys(t *testing.T) {
+	sk, err := ParseAgeIdentity(testAgeIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := ParseAgeRecipient(testAgeRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sk.pub != pk {
+		t.Fatal("identity doesn't match recipient")
+	}
+	if s := FormatAgeIdentity(*sk); s != testAgeIdentity {
+		t.Fatalf("got identity %s", s)
+	}
+	if s := FormatAgeRecipient(pk); s != testAgeRecipient {
+		t.Fatalf("got recipient %s", s)
+	}
+
+	for _, bad := range []string{
+		testAgeRecipient[:len(testAgeRecipient)-1] + "q",
+		strings.ToLower(testAgeIdentity[:20]) + testAgeIdentity[20:],
+		testAgeRecipient[:20],
+	} {
+		if _, err = ParseAgeRecipient(bad); err != ErrBadAgeKey {
+			t.Fatalf("parsed bad recipient %q: %v", bad, err)
+		}
+	}
+	if _, err = ParseAgeIdentity(testAgeRecipient); err != ErrBadAgeKey {
+		t.Fatalf("parsed recipient as identity: %v", err)
+	}
+}
+
+func TestAgeKeyring(t *testing.T) {
+	identityFile := "# created: 2021-02-02T13:09:43+01:00\n" +
+		"# public key: " + testAgeRecipient + "\n" + testAgeIdentity + "\n\n"
+	kr := NewKeyring()
+	keys, err := kr.ImportAgeIdentities(strings.NewReader(identityFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("imported %d keys", len(keys))
+	}
+
+	recipient, err := ParseAgeRecipient(testAgeRecipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := randomMsg(t, 1024)
+	ciphertext, err := saltpack.Seal(saltpack.Version2(), msg, nil, []saltpack.BoxPublicKey{recipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, plaintext, err := saltpack.Open(saltpack.SingleVersionValidator(saltpack.Version2()), ciphertext, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, msg) {
+		t.Fatal("bad plaintext")
+	}
+
+	exported, err := kr.ExportAgeIdentity(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(exported, "\n"+testAgeIdentity+"\n") {
+		t.Fatalf("bad export %q", exported)
+	}
+	if _, err = kr.ExportAgeIdentity(PublicKey{}); err != ErrKeyNotFound {
+		t.Fatalf("wanted ErrKeyNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"crypto/sha512"
+	"errors"
+
+	"filippo.io/edwards25519"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrBadSigningPublicKey is returned when converting a signing public key
+// that isn't a valid Ed25519 point into a box key.
+var ErrBadSigningPublicKey = errors.New("invalid Ed25519 public key")
+
+// BoxPublicKeyFromSigningPublicKey converts an Ed25519 signing public key
+// into the X25519 box public key of the same identity, using the
+// birational map from Edwards to Montgomery form. The matching secret key
+// is the one BoxSecretKeyFromSigningSecretKey returns.
+func BoxPublicKeyFromSigningPublicKey(pub SigningPublicKey) (PublicKey, error) {
+	p, err := new(edwards25519.Point).SetBytes(pub[:])
+	if err != nil {
+		return PublicKey{}, ErrBadSigningPublicKey
+	}
+	var ret PublicKey
+	copy(ret.RawBoxKey[:], p.BytesMontgomery())
+	return ret, nil
+}
+
+// BoxSecretKeyFromSigningSecretKey converts an Ed25519 signing secret key
+// into the X25519 box secret key of the same identity, which is the
+// clamped first half of the SHA-512 hash of its seed, the same scalar that
+// Ed25519 signs with.
+func BoxSecretKeyFromSigningSecretKey(k SigningSecretKey) SecretKey {
+	h := sha512.Sum512(k.sec[:ed25519.SeedSize])
+	defer clear(h[:])
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+
+	return secretKeyFromScalar((*[32]byte)(h[:32]))
+}
+
+// secretKeyFromScalar makes a SecretKey from a raw X25519 secret key,
+// computing its public key.
+func secretKeyFromScalar(sec *[32]byte) SecretKey {
+	var pub [32]byte
+	pubSlice, err := curve25519.X25519(sec[:], curve25519.Basepoint)
+	if err != nil {
+		// Only possible for a low-order point, and the base point
+		// isn't one.
+		panic(err)
+	}
+	copy(pub[:], pubSlice)
+	return NewSecretKey(&pub, sec)
+}
@@ -0,0 +1,426 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"crypto/mlkem"
+	"crypto/subtle"
+	"encoding"
+	"fmt"
+	"sort"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+)
+
+// keyFormatVersion is the version of the serialization format for keys
+// and keyrings.
+func keyFormatVersion() saltpack.Version {
+	return saltpack.Version{Major: 1, Minor: 0}
+}
+
+// serializedKeys is the msgpack form of an exported key or keyring. Type
+// is one of saltpack.MessageTypePublicKey, MessageTypeSecretKey or
+// MessageTypeKeyring; the first two have exactly one key.
+type serializedKeys struct {
+	_struct    bool                 `codec:",toarray"` //nolint
+	FormatName string               `codec:"format_name"`
+	Version    saltpack.Version     `codec:"vers"`
+	Type       saltpack.MessageType `codec:"type"`
+	Keys       []serializedKey      `codec:"keys"`
+}
+
+// serializedKey is a single key. Secret is empty for public keys.
+type serializedKey struct {
+	_struct bool             `codec:",toarray"` //nolint
+	Type    saltpack.KeyType `codec:"type"`
+	Public  []byte           `codec:"public"`
+	Secret  []byte           `codec:"secret"`
+}
+
+func codecHandle() *codec.MsgpackHandle {
+	var mh codec.MsgpackHandle
+	mh.WriteExt = true
+	return &mh
+}
+
+// Exportable is implemented by the key types in this package, and by
+// *Keyring, all of which can be serialized with MarshalBinary, and
+// armored with ArmorKey.
+type Exportable interface {
+	encoding.BinaryMarshaler
+	exportKeys() (saltpack.MessageType, []serializedKey)
+}
+
+func marshalKeys(k Exportable) ([]byte, error) {
+	typ, keys := k.exportKeys()
+	sk := serializedKeys{
+		FormatName: saltpack.FormatName,
+		Version:    keyFormatVersion(),
+		Type:       typ,
+		Keys:       keys,
+	}
+	var ret []byte
+	err := codec.NewEncoderBytes(&ret, codecHandle()).Encode(sk)
+	return ret, err
+}
+
+func unmarshalKeys(b []byte) (*serializedKeys, error) {
+	var sk serializedKeys
+	if err := codec.NewDecoderBytes(b, codecHandle()).Decode(&sk); err != nil {
+		return nil, saltpack.ErrBadSerializedKey(err.Error())
+	}
+	if sk.FormatName != saltpack.FormatName {
+		return nil, saltpack.ErrBadSerializedKey(fmt.Sprintf("bad format name %q", sk.FormatName))
+	}
+	if sk.Version.Major != keyFormatVersion().Major {
+		return nil, saltpack.ErrBadSerializedKey(fmt.Sprintf("unsupported version %s", sk.Version))
+	}
+	switch sk.Type {
+	case saltpack.MessageTypePublicKey, saltpack.MessageTypeSecretKey:
+		if len(sk.Keys) != 1 {
+			return nil, saltpack.ErrBadSerializedKey(fmt.Sprintf("%d keys in %s", len(sk.Keys), sk.Type))
+		}
+	case saltpack.MessageTypeKeyring:
+	default:
+		return nil, saltpack.ErrBadSerializedKey(fmt.Sprintf("unexpected type %s", sk.Type))
+	}
+	for _, key := range sk.Keys {
+		if err := key.check(sk.Type != saltpack.MessageTypePublicKey); err != nil {
+			return nil, err
+		}
+	}
+	return &sk, nil
+}
+
+// check makes sure the key is well-formed, and that its halves match.
+func (k serializedKey) check(isSecret bool) error {
+	switch k.Type {
+	case saltpack.KeyTypeCurve25519, saltpack.KeyTypeEd25519, saltpack.KeyTypeX25519MLKEM768:
+	default:
+		return saltpack.ErrBadSerializedKey(fmt.Sprintf("unknown key type 0x%02x", byte(k.Type)))
+	}
+	if len(k.Public) != k.Type.PublicKeyLen() {
+		return saltpack.ErrBadSerializedKey(fmt.Sprintf("bad public key length %d for %s", len(k.Public), k.Type))
+	}
+	if k.Type == saltpack.KeyTypeX25519MLKEM768 {
+		if _, err := mlkem.NewEncapsulationKey768(k.Public[32:]); err != nil {
+			return saltpack.ErrBadSerializedKey("bad ML-KEM-768 public key")
+		}
+	}
+	if !isSecret {
+		if len(k.Secret) != 0 {
+			return saltpack.ErrBadSerializedKey("secret key in a public key")
+		}
+		return nil
+	}
+	switch k.Type {
+	case saltpack.KeyTypeCurve25519:
+		if len(k.Secret) != 32 {
+			return saltpack.ErrBadSerializedKey(fmt.Sprintf("bad secret key length %d for %s", len(k.Secret), k.Type))
+		}
+		pub, err := curve25519.X25519(k.Secret, curve25519.Basepoint)
+		if err != nil || subtle.ConstantTimeCompare(pub, k.Public) != 1 {
+			return saltpack.ErrBadSerializedKey("secret key doesn't match public key")
+		}
+	case saltpack.KeyTypeX25519MLKEM768:
+		if len(k.Secret) != 32+mlkem.SeedSize {
+			return saltpack.ErrBadSerializedKey(fmt.Sprintf("bad secret key length %d for %s", len(k.Secret), k.Type))
+		}
+		pub, err := curve25519.X25519(k.Secret[:32], curve25519.Basepoint)
+		if err != nil || subtle.ConstantTimeCompare(pub, k.Public[:32]) != 1 {
+			return saltpack.ErrBadSerializedKey("secret key doesn't match public key")
+		}
+		dk, err := mlkem.NewDecapsulationKey768(k.Secret[32:])
+		if err != nil || !bytes.Equal(dk.EncapsulationKey().Bytes(), k.Public[32:]) {
+			return saltpack.ErrBadSerializedKey("secret key doesn't match public key")
+		}
+	case saltpack.KeyTypeEd25519:
+		if len(k.Secret) != ed25519.PrivateKeySize {
+			return saltpack.ErrBadSerializedKey(fmt.Sprintf("bad secret key length %d for %s", len(k.Secret), k.Type))
+		}
+		sec := ed25519.NewKeyFromSeed(k.Secret[:ed25519.SeedSize])
+		if subtle.ConstantTimeCompare(sec, k.Secret) != 1 || !bytes.Equal(k.Public, sec[ed25519.SeedSize:]) {
+			return saltpack.ErrBadSerializedKey("secret key doesn't match public key")
+		}
+	}
+	return nil
+}
+
+// toExportable turns a checked key into the corresponding key type in
+// this package.
+func (k serializedKey) toExportable(isSecret bool) Exportable {
+	switch {
+	case k.Type == saltpack.KeyTypeCurve25519 && isSecret:
+		return NewSecretKey((*[32]byte)(k.Public), (*[32]byte)(k.Secret))
+	case k.Type == saltpack.KeyTypeCurve25519:
+		return PublicKey{RawBoxKey: saltpack.RawBoxKey(k.Public)}
+	case k.Type == saltpack.KeyTypeX25519MLKEM768 && isSecret:
+		return NewHybridSecretKey((*[32]byte)(k.Public[:32]), (*[32]byte)(k.Secret[:32]), (*[mlkem.SeedSize]byte)(k.Secret[32:]))
+	case k.Type == saltpack.KeyTypeX25519MLKEM768:
+		return HybridPublicKey{
+			PublicKey:        PublicKey{RawBoxKey: saltpack.RawBoxKey(k.Public[:32])},
+			EncapsulationKey: bytes.Clone(k.Public[32:]),
+		}
+	case k.Type == saltpack.KeyTypeEd25519 && isSecret:
+		return NewSigningSecretKey((*[ed25519.PublicKeySize]byte)(k.Public), (*[ed25519.PrivateKeySize]byte)(k.Secret))
+	case k.Type == saltpack.KeyTypeEd25519:
+		return NewSigningPublicKey((*[ed25519.PublicKeySize]byte)(k.Public))
+	default:
+		panic(fmt.Sprintf("unchecked key type %s", k.Type)) // should be statically impossible, since check rejects other types
+	}
+}
+
+// ParseKey parses the output of MarshalBinary on any of the key types
+// in this package, or on a Keyring. It returns a PublicKey, SecretKey,
+// HybridPublicKey, HybridSecretKey, SigningPublicKey, SigningSecretKey or
+// *Keyring, accordingly.
+func ParseKey(b []byte) (Exportable, error) {
+	sk, err := unmarshalKeys(b)
+	if err != nil {
+		return nil, err
+	}
+	switch sk.Type {
+	case saltpack.MessageTypePublicKey:
+		return sk.Keys[0].toExportable(false), nil
+	case saltpack.MessageTypeSecretKey:
+		return sk.Keys[0].toExportable(true), nil
+	default:
+		ret := NewKeyring()
+		ret.importKeys(sk.Keys)
+		return ret, nil
+	}
+}
+
+// ArmorKey armors the given key or keyring in a "BEGIN SALTPACK PUBLIC
+// KEY", "BEGIN SALTPACK SECRET KEY" or "BEGIN SALTPACK SECRET KEYRING"
+// frame, with an optional brand. Secret keys are encoded in constant time.
+func ArmorKey(k Exportable, brand string) (string, error) {
+	b, err := marshalKeys(k)
+	if err != nil {
+		return "", err
+	}
+	typ, _ := k.exportKeys()
+	if typ == saltpack.MessageTypePublicKey {
+		return saltpack.Armor62Seal(b, typ, brand)
+	}
+	return saltpack.Armor62SealSecret(b, typ, brand)
+}
+
+// DearmorKey parses the output of ArmorKey, returning the key and the
+// brand, if any. Bodies are decoded in constant time, since they might
+// be secret.
+func DearmorKey(armored string) (k Exportable, brand string, err error) {
+	body, _, header, footer, err := saltpack.Armor62OpenSecretWithValidation(armored, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	typ := saltpack.MessageTypeUnknown
+	for _, t := range []saltpack.MessageType{saltpack.MessageTypePublicKey, saltpack.MessageTypeSecretKey, saltpack.MessageTypeKeyring} {
+		if brand, err = saltpack.CheckArmor62(header, footer, t); err == nil {
+			typ = t
+			break
+		}
+	}
+	if typ == saltpack.MessageTypeUnknown {
+		return nil, "", saltpack.ErrBadSerializedKey(fmt.Sprintf("not an armored key: %q", header))
+	}
+	if k, err = ParseKey(body); err != nil {
+		return nil, "", err
+	}
+	if got, _ := k.exportKeys(); got != typ {
+		return nil, "", saltpack.ErrWrongMessageType{Wanted: typ, Received: got}
+	}
+	return k, brand, nil
+}
+
+// unmarshalSingleKey parses b, which must hold a single key of the given
+// type, public or secret.
+func unmarshalSingleKey(b []byte, typ saltpack.KeyType, isSecret bool) (serializedKey, error) {
+	sk, err := unmarshalKeys(b)
+	if err != nil {
+		return serializedKey{}, err
+	}
+	wanted := saltpack.MessageTypePublicKey
+	if isSecret {
+		wanted = saltpack.MessageTypeSecretKey
+	}
+	if sk.Type != wanted {
+		return serializedKey{}, saltpack.ErrWrongMessageType{Wanted: wanted, Received: sk.Type}
+	}
+	if sk.Keys[0].Type != typ {
+		return serializedKey{}, saltpack.ErrWrongKeyType{Wanted: typ, Received: sk.Keys[0].Type}
+	}
+	return sk.Keys[0], nil
+}
+
+// ToTypedKID returns a key ID for k that says it's a Curve25519 box key.
+// See saltpack.TypedKID.
+func (k PublicKey) ToTypedKID() []byte {
+	return saltpack.TypedKID(saltpack.KeyTypeCurve25519, k.ToKID())
+}
+
+func (k PublicKey) exportKeys() (saltpack.MessageType, []serializedKey) {
+	return saltpack.MessageTypePublicKey, []serializedKey{{Type: saltpack.KeyTypeCurve25519, Public: k.ToKID()}}
+}
+
+// MarshalBinary serializes the public key.
+func (k PublicKey) MarshalBinary() ([]byte, error) {
+	return marshalKeys(k)
+}
+
+// UnmarshalBinary parses a public key serialized by MarshalBinary.
+func (k *PublicKey) UnmarshalBinary(b []byte) error {
+	key, err := unmarshalSingleKey(b, saltpack.KeyTypeCurve25519, false)
+	if err != nil {
+		return err
+	}
+	*k = key.toExportable(false).(PublicKey)
+	return nil
+}
+
+func (k SecretKey) exportKeys() (saltpack.MessageType, []serializedKey) {
+	return saltpack.MessageTypeSecretKey, []serializedKey{k.serialize()}
+}
+
+func (k SecretKey) serialize() serializedKey {
+	return serializedKey{Type: saltpack.KeyTypeCurve25519, Public: k.pub.ToKID(), Secret: k.sec[:]}
+}
+
+// MarshalBinary serializes the secret key, along with its public key.
+func (k SecretKey) MarshalBinary() ([]byte, error) {
+	return marshalKeys(k)
+}
+
+// UnmarshalBinary parses a secret key serialized by MarshalBinary.
+func (k *SecretKey) UnmarshalBinary(b []byte) error {
+	key, err := unmarshalSingleKey(b, saltpack.KeyTypeCurve25519, true)
+	if err != nil {
+		return err
+	}
+	*k = key.toExportable(true).(SecretKey)
+	return nil
+}
+
+// ToTypedKID returns a key ID for k that says it's an Ed25519 signing key.
+// See saltpack.TypedKID.
+func (k SigningPublicKey) ToTypedKID() []byte {
+	return saltpack.TypedKID(saltpack.KeyTypeEd25519, k.ToKID())
+}
+
+func (k SigningPublicKey) exportKeys() (saltpack.MessageType, []serializedKey) {
+	return saltpack.MessageTypePublicKey, []serializedKey{{Type: saltpack.KeyTypeEd25519, Public: k.ToKID()}}
+}
+
+// MarshalBinary serializes the public signing key.
+func (k SigningPublicKey) MarshalBinary() ([]byte, error) {
+	return marshalKeys(k)
+}
+
+// UnmarshalBinary parses a public signing key serialized by MarshalBinary.
+func (k *SigningPublicKey) UnmarshalBinary(b []byte) error {
+	key, err := unmarshalSingleKey(b, saltpack.KeyTypeEd25519, false)
+	if err != nil {
+		return err
+	}
+	*k = key.toExportable(false).(SigningPublicKey)
+	return nil
+}
+
+func (k SigningSecretKey) exportKeys() (saltpack.MessageType, []serializedKey) {
+	return saltpack.MessageTypeSecretKey, []serializedKey{k.serialize()}
+}
+
+func (k SigningSecretKey) serialize() serializedKey {
+	return serializedKey{Type: saltpack.KeyTypeEd25519, Public: k.pub.ToKID(), Secret: k.sec[:]}
+}
+
+// MarshalBinary serializes the secret signing key, along with its public
+// key.
+func (k SigningSecretKey) MarshalBinary() ([]byte, error) {
+	return marshalKeys(k)
+}
+
+// UnmarshalBinary parses a secret signing key serialized by MarshalBinary.
+func (k *SigningSecretKey) UnmarshalBinary(b []byte) error {
+	key, err := unmarshalSingleKey(b, saltpack.KeyTypeEd25519, true)
+	if err != nil {
+		return err
+	}
+	*k = key.toExportable(true).(SigningSecretKey)
+	return nil
+}
+
+// exportKeys returns all the secret keys in the keyring, box keys first,
+// each sorted by public key so the output is deterministic.
+func (k *Keyring) exportKeys() (saltpack.MessageType, []serializedKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var boxKeys, sigKeys []serializedKey
+	for _, sk := range k.hybridKeys {
+		boxKeys = append(boxKeys, sk.serialize())
+	}
+	for _, sk := range k.encKeys {
+		boxKeys = append(boxKeys, sk.serialize())
+	}
+	for _, sk := range k.sigKeys {
+		sigKeys = append(sigKeys, sk.serialize())
+	}
+	for _, keys := range [][]serializedKey{boxKeys, sigKeys} {
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i].Public, keys[j].Public) < 0
+		})
+	}
+	return saltpack.MessageTypeKeyring, append(boxKeys, sigKeys...)
+}
+
+func (k *Keyring) importKeys(keys []serializedKey) {
+	for _, key := range keys {
+		switch sk := key.toExportable(true).(type) {
+		case SecretKey:
+			k.encKeys[sk.pub] = sk
+		case HybridSecretKey:
+			k.addHybridKeyLocked(sk)
+		case SigningSecretKey:
+			k.sigKeys[sk.pub] = sk
+		}
+	}
+}
+
+// MarshalBinary serializes all the secret keys in the keyring.
+func (k *Keyring) MarshalBinary() ([]byte, error) {
+	return marshalKeys(k)
+}
+
+// UnmarshalBinary replaces the contents of the keyring with the keys
+// serialized by MarshalBinary. Generations aren't serialized, so the keyring
+// is left without any.
+func (k *Keyring) UnmarshalBinary(b []byte) error {
+	sk, err := unmarshalKeys(b)
+	if err != nil {
+		return err
+	}
+	if sk.Type != saltpack.MessageTypeKeyring {
+		return saltpack.ErrWrongMessageType{Wanted: saltpack.MessageTypeKeyring, Received: sk.Type}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.encKeys = make(map[PublicKey]SecretKey)
+	k.hybridKeys = make(map[PublicKey]*HybridSecretKey)
+	k.sigKeys = make(map[SigningPublicKey]SigningSecretKey)
+	k.generations = nil
+	k.importKeys(sk.Keys)
+	return nil
+}
+
+var (
+	_ Exportable = PublicKey{}
+	_ Exportable = SecretKey{}
+	_ Exportable = SigningPublicKey{}
+	_ Exportable = SigningSecretKey{}
+	_ Exportable = (*Keyring)(nil)
+)
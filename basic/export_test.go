@@ -0,0 +1,202 @@
+package basic
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+)
+
+func TestExportRoundTrip(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr.ImportSigningKey(sk.GetRawPublicKey(), sk.GetRawSecretKey())
+
+	for _, k := range []Exportable{bk.pub, *bk, sk.pub, *sk, kr} {
+		b, err := k.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, err := ParseKey(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2, err := k2.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, b2) {
+			t.Fatalf("%T didn't round-trip", k)
+		}
+
+		armored, err := ArmorKey(k, "ACME")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k3, brand, err := DearmorKey(armored)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if brand != "ACME" {
+			t.Fatalf("wrong brand %q", brand)
+		}
+		b3, err := k3.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, b3) {
+			t.Fatalf("armored %T didn't round-trip", k)
+		}
+	}
+
+	kr2 := NewKeyring()
+	b, err := kr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kr2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, k := kr2.LookupBoxSecretKey([][]byte{bk.pub.ToKID()}); k == nil {
+		t.Fatal("box key missing from imported keyring")
+	}
+	if len(kr2.sigKeys) != 1 {
+		t.Fatal("signing key missing from imported keyring")
+	}
+}
+
+func TestExportArmorFrames(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		k      Exportable
+		header string
+	}{
+		{bk.pub, "BEGIN SALTPACK PUBLIC KEY."},
+		{*bk, "BEGIN SALTPACK SECRET KEY."},
+		{kr, "BEGIN SALTPACK SECRET KEYRING."},
+	} {
+		armored, err := ArmorKey(test.k, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(armored, test.header) {
+			t.Fatalf("bad armor header for %T: %q", test.k, armored)
+		}
+	}
+
+	sig, err := saltpack.Armor62Seal([]byte("hello"), saltpack.MessageTypeAttachedSignature, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DearmorKey(sig)
+	var badKey saltpack.ErrBadSerializedKey
+	if !errors.As(err, &badKey) {
+		t.Fatalf("wanted ErrBadSerializedKey, got %v", err)
+	}
+}
+
+func TestExportKeyTypeConfusion(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := sk.pub.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk PublicKey
+	err = pk.UnmarshalBinary(b)
+	if _, ok := err.(saltpack.ErrWrongKeyType); !ok {
+		t.Fatalf("wanted ErrWrongKeyType, got %v", err)
+	}
+
+	b, err = bk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ssk SigningSecretKey
+	err = ssk.UnmarshalBinary(b)
+	if _, ok := err.(saltpack.ErrWrongKeyType); !ok {
+		t.Fatalf("wanted ErrWrongKeyType, got %v", err)
+	}
+	err = pk.UnmarshalBinary(b)
+	if _, ok := err.(saltpack.ErrWrongMessageType); !ok {
+		t.Fatalf("wanted ErrWrongMessageType, got %v", err)
+	}
+
+	// A secret key that doesn't match its public key is rejected.
+	bad := serializedKey{Type: saltpack.KeyTypeCurve25519, Public: sk.pub.ToKID(), Secret: bk.sec[:]}
+	if _, ok := bad.check(true).(saltpack.ErrBadSerializedKey); !ok {
+		t.Fatal("mismatched box key halves accepted")
+	}
+}
+
+func TestTypedKID(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		tkid []byte
+		typ  saltpack.KeyType
+		kid  []byte
+	}{
+		{bk.pub.ToTypedKID(), saltpack.KeyTypeCurve25519, bk.pub.ToKID()},
+		{sk.pub.ToTypedKID(), saltpack.KeyTypeEd25519, sk.pub.ToKID()},
+	} {
+		typ, kid, err := saltpack.ParseTypedKID(test.tkid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != test.typ || !bytes.Equal(kid, test.kid) {
+			t.Fatalf("typed KID didn't round-trip: %x", test.tkid)
+		}
+	}
+	if _, _, err := saltpack.ParseTypedKID(bk.pub.ToKID()); err != saltpack.ErrBadTypedKID {
+		t.Fatalf("wanted ErrBadTypedKID, got %v", err)
+	}
+}
+
+func TestExportUnknownKeyType(t *testing.T) {
+	for _, typ := range []saltpack.MessageType{saltpack.MessageTypePublicKey, saltpack.MessageTypeSecretKey, saltpack.MessageTypeKeyring} {
+		sk := serializedKeys{
+			FormatName: saltpack.FormatName,
+			Version:    keyFormatVersion(),
+			Type:       typ,
+			Keys:       []serializedKey{{Type: saltpack.KeyType(0x77)}},
+		}
+		var b []byte
+		if err := codec.NewEncoderBytes(&b, codecHandle()).Encode(sk); err != nil {
+			t.Fatal(err)
+		}
+		_, err := ParseKey(b)
+		if _, ok := err.(saltpack.ErrBadSerializedKey); !ok {
+			t.Fatalf("%s: wanted ErrBadSerializedKey, got %v", typ, err)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/saltpack"
+)
+
+// File names in a FileKeyring directory are the hex typed KID of the key,
+// with one of these extensions. Anything else in the directory is ignored.
+const (
+	publicKeyFileExt = ".pub"
+	secretKeyFileExt = ".sec"
+	fileKeyringLock  = "keyring.lock"
+)
+
+// FileKeyringOptions are the options for OpenFileKeyring. The zero value
+// stores secret keys in the clear, and only loads keys when the keyring
+// is opened, or when Reload is called.
+type FileKeyringOptions struct {
+	// Passphrase, if set, is used to encrypt secret keys at rest, and to
+	// decrypt them when they're loaded. Secret keys stored in the clear
+	// are still loaded.
+	Passphrase []byte
+	// KDFParams are used when encrypting secret keys. If nil,
+	// DefaultKDFParams are used.
+	KDFParams *KDFParams
+	// HotReload makes lookups check whether the directory has changed,
+	// and reload it if so, so that keys added or removed by other
+	// processes show up.
+	HotReload bool
+}
+
+// fileKeyringEntry is a key we've loaded, along with what we knew about
+// its file, so that we don't have to parse (and maybe decrypt) it again
+// unless it changes.
+type fileKeyringEntry struct {
+	modTime time.Time
+	size    int64
+	key     Exportable
+}
+
+// FileKeyring is a saltpack.SigncryptKeyring backed by a directory of key
+// files, one per key, that can be shared by several processes. Keys are
+// indexed in memory by key ID, so lookups don't touch the disk, except
+// to check for changes when HotReload is on.
+//
+// Unlike Keyring, LookupSigningPublicKey only returns keys that have been
+// added, either as public keys, or as the public halves of secret keys.
+type FileKeyring struct {
+	EphemeralKeyCreator
+	dir  string
+	opts FileKeyringOptions
+
+	mu         sync.RWMutex
+	dirModTime time.Time
+	entries    map[string]fileKeyringEntry
+	encKeys    map[PublicKey]SecretKey
+	sigKeys    map[SigningPublicKey]SigningSecretKey
+	sigPubKeys map[SigningPublicKey]bool
+}
+
+var _ saltpack.SigncryptKeyring = (*FileKeyring)(nil)
+
+// OpenFileKeyring opens the keyring in dir, creating the directory if it
+// doesn't exist, and loads all the keys in it.
+func OpenFileKeyring(dir string, opts *FileKeyringOptions) (*FileKeyring, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	k := &FileKeyring{
+		dir:     dir,
+		entries: make(map[string]fileKeyringEntry),
+	}
+	if opts != nil {
+		k.opts = *opts
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// withFileLock runs f while holding the lock on the directory, which is
+// shared between processes.
+func (k *FileKeyring) withFileLock(exclusive bool, f func() error) (err error) {
+	lf, err := os.OpenFile(filepath.Join(k.dir, fileKeyringLock), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := lf.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if err = lockFile(lf, exclusive); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlockFile(lf); err == nil {
+			err = unlockErr
+		}
+	}()
+	return f()
+}
+
+// Reload reloads the keys from the directory, picking up any changes made
+// by other processes. Only files that changed are parsed again.
+func (k *FileKeyring) Reload() error {
+	return k.withFileLock(false, func() error {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		return k.loadLocked()
+	})
+}
+
+func (k *FileKeyring) loadLocked() error {
+	info, err := os.Stat(k.dir)
+	if err != nil {
+		return err
+	}
+	dirEntries, err := os.ReadDir(k.dir)
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]fileKeyringEntry)
+	for _, de := range dirEntries {
+		name := de.Name()
+		ext := filepath.Ext(name)
+		if ext != publicKeyFileExt && ext != secretKeyFileExt {
+			continue
+		}
+		fi, err := de.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if old, ok := k.entries[name]; ok && old.modTime.Equal(fi.ModTime()) && old.size == fi.Size() {
+			entries[name] = old
+			continue
+		}
+		key, err := k.readKeyFile(name)
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, errEncryptedKeyFile) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		entries[name] = fileKeyringEntry{modTime: fi.ModTime(), size: fi.Size(), key: key}
+	}
+
+	k.entries = entries
+	k.dirModTime = info.ModTime()
+	k.encKeys = make(map[PublicKey]SecretKey)
+	k.sigKeys = make(map[SigningPublicKey]SigningSecretKey)
+	k.sigPubKeys = make(map[SigningPublicKey]bool)
+	for _, e := range entries {
+		switch key := e.key.(type) {
+		case SecretKey:
+			k.encKeys[key.pub] = key
+		case SigningSecretKey:
+			k.sigKeys[key.pub] = key
+			k.sigPubKeys[key.pub] = true
+		case SigningPublicKey:
+			k.sigPubKeys[key] = true
+		}
+	}
+	return nil
+}
+
+// errEncryptedKeyFile is returned by readKeyFile for secret key files
+// that are encrypted, when the keyring has no passphrase. We skip those,
+// so that a keyring opened without a passphrase can still verify.
+var errEncryptedKeyFile = errors.New("encrypted key file, and no passphrase")
+
+func (k *FileKeyring) readKeyFile(name string) (Exportable, error) {
+	b, err := os.ReadFile(filepath.Join(k.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	var key Exportable
+	if filepath.Ext(name) == secretKeyFileExt && isEncryptedKeyFile(b) {
+		if k.opts.Passphrase == nil {
+			return nil, errEncryptedKeyFile
+		}
+		key, err = DecryptKeys(b, k.opts.Passphrase)
+	} else {
+		key, err = ParseKey(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(*Keyring); ok {
+		return nil, saltpack.ErrBadSerializedKey("keyring in a key file: " + name)
+	}
+	return key, nil
+}
+
+// maybeReload reloads the directory if HotReload is on, and it's changed
+// since we last loaded it. Errors leave the keys as they were, since the
+// lookups can't report them.
+func (k *FileKeyring) maybeReload() {
+	if !k.opts.HotReload {
+		return
+	}
+	info, err := os.Stat(k.dir)
+	if err != nil {
+		return
+	}
+	k.mu.RLock()
+	changed := !info.ModTime().Equal(k.dirModTime)
+	k.mu.RUnlock()
+	if changed {
+		_ = k.Reload()
+	}
+}
+
+func typedKIDForKey(key Exportable) []byte {
+	switch key := key.(type) {
+	case PublicKey:
+		return key.ToTypedKID()
+	case SecretKey:
+		return key.pub.ToTypedKID()
+	case SigningPublicKey:
+		return key.ToTypedKID()
+	case SigningSecretKey:
+		return key.pub.ToTypedKID()
+	default:
+		return nil
+	}
+}
+
+func (k *FileKeyring) writeKeyFile(key Exportable) error {
+	var b []byte
+	var err error
+	ext := publicKeyFileExt
+	switch key.(type) {
+	case SecretKey, SigningSecretKey:
+		ext = secretKeyFileExt
+		if k.opts.Passphrase != nil {
+			b, err = EncryptKeys(key, k.opts.Passphrase, k.opts.KDFParams)
+		} else {
+			b, err = key.MarshalBinary()
+		}
+	default:
+		b, err = key.MarshalBinary()
+	}
+	if err != nil {
+		return err
+	}
+	defer clear(b)
+
+	path := filepath.Join(k.dir, hex.EncodeToString(typedKIDForKey(key))+ext)
+	return writeKeyFileAtomically(path, b)
+}
+
+// Add adds a key to the keyring, writing it to the directory. Secret keys
+// are encrypted if the keyring has a passphrase. Adding a *Keyring adds
+// all of its keys.
+func (k *FileKeyring) Add(key Exportable) error {
+	var keys []Exportable
+	if kr, ok := key.(*Keyring); ok {
+		kr.mu.RLock()
+		for _, sk := range kr.encKeys {
+			keys = append(keys, sk)
+		}
+		for _, sk := range kr.sigKeys {
+			keys = append(keys, sk)
+		}
+		kr.mu.RUnlock()
+	} else {
+		keys = []Exportable{key}
+	}
+	return k.withFileLock(true, func() error {
+		for _, key := range keys {
+			if err := k.writeKeyFile(key); err != nil {
+				return err
+			}
+		}
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		return k.loadLocked()
+	})
+}
+
+// Remove removes the key with the given typed KID (see ToTypedKID), both
+// public and secret halves, from the keyring and the directory. It returns
+// an error satisfying errors.Is(err, os.ErrNotExist) if there's no such
+// key.
+func (k *FileKeyring) Remove(typedKID []byte) error {
+	if _, _, err := saltpack.ParseTypedKID(typedKID); err != nil {
+		return err
+	}
+	base := hex.EncodeToString(typedKID)
+	return k.withFileLock(true, func() error {
+		removed := false
+		for _, ext := range []string{publicKeyFileExt, secretKeyFileExt} {
+			err := os.Remove(filepath.Join(k.dir, base+ext))
+			if err == nil {
+				removed = true
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if !removed {
+			return &os.PathError{Op: "remove", Path: filepath.Join(k.dir, base), Err: os.ErrNotExist}
+		}
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		return k.loadLocked()
+	})
+}
+
+// List returns the typed KIDs of all the keys in the keyring, in sorted
+// order, with each key listed once even if both its halves are present.
+func (k *FileKeyring) List() [][]byte {
+	k.maybeReload()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	seen := make(map[string]bool)
+	for name := range k.entries {
+		seen[strings.TrimSuffix(name, filepath.Ext(name))] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ret [][]byte
+	for _, name := range names {
+		if tkid, err := hex.DecodeString(name); err == nil {
+			ret = append(ret, tkid)
+		}
+	}
+	return ret
+}
+
+// LookupBoxSecretKey tries to find one of the secret keys in its keyring
+// given the possible key IDs. It returns the index and the key, if found, and -1
+// and nil otherwise.
+func (k *FileKeyring) LookupBoxSecretKey(kids [][]byte) (int, saltpack.BoxSecretKey) {
+	k.maybeReload()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for i, kid := range kids {
+		if sk, ok := k.encKeys[kidToPublicKey(kid)]; ok {
+			return i, sk
+		}
+	}
+	return -1, nil
+}
+
+// LookupBoxPublicKey returns the public key that corresponds to the
+// given key ID (or "kid")
+func (k *FileKeyring) LookupBoxPublicKey(kid []byte) saltpack.BoxPublicKey {
+	return kidToPublicKey(kid)
+}
+
+// GetAllBoxSecretKeys returns all secret Box keys in the keyring.
+func (k *FileKeyring) GetAllBoxSecretKeys() []saltpack.BoxSecretKey {
+	k.maybeReload()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var out []saltpack.BoxSecretKey
+	for _, v := range k.encKeys {
+		out = append(out, v)
+	}
+	return out
+}
+
+// ImportBoxEphemeralKey takes a key ID and returns a public key
+// useful for encryption/decryption.
+func (k *FileKeyring) ImportBoxEphemeralKey(kid []byte) saltpack.BoxPublicKey {
+	return kidToPublicKey(kid)
+}
+
+// LookupSigningPublicKey returns the signing public key with the given key
+// ID, if it's been added to the keyring, and nil otherwise.
+func (k *FileKeyring) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
+	k.maybeReload()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	pk := kidToSigningPublicKey(kid)
+	if len(kid) != len(pk) || !k.sigPubKeys[pk] {
+		return nil
+	}
+	return pk
+}
+
+// LookupSigningSecretKey returns the signing secret key whose public key
+// has the given key ID, or nil if it's not in the keyring.
+func (k *FileKeyring) LookupSigningSecretKey(kid []byte) saltpack.SigningSecretKey {
+	k.maybeReload()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	pk := kidToSigningPublicKey(kid)
+	sk, ok := k.sigKeys[pk]
+	if len(kid) != len(pk) || !ok {
+		return nil
+	}
+	return sk
+}
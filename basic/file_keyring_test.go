@@ -0,0 +1,216 @@
+package basic
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+func TestFileKeyring(t *testing.T) {
+	dir := t.TempDir()
+	fk, err := OpenFileKeyring(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []Exportable{*bk, *sk, other.pub} {
+		if err = fk.Add(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(fk.List()); n != 3 {
+		t.Fatalf("wanted 3 keys, got %d", n)
+	}
+
+	if _, k := fk.LookupBoxSecretKey([][]byte{bk.pub.ToKID()}); k == nil {
+		t.Fatal("box key not found")
+	}
+	if fk.LookupSigningPublicKey(other.pub.ToKID()) == nil {
+		t.Fatal("public signing key not found")
+	}
+	if fk.LookupSigningPublicKey(sk.pub.ToKID()) == nil {
+		t.Fatal("public half of secret signing key not found")
+	}
+	if fk.LookupSigningSecretKey(sk.pub.ToKID()) == nil {
+		t.Fatal("secret signing key not found")
+	}
+	if fk.LookupSigningPublicKey(bk.pub.ToKID()) != nil {
+		t.Fatal("found a signing key that was never added")
+	}
+
+	// A message to the box key, from the signing key, opens with a
+	// reopened keyring.
+	msg := randomMsg(t, 1024)
+	sealed, err := saltpack.SigncryptSeal(msg, EphemeralKeyCreator{}, sk, []saltpack.BoxPublicKey{bk.pub}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fk2, err := OpenFileKeyring(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, opened, err := saltpack.SigncryptOpen(sealed, fk2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, opened) {
+		t.Fatal("message mismatch")
+	}
+
+	if err = fk.Remove(bk.pub.ToTypedKID()); err != nil {
+		t.Fatal(err)
+	}
+	if _, k := fk.LookupBoxSecretKey([][]byte{bk.pub.ToKID()}); k != nil {
+		t.Fatal("removed key still found")
+	}
+	if err = fk.Remove(bk.pub.ToTypedKID()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("wanted ErrNotExist, got %v", err)
+	}
+
+	// fk2 doesn't hot reload, so it doesn't notice until it's told to.
+	if _, k := fk2.LookupBoxSecretKey([][]byte{bk.pub.ToKID()}); k == nil {
+		t.Fatal("key gone without a reload")
+	}
+	if err = fk2.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, k := fk2.LookupBoxSecretKey([][]byte{bk.pub.ToKID()}); k != nil {
+		t.Fatal("removed key still found after reload")
+	}
+}
+
+func TestFileKeyringEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	opts := &FileKeyringOptions{Passphrase: []byte("pass"), KDFParams: testKDFParams}
+	fk, err := OpenFileKeyring(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fk.Add(*bk); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"+secretKeyFileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("wanted 1 secret key file, got %d", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, bk.sec[:]) {
+		t.Fatal("secret key stored in the clear")
+	}
+
+	fk2, err := OpenFileKeyring(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, k := fk2.LookupBoxSecretKey([][]byte{bk.pub.ToKID()}); k == nil {
+		t.Fatal("encrypted key not loaded")
+	}
+
+	// Without the passphrase, the secret key is skipped.
+	fk3, err := OpenFileKeyring(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fk3.GetAllBoxSecretKeys()) != 0 {
+		t.Fatal("encrypted key loaded without a passphrase")
+	}
+
+	// With the wrong one, it's an error.
+	_, err = OpenFileKeyring(dir, &FileKeyringOptions{Passphrase: []byte("wrong")})
+	if err != saltpack.ErrBadPassphrase {
+		t.Fatalf("wanted ErrBadPassphrase, got %v", err)
+	}
+}
+
+func TestFileKeyringCorruptKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	fk, err := OpenFileKeyring(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fk.Add(*bk); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+secretKeyFileExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("wanted 1 secret key file, got %d", len(files))
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupt key file isn't mistaken for an encrypted one and skipped,
+	// with or without a passphrase.
+	if err = os.WriteFile(files[0], b[:len(b)-1], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []*FileKeyringOptions{nil, {Passphrase: []byte("pass"), KDFParams: testKDFParams}} {
+		if _, err = OpenFileKeyring(dir, opts); err == nil {
+			t.Fatal("corrupt key file silently skipped")
+		}
+		if errors.Is(err, errEncryptedKeyFile) {
+			t.Fatalf("corrupt key file reported as encrypted: %v", err)
+		}
+	}
+}
+
+func TestFileKeyringHotReload(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := OpenFileKeyring(dir, &FileKeyringOptions{HotReload: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer, err := OpenFileKeyring(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeyring()
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Add(sk.pub); err != nil {
+		t.Fatal(err)
+	}
+	if watcher.LookupSigningPublicKey(sk.pub.ToKID()) == nil {
+		t.Fatal("hot reload didn't pick up the new key")
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoCurrentGeneration is returned when asking for the current keys
+	// of a keyring that's never been rotated.
+	ErrNoCurrentGeneration = errors.New("no current key generation")
+
+	// ErrUnknownGeneration is returned when retiring a generation that
+	// isn't in the keyring.
+	ErrUnknownGeneration = errors.New("unknown key generation")
+
+	// ErrDuplicateGeneration is returned by Rotate if the label is empty,
+	// or already used by another generation in the keyring.
+	ErrDuplicateGeneration = errors.New("key generation label empty or already in use")
+
+	// ErrCurrentGeneration is returned when retiring the current
+	// generation, which would leave nothing to encrypt or sign with.
+	ErrCurrentGeneration = errors.New("can't retire the current key generation")
+
+	// ErrKeyNotFound is returned when removing a key that isn't in the
+	// keyring.
+	ErrKeyNotFound = errors.New("key not found")
+
+	// ErrKeyInGeneration is returned when removing a key that belongs to a
+	// generation. Those are removed with RetireGeneration.
+	ErrKeyInGeneration = errors.New("key belongs to a key generation")
+)
+
+// Generation is a box key and a signing key that were made current
+// together by Rotate. The newest generation is the current one, whose keys
+// new messages should be encrypted and signed with. Older generations are
+// kept so that messages sent to them can still be decrypted, until they're
+// retired.
+type Generation struct {
+	Label      string
+	Created    time.Time
+	BoxKey     PublicKey
+	SigningKey SigningPublicKey
+	Current    bool
+}
+
+// Rotate generates a new box key and signing key, and makes them the
+// current generation, with the given label. The keys of the generation
+// that was current are kept for decryption.
+func (k *Keyring) Rotate(label string) (*Generation, error) {
+	boxKey, err := generateBoxKey()
+	if err != nil {
+		return nil, err
+	}
+	sigKey, err := generateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if label == "" || k.findGenerationLocked(label) >= 0 {
+		return nil, ErrDuplicateGeneration
+	}
+	k.encKeys[boxKey.pub] = *boxKey
+	k.sigKeys[sigKey.pub] = *sigKey
+	k.generations = append(k.generations, Generation{
+		Label:      label,
+		Created:    time.Now(),
+		BoxKey:     boxKey.pub,
+		SigningKey: sigKey.pub,
+	})
+	g := k.generationLocked(len(k.generations) - 1)
+	return &g, nil
+}
+
+// CurrentBoxKey returns the box key of the current generation.
+func (k *Keyring) CurrentBoxKey() (*SecretKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.generations) == 0 {
+		return nil, ErrNoCurrentGeneration
+	}
+	sk := k.encKeys[k.generations[len(k.generations)-1].BoxKey]
+	return &sk, nil
+}
+
+// CurrentSigningKey returns the signing key of the current generation.
+func (k *Keyring) CurrentSigningKey() (*SigningSecretKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.generations) == 0 {
+		return nil, ErrNoCurrentGeneration
+	}
+	sk := k.sigKeys[k.generations[len(k.generations)-1].SigningKey]
+	return &sk, nil
+}
+
+// Generations lists the keyring's generations, oldest first.
+func (k *Keyring) Generations() []Generation {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ret := make([]Generation, len(k.generations))
+	for i := range k.generations {
+		ret[i] = k.generationLocked(i)
+	}
+	return ret
+}
+
+// RetireGeneration removes the keys of an old generation from the keyring,
+// so that messages sent to them can no longer be decrypted. The current
+// generation can't be retired.
+func (k *Keyring) RetireGeneration(label string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	i := k.findGenerationLocked(label)
+	if i < 0 {
+		return ErrUnknownGeneration
+	}
+	if i == len(k.generations)-1 {
+		return ErrCurrentGeneration
+	}
+	g := k.generations[i]
+	delete(k.encKeys, g.BoxKey)
+	delete(k.sigKeys, g.SigningKey)
+	k.generations = append(k.generations[:i], k.generations[i+1:]...)
+	return nil
+}
+
+// RemoveBoxKey removes a box key that isn't part of a generation from the
+// keyring.
+func (k *Keyring) RemoveBoxKey(pub PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.encKeys[pub]; !ok {
+		return ErrKeyNotFound
+	}
+	for _, g := range k.generations {
+		if g.BoxKey == pub {
+			return ErrKeyInGeneration
+		}
+	}
+	delete(k.encKeys, pub)
+	return nil
+}
+
+// RemoveSigningKey removes a signing key that isn't part of a generation
+// from the keyring.
+func (k *Keyring) RemoveSigningKey(pub SigningPublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.sigKeys[pub]; !ok {
+		return ErrKeyNotFound
+	}
+	for _, g := range k.generations {
+		if g.SigningKey == pub {
+			return ErrKeyInGeneration
+		}
+	}
+	delete(k.sigKeys, pub)
+	return nil
+}
+
+func (k *Keyring) findGenerationLocked(label string) int {
+	for i, g := range k.generations {
+		if g.Label == label {
+			return i
+		}
+	}
+	return -1
+}
+
+func (k *Keyring) generationLocked(i int) Generation {
+	g := k.generations[i]
+	g.Current = i == len(k.generations)-1
+	return g
+}
@@ -0,0 +1,150 @@
+package basic
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+func TestGenerations(t *testing.T) {
+	kr := NewKeyring()
+	if _, err := kr.CurrentBoxKey(); err != ErrNoCurrentGeneration {
+		t.Fatalf("wanted ErrNoCurrentGeneration, got %v", err)
+	}
+	if _, err := kr.Rotate("2024"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kr.Rotate("2024"); err != ErrDuplicateGeneration {
+		t.Fatalf("wanted ErrDuplicateGeneration, got %v", err)
+	}
+	old, err := kr.CurrentBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := randomMsg(t, 1024)
+	sealed, err := saltpack.Seal(saltpack.CurrentVersion(), msg, old, []saltpack.BoxPublicKey{old.GetPublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := kr.Rotate("2025")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !g.Current {
+		t.Fatal("new generation isn't current")
+	}
+	cur, err := kr.CurrentBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.pub != g.BoxKey || cur.pub == old.pub {
+		t.Fatal("current box key wasn't rotated")
+	}
+	sk, err := kr.CurrentSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sk.pub != g.SigningKey {
+		t.Fatal("current signing key wasn't rotated")
+	}
+
+	// The old generation still decrypts.
+	gens := kr.Generations()
+	if len(gens) != 2 || gens[0].Label != "2024" || gens[0].Current || !gens[1].Current {
+		t.Fatalf("bad generations %+v", gens)
+	}
+	if _, _, err = saltpack.Open(saltpack.CheckKnownMajorVersion, sealed, kr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = kr.RetireGeneration("2025"); err != ErrCurrentGeneration {
+		t.Fatalf("wanted ErrCurrentGeneration, got %v", err)
+	}
+	if err = kr.RemoveBoxKey(old.pub); err != ErrKeyInGeneration {
+		t.Fatalf("wanted ErrKeyInGeneration, got %v", err)
+	}
+	if err = kr.RetireGeneration("2024"); err != nil {
+		t.Fatal(err)
+	}
+	if err = kr.RetireGeneration("2024"); err != ErrUnknownGeneration {
+		t.Fatalf("wanted ErrUnknownGeneration, got %v", err)
+	}
+	if _, _, err = saltpack.Open(saltpack.CheckKnownMajorVersion, sealed, kr); err != saltpack.ErrNoDecryptionKey {
+		t.Fatalf("wanted ErrNoDecryptionKey, got %v", err)
+	}
+	if len(kr.Generations()) != 1 {
+		t.Fatal("retired generation still listed")
+	}
+
+	loose, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kr.RemoveBoxKey(loose.pub); err != nil {
+		t.Fatal(err)
+	}
+	if err = kr.RemoveBoxKey(loose.pub); err != ErrKeyNotFound {
+		t.Fatalf("wanted ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestGenerateSigningKeyInserts(t *testing.T) {
+	kr := NewKeyring()
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kr.RemoveSigningKey(sk.pub); err != nil {
+		t.Fatalf("generated signing key wasn't in the keyring: %v", err)
+	}
+}
+
+func TestKeyringConcurrentRotation(t *testing.T) {
+	kr := NewKeyring()
+	if _, err := kr.Rotate("0"); err != nil {
+		t.Fatal(err)
+	}
+	bk, err := kr.CurrentBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := randomMsg(t, 100)
+	sealed, err := saltpack.Seal(saltpack.CurrentVersion(), msg, bk, []saltpack.BoxPublicKey{bk.GetPublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				_, opened, err := saltpack.Open(saltpack.CheckKnownMajorVersion, sealed, kr)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(msg, opened) {
+					errs <- saltpack.ErrDecryptionFailed
+					return
+				}
+			}
+		}()
+	}
+	for i := range 20 {
+		if _, err := kr.Rotate(string(rune('a' + i))); err != nil {
+			t.Fatal(err)
+		}
+		kr.GetAllBoxSecretKeys()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
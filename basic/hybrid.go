@@ -0,0 +1,194 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"crypto/mlkem"
+
+	"github.com/keybase/saltpack"
+)
+
+// HybridPublicKey is a box public key with an ML-KEM-768 key alongside its
+// Curve25519 one, which saltpack.Version3 receivers need. Its key ID is
+// that of the Curve25519 key.
+type HybridPublicKey struct {
+	PublicKey
+	// EncapsulationKey is the ML-KEM-768 encapsulation key, in its
+	// 1184-byte encoding.
+	EncapsulationKey []byte
+}
+
+// MLKEMEncapsulationKey returns the ML-KEM-768 encapsulation key.
+func (k HybridPublicKey) MLKEMEncapsulationKey() []byte {
+	return k.EncapsulationKey
+}
+
+var _ saltpack.HybridBoxPublicKey = HybridPublicKey{}
+
+// HybridSecretKey is the secret key corresponding to a HybridPublicKey.
+// The ML-KEM-768 key is kept as its 64-byte seed, so that it can be wiped,
+// and is expanded again for each decapsulation.
+type HybridSecretKey struct {
+	SecretKey
+	seed [mlkem.SeedSize]byte
+	ek   []byte
+}
+
+// NewHybridSecretKey makes a new HybridSecretKey from the raw Curve25519
+// public and secret keys, and the seed of the ML-KEM-768 key.
+func NewHybridSecretKey(pub, sec *[32]byte, seed *[mlkem.SeedSize]byte) HybridSecretKey {
+	dk, err := mlkem.NewDecapsulationKey768(seed[:])
+	if err != nil {
+		panic(err) // should be statically impossible, since any seed of the right length is valid
+	}
+	return HybridSecretKey{
+		SecretKey: NewSecretKey(pub, sec),
+		seed:      *seed,
+		ek:        dk.EncapsulationKey().Bytes(),
+	}
+}
+
+func generateHybridBoxKey() (*HybridSecretKey, error) {
+	sk, err := generateBoxKey()
+	if err != nil {
+		return nil, err
+	}
+	defer sk.Wipe()
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, err
+	}
+	seed := [mlkem.SeedSize]byte(dk.Bytes())
+	ret := NewHybridSecretKey(sk.GetRawPublicKey(), sk.GetRawSecretKey(), &seed)
+	clear(seed[:])
+	return &ret, nil
+}
+
+// GetPublicKey returns the hybrid public key that corresponds to this
+// secret key.
+func (k HybridSecretKey) GetPublicKey() saltpack.BoxPublicKey {
+	return k.getHybridPublicKey()
+}
+
+func (k HybridSecretKey) getHybridPublicKey() HybridPublicKey {
+	return HybridPublicKey{PublicKey: k.pub, EncapsulationKey: k.ek}
+}
+
+// GetRawMLKEMSeed returns the seed of the ML-KEM-768 secret key.
+func (k HybridSecretKey) GetRawMLKEMSeed() *[mlkem.SeedSize]byte {
+	return &k.seed
+}
+
+// DecapsulateMLKEM returns the ML-KEM-768 shared secret for a ciphertext
+// sent to this key.
+func (k HybridSecretKey) DecapsulateMLKEM(ciphertext []byte) ([]byte, error) {
+	dk, err := mlkem.NewDecapsulationKey768(k.seed[:])
+	if err != nil {
+		return nil, err
+	}
+	return dk.Decapsulate(ciphertext)
+}
+
+// Wipe zeroes both halves of the secret key.
+func (k *HybridSecretKey) Wipe() {
+	k.SecretKey.Wipe()
+	clear(k.seed[:])
+}
+
+var _ saltpack.HybridBoxSecretKey = HybridSecretKey{}
+
+// ToTypedKID returns a key ID for k that says it's a hybrid box key. See
+// saltpack.TypedKID.
+func (k HybridPublicKey) ToTypedKID() []byte {
+	return saltpack.TypedKID(saltpack.KeyTypeX25519MLKEM768, k.ToKID())
+}
+
+func (k HybridPublicKey) exportKeys() (saltpack.MessageType, []serializedKey) {
+	return saltpack.MessageTypePublicKey, []serializedKey{{Type: saltpack.KeyTypeX25519MLKEM768, Public: k.serializePublic()}}
+}
+
+func (k HybridPublicKey) serializePublic() []byte {
+	return append(bytes.Clone(k.ToKID()), k.EncapsulationKey...)
+}
+
+// MarshalBinary serializes the hybrid public key.
+func (k HybridPublicKey) MarshalBinary() ([]byte, error) {
+	return marshalKeys(k)
+}
+
+// UnmarshalBinary parses a hybrid public key serialized by MarshalBinary.
+func (k *HybridPublicKey) UnmarshalBinary(b []byte) error {
+	key, err := unmarshalSingleKey(b, saltpack.KeyTypeX25519MLKEM768, false)
+	if err != nil {
+		return err
+	}
+	*k = key.toExportable(false).(HybridPublicKey)
+	return nil
+}
+
+func (k HybridSecretKey) exportKeys() (saltpack.MessageType, []serializedKey) {
+	return saltpack.MessageTypeSecretKey, []serializedKey{k.serialize()}
+}
+
+// serialize puts the Curve25519 key before the ML-KEM-768 key in both the
+// public and the secret key.
+func (k HybridSecretKey) serialize() serializedKey {
+	secret := make([]byte, 0, len(k.sec)+len(k.seed))
+	secret = append(append(secret, k.sec[:]...), k.seed[:]...)
+	return serializedKey{Type: saltpack.KeyTypeX25519MLKEM768, Public: k.getHybridPublicKey().serializePublic(), Secret: secret}
+}
+
+// MarshalBinary serializes the hybrid secret key, along with its public
+// key.
+func (k HybridSecretKey) MarshalBinary() ([]byte, error) {
+	return marshalKeys(k)
+}
+
+// UnmarshalBinary parses a hybrid secret key serialized by MarshalBinary.
+func (k *HybridSecretKey) UnmarshalBinary(b []byte) error {
+	key, err := unmarshalSingleKey(b, saltpack.KeyTypeX25519MLKEM768, true)
+	if err != nil {
+		return err
+	}
+	*k = key.toExportable(true).(HybridSecretKey)
+	return nil
+}
+
+var (
+	_ Exportable = HybridPublicKey{}
+	_ Exportable = HybridSecretKey{}
+)
+
+// GenerateHybridBoxKey generates a new hybrid box key, for
+// saltpack.Version3 messages, and imports it into the keyring.
+func (k *Keyring) GenerateHybridBoxKey() (*HybridSecretKey, error) {
+	ret, err := generateHybridBoxKey()
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.addHybridKeyLocked(*ret)
+	return ret, nil
+}
+
+// ImportHybridBoxKey imports an existing hybrid box key into this keyring,
+// from its raw Curve25519 public and secret keys and its ML-KEM-768 seed.
+func (k *Keyring) ImportHybridBoxKey(pub, sec *[32]byte, seed *[mlkem.SeedSize]byte) {
+	nk := NewHybridSecretKey(pub, sec, seed)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.addHybridKeyLocked(nk)
+}
+
+// addHybridKeyLocked stores a copy of sk. Hybrid keys are too big for a
+// map to hold inline, so they're stored by pointer, which lets Wipe get at
+// the stored copy.
+func (k *Keyring) addHybridKeyLocked(sk HybridSecretKey) {
+	if k.hybridKeys == nil {
+		k.hybridKeys = make(map[PublicKey]*HybridSecretKey)
+	}
+	k.hybridKeys[sk.pub] = &sk
+}
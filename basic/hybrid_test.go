@@ -0,0 +1,121 @@
+package basic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+func TestHybridSealOpen(t *testing.T) {
+	kr := NewKeyring()
+	sender, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := kr.GenerateHybridBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello, post-quantum world")
+
+	sealed, err := saltpack.Seal(saltpack.Version3(), msg, sender, []saltpack.BoxPublicKey{receiver.GetPublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mki, opened, err := saltpack.Open(saltpack.CheckKnownOrExperimentalMajorVersion, sealed, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, opened) {
+		t.Fatal("message mismatch")
+	}
+	if !saltpack.PublicKeyEqual(mki.ReceiverKey.GetPublicKey(), receiver.GetPublicKey()) {
+		t.Fatal("wrong receiver key")
+	}
+
+	// The key still works as a plain box key for earlier versions.
+	sealed, err = saltpack.Seal(saltpack.Version2(), msg, sender, []saltpack.BoxPublicKey{receiver.pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, opened, err = saltpack.Open(saltpack.CheckKnownMajorVersion, sealed, kr); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg, opened) {
+		t.Fatal("message mismatch")
+	}
+}
+
+func TestHybridExportRoundTrip(t *testing.T) {
+	kr := NewKeyring()
+	hk, err := kr.GenerateHybridBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, k := range []Exportable{hk.getHybridPublicKey(), *hk, kr} {
+		b, err := k.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, err := ParseKey(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b2, err := k2.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, b2) {
+			t.Fatalf("%T didn't round-trip", k)
+		}
+	}
+
+	b, err := hk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hk2 HybridSecretKey
+	if err := hk2.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if *hk2.GetRawMLKEMSeed() != *hk.GetRawMLKEMSeed() {
+		t.Fatal("ML-KEM seed didn't round-trip")
+	}
+
+	// A hybrid public key isn't a plain box key.
+	b, err = hk.getHybridPublicKey().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk PublicKey
+	if _, ok := pk.UnmarshalBinary(b).(saltpack.ErrWrongKeyType); !ok {
+		t.Fatal("hybrid key parsed as a plain box key")
+	}
+}
+
+func TestHybridExportMismatch(t *testing.T) {
+	kr := NewKeyring()
+	hk, err := kr.GenerateHybridBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := kr.GenerateHybridBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An ML-KEM seed that doesn't match the public key is rejected.
+	bad := hk.serialize()
+	bad.Secret = append(hk.sec[:], other.seed[:]...)
+	if _, ok := bad.check(true).(saltpack.ErrBadSerializedKey); !ok {
+		t.Fatal("mismatched ML-KEM key halves accepted")
+	}
+
+	bad = hk.serialize()
+	bad.Public = bad.Public[:len(bad.Public)-1]
+	if _, ok := bad.check(false).(saltpack.ErrBadSerializedKey); !ok {
+		t.Fatal("short hybrid public key accepted")
+	}
+}
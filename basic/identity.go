@@ -0,0 +1,182 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"crypto/rand"
+	"sync"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+)
+
+// Identity is a signing key and a box key derived from the same Ed25519
+// seed, so that one public key is all that has to be handed out to be
+// able to both verify someone's signatures and encrypt to them. The box
+// key is converted from the signing key by
+// BoxSecretKeyFromSigningSecretKey, and its public key from the signing
+// public key by SigningPublicKey.BoxPublicKey.
+//
+// An Identity is a saltpack.SigningSecretKey. Go doesn't let it also be a
+// saltpack.BoxSecretKey, since both have a GetPublicKey method, so its box
+// key is returned by BoxSecretKey.
+type Identity struct {
+	SigningSecretKey
+	box SecretKey
+}
+
+var _ saltpack.SigningSecretKey = Identity{}
+
+// NewIdentity derives an identity from an Ed25519 seed.
+func NewIdentity(seed *[ed25519.SeedSize]byte) Identity {
+	edSec := ed25519.NewKeyFromSeed(seed[:])
+	defer clear(edSec)
+	sigKey, err := SigningSecretKeyFromEd25519(edSec)
+	if err != nil {
+		panic(err)
+	}
+	return IdentityFromSigningSecretKey(sigKey)
+}
+
+// IdentityFromSigningSecretKey returns the identity of an existing signing
+// key.
+func IdentityFromSigningSecretKey(k SigningSecretKey) Identity {
+	return Identity{SigningSecretKey: k, box: BoxSecretKeyFromSigningSecretKey(k)}
+}
+
+// GenerateIdentity generates an identity from a random seed.
+func GenerateIdentity() (*Identity, error) {
+	var seed [ed25519.SeedSize]byte
+	defer clear(seed[:])
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	id := NewIdentity(&seed)
+	return &id, nil
+}
+
+// BoxSecretKey returns the identity's box key.
+func (id Identity) BoxSecretKey() SecretKey {
+	return id.box
+}
+
+// Wipe zeroes both of the identity's secret keys.
+func (id *Identity) Wipe() {
+	id.SigningSecretKey.Wipe()
+	id.box.Wipe()
+}
+
+// BoxPublicKey converts k into the box public key of the same identity.
+// See BoxPublicKeyFromSigningPublicKey.
+func (k SigningPublicKey) BoxPublicKey() (PublicKey, error) {
+	return BoxPublicKeyFromSigningPublicKey(k)
+}
+
+// IdentityKeyring holds Identities, and answers lookups for both their box
+// and signing keys, so it can be used for encryption, signing and
+// signcryption. It's safe for concurrent use.
+type IdentityKeyring struct {
+	EphemeralKeyCreator
+
+	mu    sync.RWMutex
+	ids   map[SigningPublicKey]*Identity // by pointer, so Wipe reaches them
+	byBox map[PublicKey]SigningPublicKey
+}
+
+var _ saltpack.SigncryptKeyring = (*IdentityKeyring)(nil)
+
+// NewIdentityKeyring makes an empty identity keyring.
+func NewIdentityKeyring() *IdentityKeyring {
+	return &IdentityKeyring{
+		ids:   make(map[SigningPublicKey]*Identity),
+		byBox: make(map[PublicKey]SigningPublicKey),
+	}
+}
+
+// Add adds id to the keyring.
+func (k *IdentityKeyring) Add(id Identity) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.ids[id.pub] = &id
+	k.byBox[id.box.pub] = id.pub
+}
+
+// GenerateIdentity generates an identity and adds it to the keyring.
+func (k *IdentityKeyring) GenerateIdentity() (*Identity, error) {
+	id, err := GenerateIdentity()
+	if err != nil {
+		return nil, err
+	}
+	k.Add(*id)
+	return id, nil
+}
+
+// LookupIdentity returns the identity whose signing or box public key has
+// the given key ID, if it's in the keyring.
+func (k *IdentityKeyring) LookupIdentity(kid []byte) (Identity, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if id, ok := k.ids[kidToSigningPublicKey(kid)]; ok {
+		return *id, true
+	}
+	if pub, ok := k.byBox[kidToPublicKey(kid)]; ok {
+		return *k.ids[pub], true
+	}
+	return Identity{}, false
+}
+
+// LookupBoxSecretKey tries to find the box key of one of the identities
+// in the keyring given the possible key IDs. It returns the index and the
+// key, if found, and -1 and nil otherwise.
+func (k *IdentityKeyring) LookupBoxSecretKey(kids [][]byte) (int, saltpack.BoxSecretKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for i, kid := range kids {
+		if pub, ok := k.byBox[kidToPublicKey(kid)]; ok {
+			return i, k.ids[pub].box
+		}
+	}
+	return -1, nil
+}
+
+// LookupBoxPublicKey returns the public key that corresponds to the given
+// key ID.
+func (k *IdentityKeyring) LookupBoxPublicKey(kid []byte) saltpack.BoxPublicKey {
+	return kidToPublicKey(kid)
+}
+
+// GetAllBoxSecretKeys returns the box keys of all the identities in the
+// keyring.
+func (k *IdentityKeyring) GetAllBoxSecretKeys() []saltpack.BoxSecretKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var out []saltpack.BoxSecretKey
+	for _, id := range k.ids {
+		out = append(out, id.box)
+	}
+	return out
+}
+
+// ImportBoxEphemeralKey takes a key ID and returns a public key useful for
+// encryption/decryption.
+func (k *IdentityKeyring) ImportBoxEphemeralKey(kid []byte) saltpack.BoxPublicKey {
+	return kidToPublicKey(kid)
+}
+
+// LookupSigningPublicKey turns the given key ID into a signing public
+// key.
+func (k *IdentityKeyring) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
+	return kidToSigningPublicKey(kid)
+}
+
+// Wipe zeroes all the identities in the keyring and removes them.
+func (k *IdentityKeyring) Wipe() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for pub, id := range k.ids {
+		id.Wipe()
+		delete(k.ids, pub)
+	}
+	clear(k.byBox)
+}
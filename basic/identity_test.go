@@ -0,0 +1,87 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestIdentity(t *testing.T) {
+	var seed [ed25519.SeedSize]byte
+	seed[0] = 1
+	id := NewIdentity(&seed)
+	if NewIdentity(&seed) != id {
+		t.Fatal("identity isn't deterministic")
+	}
+	boxPub, err := id.pub.BoxPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boxPub != id.BoxSecretKey().pub {
+		t.Fatal("converted public key doesn't match the box key")
+	}
+
+	// A message signcrypted by one identity to another, knowing only its
+	// signing public key.
+	senders, receivers := NewIdentityKeyring(), NewIdentityKeyring()
+	sender, err := senders.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := receivers.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiverBoxPub, err := receiver.pub.BoxPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := randomMsg(t, 1024)
+	sealed, err := saltpack.SigncryptSeal(msg, senders, sender, []saltpack.BoxPublicKey{receiverBoxPub}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	senderPub, opened, err := saltpack.SigncryptOpen(sealed, receivers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, msg) || !saltpack.PublicKeyEqual(senderPub, sender.pub) {
+		t.Fatal("bad signcryption")
+	}
+
+	// The same goes for encryption, where the sender's box key leads back
+	// to their identity.
+	ciphertext, err := saltpack.Seal(saltpack.Version2(), msg, sender.BoxSecretKey(), []saltpack.BoxPublicKey{receiverBoxPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mki, plaintext, err := saltpack.Open(saltpack.SingleVersionValidator(saltpack.Version2()), ciphertext, receivers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, msg) {
+		t.Fatal("bad decryption")
+	}
+	found, ok := senders.LookupIdentity(mki.SenderKey.ToKID())
+	if !ok || found.pub != sender.pub {
+		t.Fatal("sender's identity not found by box key")
+	}
+	if _, ok = receivers.LookupIdentity(receiver.pub.ToKID()); !ok {
+		t.Fatal("identity not found by signing key")
+	}
+
+	stored := receivers.ids[receiver.pub]
+	receivers.Wipe()
+	if len(receivers.GetAllBoxSecretKeys()) != 0 {
+		t.Fatal("keys left after Wipe")
+	}
+	// The keyring's own copy is wiped, not just forgotten.
+	if *stored.GetRawSecretKey() != ([64]byte{}) || *stored.box.GetRawSecretKey() != ([32]byte{}) {
+		t.Fatal("stored identity not wiped")
+	}
+}
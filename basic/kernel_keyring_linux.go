@@ -0,0 +1,412 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+//go:build linux
+
+package basic
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/sys/unix"
+)
+
+// The special kernel keyrings that OpenKernelKeyring takes. See
+// keyrings(7).
+const (
+	KernelThreadKeyring      = unix.KEY_SPEC_THREAD_KEYRING
+	KernelProcessKeyring     = unix.KEY_SPEC_PROCESS_KEYRING
+	KernelSessionKeyring     = unix.KEY_SPEC_SESSION_KEYRING
+	KernelUserKeyring        = unix.KEY_SPEC_USER_KEYRING
+	KernelUserSessionKeyring = unix.KEY_SPEC_USER_SESSION_KEYRING
+)
+
+const (
+	kernelKeyType       = "user"
+	kernelKeyringType   = "keyring"
+	kernelKeyDescPrefix = "saltpack:"
+)
+
+// KernelKeyring is a saltpack.Keyring and saltpack.SigKeyring whose secret
+// keys live in a Linux kernel keyring, rather than on disk or in the
+// process's memory. Each secret key is a "user" key in the keyring,
+// described as "saltpack:" followed by the hex typed KID of its public key
+// (see PublicKey.ToTypedKID), so it shows up in keyctl show, and can be
+// managed with keyctl(1).
+//
+// The secret keys that lookups return hold only the key's serial number.
+// Each operation reads the key from the kernel, uses it, and wipes it,
+// so the key is only in the process's memory for as long as the
+// operation takes.
+//
+// Like Keyring, LookupSigningPublicKey turns any key ID into a public key.
+type KernelKeyring struct {
+	EphemeralKeyCreator
+	id int
+}
+
+var (
+	_ saltpack.Keyring    = (*KernelKeyring)(nil)
+	_ saltpack.SigKeyring = (*KernelKeyring)(nil)
+)
+
+// OpenKernelKeyring opens one of the special kernel keyrings, like
+// KernelSessionKeyring or KernelUserKeyring, creating it if need be, or
+// an existing keyring with the given serial number.
+func OpenKernelKeyring(id int) (*KernelKeyring, error) {
+	ringID, err := unix.KeyctlGetKeyringID(id, true)
+	if err != nil {
+		return nil, err
+	}
+	return &KernelKeyring{id: ringID}, nil
+}
+
+// OpenPersistentKernelKeyring opens the current user's persistent
+// keyring, which outlives their login sessions, linking it into the
+// session keyring. See persistent-keyring(7).
+func OpenPersistentKernelKeyring() (*KernelKeyring, error) {
+	ringID, err := unix.KeyctlInt(unix.KEYCTL_GET_PERSISTENT, -1, unix.KEY_SPEC_SESSION_KEYRING, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &KernelKeyring{id: ringID}, nil
+}
+
+// Subkeyring opens the keyring with the given name that's linked into k,
+// creating it if it doesn't exist, so that saltpack keys can be kept
+// apart from other keys.
+func (k *KernelKeyring) Subkeyring(name string) (*KernelKeyring, error) {
+	ringID, err := unix.KeyctlSearch(k.id, kernelKeyringType, name, 0)
+	if errors.Is(err, unix.ENOKEY) {
+		ringID, err = unix.AddKey(kernelKeyringType, name, nil, k.id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &KernelKeyring{id: ringID}, nil
+}
+
+// ID returns the serial number of the kernel keyring.
+func (k *KernelKeyring) ID() int {
+	return k.id
+}
+
+func kernelKeyDescription(typedKID []byte) string {
+	return kernelKeyDescPrefix + hex.EncodeToString(typedKID)
+}
+
+func (k *KernelKeyring) add(typedKID, secret []byte) error {
+	_, err := unix.AddKey(kernelKeyType, kernelKeyDescription(typedKID), secret, k.id)
+	return err
+}
+
+func (k *KernelKeyring) search(typedKID []byte) (int, error) {
+	serial, err := unix.KeyctlSearch(k.id, kernelKeyType, kernelKeyDescription(typedKID), 0)
+	if errors.Is(err, unix.ENOKEY) {
+		return 0, ErrKeyNotFound
+	}
+	return serial, err
+}
+
+func (k *KernelKeyring) remove(typedKID []byte) error {
+	serial, err := k.search(typedKID)
+	if err != nil {
+		return err
+	}
+	_, err = unix.KeyctlInt(unix.KEYCTL_UNLINK, serial, k.id, 0, 0)
+	return err
+}
+
+// list returns the serial numbers and key IDs of the keys of the given
+// type in the keyring.
+func (k *KernelKeyring) list(typ saltpack.KeyType) (serials []int, kids [][]byte, err error) {
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, k.id, nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	buf := make([]byte, size)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, k.id, buf, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Keys may have been added since we asked for the size.
+	buf = buf[:min(n, size)]
+	for i := 0; i+4 <= len(buf); i += 4 {
+		//nolint:gosec // serial numbers are int32s
+		serial := int(int32(binary.NativeEndian.Uint32(buf[i:])))
+		desc, err := unix.KeyctlString(unix.KEYCTL_DESCRIBE, serial)
+		if err != nil {
+			// Not viewable, or gone since we listed the keyring.
+			continue
+		}
+		// The description is "type;uid;gid;perm;description".
+		parts := strings.SplitN(desc, ";", 5)
+		if len(parts) != 5 || parts[0] != kernelKeyType || !strings.HasPrefix(parts[4], kernelKeyDescPrefix) {
+			continue
+		}
+		typedKID, err := hex.DecodeString(strings.TrimPrefix(parts[4], kernelKeyDescPrefix))
+		if err != nil {
+			continue
+		}
+		kt, kid, err := saltpack.ParseTypedKID(typedKID)
+		if err != nil || kt != typ {
+			continue
+		}
+		serials = append(serials, serial)
+		kids = append(kids, kid)
+	}
+	return serials, kids, nil
+}
+
+// readKernelKey reads the payload of the key with the given serial
+// number into secret, which it must fill exactly.
+func readKernelKey(serial int, secret []byte) error {
+	// One byte more, to catch a payload that's too long.
+	buf := make([]byte, len(secret)+1)
+	defer clear(buf)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, serial, buf, 0)
+	if err != nil {
+		return err
+	}
+	if n != len(secret) {
+		return fmt.Errorf("kernel key %d has a %d-byte payload, expected %d", serial, n, len(secret))
+	}
+	copy(secret, buf)
+	return nil
+}
+
+// AddBoxKey copies sk into the kernel keyring. The caller can then wipe
+// sk.
+func (k *KernelKeyring) AddBoxKey(sk *SecretKey) error {
+	return k.add(sk.pub.ToTypedKID(), sk.sec[:])
+}
+
+// AddSigningKey copies sk into the kernel keyring. The caller can then
+// wipe sk.
+func (k *KernelKeyring) AddSigningKey(sk *SigningSecretKey) error {
+	return k.add(sk.pub.ToTypedKID(), sk.sec[:])
+}
+
+// GenerateBoxKey generates a box key in the kernel keyring, and returns
+// its public key. The secret key is wiped from the process's memory.
+func (k *KernelKeyring) GenerateBoxKey() (PublicKey, error) {
+	sk, err := generateBoxKey()
+	if err != nil {
+		return PublicKey{}, err
+	}
+	defer sk.Wipe()
+	return sk.pub, k.AddBoxKey(sk)
+}
+
+// GenerateSigningKey generates a signing key in the kernel keyring, and
+// returns its public key. The secret key is wiped from the process's
+// memory.
+func (k *KernelKeyring) GenerateSigningKey() (SigningPublicKey, error) {
+	sk, err := generateSigningKey()
+	if err != nil {
+		return SigningPublicKey{}, err
+	}
+	defer sk.Wipe()
+	return sk.pub, k.AddSigningKey(sk)
+}
+
+// RemoveBoxKey unlinks the box key with the given public key from the
+// kernel keyring. It returns ErrKeyNotFound if it isn't there.
+func (k *KernelKeyring) RemoveBoxKey(pub PublicKey) error {
+	return k.remove(pub.ToTypedKID())
+}
+
+// RemoveSigningKey unlinks the signing key with the given public key from
+// the kernel keyring. It returns ErrKeyNotFound if it isn't there.
+func (k *KernelKeyring) RemoveSigningKey(pub SigningPublicKey) error {
+	return k.remove(pub.ToTypedKID())
+}
+
+// LookupBoxSecretKey looks in the kernel keyring for the box key with one
+// of the given key IDs. It returns the index and the key, if found, and
+// -1 and nil otherwise.
+func (k *KernelKeyring) LookupBoxSecretKey(kids [][]byte) (int, saltpack.BoxSecretKey) {
+	for i, kid := range kids {
+		if len(kid) != len(saltpack.RawBoxKey{}) {
+			continue
+		}
+		pub := kidToPublicKey(kid)
+		if serial, err := k.search(pub.ToTypedKID()); err == nil {
+			return i, &KernelBoxKey{serial: serial, pub: pub}
+		}
+	}
+	return -1, nil
+}
+
+// LookupBoxPublicKey returns the public key that corresponds to the given
+// key ID.
+func (k *KernelKeyring) LookupBoxPublicKey(kid []byte) saltpack.BoxPublicKey {
+	return kidToPublicKey(kid)
+}
+
+// GetAllBoxSecretKeys returns all the box keys in the kernel keyring.
+func (k *KernelKeyring) GetAllBoxSecretKeys() []saltpack.BoxSecretKey {
+	serials, kids, err := k.list(saltpack.KeyTypeCurve25519)
+	if err != nil {
+		return nil
+	}
+	out := make([]saltpack.BoxSecretKey, len(serials))
+	for i, serial := range serials {
+		out[i] = &KernelBoxKey{serial: serial, pub: kidToPublicKey(kids[i])}
+	}
+	return out
+}
+
+// ImportBoxEphemeralKey takes a key ID and returns a public key useful
+// for encryption/decryption.
+func (k *KernelKeyring) ImportBoxEphemeralKey(kid []byte) saltpack.BoxPublicKey {
+	return kidToPublicKey(kid)
+}
+
+// LookupSigningPublicKey turns the given key ID into a signing public
+// key.
+func (k *KernelKeyring) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
+	return kidToSigningPublicKey(kid)
+}
+
+// SigningKey returns the signing key in the kernel keyring with the given
+// public key, or ErrKeyNotFound if it isn't there.
+func (k *KernelKeyring) SigningKey(pub SigningPublicKey) (*KernelSigningKey, error) {
+	serial, err := k.search(pub.ToTypedKID())
+	if err != nil {
+		return nil, err
+	}
+	return &KernelSigningKey{serial: serial, pub: pub}, nil
+}
+
+// GetAllSigningSecretKeys returns all the signing keys in the kernel
+// keyring.
+func (k *KernelKeyring) GetAllSigningSecretKeys() []saltpack.SigningSecretKey {
+	serials, kids, err := k.list(saltpack.KeyTypeEd25519)
+	if err != nil {
+		return nil
+	}
+	out := make([]saltpack.SigningSecretKey, len(serials))
+	for i, serial := range serials {
+		out[i] = &KernelSigningKey{serial: serial, pub: kidToSigningPublicKey(kids[i])}
+	}
+	return out
+}
+
+// KernelBoxKey is a saltpack.BoxSecretKey for a box key in a kernel
+// keyring.
+//
+// Box can't return an error, so if the key can't be read, because it's
+// been removed or revoked since it was looked up, Box returns random
+// bytes, which will fail to open, and the error is kept for Err to
+// return. Unbox returns the error as usual.
+type KernelBoxKey struct {
+	serial int
+	pub    PublicKey
+
+	mu  sync.Mutex
+	err error
+}
+
+var _ saltpack.BoxSecretKey = (*KernelBoxKey)(nil)
+
+// Err returns the first error from reading the key for Box or Precompute,
+// if any.
+func (k *KernelBoxKey) Err() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.err
+}
+
+func (k *KernelBoxKey) load() (*SecretKey, error) {
+	sk := SecretKey{pub: k.pub}
+	if err := readKernelKey(k.serial, sk.sec[:]); err != nil {
+		k.mu.Lock()
+		if k.err == nil {
+			k.err = err
+		}
+		k.mu.Unlock()
+		return nil, err
+	}
+	return &sk, nil
+}
+
+// Box reads the key, and boxes msg from it to receiver.
+func (k *KernelBoxKey) Box(receiver saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) []byte {
+	sk, err := k.load()
+	if err != nil {
+		// Random bytes, and not zeros, since the caller may use them as
+		// a key.
+		ret := make([]byte, len(msg)+box.Overhead)
+		if _, err := rand.Read(ret); err != nil {
+			panic(err)
+		}
+		return ret
+	}
+	defer sk.Wipe()
+	return sk.Box(receiver, nonce, msg)
+}
+
+// Unbox reads the key, and opens a box from sender to it.
+func (k *KernelBoxKey) Unbox(sender saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) ([]byte, error) {
+	sk, err := k.load()
+	if err != nil {
+		return nil, err
+	}
+	defer sk.Wipe()
+	return sk.Unbox(sender, nonce, msg)
+}
+
+// GetPublicKey returns the public key of k.
+func (k *KernelBoxKey) GetPublicKey() saltpack.BoxPublicKey {
+	return k.pub
+}
+
+// Precompute reads the key, and computes the key it shares with peer. If
+// the key can't be read, the shared key is random, and Err returns the
+// error.
+func (k *KernelBoxKey) Precompute(peer saltpack.BoxPublicKey) saltpack.BoxPrecomputedSharedKey {
+	sk, err := k.load()
+	if err != nil {
+		var res PrecomputedSharedKey
+		if _, err := rand.Read(res[:]); err != nil {
+			panic(err)
+		}
+		return &res
+	}
+	defer sk.Wipe()
+	return sk.Precompute(peer)
+}
+
+// KernelSigningKey is a saltpack.SigningSecretKey for a signing key in a
+// kernel keyring.
+type KernelSigningKey struct {
+	serial int
+	pub    SigningPublicKey
+}
+
+var _ saltpack.SigningSecretKey = (*KernelSigningKey)(nil)
+
+// Sign reads the key, and signs message with it.
+func (k *KernelSigningKey) Sign(message []byte) ([]byte, error) {
+	var sec [ed25519.PrivateKeySize]byte
+	defer clear(sec[:])
+	if err := readKernelKey(k.serial, sec[:]); err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(sec[:], message), nil
+}
+
+// GetPublicKey returns the public key of k.
+func (k *KernelSigningKey) GetPublicKey() saltpack.SigningPublicKey {
+	return k.pub
+}
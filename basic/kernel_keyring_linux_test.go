@@ -0,0 +1,138 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+//go:build linux
+
+package basic
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/sys/unix"
+)
+
+// newTestKernelKeyring makes an empty keyring in the session keyring,
+// which is unlinked when the test is done, or skips the test if the
+// kernel keyring can't be used, for instance because seccomp blocks
+// keyctl in a container.
+func newTestKernelKeyring(t *testing.T) *KernelKeyring {
+	session, err := OpenKernelKeyring(KernelSessionKeyring)
+	if errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+		t.Skipf("kernel keyring unavailable: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := session.Subkeyring("saltpack-test-" + t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_, _ = unix.KeyctlInt(unix.KEYCTL_CLEAR, k.ID(), 0, 0, 0)
+		_, _ = unix.KeyctlInt(unix.KEYCTL_UNLINK, k.ID(), session.ID(), 0, 0)
+	})
+	return k
+}
+
+func TestKernelKeyringBox(t *testing.T) {
+	k := newTestKernelKeyring(t)
+	sender, err := k.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := k.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(k.GetAllBoxSecretKeys()); n != 2 {
+		t.Fatalf("%d box keys, expected 2", n)
+	}
+
+	_, senderKey := k.LookupBoxSecretKey([][]byte{sender.ToKID()})
+	if senderKey == nil {
+		t.Fatal("sender key not found")
+	}
+	msg := randomMsg(t, 1024)
+	vv := saltpack.SingleVersionValidator(saltpack.Version2())
+	ciphertext, err := saltpack.Seal(saltpack.Version2(), msg, senderKey, []saltpack.BoxPublicKey{receiver})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = senderKey.(*KernelBoxKey).Err(); err != nil {
+		t.Fatal(err)
+	}
+	mki, plaintext, err := saltpack.Open(vv, ciphertext, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, msg) || !saltpack.PublicKeyEqual(mki.SenderKey, sender) {
+		t.Fatal("bad decryption")
+	}
+
+	if err = k.RemoveBoxKey(receiver); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.RemoveBoxKey(receiver); err != ErrKeyNotFound {
+		t.Fatalf("wanted ErrKeyNotFound, got %v", err)
+	}
+	if _, _, err = saltpack.Open(vv, ciphertext, k); err != saltpack.ErrNoDecryptionKey {
+		t.Fatalf("wanted ErrNoDecryptionKey, got %v", err)
+	}
+
+	// A key that's removed after it's looked up can't be used.
+	if err = k.RemoveBoxKey(sender); err != nil {
+		t.Fatal(err)
+	}
+	senderKey.Box(receiver, saltpack.Nonce{}, msg)
+	if senderKey.(*KernelBoxKey).Err() == nil {
+		t.Fatal("Box with a removed key succeeded")
+	}
+}
+
+func TestKernelKeyringSign(t *testing.T) {
+	k := newTestKernelKeyring(t)
+	kr := NewKeyring()
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.AddSigningKey(sk); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := k.SigningKey(sk.pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := k.GetAllSigningSecretKeys(); len(keys) != 1 || !saltpack.PublicKeyEqual(keys[0].GetPublicKey(), sk.pub) {
+		t.Fatalf("bad signing keys %v", keys)
+	}
+	if len(k.GetAllBoxSecretKeys()) != 0 {
+		t.Fatal("signing key listed as a box key")
+	}
+
+	msg := randomMsg(t, 1024)
+	sig, err := saltpack.Sign(saltpack.Version2(), msg, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, verified, err := saltpack.Verify(saltpack.SingleVersionValidator(saltpack.Version2()), sig, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(verified, msg) {
+		t.Fatal("bad verification")
+	}
+
+	if err = k.RemoveSigningKey(sk.pub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = signer.Sign(msg); err == nil {
+		t.Fatal("signed with a removed key")
+	}
+	if _, err = k.SigningKey(sk.pub); err != ErrKeyNotFound {
+		t.Fatalf("wanted ErrKeyNotFound, got %v", err)
+	}
+}
@@ -5,6 +5,8 @@ package basic
 
 import (
 	"crypto/rand"
+	"sync"
+	"time"
 
 	"github.com/keybase/saltpack"
 	"golang.org/x/crypto/ed25519"
@@ -102,6 +104,12 @@ func (k SecretKey) Precompute(peer saltpack.BoxPublicKey) saltpack.BoxPrecompute
 	return res
 }
 
+// Wipe zeroes the secret key. A SecretKey is copied by value, so copies
+// made before Wipe is called are unaffected.
+func (k *SecretKey) Wipe() {
+	clear(k.sec[:])
+}
+
 // NewSecretKey makes a new SecretKey from the raw 32-byte arrays
 // the represent Box public and secret keys.
 func NewSecretKey(pub, sec *[32]byte) SecretKey {
@@ -132,18 +140,35 @@ func (k PrecomputedSharedKey) Unbox(nonce saltpack.Nonce, msg []byte) ([]byte, e
 
 var _ saltpack.BoxPrecomputedSharedKey = PrecomputedSharedKey{}
 
-// Keyring holds signing and box secret/public keypairs.
+// Wipe zeroes the shared key. A PrecomputedSharedKey is copied by value,
+// so copies made before Wipe is called are unaffected.
+func (k *PrecomputedSharedKey) Wipe() {
+	clear(k[:])
+}
+
+var _ saltpack.Wiper = (*PrecomputedSharedKey)(nil)
+
+// Keyring holds signing and box secret/public keypairs. It's safe for
+// concurrent use, so keys can be rotated while messages are being
+// decrypted.
 type Keyring struct {
 	EphemeralKeyCreator
-	encKeys map[PublicKey]SecretKey
-	sigKeys map[SigningPublicKey]SigningSecretKey
+
+	mu          sync.RWMutex
+	encKeys     map[PublicKey]SecretKey
+	hybridKeys  map[PublicKey]*HybridSecretKey
+	sigKeys     map[SigningPublicKey]SigningSecretKey
+	sigStatus   map[SigningPublicKey]saltpack.KeyStatus
+	generations []Generation // oldest first; the last is current
 }
 
 // NewKeyring makes an empty new basic keyring.
 func NewKeyring() *Keyring {
 	return &Keyring{
-		encKeys: make(map[PublicKey]SecretKey),
-		sigKeys: make(map[SigningPublicKey]SigningSecretKey),
+		encKeys:    make(map[PublicKey]SecretKey),
+		hybridKeys: make(map[PublicKey]*HybridSecretKey),
+		sigKeys:    make(map[SigningPublicKey]SigningSecretKey),
+		sigStatus:  make(map[SigningPublicKey]saltpack.KeyStatus),
 	}
 }
 
@@ -151,6 +176,8 @@ func NewKeyring() *Keyring {
 // first the public, and then the secret key halves.
 func (k *Keyring) ImportBoxKey(pub, sec *[32]byte) {
 	nk := NewSecretKey(pub, sec)
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	k.encKeys[nk.pub] = nk
 }
 
@@ -160,12 +187,25 @@ func (k *Keyring) GenerateBoxKey() (*SecretKey, error) {
 	if err != nil {
 		return nil, err
 	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	k.encKeys[ret.pub] = *ret
 	return ret, nil
 }
 
 // GenerateSigningKey generates a signing key and import it into the keyring.
 func (k *Keyring) GenerateSigningKey() (*SigningSecretKey, error) {
+	ret, err := generateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.sigKeys[ret.pub] = *ret
+	return ret, nil
+}
+
+func generateSigningKey() (*SigningSecretKey, error) {
 	pub, sec, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return nil, err
@@ -182,14 +222,18 @@ func (k *Keyring) GenerateSigningKey() (*SigningSecretKey, error) {
 	}
 	var privArray [ed25519.PrivateKeySize]byte
 	copy(privArray[:], sec)
+	clear(sec)
 
 	ret := NewSigningSecretKey(&pubArray, &privArray)
+	clear(privArray[:])
 	return &ret, nil
 }
 
 // ImportSigningKey imports the raw signing key into the keyring.
 func (k *Keyring) ImportSigningKey(pub *[ed25519.PublicKeySize]byte, sec *[ed25519.PrivateKeySize]byte) {
 	nk := NewSigningSecretKey(pub, sec)
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	k.sigKeys[nk.pub] = nk
 }
 
@@ -203,7 +247,12 @@ func kidToPublicKey(kid []byte) PublicKey {
 // given the possible key IDs. It returns the index and the key, if found, and -1
 // and nil otherwise.
 func (k *Keyring) LookupBoxSecretKey(kids [][]byte) (int, saltpack.BoxSecretKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
 	for i, kid := range kids {
+		if sk, ok := k.hybridKeys[kidToPublicKey(kid)]; ok {
+			return i, *sk
+		}
 		if sk, ok := k.encKeys[kidToPublicKey(kid)]; ok {
 			return i, sk
 		}
@@ -217,9 +266,15 @@ func (k *Keyring) LookupBoxPublicKey(kid []byte) saltpack.BoxPublicKey {
 	return kidToPublicKey(kid)
 }
 
-// GetAllBoxSecretKeys returns all secret Box keys in the keyring.
+// GetAllBoxSecretKeys returns all secret Box keys in the keyring,
+// including the hybrid ones.
 func (k *Keyring) GetAllBoxSecretKeys() []saltpack.BoxSecretKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
 	var out []saltpack.BoxSecretKey
+	for _, v := range k.hybridKeys {
+		out = append(out, *v)
+	}
 	for _, v := range k.encKeys {
 		out = append(out, v)
 	}
@@ -232,7 +287,14 @@ func (k *Keyring) ImportBoxEphemeralKey(kid []byte) saltpack.BoxPublicKey {
 	return kidToPublicKey(kid)
 }
 
-var _ saltpack.Keyring = (*Keyring)(nil)
+// ConcurrentSecretKeys returns true, since the keyring's secret keys are
+// values that are safe for concurrent use, so hidden receivers can be
+// tried on several goroutines at once.
+func (k *Keyring) ConcurrentSecretKeys() bool {
+	return true
+}
+
+var _ saltpack.ConcurrentKeyring = (*Keyring)(nil)
 
 // SigningPublicKey is a basic public key used for verifying signatures.
 // It's just a wrapper around an array of bytes.
@@ -290,6 +352,12 @@ func (k SigningPublicKey) Verify(msg []byte, sig []byte) error {
 
 var _ saltpack.SigningPublicKey = SigningPublicKey{}
 
+// Wipe zeroes the secret key. A SigningSecretKey is copied by value, so
+// copies made before Wipe is called are unaffected.
+func (k *SigningSecretKey) Wipe() {
+	clear(k.sec[:])
+}
+
 // NewSigningSecretKey creates a new basic signing key from byte arrays.
 func NewSigningSecretKey(pub *[ed25519.PublicKeySize]byte, sec *[ed25519.PrivateKeySize]byte) SigningSecretKey {
 	return SigningSecretKey{
@@ -310,9 +378,102 @@ func kidToSigningPublicKey(kid []byte) SigningPublicKey {
 }
 
 // LookupSigningPublicKey turns the given key ID ("kid") into a corresponding
-// signing public key.
+// signing public key. It returns nil if the key has been revoked, or isn't
+// valid at the current time.
 func (k *Keyring) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
-	return kidToSigningPublicKey(kid)
+	pk, status := k.LookupSigningPublicKeyWithStatus(kid)
+	if status.Check(kid, time.Now()) != nil {
+		return nil
+	}
+	return pk
 }
 
-var _ saltpack.SigKeyring = (*Keyring)(nil)
+// LookupSigningPublicKeyWithStatus turns the given key ID ("kid") into a
+// corresponding signing public key, along with its status, as set by
+// SetSigningKeyStatus or RevokeSigningKey.
+func (k *Keyring) LookupSigningPublicKeyWithStatus(kid []byte) (saltpack.SigningPublicKey, saltpack.KeyStatus) {
+	pk := kidToSigningPublicKey(kid)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return pk, k.sigStatus[pk]
+}
+
+// SetSigningKeyStatus sets the validity period and revocation status of
+// the given signing public key. Verification with this keyring fails for
+// keys that have been revoked, or aren't valid at the time.
+func (k *Keyring) SetSigningKeyStatus(pub SigningPublicKey, status saltpack.KeyStatus) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.setSigningKeyStatusLocked(pub, status)
+}
+
+func (k *Keyring) setSigningKeyStatusLocked(pub SigningPublicKey, status saltpack.KeyStatus) {
+	if k.sigStatus == nil {
+		k.sigStatus = make(map[SigningPublicKey]saltpack.KeyStatus)
+	}
+	k.sigStatus[pub] = status
+}
+
+// RevokeSigningKey marks the given signing public key as revoked as of
+// now, for the given reason.
+func (k *Keyring) RevokeSigningKey(pub SigningPublicKey, reason string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	status := k.sigStatus[pub]
+	status.Revoked = true
+	status.RevokedAt = time.Now()
+	status.Reason = reason
+	k.setSigningKeyStatusLocked(pub, status)
+}
+
+// ImportKeyBundle sets the validity period of each signing key in b, which
+// should have come from saltpack.VerifyKeyBundle, to the one in the
+// bundle. Keys that have been revoked stay revoked.
+func (k *Keyring) ImportKeyBundle(b *saltpack.KeyBundle) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, bk := range b.SigningKeys {
+		pub := kidToSigningPublicKey(bk.Key.ToKID())
+		status := k.sigStatus[pub]
+		status.NotBefore, status.NotAfter = bk.NotBefore, bk.NotAfter
+		k.setSigningKeyStatusLocked(pub, status)
+	}
+}
+
+// GetAllSigningSecretKeys returns all secret signing keys in the keyring.
+func (k *Keyring) GetAllSigningSecretKeys() []saltpack.SigningSecretKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	var out []saltpack.SigningSecretKey
+	for _, v := range k.sigKeys {
+		out = append(out, v)
+	}
+	return out
+}
+
+var _ saltpack.SigKeyringWithStatus = (*Keyring)(nil)
+
+// Wipe zeroes all the secret keys in the keyring and removes them, along
+// with its key generations. The keys that lookups have already returned
+// are copies, and should be wiped separately.
+func (k *Keyring) Wipe() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// The range variables are copies, so wipe the stored keys in place.
+	for pub, sk := range k.encKeys {
+		sk.Wipe()
+		k.encKeys[pub] = sk
+		delete(k.encKeys, pub)
+	}
+	for pub, sk := range k.hybridKeys {
+		sk.Wipe()
+		delete(k.hybridKeys, pub)
+	}
+	for pub, sk := range k.sigKeys {
+		sk.Wipe()
+		k.sigKeys[pub] = sk
+		delete(k.sigKeys, pub)
+	}
+	clear(k.sigStatus)
+	k.generations = nil
+}
@@ -7,6 +7,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/keybase/saltpack"
 )
@@ -137,3 +138,161 @@ func TestKeyBasic(t *testing.T) {
 	}
 	runTestsOverVersions(t, "testBasic", tests)
 }
+
+func TestRevokeSigningKey(t *testing.T) {
+	kr := NewKeyring()
+	k1, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := randomMsg(t, 1024)
+	sig, err := saltpack.Sign(saltpack.Version2(), msg, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vv := saltpack.SingleVersionValidator(saltpack.Version2())
+	if _, _, err = saltpack.Verify(vv, sig, kr); err != nil {
+		t.Fatal(err)
+	}
+
+	kr.SetSigningKeyStatus(k1.pub, saltpack.KeyStatus{NotAfter: time.Now().Add(-time.Minute)})
+	_, _, err = saltpack.Verify(vv, sig, kr)
+	if _, ok := err.(saltpack.ErrExpiredKey); !ok {
+		t.Fatalf("wanted ErrExpiredKey, got %v", err)
+	}
+
+	kr.RevokeSigningKey(k1.pub, "superseded")
+	_, _, err = saltpack.Verify(vv, sig, kr)
+	revoked, ok := err.(saltpack.ErrRevokedKey)
+	if !ok {
+		t.Fatalf("wanted ErrRevokedKey, got %v", err)
+	}
+	if revoked.Reason != "superseded" || revoked.RevokedAt.IsZero() {
+		t.Fatalf("bad revocation %+v", revoked)
+	}
+	if kr.LookupSigningPublicKey(k1.pub.ToKID()) != nil {
+		t.Fatal("revoked key returned by LookupSigningPublicKey")
+	}
+}
+
+func TestKeyringWipe(t *testing.T) {
+	kr := NewKeyring()
+	boxKey, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigKey, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = kr.Rotate("g1"); err != nil {
+		t.Fatal(err)
+	}
+
+	kr.Wipe()
+	if len(kr.GetAllBoxSecretKeys()) != 0 {
+		t.Fatal("box keys left after Wipe")
+	}
+	if err = kr.RemoveSigningKey(sigKey.pub); err != ErrKeyNotFound {
+		t.Fatalf("wanted ErrKeyNotFound, got %v", err)
+	}
+	if _, err = kr.CurrentBoxKey(); err != ErrNoCurrentGeneration {
+		t.Fatalf("wanted ErrNoCurrentGeneration, got %v", err)
+	}
+
+	// Keys handed out earlier are copies, which are wiped separately.
+	if *boxKey.GetRawSecretKey() == ([32]byte{}) {
+		t.Fatal("returned box key wiped by keyring Wipe")
+	}
+	boxKey.Wipe()
+	sigKey.Wipe()
+	if *boxKey.GetRawSecretKey() != ([32]byte{}) || *sigKey.GetRawSecretKey() != ([64]byte{}) {
+		t.Fatal("secret keys not wiped")
+	}
+}
+
+func TestKeyringWipeHybridKeys(t *testing.T) {
+	kr := NewKeyring()
+	hk, err := kr.GenerateHybridBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := kr.hybridKeys[hk.pub]
+	if stored == nil {
+		t.Fatal("hybrid key not stored")
+	}
+
+	// The keyring's own copy is wiped, not just forgotten.
+	kr.Wipe()
+	if len(kr.hybridKeys) != 0 {
+		t.Fatal("hybrid keys left after Wipe")
+	}
+	if stored.sec != (saltpack.RawBoxKey{}) || stored.seed != ([len(hk.seed)]byte{}) {
+		t.Fatal("stored hybrid key not wiped")
+	}
+	if hk.sec == (saltpack.RawBoxKey{}) {
+		t.Fatal("returned hybrid key wiped by keyring Wipe")
+	}
+}
+
+func TestPrecomputeReturnsValue(t *testing.T) {
+	kr := NewKeyring()
+	k1, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Callers may rely on getting a PrecomputedSharedKey, and not a
+	// pointer to one.
+	if _, ok := k1.Precompute(k2.GetPublicKey()).(PrecomputedSharedKey); !ok {
+		t.Fatal("Precompute didn't return a PrecomputedSharedKey")
+	}
+}
+
+func TestImportKeyBundle(t *testing.T) {
+	kr := NewKeyring()
+	k1, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	boxKey, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := saltpack.KeyBundle{
+		Created:     time.Now(),
+		BoxKeys:     []saltpack.BundledBoxKey{{Key: boxKey.GetPublicKey()}},
+		SigningKeys: []saltpack.BundledSigningKey{{Key: k1.GetPublicKey(), NotAfter: time.Now().Add(-time.Minute)}},
+	}
+	signed, err := saltpack.SignKeyBundle(bundle, []saltpack.SigningSecretKey{k1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := saltpack.VerifyKeyBundle(signed, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := randomMsg(t, 1024)
+	sig, err := saltpack.Sign(saltpack.Version2(), msg, k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vv := saltpack.SingleVersionValidator(saltpack.Version2())
+	kr.ImportKeyBundle(verified)
+	_, _, err = saltpack.Verify(vv, sig, kr)
+	if _, ok := err.(saltpack.ErrExpiredKey); !ok {
+		t.Fatalf("wanted ErrExpiredKey, got %v", err)
+	}
+
+	// A revoked key stays revoked.
+	kr.RevokeSigningKey(k1.pub, "lost")
+	kr.ImportKeyBundle(verified)
+	_, _, err = saltpack.Verify(vv, sig, kr)
+	if _, ok := err.(saltpack.ErrRevokedKey); !ok {
+		t.Fatalf("wanted ErrRevokedKey, got %v", err)
+	}
+}
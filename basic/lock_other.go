@@ -0,0 +1,21 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+//go:build !unix
+
+package basic
+
+import (
+	"os"
+)
+
+// lockFile does nothing on platforms without flock, so processes sharing
+// a FileKeyring there have to coordinate some other way. Writes are still
+// atomic, so readers never see a partially written key.
+func lockFile(f *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
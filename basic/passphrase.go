@@ -0,0 +1,269 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"crypto/rand"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// encryptedKeysFormatName tells encrypted key files apart from keys
+// serialized in the clear by MarshalBinary.
+const encryptedKeysFormatName = saltpack.FormatName + " encrypted key"
+
+const kdfArgon2id = "argon2id"
+
+const encryptedKeysSaltLen = 16
+
+// KDFParams are the parameters for the Argon2id key derivation that
+// protects an encrypted key file. They're stored in the file, so they
+// only matter when encrypting.
+type KDFParams = saltpack.KDFParams
+
+// DefaultKDFParams returns the parameters that EncryptKeys uses when it's
+// given nil.
+func DefaultKDFParams() KDFParams {
+	return saltpack.DefaultKDFParams()
+}
+
+func checkKDFParams(p KDFParams) error {
+	if err := p.Validate(); err != nil {
+		return saltpack.ErrBadSerializedKey(err.Error())
+	}
+	return nil
+}
+
+// encryptedKeys is the msgpack form of an encrypted key file. Ciphertext
+// is the secretbox of the MarshalBinary output of the keys.
+type encryptedKeys struct {
+	_struct    bool             `codec:",toarray"` //nolint
+	FormatName string           `codec:"format_name"`
+	Version    saltpack.Version `codec:"vers"`
+	KDF        string           `codec:"kdf"`
+	Salt       []byte           `codec:"salt"`
+	Time       uint32           `codec:"time"`
+	Memory     uint32           `codec:"memory"`
+	Threads    uint8            `codec:"threads"`
+	Nonce      []byte           `codec:"nonce"`
+	Ciphertext []byte           `codec:"ctext"`
+}
+
+func (e encryptedKeys) params() KDFParams {
+	return KDFParams{Time: e.Time, Memory: e.Memory, Threads: e.Threads}
+}
+
+func sealKeys(plaintext []byte, passphrase []byte, p KDFParams) ([]byte, error) {
+	if err := checkKDFParams(p); err != nil {
+		return nil, err
+	}
+	e := encryptedKeys{
+		FormatName: encryptedKeysFormatName,
+		Version:    keyFormatVersion(),
+		KDF:        kdfArgon2id,
+		Salt:       make([]byte, encryptedKeysSaltLen),
+		Time:       p.Time,
+		Memory:     p.Memory,
+		Threads:    p.Threads,
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(e.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := p.DeriveKey(passphrase, e.Salt)
+	defer clear(key[:])
+	e.Nonce = nonce[:]
+	e.Ciphertext = secretbox.Seal(nil, plaintext, &nonce, (*[32]byte)(key))
+
+	var ret []byte
+	err := codec.NewEncoderBytes(&ret, codecHandle()).Encode(e)
+	return ret, err
+}
+
+// isEncryptedKeyFile tells whether b is framed as the output of
+// EncryptKeys, whether or not it decrypts.
+func isEncryptedKeyFile(b []byte) bool {
+	var e encryptedKeys
+	err := codec.NewDecoderBytes(b, codecHandle()).Decode(&e)
+	return err == nil && e.FormatName == encryptedKeysFormatName
+}
+
+// openKeys returns the serialized keys in an encrypted key file, along
+// with the KDF parameters it used.
+func openKeys(b []byte, passphrase []byte) ([]byte, KDFParams, error) {
+	var e encryptedKeys
+	if err := codec.NewDecoderBytes(b, codecHandle()).Decode(&e); err != nil {
+		return nil, KDFParams{}, saltpack.ErrBadSerializedKey(err.Error())
+	}
+	if e.FormatName != encryptedKeysFormatName {
+		return nil, KDFParams{}, saltpack.ErrBadSerializedKey(fmt.Sprintf("bad format name %q", e.FormatName))
+	}
+	if e.Version.Major != keyFormatVersion().Major {
+		return nil, KDFParams{}, saltpack.ErrBadSerializedKey(fmt.Sprintf("unsupported version %s", e.Version))
+	}
+	if e.KDF != kdfArgon2id {
+		return nil, KDFParams{}, saltpack.ErrBadSerializedKey(fmt.Sprintf("unknown KDF %q", e.KDF))
+	}
+	p := e.params()
+	if err := checkKDFParams(p); err != nil {
+		return nil, KDFParams{}, err
+	}
+	if len(e.Salt) != encryptedKeysSaltLen {
+		return nil, KDFParams{}, saltpack.ErrBadSerializedKey("bad salt length")
+	}
+	if len(e.Nonce) != 24 {
+		return nil, KDFParams{}, saltpack.ErrBadSerializedKey("bad nonce length")
+	}
+	key := p.DeriveKey(passphrase, e.Salt)
+	defer clear(key[:])
+	plaintext, ok := secretbox.Open(nil, e.Ciphertext, (*[24]byte)(e.Nonce), (*[32]byte)(key))
+	if !ok {
+		return nil, KDFParams{}, saltpack.ErrBadPassphrase
+	}
+	return plaintext, p, nil
+}
+
+// EncryptKeys serializes the given key or keyring, and encrypts it under
+// the passphrase, with a key derived by Argon2id. If params is nil,
+// DefaultKDFParams are used.
+func EncryptKeys(k Exportable, passphrase []byte, params *KDFParams) ([]byte, error) {
+	p := DefaultKDFParams()
+	if params != nil {
+		p = *params
+	}
+	plaintext, err := k.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	defer clear(plaintext)
+	return sealKeys(plaintext, passphrase, p)
+}
+
+// DecryptKeys decrypts the output of EncryptKeys, returning the key or
+// keyring as ParseKey would. It returns saltpack.ErrBadPassphrase if the
+// passphrase is wrong.
+func DecryptKeys(b []byte, passphrase []byte) (Exportable, error) {
+	plaintext, _, err := openKeys(b, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(plaintext)
+	return ParseKey(plaintext)
+}
+
+// ChangePassphrase re-encrypts the output of EncryptKeys under a new
+// passphrase, with a fresh salt and nonce, and the same KDF parameters.
+func ChangePassphrase(b []byte, oldPassphrase, newPassphrase []byte) ([]byte, error) {
+	plaintext, p, err := openKeys(b, oldPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(plaintext)
+	// Make sure it's something we'd be able to use.
+	if _, err = ParseKey(plaintext); err != nil {
+		return nil, err
+	}
+	return sealKeys(plaintext, newPassphrase, p)
+}
+
+// ImportEncryptedKeys decrypts the output of EncryptKeys, and imports the
+// secret keys in it into the keyring. Public keys are ignored, since the
+// keyring only holds secret keys.
+func (k *Keyring) ImportEncryptedKeys(b []byte, passphrase []byte) error {
+	ek, err := DecryptKeys(b, passphrase)
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	switch ek := ek.(type) {
+	case SecretKey:
+		k.encKeys[ek.pub] = ek
+	case HybridSecretKey:
+		k.addHybridKeyLocked(ek)
+	case SigningSecretKey:
+		k.sigKeys[ek.pub] = ek
+	case *Keyring:
+		maps.Copy(k.encKeys, ek.encKeys)
+		for _, sk := range ek.hybridKeys {
+			k.addHybridKeyLocked(*sk)
+		}
+		maps.Copy(k.sigKeys, ek.sigKeys)
+	}
+	return nil
+}
+
+// writeKeyFileAtomically replaces the file at path with one that holds b
+// and is only readable by its owner. The file is written to a temporary
+// file in the same directory and synced before it's renamed into place,
+// so path never holds a partial key file, and an existing file's
+// permissions don't carry over.
+func writeKeyFileAtomically(path string, b []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	if err = tmp.Chmod(0o600); err != nil {
+		return err
+	}
+	if _, err = tmp.Write(b); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteEncryptedKeyFile writes the output of EncryptKeys to the file at
+// path, which is only readable by its owner.
+func WriteEncryptedKeyFile(path string, k Exportable, passphrase []byte, params *KDFParams) error {
+	b, err := EncryptKeys(k, passphrase, params)
+	if err != nil {
+		return err
+	}
+	return writeKeyFileAtomically(path, b)
+}
+
+// ImportEncryptedKeyFile reads a file written by WriteEncryptedKeyFile,
+// and imports the keys in it into the keyring.
+func (k *Keyring) ImportEncryptedKeyFile(path string, passphrase []byte) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return k.ImportEncryptedKeys(b, passphrase)
+}
+
+// ChangeKeyFilePassphrase changes the passphrase of the encrypted key file
+// at path, replacing it atomically.
+func ChangeKeyFilePassphrase(path string, oldPassphrase, newPassphrase []byte) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	b, err = ChangePassphrase(b, oldPassphrase, newPassphrase)
+	if err != nil {
+		return err
+	}
+	return writeKeyFileAtomically(path, b)
+}
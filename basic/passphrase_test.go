@@ -0,0 +1,204 @@
+package basic
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+)
+
+// testKDFParams are cheap, so that tests run quickly.
+var testKDFParams = &KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+func TestEncryptKeys(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass := []byte("correct horse battery staple")
+	b, err := EncryptKeys(*bk, pass, testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, bk.sec[:]) {
+		t.Fatal("secret key in the clear")
+	}
+	k, err := DecryptKeys(b, pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.(SecretKey) != *bk {
+		t.Fatal("secret key didn't round-trip")
+	}
+	if _, err = DecryptKeys(b, []byte("wrong")); err != saltpack.ErrBadPassphrase {
+		t.Fatalf("wanted ErrBadPassphrase, got %v", err)
+	}
+
+	b2, err := ChangePassphrase(b, pass, []byte("new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DecryptKeys(b2, pass); err != saltpack.ErrBadPassphrase {
+		t.Fatalf("wanted ErrBadPassphrase, got %v", err)
+	}
+	if _, err = DecryptKeys(b2, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ChangePassphrase(b, []byte("wrong"), []byte("new")); err != saltpack.ErrBadPassphrase {
+		t.Fatalf("wanted ErrBadPassphrase, got %v", err)
+	}
+
+	// Unencrypted keys aren't encrypted key files.
+	plain, err := bk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = DecryptKeys(plain, pass); err == nil {
+		t.Fatal("decrypted unencrypted key")
+	}
+}
+
+func TestEncryptKeysBadParams(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []KDFParams{
+		{Time: 0, Memory: 64, Threads: 1},
+		{Time: 1, Memory: 64, Threads: 0},
+		{Time: 1, Memory: 1 << 30, Threads: 1},
+	} {
+		_, err := EncryptKeys(*bk, []byte("pass"), &p)
+		if _, ok := err.(saltpack.ErrBadSerializedKey); !ok {
+			t.Fatalf("wanted ErrBadSerializedKey for %+v, got %v", p, err)
+		}
+	}
+}
+
+func TestDecryptKeysBadSalt(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass := []byte("pass")
+	b, err := EncryptKeys(*bk, pass, testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A key file with a truncated or padded salt is corrupt, even with the
+	// right passphrase.
+	for _, salt := range [][]byte{nil, make([]byte, encryptedKeysSaltLen-1), make([]byte, encryptedKeysSaltLen+1)} {
+		var e encryptedKeys
+		if err = codec.NewDecoderBytes(b, codecHandle()).Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		e.Salt = salt
+		var bad []byte
+		if err = codec.NewEncoderBytes(&bad, codecHandle()).Encode(e); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = DecryptKeys(bad, pass); err == nil {
+			t.Fatalf("decrypted with a %d-byte salt", len(salt))
+		} else if _, ok := err.(saltpack.ErrBadSerializedKey); !ok {
+			t.Fatalf("wanted ErrBadSerializedKey for a %d-byte salt, got %v", len(salt), err)
+		}
+	}
+}
+
+func TestEncryptedKeyFile(t *testing.T) {
+	kr := NewKeyring()
+	bk, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr.ImportSigningKey(sk.GetRawPublicKey(), sk.GetRawSecretKey())
+
+	path := filepath.Join(t.TempDir(), "keys")
+	if err = WriteEncryptedKeyFile(path, kr, []byte("pass"), testKDFParams); err != nil {
+		t.Fatal(err)
+	}
+	if err = ChangeKeyFilePassphrase(path, []byte("pass"), []byte("pass2")); err != nil {
+		t.Fatal(err)
+	}
+
+	kr2 := NewKeyring()
+	if err = kr2.ImportEncryptedKeyFile(path, []byte("pass")); err != saltpack.ErrBadPassphrase {
+		t.Fatalf("wanted ErrBadPassphrase, got %v", err)
+	}
+	if err = kr2.ImportEncryptedKeyFile(path, []byte("pass2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, k := kr2.LookupBoxSecretKey([][]byte{bk.pub.ToKID()}); k == nil {
+		t.Fatal("box key missing from imported keyring")
+	}
+	if kr2.sigKeys[sk.pub] != *sk {
+		t.Fatal("signing key missing from imported keyring")
+	}
+}
+
+func TestEncryptedKeyFilePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions on Windows")
+	}
+	kr := NewKeyring()
+	if _, err := kr.GenerateBoxKey(); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys")
+	checkMode := func() {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := fi.Mode().Perm(); mode != 0o600 {
+			t.Fatalf("key file has mode %o", mode)
+		}
+	}
+
+	// An existing world-readable file is replaced by a private one.
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteEncryptedKeyFile(path, kr, []byte("pass"), testKDFParams); err != nil {
+		t.Fatal(err)
+	}
+	checkMode()
+
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ChangeKeyFilePassphrase(path, []byte("pass"), []byte("pass2")); err != nil {
+		t.Fatal(err)
+	}
+	checkMode()
+
+	// No temporary files are left behind, even when changing the
+	// passphrase fails.
+	if err := ChangeKeyFilePassphrase(path, []byte("pass"), []byte("pass3")); err != saltpack.ErrBadPassphrase {
+		t.Fatalf("wanted ErrBadPassphrase, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("wanted only the key file, got %d files", len(entries))
+	}
+}
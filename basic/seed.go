@@ -0,0 +1,257 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/keybase/saltpack/encoding/wordlist"
+	"golang.org/x/crypto/ed25519"
+)
+
+var (
+	// ErrBadMnemonic is returned for a mnemonic phrase with the wrong
+	// number of words, a word that isn't in the word list, or a bad
+	// checksum.
+	ErrBadMnemonic = errors.New("invalid mnemonic phrase")
+
+	// ErrBadSeed is returned by NewSeed for a seed that's too short.
+	ErrBadSeed = errors.New("seed must be at least 16 bytes")
+)
+
+const (
+	bip39Iterations = 2048
+	bip39SeedLen    = 64
+	minSeedLen      = 16
+	paperKeyWords   = 13
+
+	seedRootContext   = "saltpack seed"
+	seedChildContext  = "saltpack seed child\x00"
+	seedBoxContext    = "saltpack seed box key"
+	seedSignContext   = "saltpack seed signing key"
+	paperKeySaltInput = "saltpack paper key"
+)
+
+// NewMnemonic encodes entropy, which must be 16, 20, 24, 28 or 32 bytes,
+// as a BIP 39 mnemonic phrase of 12 to 24 English words.
+func NewMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", ErrBadMnemonic
+	}
+	// The checksum is the first len(entropy)/4 bits of its SHA-256, which
+	// makes the total a multiple of 11 bits, so no padding is added.
+	sum := sha256.Sum256(entropy)
+	checksumBits := len(entropy) / 4
+	words := wordlist.Encode(append(append([]byte(nil), entropy...), sum[0]))
+	return strings.Join(words[:(len(entropy)*8+checksumBits)/wordlist.BitsPerWord], " "), nil
+}
+
+// GenerateMnemonic returns a random BIP 39 mnemonic phrase with the given
+// number of words, which must be 12, 15, 18, 21 or 24.
+func GenerateMnemonic(words int) (string, error) {
+	if words%3 != 0 {
+		return "", ErrBadMnemonic
+	}
+	entropy := make([]byte, words*4/3)
+	defer clear(entropy)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return NewMnemonic(entropy)
+}
+
+// mnemonicEntropy checks the words and checksum of a BIP 39 mnemonic
+// phrase, returning its entropy.
+func mnemonicEntropy(words []string) ([]byte, error) {
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrBadMnemonic
+	}
+	totalBits := len(words) * wordlist.BitsPerWord
+	checksumBits := totalBits / 33
+	buf := make([]byte, (totalBits+7)/8)
+	for i, w := range words {
+		idx, ok := wordlist.Index(w)
+		if !ok {
+			return nil, ErrBadMnemonic
+		}
+		for b := range wordlist.BitsPerWord {
+			if idx>>(wordlist.BitsPerWord-1-b)&1 == 1 {
+				pos := i*wordlist.BitsPerWord + b
+				buf[pos/8] |= 0x80 >> (pos % 8)
+			}
+		}
+	}
+	entropy := buf[:(totalBits-checksumBits)/8]
+	sum := sha256.Sum256(entropy)
+	mask := byte(0xff << (8 - checksumBits))
+	if buf[len(entropy)]&mask != sum[0]&mask {
+		return nil, ErrBadMnemonic
+	}
+	return entropy, nil
+}
+
+// MnemonicToSeed checks a BIP 39 mnemonic phrase and turns it into a 64-byte
+// seed, with PBKDF2 as BIP 39 specifies, so the same phrase and passphrase
+// give the same seed as other BIP 39 implementations. The passphrase may
+// be empty. Only the English word list is supported, so the phrase and
+// passphrase aren't Unicode-normalized.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	entropy, err := mnemonicEntropy(words)
+	clear(entropy)
+	if err != nil {
+		return nil, err
+	}
+	return pbkdf2.Key(sha512.New, strings.Join(words, " "), []byte("mnemonic"+passphrase), bip39Iterations, bip39SeedLen)
+}
+
+// GeneratePaperKey returns a random paper key phrase of 13 words, which
+// encodes 143 bits, for SeedFromPaperKey.
+func GeneratePaperKey() (string, error) {
+	words := make([]string, paperKeyWords)
+	var b [2]byte
+	for i := range words {
+		if _, err := rand.Read(b[:]); err != nil {
+			return "", err
+		}
+		words[i] = wordlist.English[binary.BigEndian.Uint16(b[:])%uint16(len(wordlist.English))]
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Seed is a secret that box and signing keys are derived from, so that the
+// keys can be regenerated from a backup of the seed, like a mnemonic or
+// paper key phrase. Derive makes labeled child seeds, for a key hierarchy
+// like one per device, then per purpose, then per generation. A child
+// seed doesn't reveal its parent or siblings.
+type Seed struct {
+	key [32]byte
+}
+
+// NewSeed makes a seed from secret bytes, which must be at least 16 bytes
+// long and uniformly random, like the output of MnemonicToSeed.
+func NewSeed(b []byte) (*Seed, error) {
+	if len(b) < minSeedLen {
+		return nil, ErrBadSeed
+	}
+	var s Seed
+	s.setHMAC([]byte(seedRootContext), b)
+	return &s, nil
+}
+
+// SeedFromMnemonic checks a BIP 39 mnemonic phrase and makes a seed from
+// it and the passphrase, which may be empty.
+func SeedFromMnemonic(mnemonic, passphrase string) (*Seed, error) {
+	b, err := MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(b)
+	return NewSeed(b)
+}
+
+// SeedFromPaperKey makes a seed from a paper key phrase, like the output
+// of GeneratePaperKey, by running it through Argon2id with the given
+// parameters, or DefaultKDFParams if p is nil. The phrase is
+// case-insensitive and extra spaces are ignored. The same parameters have
+// to be used every time, or the seed will be different.
+func SeedFromPaperKey(phrase string, p *KDFParams) (*Seed, error) {
+	params := DefaultKDFParams()
+	if p != nil {
+		params = *p
+	}
+	if err := checkKDFParams(params); err != nil {
+		return nil, err
+	}
+	normalized := []byte(strings.Join(strings.Fields(strings.ToLower(phrase)), " "))
+	defer clear(normalized)
+	salt := sha256.Sum256([]byte(paperKeySaltInput))
+	key := params.DeriveKey(normalized, salt[:])
+	defer clear(key[:])
+	return NewSeed(key[:])
+}
+
+func (s *Seed) setHMAC(key []byte, parts ...[]byte) {
+	mac := hmac.New(sha512.New, key)
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	sum := mac.Sum(nil)
+	defer clear(sum)
+	copy(s.key[:], sum)
+}
+
+// Derive returns the child seed at the given path of labels below s. For
+// instance, s.Derive("laptop", "mail", "2") is the same as
+// s.Derive("laptop").Derive("mail").Derive("2").
+func (s *Seed) Derive(labels ...string) *Seed {
+	child := *s
+	for _, label := range labels {
+		var n [4]byte
+		//nolint:gosec // labels are short, conversion is safe
+		binary.BigEndian.PutUint32(n[:], uint32(len(label)))
+		child.setHMAC(child.key[:], []byte(seedChildContext), n[:], []byte(label))
+	}
+	return &child
+}
+
+func (s *Seed) expand(context string) [32]byte {
+	mac := hmac.New(sha512.New, s.key[:])
+	mac.Write([]byte(context))
+	sum := mac.Sum(nil)
+	defer clear(sum)
+	var ret [32]byte
+	copy(ret[:], sum)
+	return ret
+}
+
+// BoxKey returns the box key derived from s.
+func (s *Seed) BoxKey() SecretKey {
+	sec := s.expand(seedBoxContext)
+	defer clear(sec[:])
+	return secretKeyFromScalar(&sec)
+}
+
+// SigningKey returns the signing key derived from s.
+func (s *Seed) SigningKey() SigningSecretKey {
+	seed := s.expand(seedSignContext)
+	defer clear(seed[:])
+	edSec := ed25519.NewKeyFromSeed(seed[:])
+	defer clear(edSec)
+	sk, err := SigningSecretKeyFromEd25519(edSec)
+	if err != nil {
+		panic(err)
+	}
+	return sk
+}
+
+// Identity returns the identity derived from s, whose box key is converted
+// from its signing key. It's the same as
+// IdentityFromSigningSecretKey(s.SigningKey()).
+func (s *Seed) Identity() Identity {
+	return IdentityFromSigningSecretKey(s.SigningKey())
+}
+
+// Wipe zeroes the seed.
+func (s *Seed) Wipe() {
+	clear(s.key[:])
+}
+
+// ImportSeed imports the box key and the signing key derived from s into
+// the keyring.
+func (k *Keyring) ImportSeed(s *Seed) (*SecretKey, *SigningSecretKey) {
+	boxKey, sigKey := s.BoxKey(), s.SigningKey()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.encKeys[boxKey.pub] = boxKey
+	k.sigKeys[sigKey.pub] = sigKey
+	return &boxKey, &sigKey
+}
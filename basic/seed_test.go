@@ -0,0 +1,137 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+func TestMnemonic(t *testing.T) {
+	// The first test vector from the reference BIP 39 implementation.
+	const mnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	const seedHex = "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"
+
+	got, err := NewMnemonic(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != mnemonic {
+		t.Fatalf("got mnemonic %q", got)
+	}
+	seed, err := MnemonicToSeed(strings.ToUpper(mnemonic)+" ", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(seed) != seedHex {
+		t.Fatalf("got seed %x", seed)
+	}
+
+	for _, words := range []int{12, 15, 18, 21, 24} {
+		m, err := GenerateMnemonic(words)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(strings.Fields(m)); n != words {
+			t.Fatalf("wanted %d words, got %d", words, n)
+		}
+		if _, err = MnemonicToSeed(m, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The last word holds the checksum.
+	bad := strings.TrimSuffix(mnemonic, "about") + "abandon"
+	if _, err = MnemonicToSeed(bad, ""); err != ErrBadMnemonic {
+		t.Fatalf("wanted ErrBadMnemonic, got %v", err)
+	}
+	if _, err = MnemonicToSeed("abandon", ""); err != ErrBadMnemonic {
+		t.Fatalf("wanted ErrBadMnemonic, got %v", err)
+	}
+}
+
+func TestSeedDerivation(t *testing.T) {
+	s1, err := SeedFromMnemonic("legal winner thank year wave sausage worth useful legal winner thank yellow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := SeedFromMnemonic("legal winner thank year wave sausage worth useful legal winner thank yellow", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1.BoxKey() != s2.BoxKey() || s1.SigningKey() != s2.SigningKey() {
+		t.Fatal("derivation isn't deterministic")
+	}
+	if *s1.Derive("laptop", "mail") != *s1.Derive("laptop").Derive("mail") {
+		t.Fatal("path derivation doesn't match step by step derivation")
+	}
+	if s1.Derive("laptop").BoxKey() == s1.Derive("phone").BoxKey() ||
+		s1.Derive("a", "bc").BoxKey() == s1.Derive("ab", "c").BoxKey() ||
+		s1.Derive("laptop").BoxKey() == s1.BoxKey() {
+		t.Fatal("different paths derived the same key")
+	}
+	if s1.Identity() != IdentityFromSigningSecretKey(s1.SigningKey()) {
+		t.Fatal("bad identity")
+	}
+
+	// Keys regenerated from the seed decrypt what was sent to the
+	// original ones.
+	kr := NewKeyring()
+	boxKey, sigKey := kr.ImportSeed(s1.Derive("laptop"))
+	msg := randomMsg(t, 1024)
+	ciphertext, err := saltpack.Seal(saltpack.Version2(), msg, nil, []saltpack.BoxPublicKey{boxKey.pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewKeyring()
+	restored.ImportSeed(s2.Derive("laptop"))
+	_, plaintext, err := saltpack.Open(saltpack.SingleVersionValidator(saltpack.Version2()), ciphertext, restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, msg) {
+		t.Fatal("bad plaintext")
+	}
+	if restored.LookupSigningPublicKey(sigKey.pub.ToKID()) == nil {
+		t.Fatal("signing key not restored")
+	}
+
+	if _, err = NewSeed(make([]byte, 15)); err != ErrBadSeed {
+		t.Fatalf("wanted ErrBadSeed, got %v", err)
+	}
+}
+
+func TestSeedFromPaperKey(t *testing.T) {
+	phrase, err := GeneratePaperKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(strings.Fields(phrase)); n != 13 {
+		t.Fatalf("wanted 13 words, got %d", n)
+	}
+	params := KDFParams{Time: 1, Memory: 64, Threads: 1}
+	s1, err := SeedFromPaperKey(phrase, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := SeedFromPaperKey("  "+strings.ToUpper(phrase), &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s1 != *s2 {
+		t.Fatal("paper key isn't normalized")
+	}
+	params.Time = 2
+	s3, err := SeedFromPaperKey(phrase, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *s1 == *s3 {
+		t.Fatal("KDF parameters ignored")
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	// ErrNoSSHAgent is returned by DialSSHAgent when SSH_AUTH_SOCK isn't
+	// set.
+	ErrNoSSHAgent = errors.New("SSH_AUTH_SOCK not set")
+
+	// ErrNotEd25519Key is returned for an SSH key that isn't an Ed25519
+	// key, since saltpack signs only with Ed25519.
+	ErrNotEd25519Key = errors.New("not an ssh-ed25519 key")
+
+	// ErrAgentKeyNotFound is returned when the SSH agent doesn't hold the
+	// requested key.
+	ErrAgentKeyNotFound = errors.New("key not held by the SSH agent")
+)
+
+// DialSSHAgent connects to the SSH agent listening on SSH_AUTH_SOCK. The
+// caller should close the returned connection when it's done with the
+// agent.
+func DialSSHAgent() (agent.ExtendedAgent, net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, ErrNoSSHAgent
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, err
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// SSHAgentSigningKey is a saltpack.SigningSecretKey for an Ed25519 key
+// held by an SSH agent. The secret key never leaves the agent; each
+// signature is a request to it.
+type SSHAgentSigningKey struct {
+	agent  agent.Agent
+	sshPub ssh.PublicKey
+	pub    SigningPublicKey
+}
+
+var _ saltpack.SigningSecretKey = (*SSHAgentSigningKey)(nil)
+
+// NewSSHAgentSigningKey returns the signing key for pub, which must be
+// held by the given agent.
+func NewSSHAgentSigningKey(a agent.Agent, pub SigningPublicKey) (*SSHAgentSigningKey, error) {
+	keys, err := SSHAgentSigningKeys(a)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.pub == pub {
+			return k, nil
+		}
+	}
+	return nil, ErrAgentKeyNotFound
+}
+
+// SSHAgentSigningKeys returns signing keys for all the Ed25519 keys held
+// by the given agent. Keys of other types are skipped.
+func SSHAgentSigningKeys(a agent.Agent) ([]*SSHAgentSigningKey, error) {
+	agentKeys, err := a.List()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*SSHAgentSigningKey
+	for _, ak := range agentKeys {
+		sshPub, err := ssh.ParsePublicKey(ak.Blob)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := signingPublicKeyFromSSH(sshPub)
+		if err == ErrNotEd25519Key {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ret = append(ret, &SSHAgentSigningKey{agent: a, sshPub: sshPub, pub: pub})
+	}
+	return ret, nil
+}
+
+// Sign asks the agent to sign message.
+func (k *SSHAgentSigningKey) Sign(message []byte) ([]byte, error) {
+	sig, err := k.agent.Sign(k.sshPub, message)
+	if err != nil {
+		return nil, err
+	}
+	if sig.Format != ssh.KeyAlgoED25519 || len(sig.Blob) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("unexpected %q signature from SSH agent", sig.Format)
+	}
+	return sig.Blob, nil
+}
+
+// GetPublicKey returns the public key of the agent-held key.
+func (k *SSHAgentSigningKey) GetPublicKey() saltpack.SigningPublicKey {
+	return k.pub
+}
+
+// ParseAuthorizedSigningPublicKey parses an ssh-ed25519 public key in the
+// OpenSSH authorized_keys format, like a line of ~/.ssh/authorized_keys or
+// the contents of ~/.ssh/id_ed25519.pub, and returns it as a signing
+// public key, along with its comment.
+func ParseAuthorizedSigningPublicKey(line []byte) (SigningPublicKey, string, error) {
+	sshPub, comment, _, _, err := ssh.ParseAuthorizedKey(bytes.TrimSpace(line))
+	if err != nil {
+		return SigningPublicKey{}, "", err
+	}
+	pub, err := signingPublicKeyFromSSH(sshPub)
+	if err != nil {
+		return SigningPublicKey{}, "", err
+	}
+	return pub, comment, nil
+}
+
+func signingPublicKeyFromSSH(sshPub ssh.PublicKey) (SigningPublicKey, error) {
+	if sshPub.Type() != ssh.KeyAlgoED25519 {
+		return SigningPublicKey{}, ErrNotEd25519Key
+	}
+	cryptoPub, ok := sshPub.(ssh.CryptoPublicKey)
+	if !ok {
+		return SigningPublicKey{}, ErrNotEd25519Key
+	}
+	edPub, ok := cryptoPub.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok || len(edPub) != ed25519.PublicKeySize {
+		return SigningPublicKey{}, ErrNotEd25519Key
+	}
+	return NewSigningPublicKey((*[ed25519.PublicKeySize]byte)(edPub)), nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// serveSSHAgent serves an in-process agent on a Unix socket and points
+// SSH_AUTH_SOCK at it.
+func serveSSHAgent(t *testing.T, a agent.Agent) {
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(a, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+}
+
+func TestSSHAgentSigningKey(t *testing.T) {
+	edPub, edSec, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(edPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err = keyring.Add(agent.AddedKey{PrivateKey: edSec, Comment: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	serveSSHAgent(t, keyring)
+
+	a, conn, err := DialSSHAgent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line := ssh.MarshalAuthorizedKey(sshPub)
+	line = append(bytes.TrimSpace(line), " me@example.com\n"...)
+	pub, comment, err := ParseAuthorizedSigningPublicKey(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != "me@example.com" || !bytes.Equal(pub[:], edPub) {
+		t.Fatalf("bad authorized key %x %q", pub, comment)
+	}
+
+	signer, err := NewSSHAgentSigningKey(a, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := randomMsg(t, 1024)
+	vv := saltpack.SingleVersionValidator(saltpack.Version2())
+	kr := NewKeyring()
+
+	armored, err := saltpack.SignArmor62(saltpack.Version2(), msg, signer, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey, verified, _, err := saltpack.Dearmor62Verify(vv, armored, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(verified, msg) || !saltpack.PublicKeyEqual(skey, pub) {
+		t.Fatal("bad attached signature")
+	}
+
+	sig, err := saltpack.SignDetached(saltpack.Version2(), msg, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = saltpack.VerifyDetached(vv, msg, sig, kr); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSSHAgentSigningKey(a, NewSigningPublicKey((*[ed25519.PublicKeySize]byte)(other))); err != ErrAgentKeyNotFound {
+		t.Fatalf("wanted ErrAgentKeyNotFound, got %v", err)
+	}
+}
+
+func TestParseAuthorizedSigningPublicKeyNotEd25519(t *testing.T) {
+	ecSec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(&ecSec.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ParseAuthorizedSigningPublicKey(ssh.MarshalAuthorizedKey(sshPub)); err != ErrNotEd25519Key {
+		t.Fatalf("wanted ErrNotEd25519Key, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"encoding/pem"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseSSHKey parses an OpenSSH ed25519 private key, in the openssh-key-v1
+// format of ~/.ssh/id_ed25519, decrypting it with passphrase if it's
+// encrypted. It returns the key as a signing key, along with the box key
+// derived from it by BoxSecretKeyFromSigningSecretKey, so that the one SSH
+// identity can both sign and receive encrypted messages.
+func ParseSSHKey(pemBytes, passphrase []byte) (*SigningSecretKey, *SecretKey, error) {
+	var raw any
+	var err error
+	if len(passphrase) > 0 {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+	} else {
+		raw, err = ssh.ParseRawPrivateKey(pemBytes)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var edSec ed25519.PrivateKey
+	switch k := raw.(type) {
+	case ed25519.PrivateKey:
+		edSec = k
+	case *ed25519.PrivateKey:
+		edSec = *k
+	default:
+		return nil, nil, ErrNotEd25519Key
+	}
+	defer clear(edSec)
+	if len(edSec) != ed25519.PrivateKeySize {
+		return nil, nil, ErrNotEd25519Key
+	}
+
+	sigKey := NewSigningSecretKey(
+		(*[ed25519.PublicKeySize]byte)(edSec[ed25519.SeedSize:]),
+		(*[ed25519.PrivateKeySize]byte)(edSec))
+	boxKey := BoxSecretKeyFromSigningSecretKey(sigKey)
+	return &sigKey, &boxKey, nil
+}
+
+// ImportSSHKey imports an OpenSSH ed25519 private key, as parsed by
+// ParseSSHKey, as both a signing key and a box key.
+func (k *Keyring) ImportSSHKey(pemBytes, passphrase []byte) (*SigningSecretKey, *SecretKey, error) {
+	sigKey, boxKey, err := ParseSSHKey(pemBytes, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.sigKeys[sigKey.pub] = *sigKey
+	k.encKeys[boxKey.pub] = *boxKey
+	return sigKey, boxKey, nil
+}
+
+// ExportSSHKey returns the signing key for pub as an OpenSSH private key,
+// in the openssh-key-v1 format, with the given comment. If passphrase
+// isn't empty, the key is encrypted with it.
+func (k *Keyring) ExportSSHKey(pub SigningPublicKey, comment string, passphrase []byte) ([]byte, error) {
+	k.mu.RLock()
+	sk, ok := k.sigKeys[pub]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	defer sk.Wipe()
+
+	edSec := ed25519.PrivateKey(sk.sec[:])
+	var block *pem.Block
+	var err error
+	if len(passphrase) > 0 {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(edSec, comment, passphrase)
+	} else {
+		block, err = ssh.MarshalPrivateKey(edSec, comment)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer clear(block.Bytes)
+	return pem.EncodeToMemory(block), nil
+}
+
+// MarshalAuthorizedSigningPublicKey formats pub as an ssh-ed25519 key in
+// the OpenSSH authorized_keys format, followed by comment if it isn't
+// empty. It's the inverse of ParseAuthorizedSigningPublicKey.
+func MarshalAuthorizedSigningPublicKey(pub SigningPublicKey, comment string) []byte {
+	sshPub, err := ssh.NewPublicKey(ed25519.PublicKey(pub[:]))
+	if err != nil {
+		panic(err)
+	}
+	line := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(sshPub), []byte("\n"))
+	if comment != "" {
+		line = append(line, ' ')
+		line = append(line, comment...)
+	}
+	return append(line, '\n')
+}
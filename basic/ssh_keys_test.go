@@ -0,0 +1,96 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/pem"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSSHKeys(t *testing.T) {
+	edPub, edSec, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(edSec, "me@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kr := NewKeyring()
+	sigKey, boxKey, err := kr.ImportSSHKey(pem.EncodeToMemory(block), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sigKey.pub[:], edPub) {
+		t.Fatal("bad signing public key")
+	}
+
+	// Someone with only our authorized_keys line can encrypt to us.
+	line := MarshalAuthorizedSigningPublicKey(sigKey.pub, "me@example.com")
+	pub, comment, err := ParseAuthorizedSigningPublicKey(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub != sigKey.pub || comment != "me@example.com" {
+		t.Fatalf("bad authorized key line %q", line)
+	}
+	boxPub, err := BoxPublicKeyFromSigningPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boxPub != boxKey.pub {
+		t.Fatal("converted public key doesn't match converted secret key")
+	}
+	msg := randomMsg(t, 1024)
+	ciphertext, err := saltpack.Seal(saltpack.Version2(), msg, nil, []saltpack.BoxPublicKey{boxPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vv := saltpack.SingleVersionValidator(saltpack.Version2())
+	_, plaintext, err := saltpack.Open(vv, ciphertext, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, msg) {
+		t.Fatal("bad plaintext")
+	}
+
+	// Export and reimport with a passphrase.
+	exported, err := kr.ExportSSHKey(sigKey.pub, "me@example.com", []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ParseSSHKey(exported, nil); err == nil {
+		t.Fatal("parsed encrypted key without a passphrase")
+	}
+	sigKey2, boxKey2, err := ParseSSHKey(exported, []byte("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sigKey2.sec != sigKey.sec || boxKey2.sec != boxKey.sec {
+		t.Fatal("reimported key doesn't match")
+	}
+	sig, err := saltpack.SignDetached(saltpack.Version2(), msg, sigKey2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = saltpack.VerifyDetached(vv, msg, sig, kr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoxPublicKeyFromSigningPublicKeyInvalid(t *testing.T) {
+	// y = 2 isn't the y-coordinate of any point on the curve.
+	pub := SigningPublicKey{2}
+	if _, err := BoxPublicKeyFromSigningPublicKey(pub); err != ErrBadSigningPublicKey {
+		t.Fatalf("wanted ErrBadSigningPublicKey, got %v", err)
+	}
+}
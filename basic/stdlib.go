@@ -0,0 +1,209 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// ErrNotX25519Key is returned for a crypto/ecdh key on a curve other than
+// X25519, since saltpack boxes only with X25519.
+var ErrNotX25519Key = errors.New("not an X25519 key")
+
+// CryptoSigner is a saltpack.SigningSecretKey for any crypto.Signer with an
+// Ed25519 public key, like an ed25519.PrivateKey, or a client for a key
+// held in a KMS or HSM.
+type CryptoSigner struct {
+	signer crypto.Signer
+	pub    SigningPublicKey
+}
+
+var _ saltpack.SigningSecretKey = (*CryptoSigner)(nil)
+
+// NewCryptoSigner wraps signer, whose public key must be an
+// ed25519.PublicKey.
+func NewCryptoSigner(signer crypto.Signer) (*CryptoSigner, error) {
+	edPub, ok := signer.Public().(ed25519.PublicKey)
+	if !ok || len(edPub) != ed25519.PublicKeySize {
+		return nil, ErrNotEd25519Key
+	}
+	return &CryptoSigner{
+		signer: signer,
+		pub:    NewSigningPublicKey((*[ed25519.PublicKeySize]byte)(edPub)),
+	}, nil
+}
+
+// Sign signs message with the wrapped signer, as pure Ed25519.
+func (k *CryptoSigner) Sign(message []byte) ([]byte, error) {
+	sig, err := k.signer.Sign(rand.Reader, message, crypto.Hash(0))
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("unexpected %d-byte signature from crypto.Signer", len(sig))
+	}
+	return sig, nil
+}
+
+// GetPublicKey returns the wrapped signer's public key.
+func (k *CryptoSigner) GetPublicKey() saltpack.SigningPublicKey {
+	return k.pub
+}
+
+// signingKeySigner is a crypto.Signer for a saltpack.SigningSecretKey.
+type signingKeySigner struct {
+	k saltpack.SigningSecretKey
+}
+
+// NewSignerFromSigningSecretKey returns a crypto.Signer that signs with k,
+// as pure Ed25519. Its Public method returns an ed25519.PublicKey.
+func NewSignerFromSigningSecretKey(k saltpack.SigningSecretKey) crypto.Signer {
+	return signingKeySigner{k: k}
+}
+
+func (s signingKeySigner) Public() crypto.PublicKey {
+	return ed25519.PublicKey(s.k.GetPublicKey().ToKID())
+}
+
+func (s signingKeySigner) Sign(_ io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.Hash(0) {
+		return nil, errors.New("saltpack signing keys only sign unhashed messages")
+	}
+	return s.k.Sign(message)
+}
+
+// SigningSecretKeyFromEd25519 converts an ed25519.PrivateKey into a
+// SigningSecretKey.
+func SigningSecretKeyFromEd25519(sec ed25519.PrivateKey) (SigningSecretKey, error) {
+	if len(sec) != ed25519.PrivateKeySize {
+		return SigningSecretKey{}, ErrNotEd25519Key
+	}
+	return NewSigningSecretKey(
+		(*[ed25519.PublicKeySize]byte)(sec[ed25519.SeedSize:]),
+		(*[ed25519.PrivateKeySize]byte)(sec)), nil
+}
+
+// Ed25519PrivateKey returns a copy of k as an ed25519.PrivateKey.
+func (k SigningSecretKey) Ed25519PrivateKey() ed25519.PrivateKey {
+	return append(ed25519.PrivateKey(nil), k.sec[:]...)
+}
+
+// SigningPublicKeyFromEd25519 converts an ed25519.PublicKey into a
+// SigningPublicKey.
+func SigningPublicKeyFromEd25519(pub ed25519.PublicKey) (SigningPublicKey, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return SigningPublicKey{}, ErrNotEd25519Key
+	}
+	return NewSigningPublicKey((*[ed25519.PublicKeySize]byte)(pub)), nil
+}
+
+// Ed25519PublicKey returns a copy of k as an ed25519.PublicKey.
+func (k SigningPublicKey) Ed25519PublicKey() ed25519.PublicKey {
+	return append(ed25519.PublicKey(nil), k[:]...)
+}
+
+// ECDHSecretKey is a saltpack.BoxSecretKey for a crypto/ecdh X25519
+// private key, so that keys held as *ecdh.PrivateKey can be used without
+// copying them into a SecretKey.
+type ECDHSecretKey struct {
+	priv *ecdh.PrivateKey
+	pub  PublicKey
+}
+
+var _ saltpack.BoxSecretKey = (*ECDHSecretKey)(nil)
+
+// NewECDHSecretKey wraps priv, which must be an X25519 key.
+func NewECDHSecretKey(priv *ecdh.PrivateKey) (*ECDHSecretKey, error) {
+	pub, err := BoxPublicKeyFromECDH(priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return &ECDHSecretKey{priv: priv, pub: pub}, nil
+}
+
+// Box boxes msg from k to receiver, like box.Seal.
+func (k *ECDHSecretKey) Box(receiver saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) []byte {
+	shared := k.precompute(receiver)
+	defer shared.Wipe()
+	return shared.Box(nonce, msg)
+}
+
+// Unbox opens a box from sender to k, like box.Open.
+func (k *ECDHSecretKey) Unbox(sender saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) ([]byte, error) {
+	shared := k.precompute(sender)
+	defer shared.Wipe()
+	return shared.Unbox(nonce, msg)
+}
+
+// GetPublicKey returns the public key of k.
+func (k *ECDHSecretKey) GetPublicKey() saltpack.BoxPublicKey {
+	return k.pub
+}
+
+// Precompute computes the key shared between k and peer, like
+// box.Precompute.
+func (k *ECDHSecretKey) Precompute(peer saltpack.BoxPublicKey) saltpack.BoxPrecomputedSharedKey {
+	return k.precompute(peer)
+}
+
+func (k *ECDHSecretKey) precompute(peer saltpack.BoxPublicKey) PrecomputedSharedKey {
+	var dh [32]byte
+	defer clear(dh[:])
+	// crypto/ecdh fails only when the result is all zeros, for a
+	// low-order peer key. box.Precompute carries on with the zeros
+	// anyway, and so do we, so that both compute the same key.
+	peerPub, err := ecdh.X25519().NewPublicKey(peer.ToRawBoxKeyPointer()[:])
+	if err == nil {
+		if secret, err := k.priv.ECDH(peerPub); err == nil {
+			copy(dh[:], secret)
+			clear(secret)
+		}
+	}
+
+	var res PrecomputedSharedKey
+	var zeros [16]byte
+	salsa.HSalsa20((*[32]byte)(&res), &zeros, &dh, &salsa.Sigma)
+	return res
+}
+
+// BoxPublicKeyFromECDH converts a crypto/ecdh X25519 public key into a
+// PublicKey.
+func BoxPublicKeyFromECDH(pub *ecdh.PublicKey) (PublicKey, error) {
+	if pub.Curve() != ecdh.X25519() {
+		return PublicKey{}, ErrNotX25519Key
+	}
+	var ret PublicKey
+	copy(ret.RawBoxKey[:], pub.Bytes())
+	return ret, nil
+}
+
+// ECDHPublicKey returns k as a crypto/ecdh X25519 public key.
+func (k PublicKey) ECDHPublicKey() (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(k.RawBoxKey[:])
+}
+
+// SecretKeyFromECDH converts a crypto/ecdh X25519 private key into a
+// SecretKey.
+func SecretKeyFromECDH(priv *ecdh.PrivateKey) (SecretKey, error) {
+	if priv.Curve() != ecdh.X25519() {
+		return SecretKey{}, ErrNotX25519Key
+	}
+	sec := priv.Bytes()
+	defer clear(sec)
+	return secretKeyFromScalar((*[32]byte)(sec)), nil
+}
+
+// ECDHPrivateKey returns a copy of k as a crypto/ecdh X25519 private key.
+func (k SecretKey) ECDHPrivateKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().NewPrivateKey(k.sec[:])
+}
@@ -0,0 +1,134 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basic
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestCryptoSigner(t *testing.T) {
+	edPub, edSec, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewCryptoSigner(edSec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := randomMsg(t, 1024)
+	sig, err := saltpack.SignDetached(saltpack.Version2(), msg, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vv := saltpack.SingleVersionValidator(saltpack.Version2())
+	skey, err := saltpack.VerifyDetached(vv, msg, sig, NewKeyring())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(skey.ToKID(), edPub) {
+		t.Fatal("wrong signer")
+	}
+
+	// And back the other way.
+	sk, err := SigningSecretKeyFromEd25519(edSec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sk.Ed25519PrivateKey(), edSec) || !sk.pub.Ed25519PublicKey().Equal(edPub) {
+		t.Fatal("bad conversion")
+	}
+	stdSigner := NewSignerFromSigningSecretKey(sk)
+	if !edPub.Equal(stdSigner.Public()) {
+		t.Fatal("bad crypto.Signer public key")
+	}
+	sig, err = stdSigner.Sign(rand.Reader, msg, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(edPub, msg, sig) {
+		t.Fatal("bad crypto.Signer signature")
+	}
+	if _, err = stdSigner.Sign(rand.Reader, msg, crypto.SHA512); err == nil {
+		t.Fatal("signed a prehashed message")
+	}
+}
+
+func TestECDHSecretKey(t *testing.T) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := NewECDHSecretKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr := NewKeyring()
+	receiver, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An ECDHSecretKey computes the same boxes as a SecretKey.
+	converted, err := SecretKeyFromECDH(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.GetPublicKey() != sender.GetPublicKey() {
+		t.Fatal("bad public key")
+	}
+	var nonce saltpack.Nonce
+	msg := randomMsg(t, 100)
+	if !bytes.Equal(sender.Box(receiver.pub, nonce, msg), converted.Box(receiver.pub, nonce, msg)) {
+		t.Fatal("boxes differ")
+	}
+	boxed := receiver.Box(sender.pub, nonce, msg)
+	opened, err := sender.Unbox(receiver.pub, nonce, boxed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, msg) {
+		t.Fatal("bad unbox")
+	}
+
+	msg = randomMsg(t, 1024)
+	ciphertext, err := saltpack.Seal(saltpack.Version2(), msg, sender, []saltpack.BoxPublicKey{receiver.pub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mki, plaintext, err := saltpack.Open(saltpack.SingleVersionValidator(saltpack.Version2()), ciphertext, kr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, msg) || !saltpack.PublicKeyEqual(mki.SenderKey, sender.pub) {
+		t.Fatal("bad decryption")
+	}
+
+	// And back the other way.
+	ecdhPub, err := receiver.pub.ECDHPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdhPriv, err := receiver.ECDHPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdhPriv.PublicKey().Equal(ecdhPub) {
+		t.Fatal("bad conversion")
+	}
+
+	p256, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewECDHSecretKey(p256); err != ErrNotX25519Key {
+		t.Fatalf("wanted ErrNotX25519Key, got %v", err)
+	}
+}
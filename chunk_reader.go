@@ -16,9 +16,19 @@ type chunker interface {
 	getNextChunk() ([]byte, error)
 }
 
+// secretChunker is a chunker whose chunks are secret, like decrypted
+// plaintext. The chunkReader wipes each chunk once it's been read, and
+// calls wipe once getNextChunk returns an error, so that the chunker can
+// wipe its keys.
+type secretChunker interface {
+	chunker
+	wipe()
+}
+
 // chunkReader is an io.Reader adaptor for chunker.
 type chunkReader struct {
 	chunker   chunker
+	chunk     []byte // all of the current chunk, for wiping
 	prevChunk []byte
 	prevErr   error
 }
@@ -42,6 +52,11 @@ func (r *chunkReader) Read(p []byte) (n int, err error) {
 			}
 		}
 
+		sc, secret := r.chunker.(secretChunker)
+		if secret {
+			clear(r.chunk)
+		}
+
 		if r.prevErr != nil {
 			// r.prevChunk is fully drained, so return the
 			// error.
@@ -52,5 +67,9 @@ func (r *chunkReader) Read(p []byte) (n int, err error) {
 		if len(r.prevChunk) == 0 && r.prevErr == nil {
 			panic("empty chunk and nil error")
 		}
+		r.chunk = r.prevChunk
+		if secret && r.prevErr != nil {
+			sc.wipe()
+		}
 	}
 }
@@ -0,0 +1,204 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package main
+
+/*
+#include "saltpack_types.h"
+*/
+import "C"
+
+import (
+	"bytes"
+
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+)
+
+func lookupKeyring(h C.saltpack_keyring) (*basic.Keyring, error) {
+	return getHandle[*basic.Keyring](&handles, uintptr(h))
+}
+
+// boxSecretKey returns the secret key in kr for the box public key pub,
+// or nil for an anonymous sender if pub is nil.
+func boxSecretKey(kr *basic.Keyring, pub []byte) (saltpack.BoxSecretKey, error) {
+	if pub == nil {
+		return nil, nil
+	}
+	if _, sk := kr.LookupBoxSecretKey([][]byte{pub}); sk != nil {
+		return sk, nil
+	}
+	return nil, errKeyNotFound
+}
+
+// signingSecretKey returns the secret key in kr for the signing public
+// key pub, or nil for an anonymous sender if pub is nil.
+func signingSecretKey(kr *basic.Keyring, pub []byte) (saltpack.SigningSecretKey, error) {
+	if pub == nil {
+		return nil, nil
+	}
+	for _, sk := range kr.GetAllSigningSecretKeys() {
+		if bytes.Equal(sk.GetPublicKey().ToKID(), pub) {
+			return sk, nil
+		}
+	}
+	return nil, errKeyNotFound
+}
+
+// boxPublicKeys returns the box public keys with the given key IDs.
+func boxPublicKeys(kids [][]byte) []saltpack.BoxPublicKey {
+	pubs := make([]saltpack.BoxPublicKey, len(kids))
+	for i, kid := range kids {
+		pubs[i] = basic.PublicKey{RawBoxKey: saltpack.RawBoxKey(kid)}
+	}
+	return pubs
+}
+
+// importKey adds the secret keys in b, a key or keyring from
+// basic.ArmorKey or MarshalBinary, to kr.
+func importKey(kr *basic.Keyring, b []byte) error {
+	var (
+		k   basic.Exportable
+		err error
+	)
+	if trimmed := bytes.TrimSpace(b); bytes.HasPrefix(trimmed, []byte("BEGIN")) {
+		k, _, err = basic.DearmorKey(string(trimmed))
+	} else {
+		k, err = basic.ParseKey(b)
+	}
+	if err != nil {
+		return err
+	}
+	switch k := k.(type) {
+	case basic.SecretKey:
+		kr.ImportBoxKey(k.GetRawPublicKey(), k.GetRawSecretKey())
+		k.Wipe()
+	case basic.HybridSecretKey:
+		kr.ImportHybridBoxKey(k.GetRawPublicKey(), k.GetRawSecretKey(), k.GetRawMLKEMSeed())
+		k.Wipe()
+	case basic.SigningSecretKey:
+		kr.ImportSigningKey(k.GetRawPublicKey(), k.GetRawSecretKey())
+		k.Wipe()
+	case *basic.Keyring:
+		defer k.Wipe()
+		for _, sk := range k.GetAllBoxSecretKeys() {
+			switch sk := sk.(type) {
+			case basic.SecretKey:
+				kr.ImportBoxKey(sk.GetRawPublicKey(), sk.GetRawSecretKey())
+			case basic.HybridSecretKey:
+				kr.ImportHybridBoxKey(sk.GetRawPublicKey(), sk.GetRawSecretKey(), sk.GetRawMLKEMSeed())
+			}
+		}
+		for _, sk := range k.GetAllSigningSecretKeys() {
+			sk := sk.(basic.SigningSecretKey)
+			kr.ImportSigningKey(sk.GetRawPublicKey(), sk.GetRawSecretKey())
+		}
+	default:
+		// The keyring only holds secret keys.
+		return errInvalidArgument
+	}
+	return nil
+}
+
+// saltpack_keyring_new returns a new, empty keyring, or 0 if that fails.
+//
+//export saltpack_keyring_new
+func saltpack_keyring_new() (ret C.saltpack_keyring) {
+	defer func() {
+		if recover() != nil {
+			ret = 0
+		}
+	}()
+	return C.saltpack_keyring(handles.add(basic.NewKeyring()))
+}
+
+// saltpack_keyring_free wipes the keys in the keyring, and frees it.
+//
+//export saltpack_keyring_free
+func saltpack_keyring_free(h C.saltpack_keyring) (ret C.int) {
+	defer recoverPanic(&ret)
+	kr, err := removeHandle[*basic.Keyring](&handles, uintptr(h))
+	if err != nil {
+		return errorCode(err)
+	}
+	kr.Wipe()
+	return codeOK
+}
+
+// saltpack_keyring_generate_box_key adds a new box key to the keyring,
+// and writes its public key to pub.
+//
+//export saltpack_keyring_generate_box_key
+func saltpack_keyring_generate_box_key(h C.saltpack_keyring, pub *C.uint8_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	sk, err := kr.GenerateBoxKey()
+	if err != nil {
+		return errorCode(err)
+	}
+	defer sk.Wipe()
+	setKey(pub, sk.GetPublicKey().ToKID())
+	return codeOK
+}
+
+// saltpack_keyring_generate_signing_key adds a new signing key to the
+// keyring, and writes its public key to pub.
+//
+//export saltpack_keyring_generate_signing_key
+func saltpack_keyring_generate_signing_key(h C.saltpack_keyring, pub *C.uint8_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	sk, err := kr.GenerateSigningKey()
+	if err != nil {
+		return errorCode(err)
+	}
+	defer sk.Wipe()
+	setKey(pub, sk.GetPublicKey().ToKID())
+	return codeOK
+}
+
+// saltpack_keyring_import adds the secret keys in a key or keyring,
+// binary or armored, to the keyring.
+//
+//export saltpack_keyring_import
+func saltpack_keyring_import(h C.saltpack_keyring, key *C.saltpack_in_byte, keyLen C.size_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	b, err := goBytes(key, keyLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	return errorCode(importKey(kr, b))
+}
+
+// saltpack_keyring_export serializes all the secret keys in the keyring,
+// in the binary form that saltpack_keyring_import reads. The output
+// holds secret keys, so the caller should wipe it before freeing it.
+//
+//export saltpack_keyring_export
+func saltpack_keyring_export(h C.saltpack_keyring, out **C.uint8_t, outLen *C.size_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	b, err := kr.MarshalBinary()
+	if err != nil {
+		return errorCode(err)
+	}
+	setOutput(b, out, outLen)
+	clear(b)
+	return codeOK
+}
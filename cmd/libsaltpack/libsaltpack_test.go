@@ -0,0 +1,233 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+)
+
+func randomMsg(t *testing.T, n int) []byte {
+	msg := make([]byte, n)
+	if _, err := rand.Read(msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func newTestKeyring(t *testing.T) (kr *basic.Keyring, box, sig []byte) {
+	kr = basic.NewKeyring()
+	boxKey, err := kr.GenerateBoxKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigKey, err := kr.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr, boxKey.GetPublicKey().ToKID(), sigKey.GetPublicKey().ToKID()
+}
+
+// runStream writes in to s in pieces of n bytes, and returns the output.
+func runStream(t *testing.T, s stream, in []byte, n int) ([]byte, error) {
+	var out []byte
+	for len(in) > 0 {
+		m := min(n, len(in))
+		if err := s.write(in[:m]); err != nil {
+			return nil, err
+		}
+		in = in[m:]
+		out = append(out, s.read()...)
+	}
+	err := s.close()
+	return append(out, s.read()...), err
+}
+
+func TestOneShot(t *testing.T) {
+	kr, box, sig := newTestKeyring(t)
+	msg := randomMsg(t, 100000)
+
+	for _, major := range []int{0, 1, 2} {
+		ciphertext, err := encrypt(major, kr, box, [][]byte{box}, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, sender, anon, err := decrypt(kr, ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, msg) || !bytes.Equal(sender, box) || anon {
+			t.Fatalf("version %d: bad decryption", major)
+		}
+
+		signed, err := sign(major, kr, sig, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		verified, signer, err := verify(basic.NewKeyring(), signed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(verified, msg) || !bytes.Equal(signer, sig) {
+			t.Fatalf("version %d: bad verification", major)
+		}
+	}
+
+	for _, signerPub := range [][]byte{sig, nil} {
+		ciphertext, err := signcrypt(kr, signerPub, [][]byte{box}, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, signer, anon, err := signcryptOpen(kr, ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, msg) || !bytes.Equal(signer, signerPub) || anon != (signerPub == nil) {
+			t.Fatal("bad signcryption")
+		}
+	}
+
+	ciphertext, err := encrypt(0, kr, nil, [][]byte{box}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, sender, anon, err := decrypt(kr, ciphertext); err != nil || sender != nil || !anon {
+		t.Fatalf("bad anonymous decryption: %v", err)
+	}
+}
+
+func TestStreams(t *testing.T) {
+	kr, box, sig := newTestKeyring(t)
+	msg := randomMsg(t, 3*1024*1024+17)
+
+	for _, tc := range []struct {
+		name string
+		seal func() (stream, error)
+		open func() stream
+		from []byte
+	}{
+		{"encrypt", func() (stream, error) { return newEncryptStream(0, kr, box, [][]byte{box}) }, func() stream { return newDecryptStream(kr) }, box},
+		{"sign", func() (stream, error) { return newSignStream(0, kr, sig) }, func() stream { return newVerifyStream(kr) }, sig},
+		{"signcrypt", func() (stream, error) { return newSigncryptStream(kr, sig, [][]byte{box}) }, func() stream { return newSigncryptOpenStream(kr) }, sig},
+	} {
+		seal, err := tc.seal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := runStream(t, seal, msg, 100000)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if err = seal.close(); errorCode(err) != codeStreamClosed {
+			t.Fatalf("%s: closed twice: %v", tc.name, err)
+		}
+
+		open := tc.open()
+		if _, _, err = open.sender(); errorCode(err) != codeNotReady {
+			t.Fatalf("%s: sender known before the header: %v", tc.name, err)
+		}
+		opened, err := runStream(t, open, sealed, 4096)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(opened, msg) {
+			t.Fatalf("%s: bad output", tc.name)
+		}
+		if kid, _, err := open.sender(); err != nil || !bytes.Equal(kid, tc.from) {
+			t.Fatalf("%s: bad sender: %v", tc.name, err)
+		}
+		open.free()
+
+		// A truncated message fails when the stream is closed.
+		open = tc.open()
+		if _, err = runStream(t, open, sealed[:len(sealed)/2], 4096); errorCode(err) != codeTruncated {
+			t.Fatalf("%s: truncated: %v", tc.name, err)
+		}
+		open.free()
+
+		// Abandoning a stream partway doesn't block.
+		open = tc.open()
+		if err = open.write(sealed[:len(sealed)/2]); err != nil {
+			t.Fatal(err)
+		}
+		open.free()
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	kr, box, sig := newTestKeyring(t)
+	other, _, _ := newTestKeyring(t)
+	msg := randomMsg(t, 1000)
+
+	ciphertext, err := encrypt(0, kr, box, [][]byte{box}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := sign(0, kr, sig, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Clone(signed)
+	tampered[len(tampered)-100] ^= 1
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"no key", func() error { _, _, _, err := decrypt(other, ciphertext); return err }(), codeNoDecryptionKey},
+		{"truncated", func() error { _, _, _, err := decrypt(kr, ciphertext[:len(ciphertext)-10]); return err }(), codeTruncated},
+		{"garbage", func() error { _, _, _, err := decrypt(kr, []byte("garbage")); return err }(), codeMalformed},
+		{"wrong type", func() error { _, _, _, err := decrypt(kr, signed); return err }(), codeWrongMessageType},
+		{"bad signature", func() error { _, _, err := verify(kr, tampered); return err }(), codeBadSignature},
+		{"bad version", func() error { _, err := encrypt(3, kr, box, [][]byte{box}, msg); return err }(), codeBadVersion},
+		{"unknown sender", func() error { _, err := encrypt(0, other, box, [][]byte{box}, msg); return err }(), codeKeyNotFound},
+		{"unknown signer", func() error { _, err := sign(0, other, sig, msg); return err }(), codeKeyNotFound},
+	} {
+		if code := errorCode(tc.err); int(code) != tc.code {
+			t.Fatalf("%s: got code %d for %v, wanted %d", tc.name, code, tc.err, tc.code)
+		}
+	}
+}
+
+func TestHandles(t *testing.T) {
+	kr, box, _ := newTestKeyring(t)
+	h := handles.add(kr)
+	if _, err := getHandle[stream](&handles, h); err != errInvalidHandle {
+		t.Fatal("keyring handle used as a stream")
+	}
+	if _, err := removeHandle[stream](&handles, h); err != errInvalidHandle {
+		t.Fatal("keyring handle removed as a stream")
+	}
+	if got, err := removeHandle[*basic.Keyring](&handles, h); err != nil || got != kr {
+		t.Fatalf("bad keyring handle: %v", err)
+	}
+	if _, err := getHandle[*basic.Keyring](&handles, h); err != errInvalidHandle {
+		t.Fatal("freed handle still valid")
+	}
+
+	// A keyring exports and imports.
+	b, err := kr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported := basic.NewKeyring()
+	if err = importKey(imported, b); err != nil {
+		t.Fatal(err)
+	}
+	if _, sk := imported.LookupBoxSecretKey([][]byte{box}); sk == nil {
+		t.Fatal("box key not imported")
+	}
+	armored, err := basic.ArmorKey(basic.PublicKey{RawBoxKey: saltpack.RawBoxKey(box)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = importKey(imported, []byte(armored)); err != errInvalidArgument {
+		t.Fatalf("imported a public key: %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+// Command libsaltpack is a C library for saltpack encryption, signing and
+// signcryption, built with cgo:
+//
+//	go build -buildmode=c-shared -o libsaltpack.so ./cmd/libsaltpack
+//
+// The build also writes libsaltpack.h, which includes saltpack_types.h
+// from this directory; install both. Every function returns one of the
+// stable codes in enum saltpack_error, and saltpack_strerror describes
+// them.
+//
+// Secret keys are kept in a keyring, behind an opaque saltpack_keyring
+// handle from saltpack_keyring_new, and never leave it except through
+// saltpack_keyring_export. Keys are named by their 32-byte public keys:
+// a sender or signer argument is the public key of a secret key in the
+// keyring, or NULL for an anonymous sender, and receivers are packed
+// back to back, SALTPACK_KEY_LEN bytes each. Version arguments are
+// saltpack major versions, or 0 for the current one.
+//
+// Buffer ownership:
+//
+//   - Input buffers, of saltpack_in_byte, are only read during the call,
+//     and are never kept.
+//   - Output buffers (uint8_t **out, size_t *out_len) are allocated by
+//     the library, and belong to the caller, who frees them with
+//     saltpack_free. On error, *out is NULL. An empty output may be NULL.
+//   - Fixed-size outputs, like a public key, are written to a buffer of
+//     SALTPACK_KEY_LEN bytes supplied by the caller, and may be NULL if
+//     the caller doesn't want them.
+//   - Handles belong to the caller until they're passed to
+//     saltpack_keyring_free or saltpack_stream_free, which wipe their
+//     secrets. A handle can be used from several threads at once.
+//
+// The one-shot functions, like saltpack_encrypt, take the whole message
+// in memory. The streaming ones, like saltpack_encrypt_stream_new, take
+// input with saltpack_stream_write in pieces of any size, and hand back
+// whatever output is ready with saltpack_stream_read. After
+// saltpack_stream_close, read once more for the rest of the output.
+// Streams that decrypt or verify return output as soon as each chunk of
+// the message has been authenticated, so a later error means the
+// message was altered or truncated after that point.
+package main
+
+/*
+#include <stdlib.h>
+#include "saltpack_types.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"unsafe"
+
+	"github.com/keybase/saltpack"
+)
+
+// The error codes, from enum saltpack_error.
+const (
+	codeOK                   = C.SALTPACK_OK
+	codeInvalidArgument      = C.SALTPACK_ERR_INVALID_ARGUMENT
+	codeInvalidHandle        = C.SALTPACK_ERR_INVALID_HANDLE
+	codeKeyNotFound          = C.SALTPACK_ERR_KEY_NOT_FOUND
+	codeNoDecryptionKey      = C.SALTPACK_ERR_NO_DECRYPTION_KEY
+	codeNoSenderKey          = C.SALTPACK_ERR_NO_SENDER_KEY
+	codeBadSignature         = C.SALTPACK_ERR_BAD_SIGNATURE
+	codeAuthenticationFailed = C.SALTPACK_ERR_AUTHENTICATION_FAILED
+	codeTruncated            = C.SALTPACK_ERR_TRUNCATED
+	codeMalformed            = C.SALTPACK_ERR_MALFORMED
+	codeBadVersion           = C.SALTPACK_ERR_BAD_VERSION
+	codeWrongMessageType     = C.SALTPACK_ERR_WRONG_MESSAGE_TYPE
+	codeSenderKeyInvalid     = C.SALTPACK_ERR_SENDER_KEY_INVALID
+	codeNotReady             = C.SALTPACK_ERR_NOT_READY
+	codeStreamClosed         = C.SALTPACK_ERR_STREAM_CLOSED
+	codeOther                = C.SALTPACK_ERR_OTHER
+)
+
+var (
+	errInvalidArgument = errors.New("invalid argument")
+	errInvalidHandle   = errors.New("invalid handle")
+	errKeyNotFound     = errors.New("secret key not found in keyring")
+	errNotReady        = errors.New("stream not ready")
+	errStreamClosed    = errors.New("stream closed")
+)
+
+var codeMessages = map[C.int]string{
+	codeOK:                   "success",
+	codeInvalidArgument:      "invalid argument",
+	codeInvalidHandle:        "invalid handle",
+	codeKeyNotFound:          "secret key not found in keyring",
+	codeNoDecryptionKey:      "no decryption key found for message",
+	codeNoSenderKey:          "no sender key found for message",
+	codeBadSignature:         "bad signature",
+	codeAuthenticationFailed: "message authentication failed",
+	codeTruncated:            "message truncated",
+	codeMalformed:            "malformed message",
+	codeBadVersion:           "bad version",
+	codeWrongMessageType:     "wrong message type",
+	codeSenderKeyInvalid:     "sender key revoked or expired",
+	codeNotReady:             "stream not ready",
+	codeStreamClosed:         "stream closed",
+	codeOther:                "error",
+}
+
+// errorCode maps err to its code in enum saltpack_error.
+func errorCode(err error) C.int {
+	var (
+		noSenderKey saltpack.ErrNoSenderKey
+		revokedKey  saltpack.ErrRevokedKey
+		expiredKey  saltpack.ErrExpiredKey
+		badTag      saltpack.ErrBadTag
+		badCipher   saltpack.ErrBadCiphertext
+		badVersion  saltpack.ErrBadVersion
+		wrongType   saltpack.ErrWrongMessageType
+		badFrame    saltpack.ErrBadFrame
+		repeatedKey saltpack.ErrRepeatedKey
+	)
+	switch {
+	case err == nil:
+		return codeOK
+	case errors.Is(err, errInvalidArgument):
+		return codeInvalidArgument
+	case errors.Is(err, errInvalidHandle):
+		return codeInvalidHandle
+	case errors.Is(err, errKeyNotFound):
+		return codeKeyNotFound
+	case errors.Is(err, errNotReady):
+		return codeNotReady
+	case errors.Is(err, errStreamClosed):
+		return codeStreamClosed
+	case errors.Is(err, saltpack.ErrNoDecryptionKey):
+		return codeNoDecryptionKey
+	case errors.As(err, &noSenderKey):
+		return codeNoSenderKey
+	case errors.As(err, &revokedKey), errors.As(err, &expiredKey):
+		return codeSenderKeyInvalid
+	case errors.Is(err, saltpack.ErrBadSignature):
+		return codeBadSignature
+	case errors.Is(err, saltpack.ErrDecryptionFailed),
+		errors.Is(err, saltpack.ErrBadSenderKeySecretbox),
+		errors.As(err, &badTag),
+		errors.As(err, &badCipher):
+		return codeAuthenticationFailed
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, saltpack.ErrUnexpectedEmptyBlock):
+		return codeTruncated
+	case errors.As(err, &badVersion):
+		return codeBadVersion
+	case errors.As(err, &wrongType):
+		return codeWrongMessageType
+	case errors.Is(err, saltpack.ErrNotASaltpackMessage),
+		errors.Is(err, saltpack.ErrTrailingGarbage),
+		errors.Is(err, saltpack.ErrFailedToReadHeaderBytes),
+		errors.Is(err, saltpack.ErrBadEphemeralKey),
+		errors.As(err, &badFrame),
+		errors.As(err, &repeatedKey):
+		return codeMalformed
+	default:
+		return codeOther
+	}
+}
+
+// recoverPanic is deferred by each exported function that returns an
+// error code, with a pointer to its result, so that a panic returns
+// SALTPACK_ERR_OTHER rather than taking down the whole host process.
+func recoverPanic(ret *C.int) {
+	if recover() != nil {
+		*ret = codeOther
+	}
+}
+
+// handleTable maps the handles given to C to the Go values behind them.
+// C can't hold Go pointers, and a table lets bad handles be caught
+// rather than crash.
+type handleTable struct {
+	mu   sync.Mutex
+	next uintptr
+	m    map[uintptr]any
+}
+
+var handles = handleTable{m: make(map[uintptr]any)}
+
+func (t *handleTable) add(v any) uintptr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next++
+	t.m[t.next] = v
+	return t.next
+}
+
+// getHandle returns the value of type T behind h.
+func getHandle[T any](t *handleTable, h uintptr) (T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.m[h].(T)
+	if !ok {
+		return v, errInvalidHandle
+	}
+	return v, nil
+}
+
+// removeHandle removes h from the table, if its value is of type T, and
+// returns the value.
+func removeHandle[T any](t *handleTable, h uintptr) (T, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v, ok := t.m[h].(T)
+	if !ok {
+		return v, errInvalidHandle
+	}
+	delete(t.m, h)
+	return v, nil
+}
+
+// goBytes returns the C buffer p of length n as a slice, without
+// copying it, so it's only valid during the call.
+func goBytes(p *C.saltpack_in_byte, n C.size_t) ([]byte, error) {
+	if p == nil {
+		if n != 0 {
+			return nil, errInvalidArgument
+		}
+		return nil, nil
+	}
+	if n > math.MaxInt {
+		return nil, errInvalidArgument
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(p)), int(n)), nil
+}
+
+// goKey returns the SALTPACK_KEY_LEN bytes at p, or nil if p is NULL.
+func goKey(p *C.saltpack_in_byte) []byte {
+	if p == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(p)), C.SALTPACK_KEY_LEN)
+}
+
+// goKeys splits the n keys packed at p.
+func goKeys(p *C.saltpack_in_byte, n C.size_t) ([][]byte, error) {
+	// Make sure the length of the keys doesn't overflow.
+	if n > math.MaxInt/C.SALTPACK_KEY_LEN {
+		return nil, errInvalidArgument
+	}
+	b, err := goBytes(p, n*C.SALTPACK_KEY_LEN)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = b[i*C.SALTPACK_KEY_LEN : (i+1)*C.SALTPACK_KEY_LEN]
+	}
+	return keys, nil
+}
+
+// setOutput copies b to a new C buffer for the caller, who frees it with
+// saltpack_free.
+func setOutput(b []byte, out **C.uint8_t, outLen *C.size_t) {
+	*outLen = C.size_t(len(b))
+	*out = nil
+	if len(b) != 0 {
+		*out = (*C.uint8_t)(C.CBytes(b))
+	}
+}
+
+// setKey writes the key ID kid to the caller's buffer p, if it's not
+// NULL.
+func setKey(p *C.uint8_t, kid []byte) {
+	if p != nil && len(kid) == C.SALTPACK_KEY_LEN {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(p)), C.SALTPACK_KEY_LEN), kid)
+	}
+}
+
+func setBool(p *C.int, b bool) {
+	if p == nil {
+		return
+	}
+	*p = 0
+	if b {
+		*p = 1
+	}
+}
+
+// saltpack_free frees a buffer returned by a libsaltpack function. It
+// does nothing if p is NULL.
+//
+//export saltpack_free
+func saltpack_free(p unsafe.Pointer) {
+	defer func() { _ = recover() }()
+	C.free(p)
+}
+
+var (
+	strerrorOnce sync.Once
+	strerrors    map[C.int]*C.char
+)
+
+// saltpack_strerror returns a static description of the error code,
+// which must not be freed. It only returns NULL if the library fails
+// internally.
+//
+//export saltpack_strerror
+func saltpack_strerror(code C.int) (ret *C.char) {
+	defer func() {
+		if recover() != nil {
+			ret = nil
+		}
+	}()
+	strerrorOnce.Do(func() {
+		strerrors = make(map[C.int]*C.char, len(codeMessages))
+		for code, msg := range codeMessages {
+			strerrors[code] = C.CString(msg)
+		}
+	})
+	if s, ok := strerrors[code]; ok {
+		return s
+	}
+	return strerrors[codeOther]
+}
+
+func main() {}
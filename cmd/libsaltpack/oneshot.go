@@ -0,0 +1,276 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package main
+
+/*
+#include "saltpack_types.h"
+*/
+import "C"
+
+import (
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+)
+
+// saltpackVersion returns the known version with the given major
+// version, or the current version if major is 0.
+func saltpackVersion(major int) (saltpack.Version, error) {
+	if major == 0 {
+		return saltpack.CurrentVersion(), nil
+	}
+	for _, v := range saltpack.KnownVersions() {
+		if v.Major == major {
+			return v, nil
+		}
+	}
+	return saltpack.Version{}, saltpack.CheckKnownMajorVersion(saltpack.Version{Major: major})
+}
+
+// verifyKeyring returns the keyring behind h, or an empty one if h is 0,
+// since verifying only needs the keyring for the signer's key status.
+func verifyKeyring(h C.saltpack_keyring) (*basic.Keyring, error) {
+	if h == 0 {
+		return basic.NewKeyring(), nil
+	}
+	return lookupKeyring(h)
+}
+
+func encrypt(major int, kr *basic.Keyring, senderPub []byte, receivers [][]byte, msg []byte) ([]byte, error) {
+	version, err := saltpackVersion(major)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := boxSecretKey(kr, senderPub)
+	if err != nil {
+		return nil, err
+	}
+	return saltpack.Seal(version, msg, sender, boxPublicKeys(receivers))
+}
+
+func decrypt(kr *basic.Keyring, ciphertext []byte) (plaintext, sender []byte, anon bool, err error) {
+	mki, plaintext, err := saltpack.Open(saltpack.CheckKnownMajorVersion, ciphertext, kr)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if mki.SenderIsAnon {
+		return plaintext, nil, true, nil
+	}
+	return plaintext, mki.SenderKey.ToKID(), false, nil
+}
+
+func sign(major int, kr *basic.Keyring, signerPub []byte, msg []byte) ([]byte, error) {
+	version, err := saltpackVersion(major)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := signingSecretKey(kr, signerPub)
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, errInvalidArgument
+	}
+	return saltpack.Sign(version, msg, signer)
+}
+
+func verify(kr *basic.Keyring, signed []byte) (msg, signer []byte, err error) {
+	pub, msg, err := saltpack.Verify(saltpack.CheckKnownMajorVersion, signed, kr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg, pub.ToKID(), nil
+}
+
+func signcrypt(kr *basic.Keyring, signerPub []byte, receivers [][]byte, msg []byte) ([]byte, error) {
+	signer, err := signingSecretKey(kr, signerPub)
+	if err != nil {
+		return nil, err
+	}
+	return saltpack.SigncryptSeal(msg, basic.EphemeralKeyCreator{}, signer, boxPublicKeys(receivers), nil)
+}
+
+func signcryptOpen(kr *basic.Keyring, ciphertext []byte) (plaintext, signer []byte, anon bool, err error) {
+	pub, plaintext, err := saltpack.SigncryptOpen(ciphertext, kr, nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if pub == nil {
+		return plaintext, nil, true, nil
+	}
+	return plaintext, pub.ToKID(), false, nil
+}
+
+// saltpack_encrypt encrypts msg from sender, or from an anonymous sender
+// if sender is NULL, to n_receivers box public keys.
+//
+//export saltpack_encrypt
+func saltpack_encrypt(version C.int, h C.saltpack_keyring, sender *C.saltpack_in_byte,
+	receivers *C.saltpack_in_byte, nReceivers C.size_t, msg *C.saltpack_in_byte, msgLen C.size_t,
+	out **C.uint8_t, outLen *C.size_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	rs, err := goKeys(receivers, nReceivers)
+	if err != nil {
+		return errorCode(err)
+	}
+	m, err := goBytes(msg, msgLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	ciphertext, err := encrypt(int(version), kr, goKey(sender), rs, m)
+	if err != nil {
+		return errorCode(err)
+	}
+	setOutput(ciphertext, out, outLen)
+	return codeOK
+}
+
+// saltpack_decrypt decrypts an encrypted message with a key in the
+// keyring, and writes the sender's box public key to sender, and whether
+// the sender was anonymous to anon.
+//
+//export saltpack_decrypt
+func saltpack_decrypt(h C.saltpack_keyring, ciphertext *C.saltpack_in_byte, ciphertextLen C.size_t,
+	out **C.uint8_t, outLen *C.size_t, sender *C.uint8_t, anon *C.int) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	ct, err := goBytes(ciphertext, ciphertextLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	plaintext, senderKID, isAnon, err := decrypt(kr, ct)
+	if err != nil {
+		return errorCode(err)
+	}
+	setOutput(plaintext, out, outLen)
+	setKey(sender, senderKID)
+	setBool(anon, isAnon)
+	return codeOK
+}
+
+// saltpack_sign makes an attached signature of msg with the signing key
+// signer.
+//
+//export saltpack_sign
+func saltpack_sign(version C.int, h C.saltpack_keyring, signer *C.saltpack_in_byte,
+	msg *C.saltpack_in_byte, msgLen C.size_t, out **C.uint8_t, outLen *C.size_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	m, err := goBytes(msg, msgLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	signed, err := sign(int(version), kr, goKey(signer), m)
+	if err != nil {
+		return errorCode(err)
+	}
+	setOutput(signed, out, outLen)
+	return codeOK
+}
+
+// saltpack_verify verifies an attached signature, and writes the signed
+// message to out and the signer's public key to signer. The keyring may
+// be 0.
+//
+//export saltpack_verify
+func saltpack_verify(h C.saltpack_keyring, signedMsg *C.saltpack_in_byte, signedLen C.size_t,
+	out **C.uint8_t, outLen *C.size_t, signer *C.uint8_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	kr, err := verifyKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	s, err := goBytes(signedMsg, signedLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	msg, signerKID, err := verify(kr, s)
+	if err != nil {
+		return errorCode(err)
+	}
+	setOutput(msg, out, outLen)
+	setKey(signer, signerKID)
+	return codeOK
+}
+
+// saltpack_signcrypt signcrypts msg from the signing key signer, or from
+// an anonymous sender if signer is NULL, to n_receivers box public keys.
+//
+//export saltpack_signcrypt
+func saltpack_signcrypt(h C.saltpack_keyring, signer *C.saltpack_in_byte,
+	receivers *C.saltpack_in_byte, nReceivers C.size_t, msg *C.saltpack_in_byte, msgLen C.size_t,
+	out **C.uint8_t, outLen *C.size_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	rs, err := goKeys(receivers, nReceivers)
+	if err != nil {
+		return errorCode(err)
+	}
+	m, err := goBytes(msg, msgLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	ciphertext, err := signcrypt(kr, goKey(signer), rs, m)
+	if err != nil {
+		return errorCode(err)
+	}
+	setOutput(ciphertext, out, outLen)
+	return codeOK
+}
+
+// saltpack_signcrypt_open opens a signcrypted message with a box key in
+// the keyring, and writes the sender's signing public key to signer, and
+// whether the sender was anonymous to anon.
+//
+//export saltpack_signcrypt_open
+func saltpack_signcrypt_open(h C.saltpack_keyring, ciphertext *C.saltpack_in_byte, ciphertextLen C.size_t,
+	out **C.uint8_t, outLen *C.size_t, signer *C.uint8_t, anon *C.int) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	ct, err := goBytes(ciphertext, ciphertextLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	plaintext, signerKID, isAnon, err := signcryptOpen(kr, ct)
+	if err != nil {
+		return errorCode(err)
+	}
+	setOutput(plaintext, out, outLen)
+	setKey(signer, signerKID)
+	setBool(anon, isAnon)
+	return codeOK
+}
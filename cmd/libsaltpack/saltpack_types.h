@@ -0,0 +1,65 @@
+/*
+ * Copyright 2015 Keybase, Inc. All rights reserved. Use of
+ * this source code is governed by the included BSD license.
+ *
+ * Types shared by the functions in libsaltpack.h. Install this file next
+ * to libsaltpack.h, which includes it.
+ */
+
+#ifndef SALTPACK_TYPES_H
+#define SALTPACK_TYPES_H
+
+#include <stddef.h>
+#include <stdint.h>
+
+/* The length of a public key, and of a key ID. */
+#define SALTPACK_KEY_LEN 32
+
+/* A byte of an input buffer, which the library only reads. */
+typedef const uint8_t saltpack_in_byte;
+
+/* An opaque handle to a keyring of secret keys. 0 is never a valid handle. */
+typedef uintptr_t saltpack_keyring;
+
+/* An opaque handle to a stream. 0 is never a valid handle. */
+typedef uintptr_t saltpack_stream;
+
+/*
+ * The error codes returned by libsaltpack functions. These values are
+ * stable: codes may be added, but never renumbered or reused.
+ */
+enum saltpack_error {
+	SALTPACK_OK = 0,
+	/* An argument was NULL, or had the wrong length. */
+	SALTPACK_ERR_INVALID_ARGUMENT = 1,
+	/* A keyring or stream handle was unknown, freed, or of the wrong kind. */
+	SALTPACK_ERR_INVALID_HANDLE = 2,
+	/* A sender or signer public key has no secret key in the keyring. */
+	SALTPACK_ERR_KEY_NOT_FOUND = 3,
+	/* None of the message's receivers has a secret key in the keyring. */
+	SALTPACK_ERR_NO_DECRYPTION_KEY = 4,
+	/* The signer of the message isn't known to the keyring. */
+	SALTPACK_ERR_NO_SENDER_KEY = 5,
+	/* A signature didn't verify. */
+	SALTPACK_ERR_BAD_SIGNATURE = 6,
+	/* A message authenticator didn't verify, so the message was altered. */
+	SALTPACK_ERR_AUTHENTICATION_FAILED = 7,
+	/* The message ended early. */
+	SALTPACK_ERR_TRUNCATED = 8,
+	/* The input isn't a well-formed saltpack message. */
+	SALTPACK_ERR_MALFORMED = 9,
+	/* The message's version is unknown, or the requested version is. */
+	SALTPACK_ERR_BAD_VERSION = 10,
+	/* The message is of another type, like a signature given to decrypt. */
+	SALTPACK_ERR_WRONG_MESSAGE_TYPE = 11,
+	/* The signer's key was revoked or has expired. */
+	SALTPACK_ERR_SENDER_KEY_INVALID = 12,
+	/* The stream hasn't read far enough to answer yet. */
+	SALTPACK_ERR_NOT_READY = 13,
+	/* The stream was already closed. */
+	SALTPACK_ERR_STREAM_CLOSED = 14,
+	/* Any other error. */
+	SALTPACK_ERR_OTHER = 255
+};
+
+#endif /* SALTPACK_TYPES_H */
@@ -0,0 +1,503 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package main
+
+/*
+#include "saltpack_types.h"
+*/
+import "C"
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/basic"
+)
+
+// stream is an incremental operation behind a saltpack_stream handle.
+type stream interface {
+	// write feeds input to the stream.
+	write(p []byte) error
+	// read returns the output that's ready, and removes it from the
+	// stream.
+	read() []byte
+	// close ends the input, and returns the result of the operation.
+	close() error
+	// sender returns the key ID of the message's sender, once it's known.
+	sender() (kid []byte, anon bool, err error)
+	// free abandons the stream.
+	free()
+}
+
+// drain returns the contents of b, and empties it.
+func drain(b *bytes.Buffer) []byte {
+	out := bytes.Clone(b.Bytes())
+	clear(b.Bytes())
+	b.Reset()
+	return out
+}
+
+// sealStream encrypts, signs or signcrypts its input, through one of the
+// saltpack writers.
+type sealStream struct {
+	mu     sync.Mutex
+	out    bytes.Buffer
+	w      io.WriteCloser
+	closed bool
+}
+
+// newSealStream returns a stream that writes its input to the writer
+// made by newWriter.
+func newSealStream(newWriter func(out io.Writer) (io.WriteCloser, error)) (stream, error) {
+	s := &sealStream{}
+	w, err := newWriter(&s.out)
+	if err != nil {
+		return nil, err
+	}
+	s.w = w
+	return s, nil
+}
+
+func (s *sealStream) write(p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	_, err := s.w.Write(p)
+	return err
+}
+
+func (s *sealStream) read() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return drain(&s.out)
+}
+
+func (s *sealStream) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStreamClosed
+	}
+	s.closed = true
+	return s.w.Close()
+}
+
+func (s *sealStream) sender() ([]byte, bool, error) {
+	// The caller chose the sender.
+	return nil, false, errInvalidHandle
+}
+
+func (s *sealStream) free() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	clear(s.out.Bytes())
+}
+
+// opener starts decrypting or verifying the message in r, and returns the
+// sender's key ID, whether the sender is anonymous, and a reader for the
+// message.
+type opener func(r io.Reader) (kid []byte, anon bool, plaintext io.Reader, err error)
+
+// openStream decrypts or verifies its input. The saltpack readers pull
+// their input, so the opener runs in a goroutine that reads the input
+// written to the stream from a pipe, and buffers its output.
+type openStream struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+
+	mu         sync.Mutex
+	out        bytes.Buffer
+	headerRead bool
+	kid        []byte
+	anon       bool
+	err        error
+	closed     bool
+}
+
+func newOpenStream(open opener) *openStream {
+	pr, pw := io.Pipe()
+	s := &openStream{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		kid, anon, r, err := open(pr)
+		if err == nil {
+			s.mu.Lock()
+			s.headerRead, s.kid, s.anon = true, kid, anon
+			s.mu.Unlock()
+			_, err = io.Copy(openStreamWriter{s}, r)
+		}
+		// Make any further writes fail with err.
+		pr.CloseWithError(err)
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}()
+	return s
+}
+
+// openStreamWriter appends to the output of an openStream.
+type openStreamWriter struct {
+	s *openStream
+}
+
+func (w openStreamWriter) Write(p []byte) (int, error) {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	return w.s.out.Write(p)
+}
+
+func (s *openStream) write(p []byte) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return errStreamClosed
+	}
+	// The pipe hands p to the opener, which may need to write output, so
+	// don't hold the lock.
+	if _, err := s.pw.Write(p); err != nil {
+		if err == io.ErrClosedPipe {
+			// The opener finished, and didn't want any more input.
+			return saltpack.ErrTrailingGarbage
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *openStream) read() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return drain(&s.out)
+}
+
+func (s *openStream) close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errStreamClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+	s.pw.Close()
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *openStream) sender() ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.headerRead {
+		return nil, false, errNotReady
+	}
+	return s.kid, s.anon, nil
+}
+
+func (s *openStream) free() {
+	s.pw.CloseWithError(errStreamClosed)
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	clear(s.out.Bytes())
+}
+
+func newEncryptStream(major int, kr *basic.Keyring, senderPub []byte, receivers [][]byte) (stream, error) {
+	version, err := saltpackVersion(major)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := boxSecretKey(kr, senderPub)
+	if err != nil {
+		return nil, err
+	}
+	return newSealStream(func(out io.Writer) (io.WriteCloser, error) {
+		return saltpack.NewEncryptStream(version, out, sender, boxPublicKeys(receivers))
+	})
+}
+
+func newSignStream(major int, kr *basic.Keyring, signerPub []byte) (stream, error) {
+	version, err := saltpackVersion(major)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := signingSecretKey(kr, signerPub)
+	if err != nil {
+		return nil, err
+	}
+	if signer == nil {
+		return nil, errInvalidArgument
+	}
+	return newSealStream(func(out io.Writer) (io.WriteCloser, error) {
+		return saltpack.NewSignStream(version, out, signer)
+	})
+}
+
+func newSigncryptStream(kr *basic.Keyring, signerPub []byte, receivers [][]byte) (stream, error) {
+	signer, err := signingSecretKey(kr, signerPub)
+	if err != nil {
+		return nil, err
+	}
+	return newSealStream(func(out io.Writer) (io.WriteCloser, error) {
+		return saltpack.NewSigncryptSealStream(out, basic.EphemeralKeyCreator{}, signer, boxPublicKeys(receivers), nil)
+	})
+}
+
+func newDecryptStream(kr *basic.Keyring) stream {
+	return newOpenStream(func(r io.Reader) ([]byte, bool, io.Reader, error) {
+		mki, plaintext, err := saltpack.NewDecryptStream(saltpack.CheckKnownMajorVersion, r, kr)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		if mki.SenderIsAnon {
+			return nil, true, plaintext, nil
+		}
+		return mki.SenderKey.ToKID(), false, plaintext, nil
+	})
+}
+
+func newVerifyStream(kr *basic.Keyring) stream {
+	return newOpenStream(func(r io.Reader) ([]byte, bool, io.Reader, error) {
+		signer, msg, err := saltpack.NewVerifyStream(saltpack.CheckKnownMajorVersion, r, kr)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		return signer.ToKID(), false, msg, nil
+	})
+}
+
+func newSigncryptOpenStream(kr *basic.Keyring) stream {
+	return newOpenStream(func(r io.Reader) ([]byte, bool, io.Reader, error) {
+		signer, plaintext, err := saltpack.NewSigncryptOpenStream(r, kr, nil)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		if signer == nil {
+			return nil, true, plaintext, nil
+		}
+		return signer.ToKID(), false, plaintext, nil
+	})
+}
+
+func lookupStream(h C.saltpack_stream) (stream, error) {
+	return getHandle[stream](&handles, uintptr(h))
+}
+
+// setStream gives s to the caller as a new handle in out.
+func setStream(s stream, out *C.saltpack_stream) C.int {
+	*out = C.saltpack_stream(handles.add(s))
+	return codeOK
+}
+
+// saltpack_encrypt_stream_new starts encrypting from sender, or from an
+// anonymous sender if sender is NULL, to n_receivers box public keys.
+//
+//export saltpack_encrypt_stream_new
+func saltpack_encrypt_stream_new(version C.int, h C.saltpack_keyring, sender *C.saltpack_in_byte,
+	receivers *C.saltpack_in_byte, nReceivers C.size_t, out *C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	rs, err := goKeys(receivers, nReceivers)
+	if err != nil {
+		return errorCode(err)
+	}
+	s, err := newEncryptStream(int(version), kr, goKey(sender), rs)
+	if err != nil {
+		return errorCode(err)
+	}
+	return setStream(s, out)
+}
+
+// saltpack_decrypt_stream_new starts decrypting an encrypted message
+// with a key in the keyring.
+//
+//export saltpack_decrypt_stream_new
+func saltpack_decrypt_stream_new(h C.saltpack_keyring, out *C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	return setStream(newDecryptStream(kr), out)
+}
+
+// saltpack_sign_stream_new starts making an attached signature with the
+// signing key signer.
+//
+//export saltpack_sign_stream_new
+func saltpack_sign_stream_new(version C.int, h C.saltpack_keyring, signer *C.saltpack_in_byte, out *C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	s, err := newSignStream(int(version), kr, goKey(signer))
+	if err != nil {
+		return errorCode(err)
+	}
+	return setStream(s, out)
+}
+
+// saltpack_verify_stream_new starts verifying an attached signature. The
+// keyring may be 0.
+//
+//export saltpack_verify_stream_new
+func saltpack_verify_stream_new(h C.saltpack_keyring, out *C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil {
+		return codeInvalidArgument
+	}
+	kr, err := verifyKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	return setStream(newVerifyStream(kr), out)
+}
+
+// saltpack_signcrypt_stream_new starts signcrypting from the signing key
+// signer, or from an anonymous sender if signer is NULL, to n_receivers
+// box public keys.
+//
+//export saltpack_signcrypt_stream_new
+func saltpack_signcrypt_stream_new(h C.saltpack_keyring, signer *C.saltpack_in_byte,
+	receivers *C.saltpack_in_byte, nReceivers C.size_t, out *C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	rs, err := goKeys(receivers, nReceivers)
+	if err != nil {
+		return errorCode(err)
+	}
+	s, err := newSigncryptStream(kr, goKey(signer), rs)
+	if err != nil {
+		return errorCode(err)
+	}
+	return setStream(s, out)
+}
+
+// saltpack_signcrypt_open_stream_new starts opening a signcrypted
+// message with a box key in the keyring.
+//
+//export saltpack_signcrypt_open_stream_new
+func saltpack_signcrypt_open_stream_new(h C.saltpack_keyring, out *C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil {
+		return codeInvalidArgument
+	}
+	kr, err := lookupKeyring(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	return setStream(newSigncryptOpenStream(kr), out)
+}
+
+// saltpack_stream_write feeds in_len bytes of input to the stream. A
+// stream that decrypts or verifies may return an error for input that
+// it's found to be bad, after which the stream can only be freed.
+//
+//export saltpack_stream_write
+func saltpack_stream_write(h C.saltpack_stream, in *C.saltpack_in_byte, inLen C.size_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	s, err := lookupStream(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	p, err := goBytes(in, inLen)
+	if err != nil {
+		return errorCode(err)
+	}
+	return errorCode(s.write(p))
+}
+
+// saltpack_stream_read returns the stream's output that's ready, which
+// may be empty.
+//
+//export saltpack_stream_read
+func saltpack_stream_read(h C.saltpack_stream, out **C.uint8_t, outLen *C.size_t) (ret C.int) {
+	defer recoverPanic(&ret)
+	if out == nil || outLen == nil {
+		return codeInvalidArgument
+	}
+	s, err := lookupStream(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	b := s.read()
+	setOutput(b, out, outLen)
+	clear(b)
+	return codeOK
+}
+
+// saltpack_stream_close ends the stream's input, and returns the result:
+// SALTPACK_OK once a message is fully written, decrypted or verified.
+// Read the rest of the output after closing.
+//
+//export saltpack_stream_close
+func saltpack_stream_close(h C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	s, err := lookupStream(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	return errorCode(s.close())
+}
+
+// saltpack_stream_sender writes the public key of the sender of the
+// message that a stream decrypts or verifies to sender, and whether the
+// sender is anonymous to anon. It returns SALTPACK_ERR_NOT_READY until
+// the stream has read the message's header.
+//
+//export saltpack_stream_sender
+func saltpack_stream_sender(h C.saltpack_stream, sender *C.uint8_t, anon *C.int) (ret C.int) {
+	defer recoverPanic(&ret)
+	s, err := lookupStream(h)
+	if err != nil {
+		return errorCode(err)
+	}
+	kid, isAnon, err := s.sender()
+	if err != nil {
+		return errorCode(err)
+	}
+	setKey(sender, kid)
+	setBool(anon, isAnon)
+	return codeOK
+}
+
+// saltpack_stream_free abandons the stream if it's still open, wipes its
+// buffered output, and frees it.
+//
+//export saltpack_stream_free
+func saltpack_stream_free(h C.saltpack_stream) (ret C.int) {
+	defer recoverPanic(&ret)
+	s, err := removeHandle[stream](&handles, uintptr(h))
+	if err != nil {
+		return errorCode(err)
+	}
+	s.free()
+	return codeOK
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+// Command saltpack-agent holds saltpack secret keys in memory, and uses
+// them on behalf of clients connected to its Unix socket. See package
+// github.com/keybase/saltpack/agent.
+//
+// It decrypts the key file written by basic.WriteEncryptedKeyFile, asking
+// for its passphrase on the terminal, or with the -askpass program, then
+// prints a line for the shell to set SALTPACK_AGENT_SOCK, and serves
+// until it's interrupted.
+//
+// An -askpass program is run with a prompt as its argument. For a
+// passphrase, it prints the passphrase; for a confirmation, it exits
+// with status 0 to allow, like the SSH_ASKPASS programs of ssh-agent.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/keybase/saltpack/agent"
+	"github.com/keybase/saltpack/basic"
+	"golang.org/x/term"
+)
+
+func defaultSocketPath() string {
+	if p := os.Getenv(agent.SocketEnvVar); p != "" {
+		return p
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "saltpack", "agent.sock")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".saltpack", "agent.sock")
+	}
+	return ""
+}
+
+func askpass(program, prompt string) ([]byte, error) {
+	out, err := exec.Command(program, prompt).Output()
+	return bytes.TrimRight(out, "\r\n"), err
+}
+
+func readPassphrase(program, prompt string) ([]byte, error) {
+	if program == "" {
+		fmt.Fprint(os.Stderr, prompt)
+		defer fmt.Fprintln(os.Stderr)
+		return term.ReadPassword(int(os.Stdin.Fd()))
+	}
+	return askpass(program, prompt)
+}
+
+func main() {
+	var (
+		socket  = flag.String("socket", defaultSocketPath(), "path of the Unix socket to listen on")
+		keys    = flag.String("keys", "", "encrypted key file to load (required)")
+		idle    = flag.Duration("idle", 0, "lock after this long without using a key (0 never locks)")
+		confirm = flag.Bool("confirm", false, "ask -askpass to confirm each client's first use of each key")
+		prog    = flag.String("askpass", "", "program that asks for passphrases and confirmations")
+	)
+	flag.Parse()
+	if *keys == "" || *socket == "" || (*confirm && *prog == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*socket, *keys, *idle, *confirm, *prog); err != nil {
+		fmt.Fprintln(os.Stderr, "saltpack-agent:", err)
+		os.Exit(1)
+	}
+}
+
+func run(socket, keyFile string, idle time.Duration, confirm bool, prog string) error {
+	passphrase, err := readPassphrase(prog, "Passphrase for "+keyFile+": ")
+	if err != nil {
+		return err
+	}
+	kr := basic.NewKeyring()
+	err = kr.ImportEncryptedKeyFile(keyFile, passphrase)
+	clear(passphrase)
+	if err != nil {
+		return err
+	}
+
+	opts := &agent.Options{}
+	if idle > 0 {
+		opts.IdleTimeout = idle
+		if prog != "" {
+			// Unlock with the key file's passphrase again.
+			opts.Reauthenticate = func() bool {
+				p, err := askpass(prog, "saltpack-agent is locked. Passphrase for "+keyFile+":")
+				defer clear(p)
+				if err != nil {
+					return false
+				}
+				check := basic.NewKeyring()
+				defer check.Wipe()
+				return check.ImportEncryptedKeyFile(keyFile, p) == nil
+			}
+		}
+	}
+	a := agent.New(opts)
+	defer a.Wipe()
+
+	var keyOpts *agent.KeyOptions
+	if confirm {
+		keyOpts = &agent.KeyOptions{Confirm: func(op agent.Op, kid []byte) bool {
+			_, err := askpass(prog, fmt.Sprintf("Allow saltpack-agent to %s with key %s?", op, hex.EncodeToString(kid)))
+			return err == nil
+		}}
+	}
+	a.AddKeyring(kr, keyOpts)
+	kr.Wipe()
+
+	l, err := agent.Listen(socket)
+	if err != nil {
+		return err
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		l.Close()
+	}()
+
+	fmt.Printf("%s=%s; export %s;\n", agent.SocketEnvVar, socket, agent.SocketEnvVar)
+	return a.Serve(l)
+}
@@ -0,0 +1,555 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/agent"
+	"github.com/keybase/saltpack/basic"
+)
+
+// flags are the flags shared by the commands. Each command only
+// registers the ones it uses.
+type flags struct {
+	*flag.FlagSet
+	keyFiles   stringList
+	recipients stringList
+	useAgent   bool
+	armor      bool
+	brand      string
+	version    int
+	anon       bool
+	hide       bool
+	passphrase bool
+	sender     string
+	signer     string
+}
+
+func newFlags(e *env, name string) *flags {
+	f := &flags{FlagSet: flag.NewFlagSet("saltpack "+name, flag.ContinueOnError)}
+	f.SetOutput(e.stderr)
+	return f
+}
+
+func (f *flags) secretKeyFlags() {
+	f.Var(&f.keyFiles, "k", "read secret keys from the key `file` (repeatable)")
+	f.BoolVar(&f.useAgent, "agent", false, "use the keys in the agent at $"+agent.SocketEnvVar)
+}
+
+func (f *flags) recipientFlags() {
+	f.Var(&f.recipients, "r", "encrypt to the recipient `key`, a key file or hex key ID (repeatable)")
+	f.BoolVar(&f.hide, "hide", false, "hide the recipients' key IDs")
+}
+
+func (f *flags) armorFlags() {
+	f.BoolVar(&f.armor, "a", false, "armor the output")
+	f.StringVar(&f.brand, "brand", "", "the `brand` in the armor header and footer")
+}
+
+func (f *flags) versionFlag() {
+	f.IntVar(&f.version, "version", saltpack.CurrentVersion().Major, "the saltpack major `version`, 1 or 2")
+}
+
+// parse parses args, and checks that there are no arguments left over.
+func (f *flags) parse(args []string) error {
+	if err := f.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError("")
+	}
+	if f.NArg() != 0 {
+		return usageError(fmt.Sprintf("unexpected argument %q", f.Arg(0)))
+	}
+	return nil
+}
+
+func (f *flags) saltpackVersion() (saltpack.Version, error) {
+	for _, v := range saltpack.KnownVersions() {
+		if v.Major == f.version {
+			return v, nil
+		}
+	}
+	return saltpack.Version{}, usageError(fmt.Sprintf("unknown version %d", f.version))
+}
+
+// loadSecretKeys loads the keys given by the -k and -agent flags.
+func (f *flags) loadSecretKeys() (*secretKeys, error) {
+	if len(f.keyFiles) == 0 && !f.useAgent {
+		return nil, usageError("no secret keys; use -k or -agent")
+	}
+	return loadSecretKeys(f.keyFiles, f.useAgent)
+}
+
+// closeStream closes w, and returns the first of its error and err.
+func closeStream(w io.WriteCloser, err error) error {
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// passphrasePrompter prompts for the passphrase of a passphrase
+// receiver.
+func passphrasePrompter() ([]byte, error) {
+	return readPassphrase("Message passphrase: ")
+}
+
+func runEncrypt(e *env, args []string) error {
+	f := newFlags(e, "encrypt")
+	f.secretKeyFlags()
+	f.recipientFlags()
+	f.armorFlags()
+	f.versionFlag()
+	f.BoolVar(&f.anon, "anon", false, "encrypt from an anonymous sender")
+	f.StringVar(&f.sender, "sender", "", "encrypt from the box key whose hex key ID starts with `prefix`")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	version, err := f.saltpackVersion()
+	if err != nil {
+		return err
+	}
+	receivers, err := readRecipients(f.recipients, f.hide)
+	if err != nil {
+		return err
+	}
+
+	var sender saltpack.BoxSecretKey
+	if !f.anon {
+		keys, err := f.loadSecretKeys()
+		if err != nil {
+			return err
+		}
+		defer keys.close()
+		if sender, err = keys.boxKey(f.sender); err != nil {
+			return err
+		}
+	}
+
+	var w io.WriteCloser
+	if f.armor {
+		w, err = saltpack.NewEncryptArmor62Stream(version, e.stdout, sender, receivers, f.brand)
+	} else {
+		w, err = saltpack.NewEncryptStream(version, e.stdout, sender, receivers)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, e.stdin)
+	if err = closeStream(w, err); err != nil {
+		return err
+	}
+	if sender != nil {
+		return boxKeyErr(sender)
+	}
+	return nil
+}
+
+func runDecrypt(e *env, args []string) error {
+	f := newFlags(e, "decrypt")
+	f.secretKeyFlags()
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	keys, err := f.loadSecretKeys()
+	if err != nil {
+		return err
+	}
+	defer keys.close()
+
+	resolver := saltpack.NewPassphraseResolver(passphrasePrompter, nil)
+	r, typ, mki, senderPub, _, _, _, err := saltpack.ClassifyEncryptedStreamAndMakeDecoder(e.stdin, keys.signcryptKeyring(), resolver)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(e.stdout, r); err != nil {
+		return err
+	}
+	if typ == saltpack.MessageTypeEncryption {
+		if fp, ok := mki.SenderFingerprint(); ok {
+			fmt.Fprintf(e.stderr, "from box key %s\n", fp)
+		} else {
+			fmt.Fprintln(e.stderr, "from an anonymous sender")
+		}
+		return nil
+	}
+	printSigner(e, senderPub)
+	return nil
+}
+
+// printSigner reports the sender of a signcrypted message.
+func printSigner(e *env, senderPub saltpack.SigningPublicKey) {
+	if senderPub == nil {
+		fmt.Fprintln(e.stderr, "from an anonymous sender")
+		return
+	}
+	fmt.Fprintf(e.stderr, "signed by %s\n", saltpack.SigningPublicKeyFingerprint(senderPub))
+}
+
+func runSign(e *env, args []string) error {
+	f := newFlags(e, "sign")
+	f.secretKeyFlags()
+	f.armorFlags()
+	f.versionFlag()
+	detached := f.Bool("detached", false, "write a detached signature of stdin")
+	f.StringVar(&f.signer, "signer", "", "sign with the signing key whose hex key ID starts with `prefix`")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	version, err := f.saltpackVersion()
+	if err != nil {
+		return err
+	}
+	keys, err := f.loadSecretKeys()
+	if err != nil {
+		return err
+	}
+	defer keys.close()
+	signer, err := keys.signingKey(f.signer)
+	if err != nil {
+		return err
+	}
+
+	var w io.WriteCloser
+	switch {
+	case *detached && f.armor:
+		w, err = saltpack.NewSignDetachedArmor62Stream(version, e.stdout, signer, f.brand)
+	case *detached:
+		w, err = saltpack.NewSignDetachedStream(version, e.stdout, signer)
+	case f.armor:
+		w, err = saltpack.NewSignArmor62Stream(version, e.stdout, signer, f.brand)
+	default:
+		w, err = saltpack.NewSignStream(version, e.stdout, signer)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, e.stdin)
+	return closeStream(w, err)
+}
+
+func runVerify(e *env, args []string) error {
+	f := newFlags(e, "verify")
+	detached := f.String("detached", "", "verify stdin against the detached signature in `file`")
+	from := f.String("from", "", "require the signer to be the signing key in the key `file`")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	var want saltpack.SigningPublicKey
+	if *from != "" {
+		var err error
+		if want, err = readSigningPublicKey(*from); err != nil {
+			return err
+		}
+	}
+	keyring := basic.NewKeyring()
+
+	var signer saltpack.SigningPublicKey
+	if *detached != "" {
+		sig, err := os.ReadFile(*detached)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("BEGIN")) {
+			signer, _, err = saltpack.Dearmor62VerifyDetachedReader(saltpack.CheckKnownMajorVersion, e.stdin, string(sig), keyring)
+		} else {
+			signer, err = saltpack.VerifyDetachedReader(saltpack.CheckKnownMajorVersion, e.stdin, sig, keyring)
+		}
+		if err != nil {
+			return err
+		}
+	} else {
+		stream := bufio.NewReader(e.stdin)
+		isArmored, _, _, _, err := saltpack.ClassifyStream(stream)
+		if err != nil {
+			return err
+		}
+		var r io.Reader
+		if isArmored {
+			signer, r, _, err = saltpack.NewDearmor62VerifyStream(saltpack.CheckKnownMajorVersion, stream, keyring)
+		} else {
+			signer, r, err = saltpack.NewVerifyStream(saltpack.CheckKnownMajorVersion, stream, keyring)
+		}
+		if err != nil {
+			return err
+		}
+		// The signer is only known to be right once the whole message has
+		// verified, so check it after copying.
+		if _, err = io.Copy(e.stdout, r); err != nil {
+			return err
+		}
+	}
+	if want != nil && !saltpack.PublicKeyEqual(signer, want) {
+		return errWrongSigner{signer}
+	}
+	fmt.Fprintf(e.stderr, "signed by %s\n", saltpack.SigningPublicKeyFingerprint(signer))
+	return nil
+}
+
+func runSigncrypt(e *env, args []string) error {
+	f := newFlags(e, "signcrypt")
+	f.secretKeyFlags()
+	f.recipientFlags()
+	f.armorFlags()
+	f.BoolVar(&f.anon, "anon", false, "signcrypt from an anonymous sender")
+	f.BoolVar(&f.passphrase, "passphrase", false, "also let the message be opened with a passphrase")
+	f.StringVar(&f.signer, "signer", "", "sign with the signing key whose hex key ID starts with `prefix`")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+
+	var receivers []saltpack.BoxPublicKey
+	if len(f.recipients) != 0 || !f.passphrase {
+		var err error
+		if receivers, err = readRecipients(f.recipients, f.hide); err != nil {
+			return err
+		}
+	}
+	var symmetricReceivers []saltpack.ReceiverSymmetricKey
+	if f.passphrase {
+		passphrase, err := passphrasePrompter()
+		if err != nil {
+			return err
+		}
+		receiver, err := saltpack.NewPassphraseReceiver(passphrase, nil)
+		clear(passphrase)
+		if err != nil {
+			return err
+		}
+		symmetricReceivers = append(symmetricReceivers, receiver)
+	}
+
+	var signer saltpack.SigningSecretKey
+	if !f.anon {
+		keys, err := f.loadSecretKeys()
+		if err != nil {
+			return err
+		}
+		defer keys.close()
+		if signer, err = keys.signingKey(f.signer); err != nil {
+			return err
+		}
+	}
+
+	var (
+		w   io.WriteCloser
+		err error
+	)
+	if f.armor {
+		w, err = saltpack.NewSigncryptArmor62SealStream(e.stdout, basic.EphemeralKeyCreator{}, signer, receivers, symmetricReceivers, f.brand)
+	} else {
+		w, err = saltpack.NewSigncryptSealStream(e.stdout, basic.EphemeralKeyCreator{}, signer, receivers, symmetricReceivers)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, e.stdin)
+	return closeStream(w, err)
+}
+
+func runSigncryptOpen(e *env, args []string) error {
+	f := newFlags(e, "signcrypt-open")
+	f.secretKeyFlags()
+	from := f.String("from", "", "require the sender to be the signing key in the key `file`")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	var want saltpack.SigningPublicKey
+	if *from != "" {
+		var err error
+		if want, err = readSigningPublicKey(*from); err != nil {
+			return err
+		}
+	}
+	// Without -k or -agent, only passphrase receivers can open the
+	// message.
+	keys, err := loadSecretKeys(f.keyFiles, f.useAgent)
+	if err != nil {
+		return err
+	}
+	defer keys.close()
+
+	stream := bufio.NewReader(e.stdin)
+	isArmored, _, typ, _, err := saltpack.ClassifyStream(stream)
+	if err != nil {
+		return err
+	}
+	if typ != saltpack.MessageTypeSigncryption {
+		return saltpack.ErrWrongMessageType{Wanted: saltpack.MessageTypeSigncryption, Received: typ}
+	}
+	resolver := saltpack.NewPassphraseResolver(passphrasePrompter, nil)
+	var (
+		senderPub saltpack.SigningPublicKey
+		r         io.Reader
+	)
+	if isArmored {
+		senderPub, r, _, err = saltpack.NewDearmor62SigncryptOpenStream(stream, keys.signcryptKeyring(), resolver)
+	} else {
+		senderPub, r, err = saltpack.NewSigncryptOpenStream(stream, keys.signcryptKeyring(), resolver)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(e.stdout, r); err != nil {
+		return err
+	}
+	if want != nil && (senderPub == nil || !saltpack.PublicKeyEqual(senderPub, want)) {
+		return errWrongSigner{senderPub}
+	}
+	printSigner(e, senderPub)
+	return nil
+}
+
+// armorType returns the message type named in the armor frame of a
+// message of type typ. Signcrypted messages are framed as encrypted.
+func armorType(typ saltpack.MessageType) saltpack.MessageType {
+	if typ == saltpack.MessageTypeSigncryption {
+		return saltpack.MessageTypeEncryption
+	}
+	return typ
+}
+
+func runArmor(e *env, args []string) error {
+	f := newFlags(e, "armor")
+	f.StringVar(&f.brand, "brand", "", "the `brand` in the armor header and footer")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	stream := bufio.NewReader(e.stdin)
+	typ, _, err := saltpack.IsSaltpackBinary(stream)
+	if err != nil {
+		return err
+	}
+	w, err := saltpack.NewArmor62EncoderStream(e.stdout, armorType(typ), f.brand)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, stream)
+	if err = closeStream(w, err); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.stdout)
+	return err
+}
+
+func runDearmor(e *env, args []string) error {
+	f := newFlags(e, "dearmor")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	stream := bufio.NewReader(e.stdin)
+	isArmored, _, typ, _, err := saltpack.ClassifyStream(stream)
+	if err != nil {
+		return err
+	}
+	if !isArmored {
+		return fmt.Errorf("input isn't armored: %w", saltpack.ErrNotASaltpackMessage)
+	}
+	frameChecker := func(header, footer string) (string, error) {
+		return saltpack.CheckArmor62(header, footer, armorType(typ))
+	}
+	r, _, err := saltpack.NewArmor62DecoderStream(stream, nil, frameChecker)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(e.stdout, r)
+	return err
+}
+
+func runKeygen(e *env, args []string) error {
+	f := newFlags(e, "keygen")
+	out := f.String("o", "", "write the new keyring to `file`")
+	f.BoolVar(&f.passphrase, "passphrase", false, "encrypt the keyring with a passphrase")
+	f.StringVar(&f.brand, "brand", "", "the `brand` in the armor header and footer")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	if *out == "" {
+		return usageError("no output file; use -o")
+	}
+
+	kr := basic.NewKeyring()
+	defer kr.Wipe()
+	boxKey, err := kr.GenerateBoxKey()
+	if err != nil {
+		return err
+	}
+	defer boxKey.Wipe()
+	sigKey, err := kr.GenerateSigningKey()
+	if err != nil {
+		return err
+	}
+	defer sigKey.Wipe()
+
+	var b []byte
+	if f.passphrase {
+		passphrase, err := readPassphrase("New passphrase: ")
+		if err != nil {
+			return err
+		}
+		b, err = basic.EncryptKeys(kr, passphrase, nil)
+		clear(passphrase)
+		if err != nil {
+			return err
+		}
+	} else {
+		armored, err := basic.ArmorKey(kr, f.brand)
+		if err != nil {
+			return err
+		}
+		b = []byte(armored + "\n")
+	}
+	// O_EXCL, so that an existing keyring is never overwritten.
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(b)
+	clear(b)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, pub := range []basic.Exportable{boxKey.GetPublicKey().(basic.PublicKey), sigKey.GetPublicKey().(basic.SigningPublicKey)} {
+		armored, err := basic.ArmorKey(pub, f.brand)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(e.stdout, armored)
+	}
+	return nil
+}
+
+func runInspect(e *env, args []string) error {
+	f := newFlags(e, "inspect")
+	if err := f.parse(args); err != nil {
+		return err
+	}
+	stream := bufio.NewReader(e.stdin)
+	isArmored, brand, typ, version, err := saltpack.ClassifyStream(stream)
+	if err != nil {
+		return err
+	}
+	format := "binary"
+	if isArmored {
+		format = "armored"
+		if brand != "" {
+			format += ", brand " + brand
+		}
+	}
+	_, err = fmt.Fprintf(e.stdout, "%s, version %s, %s\n", typ, version, format)
+	return err
+}
@@ -0,0 +1,385 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/keybase/saltpack"
+	"github.com/keybase/saltpack/agent"
+	"github.com/keybase/saltpack/basic"
+	"golang.org/x/term"
+)
+
+// passphraseEnvVar holds the passphrase for encrypted key files and
+// passphrase receivers, for scripts that can't use a terminal.
+const passphraseEnvVar = "SALTPACK_PASSPHRASE"
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// errWrongSigner is returned by verify when the message was signed by
+// some other key than the -from key.
+type errWrongSigner struct {
+	signer saltpack.SigningPublicKey
+}
+
+func (e errWrongSigner) Error() string {
+	return "signed by " + saltpack.SigningPublicKeyFingerprint(e.signer).String() + ", not by the -from key"
+}
+
+// readPassphrase returns $SALTPACK_PASSPHRASE if it's set, or else reads
+// a passphrase from the terminal, after showing the prompt.
+func readPassphrase(prompt string) ([]byte, error) {
+	if p, ok := os.LookupEnv(passphraseEnvVar); ok {
+		return []byte(p), nil
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to read a passphrase from, and %s isn't set", passphraseEnvVar)
+	}
+	defer tty.Close()
+	fmt.Fprint(tty, prompt)
+	defer fmt.Fprintln(tty)
+	return term.ReadPassword(int(tty.Fd()))
+}
+
+// splitArmored splits s into the armored blocks it holds, each ending
+// with an "END ... ." footer.
+func splitArmored(s string) []string {
+	var blocks []string
+	for {
+		begin := strings.Index(s, "BEGIN")
+		if begin < 0 {
+			return blocks
+		}
+		end := strings.Index(s[begin:], "END")
+		if end < 0 {
+			return append(blocks, s[begin:])
+		}
+		end += begin
+		dot := strings.IndexByte(s[end:], '.')
+		if dot < 0 {
+			return append(blocks, s[begin:])
+		}
+		end += dot + 1
+		blocks = append(blocks, s[begin:end])
+		s = s[end:]
+	}
+}
+
+// readKeyFile reads the keys in a key file: one or more armored keys, a
+// binary key or keyring, or a key or keyring encrypted with a passphrase
+// by basic.EncryptKeys. An armored key bundle is verified, and its box
+// keys that are valid now are returned.
+func readKeyFile(path string) ([]basic.Exportable, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(b)
+	trimmed := bytes.TrimSpace(b)
+	if !bytes.HasPrefix(trimmed, []byte("BEGIN")) {
+		k, err := basic.ParseKey(trimmed)
+		if err == nil {
+			return []basic.Exportable{k}, nil
+		}
+		passphrase, err := readPassphrase("Passphrase for " + path + ": ")
+		if err != nil {
+			return nil, err
+		}
+		defer clear(passphrase)
+		k, err = basic.DecryptKeys(trimmed, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return []basic.Exportable{k}, nil
+	}
+
+	var keys []basic.Exportable
+	for _, block := range splitArmored(string(trimmed)) {
+		if strings.Contains(block[:strings.IndexByte(block+".", '.')], saltpack.KeyBundleArmorString) {
+			bundle, _, err := saltpack.Dearmor62VerifyKeyBundle(block, basic.NewKeyring())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			for _, pub := range bundle.BoxPublicKeysAt(time.Now()) {
+				keys = append(keys, pub.(basic.PublicKey))
+			}
+			continue
+		}
+		k, _, err := basic.DearmorKey(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys found", path)
+	}
+	return keys, nil
+}
+
+// hiddenPublicKey is a box public key whose identity is hidden in
+// encrypted messages.
+type hiddenPublicKey struct {
+	basic.PublicKey
+}
+
+func (k hiddenPublicKey) HideIdentity() bool { return true }
+
+// appendBoxPublicKey appends k to pubs if it's a box public key, using
+// the Curve25519 half of a hybrid key.
+func appendBoxPublicKey(pubs []basic.PublicKey, k any) []basic.PublicKey {
+	switch k := k.(type) {
+	case basic.PublicKey:
+		return append(pubs, k)
+	case basic.HybridPublicKey:
+		return append(pubs, k.PublicKey)
+	default:
+		return pubs
+	}
+}
+
+// readRecipients reads the box public keys of the recipients, each given
+// as a key file or a hex key ID, and hides them if hide is set. A secret
+// key file's public keys are used, so that one can encrypt to oneself.
+// Hybrid keys are used for their Curve25519 half, since the versions this
+// tool writes don't use ML-KEM.
+func readRecipients(recipients []string, hide bool) ([]saltpack.BoxPublicKey, error) {
+	var pubs []basic.PublicKey
+	for _, r := range recipients {
+		if _, err := os.Stat(r); err != nil {
+			kid, herr := hex.DecodeString(r)
+			if herr != nil {
+				return nil, err
+			}
+			if typ, untyped, terr := saltpack.ParseTypedKID(kid); terr == nil && (typ == saltpack.KeyTypeCurve25519 || typ == saltpack.KeyTypeX25519MLKEM768) {
+				kid = untyped
+			}
+			if len(kid) != len(saltpack.RawBoxKey{}) {
+				return nil, usageError(fmt.Sprintf("bad recipient %q", r))
+			}
+			pubs = append(pubs, basic.PublicKey{RawBoxKey: saltpack.RawBoxKey(kid)})
+			continue
+		}
+		keys, err := readKeyFile(r)
+		if err != nil {
+			return nil, err
+		}
+		n := len(pubs)
+		for _, k := range keys {
+			switch k := k.(type) {
+			case *basic.Keyring:
+				for _, sk := range k.GetAllBoxSecretKeys() {
+					pubs = appendBoxPublicKey(pubs, sk.GetPublicKey())
+				}
+				k.Wipe()
+			case saltpack.BoxSecretKey:
+				pubs = appendBoxPublicKey(pubs, k.GetPublicKey())
+			default:
+				pubs = appendBoxPublicKey(pubs, k)
+			}
+		}
+		if len(pubs) == n {
+			return nil, fmt.Errorf("%s: no box public keys", r)
+		}
+	}
+	if len(pubs) == 0 {
+		return nil, usageError("no recipients; use -r")
+	}
+	out := make([]saltpack.BoxPublicKey, len(pubs))
+	for i, pub := range pubs {
+		if hide {
+			out[i] = hiddenPublicKey{pub}
+		} else {
+			out[i] = pub
+		}
+	}
+	return out, nil
+}
+
+// readSigningPublicKey reads the signing public key in a key file.
+func readSigningPublicKey(path string) (saltpack.SigningPublicKey, error) {
+	keys, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		switch k := k.(type) {
+		case basic.SigningPublicKey:
+			return k, nil
+		case basic.SigningSecretKey:
+			return k.GetPublicKey(), nil
+		case *basic.Keyring:
+			defer k.Wipe()
+			if sks := k.GetAllSigningSecretKeys(); len(sks) == 1 {
+				return sks[0].GetPublicKey(), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s: no single signing public key", path)
+}
+
+// secretKeys are the secret keys from key files and the agent.
+type secretKeys struct {
+	keyring *basic.Keyring
+	client  *agent.Client
+}
+
+// loadSecretKeys loads the secret keys in the given key files, and
+// connects to the agent if useAgent is set.
+func loadSecretKeys(paths []string, useAgent bool) (*secretKeys, error) {
+	s := &secretKeys{keyring: basic.NewKeyring()}
+	for _, path := range paths {
+		keys, err := readKeyFile(path)
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		for _, k := range keys {
+			addSecretKeys(s.keyring, k)
+		}
+	}
+	if useAgent {
+		c, err := agent.Dial("")
+		if err != nil {
+			s.close()
+			return nil, err
+		}
+		s.client = c
+	}
+	return s, nil
+}
+
+// addSecretKeys adds the secret keys in k to kr, and wipes them from k.
+// Public keys are ignored.
+func addSecretKeys(kr *basic.Keyring, k basic.Exportable) {
+	switch k := k.(type) {
+	case basic.SecretKey:
+		kr.ImportBoxKey(k.GetRawPublicKey(), k.GetRawSecretKey())
+		k.Wipe()
+	case basic.HybridSecretKey:
+		kr.ImportHybridBoxKey(k.GetRawPublicKey(), k.GetRawSecretKey(), k.GetRawMLKEMSeed())
+		k.Wipe()
+	case basic.SigningSecretKey:
+		kr.ImportSigningKey(k.GetRawPublicKey(), k.GetRawSecretKey())
+		k.Wipe()
+	case *basic.Keyring:
+		for _, sk := range k.GetAllBoxSecretKeys() {
+			addSecretKeys(kr, sk.(basic.Exportable))
+		}
+		for _, sk := range k.GetAllSigningSecretKeys() {
+			addSecretKeys(kr, sk.(basic.SigningSecretKey))
+		}
+		k.Wipe()
+	}
+}
+
+func (s *secretKeys) close() {
+	s.keyring.Wipe()
+	if s.client != nil {
+		s.client.Close()
+	}
+}
+
+// signcryptKeyring returns a keyring for decryption and verification
+// that has the keys from both the files and the agent.
+func (s *secretKeys) signcryptKeyring() saltpack.SigncryptKeyring {
+	if s.client == nil {
+		return s.keyring
+	}
+	return saltpack.ChainSigncryptKeyrings(s.keyring, clientSigncryptKeyring{s.client, s.keyring})
+}
+
+// clientSigncryptKeyring is the agent's keyring, with the key files'
+// keyring answering signing key lookups, so that messages from anyone
+// can be verified.
+type clientSigncryptKeyring struct {
+	*agent.Client
+	pubs saltpack.SigKeyring
+}
+
+func (k clientSigncryptKeyring) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
+	return k.pubs.LookupSigningPublicKey(kid)
+}
+
+func kidMatches(kid []byte, prefix string) bool {
+	return prefix == "" || strings.HasPrefix(hex.EncodeToString(kid), strings.ToLower(prefix))
+}
+
+// boxKey returns the box secret key whose hex key ID starts with prefix,
+// or the only one if prefix is empty.
+func (s *secretKeys) boxKey(prefix string) (saltpack.BoxSecretKey, error) {
+	keys := s.keyring.GetAllBoxSecretKeys()
+	if s.client != nil {
+		keys = append(keys, s.client.GetAllBoxSecretKeys()...)
+	}
+	var found []saltpack.BoxSecretKey
+	for _, k := range keys {
+		if kidMatches(k.GetPublicKey().ToKID(), prefix) {
+			found = append(found, k)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, usageError("no box secret key; use -k or -agent")
+	case 1:
+		return found[0], nil
+	default:
+		return nil, usageError("more than one box secret key; pick one with -sender")
+	}
+}
+
+// signingKey returns the signing secret key whose hex key ID starts with
+// prefix, or the only one if prefix is empty.
+func (s *secretKeys) signingKey(prefix string) (saltpack.SigningSecretKey, error) {
+	keys := s.keyring.GetAllSigningSecretKeys()
+	if s.client != nil {
+		agentKeys, err := s.client.SigningKeys()
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range agentKeys {
+			keys = append(keys, k)
+		}
+	}
+	var found []saltpack.SigningSecretKey
+	for _, k := range keys {
+		if kidMatches(k.GetPublicKey().ToKID(), prefix) {
+			found = append(found, k)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, usageError("no signing secret key; use -k or -agent")
+	case 1:
+		return found[0], nil
+	default:
+		return nil, usageError("more than one signing secret key; pick one with -signer")
+	}
+}
+
+// boxKeyErr returns the error kept by an agent or kernel box key whose
+// Box failed, since Box can't return it.
+func boxKeyErr(k saltpack.BoxSecretKey) error {
+	if e, ok := k.(interface{ Err() error }); ok {
+		return e.Err()
+	}
+	return nil
+}
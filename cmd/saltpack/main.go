@@ -0,0 +1,199 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+// Command saltpack encrypts, decrypts, signs, verifies, signcrypts and
+// armors saltpack messages, streaming from stdin to stdout.
+//
+// Usage:
+//
+//	saltpack <command> [flags]
+//
+// The commands are:
+//
+//	encrypt         encrypt stdin to the -r recipients
+//	decrypt         decrypt an encrypted or signcrypted message
+//	sign            sign stdin, attached or -detached
+//	verify          verify a signed message, or stdin against a -detached signature
+//	signcrypt       signcrypt stdin to the -r recipients
+//	signcrypt-open  open a signcrypted message
+//	armor           armor a binary message
+//	dearmor         dearmor an armored message
+//	keygen          generate a keyring with a box key and a signing key
+//	inspect         describe a message without decrypting or verifying it
+//
+// Run "saltpack <command> -h" for a command's flags.
+//
+// Secret keys come from -k key files, written by keygen or
+// basic.ArmorKey, and from a saltpack-agent with -agent. Recipients are
+// given with -r, either as key files holding public keys or verified key
+// bundles, or as hex key IDs. A key file that's encrypted with a
+// passphrase is decrypted with $SALTPACK_PASSPHRASE if it's set, or else
+// with a passphrase read from the terminal.
+//
+// Messages are read and written as streams, so decrypt and verify may
+// write part of a message before they find that it's been tampered with
+// or truncated. Check the exit status before trusting the output.
+//
+// The exit status is 0 on success, 2 for bad usage, and otherwise:
+//
+//	3  a signature or authenticator didn't verify
+//	4  no key was found to decrypt the message, or to verify its sender
+//	5  the message was truncated
+//	6  the input isn't a well-formed saltpack message
+//	1  any other error
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/keybase/saltpack"
+)
+
+const (
+	exitOK           = 0
+	exitError        = 1
+	exitUsage        = 2
+	exitBadSignature = 3
+	exitNoKey        = 4
+	exitTruncated    = 5
+	exitMalformed    = 6
+)
+
+// env is where a command reads and writes.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	summary string
+	run     func(e *env, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"encrypt":        {"encrypt stdin to the -r recipients", runEncrypt},
+		"decrypt":        {"decrypt an encrypted or signcrypted message", runDecrypt},
+		"sign":           {"sign stdin, attached or -detached", runSign},
+		"verify":         {"verify a signed message, or stdin against a -detached signature", runVerify},
+		"signcrypt":      {"signcrypt stdin to the -r recipients", runSigncrypt},
+		"signcrypt-open": {"open a signcrypted message", runSigncryptOpen},
+		"armor":          {"armor a binary message", runArmor},
+		"dearmor":        {"dearmor an armored message", runDearmor},
+		"keygen":         {"generate a keyring with a box key and a signing key", runKeygen},
+		"inspect":        {"describe a message without decrypting or verifying it", runInspect},
+	}
+}
+
+// usageError is returned for bad command-line arguments.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: saltpack <command> [flags]")
+	fmt.Fprintln(w)
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-15s %s\n", name, commands[name].summary)
+	}
+}
+
+// exitCode maps err to the exit status of the command.
+func exitCode(err error) int {
+	var (
+		usageErr    usageError
+		noSenderKey saltpack.ErrNoSenderKey
+		revokedKey  saltpack.ErrRevokedKey
+		expiredKey  saltpack.ErrExpiredKey
+		badTag      saltpack.ErrBadTag
+		badFrame    saltpack.ErrBadFrame
+		badArmor    saltpack.ErrBadArmor
+		wrongType   saltpack.ErrWrongMessageType
+		badVersion  saltpack.ErrBadVersion
+		badCipher   saltpack.ErrBadCiphertext
+		repeatedKey saltpack.ErrRepeatedKey
+		missingPart saltpack.ErrMissingArmorPart
+		wrongSigner errWrongSigner
+	)
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.Is(err, saltpack.ErrBadSignature),
+		errors.Is(err, saltpack.ErrDecryptionFailed),
+		errors.Is(err, saltpack.ErrBadSenderKeySecretbox),
+		errors.As(err, &badTag),
+		errors.As(err, &wrongSigner):
+		return exitBadSignature
+	case errors.Is(err, saltpack.ErrNoDecryptionKey),
+		errors.As(err, &noSenderKey),
+		errors.As(err, &revokedKey),
+		errors.As(err, &expiredKey):
+		return exitNoKey
+	case errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, saltpack.ErrUnexpectedEmptyBlock):
+		return exitTruncated
+	case errors.Is(err, saltpack.ErrNotASaltpackMessage),
+		errors.Is(err, saltpack.ErrShortSliceOrBuffer),
+		errors.Is(err, saltpack.ErrTrailingGarbage),
+		errors.Is(err, saltpack.ErrFailedToReadHeaderBytes),
+		errors.Is(err, saltpack.ErrBadEphemeralKey),
+		errors.As(err, &badFrame),
+		errors.As(err, &badArmor),
+		errors.As(err, &wrongType),
+		errors.As(err, &badVersion),
+		errors.As(err, &badCipher),
+		errors.As(err, &repeatedKey),
+		errors.As(err, &missingPart):
+		return exitMalformed
+	default:
+		return exitError
+	}
+}
+
+// run runs the command line args, and returns the exit status.
+func run(args []string, e *env) int {
+	if len(args) == 0 {
+		usage(e.stderr)
+		return exitUsage
+	}
+	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		usage(e.stdout)
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(e.stderr, "saltpack: unknown command %q\n", args[0])
+		usage(e.stderr)
+		return exitUsage
+	}
+	err := cmd.run(e, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	// The flag package has already reported bad flags.
+	if err != nil && err.Error() != "" {
+		fmt.Fprintf(e.stderr, "saltpack %s: %v\n", args[0], err)
+	}
+	return exitCode(err)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], &env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}))
+}
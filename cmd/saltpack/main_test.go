@@ -0,0 +1,211 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/saltpack/basic"
+)
+
+// runCmd runs the command line args with stdin, and returns its exit
+// status and stdout.
+func runCmd(t *testing.T, stdin []byte, args ...string) (int, []byte) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &env{stdin: bytes.NewReader(stdin), stdout: &stdout, stderr: &stderr})
+	if code != exitOK {
+		t.Logf("saltpack %s: exit %d: %s", strings.Join(args, " "), code, stderr.String())
+	}
+	return code, stdout.Bytes()
+}
+
+// mustRun runs the command line args, and fails the test if it doesn't
+// succeed.
+func mustRun(t *testing.T, stdin []byte, args ...string) []byte {
+	t.Helper()
+	code, out := runCmd(t, stdin, args...)
+	if code != exitOK {
+		t.Fatalf("saltpack %s: exit %d", strings.Join(args, " "), code)
+	}
+	return out
+}
+
+// keygen makes a keyring in dir, and returns the paths of the keyring
+// and of a file holding its public keys.
+func keygen(t *testing.T, dir, name string) (secret, public string) {
+	t.Helper()
+	secret = filepath.Join(dir, name+".keys")
+	public = filepath.Join(dir, name+".pub")
+	pubs := mustRun(t, nil, "keygen", "-o", secret)
+	if err := os.WriteFile(public, pubs, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return secret, public
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	alice, _ := keygen(t, dir, "alice")
+	bob, bobPub := keygen(t, dir, "bob")
+	msg := []byte("hello from the command line")
+
+	for _, args := range [][]string{
+		{"-version", "1"},
+		{"-version", "2"},
+		{"-a", "-brand", "TEST"},
+		{"-hide"},
+		{"-anon"},
+	} {
+		encrypt := append([]string{"encrypt", "-k", alice, "-r", bobPub}, args...)
+		ciphertext := mustRun(t, msg, encrypt...)
+		if plaintext := mustRun(t, ciphertext, "decrypt", "-k", bob); !bytes.Equal(plaintext, msg) {
+			t.Fatalf("%v: bad decryption", args)
+		}
+		if code, _ := runCmd(t, ciphertext, "decrypt", "-k", alice); code != exitNoKey {
+			t.Fatalf("%v: decrypt without the key: exit %d", args, code)
+		}
+	}
+}
+
+func TestEncryptToHybridKey(t *testing.T) {
+	dir := t.TempDir()
+	kr := basic.NewKeyring()
+	if _, err := kr.GenerateHybridBoxKey(); err != nil {
+		t.Fatal(err)
+	}
+	armored, err := basic.ArmorKey(kr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := filepath.Join(dir, "hybrid.keys")
+	if err := os.WriteFile(keys, []byte(armored), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello, hybrid key")
+
+	// Hybrid keys are used as plain box keys in the versions this tool
+	// writes.
+	ciphertext := mustRun(t, msg, "encrypt", "-k", keys, "-r", keys)
+	if plaintext := mustRun(t, ciphertext, "decrypt", "-k", keys); !bytes.Equal(plaintext, msg) {
+		t.Fatal("bad decryption")
+	}
+}
+
+func TestSigncrypt(t *testing.T) {
+	dir := t.TempDir()
+	alice, alicePub := keygen(t, dir, "alice")
+	bob, bobPub := keygen(t, dir, "bob")
+	msg := []byte("signed and encrypted")
+
+	for _, armor := range []bool{false, true} {
+		args := []string{"signcrypt", "-k", alice, "-r", bobPub}
+		if armor {
+			args = append(args, "-a")
+		}
+		ciphertext := mustRun(t, msg, args...)
+		if plaintext := mustRun(t, ciphertext, "signcrypt-open", "-k", bob, "-from", alicePub); !bytes.Equal(plaintext, msg) {
+			t.Fatal("bad signcrypt-open")
+		}
+		if plaintext := mustRun(t, ciphertext, "decrypt", "-k", bob); !bytes.Equal(plaintext, msg) {
+			t.Fatal("bad decrypt of a signcrypted message")
+		}
+		if code, _ := runCmd(t, ciphertext, "signcrypt-open", "-k", bob, "-from", bobPub); code != exitBadSignature {
+			t.Fatalf("wrong sender: exit %d", code)
+		}
+	}
+
+	t.Setenv(passphraseEnvVar, "correct horse battery staple")
+	ciphertext := mustRun(t, msg, "signcrypt", "-anon", "-passphrase")
+	if plaintext := mustRun(t, ciphertext, "signcrypt-open"); !bytes.Equal(plaintext, msg) {
+		t.Fatal("bad signcrypt-open with a passphrase")
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	dir := t.TempDir()
+	alice, alicePub := keygen(t, dir, "alice")
+	_, bobPub := keygen(t, dir, "bob")
+	msg := []byte("signed on the command line")
+
+	for _, armor := range []bool{false, true} {
+		args := []string{"sign", "-k", alice}
+		if armor {
+			args = append(args, "-a")
+		}
+		signed := mustRun(t, msg, args...)
+		if verified := mustRun(t, signed, "verify", "-from", alicePub); !bytes.Equal(verified, msg) {
+			t.Fatal("bad verification")
+		}
+		if code, _ := runCmd(t, signed, "verify", "-from", bobPub); code != exitBadSignature {
+			t.Fatalf("wrong signer: exit %d", code)
+		}
+
+		sig := filepath.Join(dir, "sig")
+		if err := os.WriteFile(sig, mustRun(t, msg, append(args, "-detached")...), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		mustRun(t, msg, "verify", "-detached", sig, "-from", alicePub)
+		if code, _ := runCmd(t, []byte("something else"), "verify", "-detached", sig); code != exitBadSignature {
+			t.Fatalf("detached signature of another message: exit %d", code)
+		}
+	}
+}
+
+func TestArmorDearmorInspect(t *testing.T) {
+	dir := t.TempDir()
+	alice, _ := keygen(t, dir, "alice")
+	signed := mustRun(t, []byte("armor me"), "sign", "-k", alice)
+
+	armored := mustRun(t, signed, "armor", "-brand", "TEST")
+	if !bytes.HasPrefix(armored, []byte("BEGIN TEST SALTPACK SIGNED MESSAGE.")) {
+		t.Fatalf("bad armor %q", armored)
+	}
+	if dearmored := mustRun(t, armored, "dearmor"); !bytes.Equal(dearmored, signed) {
+		t.Fatal("bad dearmor")
+	}
+	if info := string(mustRun(t, armored, "inspect")); !strings.Contains(info, "armored, brand TEST") {
+		t.Fatalf("bad inspect output %q", info)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	alice, alicePub := keygen(t, dir, "alice")
+	msg := bytes.Repeat([]byte("x"), 3<<20)
+
+	signed := mustRun(t, msg, "sign", "-k", alice)
+	bad := bytes.Clone(signed)
+	bad[len(bad)/2] ^= 1
+	if code, _ := runCmd(t, bad, "verify"); code != exitBadSignature {
+		t.Fatalf("tampered signature: exit %d", code)
+	}
+	if code, _ := runCmd(t, signed[:len(signed)/2], "verify"); code != exitTruncated {
+		t.Fatalf("truncated signature: exit %d", code)
+	}
+
+	ciphertext := mustRun(t, msg, "encrypt", "-k", alice, "-r", alicePub)
+	bad = bytes.Clone(ciphertext)
+	bad[len(bad)/2] ^= 1
+	if code, _ := runCmd(t, bad, "decrypt", "-k", alice); code != exitBadSignature {
+		t.Fatalf("tampered ciphertext: exit %d", code)
+	}
+	if code, _ := runCmd(t, ciphertext[:len(ciphertext)/2], "decrypt", "-k", alice); code != exitTruncated {
+		t.Fatalf("truncated ciphertext: exit %d", code)
+	}
+
+	if code, _ := runCmd(t, []byte("not a saltpack message at all, not even close"), "decrypt", "-k", alice); code != exitMalformed {
+		t.Fatalf("garbage: exit %d", code)
+	}
+	if code, _ := runCmd(t, nil, "encrypt", "-k", alice); code != exitUsage {
+		t.Fatalf("no recipients: exit %d", code)
+	}
+	if code, _ := runCmd(t, nil, "frobnicate"); code != exitUsage {
+		t.Fatalf("unknown command: exit %d", code)
+	}
+}
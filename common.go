@@ -151,15 +151,25 @@ func computePayloadAuthenticator(macKey macKey, payloadHash payloadHash) payload
 
 func computeMACKeySingle(secret BoxSecretKey, public BoxPublicKey, nonce Nonce) macKey {
 	macKeyBox := secret.Box(public, nonce, make([]byte, cryptoAuthKeyBytes))
+	defer clear(macKeyBox)
 	return sliceToByte32(macKeyBox[chacha20poly1305.Overhead : chacha20poly1305.Overhead+cryptoAuthKeyBytes])
 }
 
-func sum512Truncate256(in []byte) [32]byte {
+// combineMACKeys hashes a V2 receiver's two MAC keys together into the one
+// that's used, wiping the copies made along the way.
+func combineMACKeys(mac, eMAC macKey) macKey {
+	var both [2 * cryptoAuthKeyBytes]byte
+	copy(both[:], mac[:])
+	copy(both[cryptoAuthKeyBytes:], eMAC[:])
 	// Consistent with computePayloadAuthenticator in that it
 	// truncates SHA512 instead of calling SHA512/256, which has
 	// different IVs.
-	sum512 := sha512.Sum512(in)
-	return sliceToByte32(sum512[:32])
+	sum512 := sha512.Sum512(both[:])
+	clear(both[:])
+	var ret macKey
+	copy(ret[:], sum512[:])
+	clear(sum512[:])
+	return ret
 }
 
 func computePayloadHash(version Version, headerHash headerHash, nonce Nonce, ciphertext []byte, isFinal bool) payloadHash {
@@ -169,7 +179,7 @@ func computePayloadHash(version Version, headerHash headerHash, nonce Nonce, cip
 	switch version.Major {
 	case 1:
 	// Nothing to do.
-	case 2:
+	case 2, 3:
 		var isFinalByte byte
 		if isFinal {
 			isFinalByte = 1
@@ -221,6 +231,18 @@ func CheckKnownMajorVersion(version Version) error {
 	return ErrBadVersion{version}
 }
 
+// CheckKnownOrExperimentalMajorVersion is CheckKnownMajorVersion, but
+// also accepts the major versions of the ExperimentalVersions, for
+// opening messages like V3 ones.
+func CheckKnownOrExperimentalMajorVersion(version Version) error {
+	for _, experimentalVersion := range ExperimentalVersions() {
+		if version.Major == experimentalVersion.Major {
+			return nil
+		}
+	}
+	return CheckKnownMajorVersion(version)
+}
+
 // SingleVersionValidator returns a VersionValidator that returns nil
 // if its given version is equal to desiredVersion.
 func SingleVersionValidator(desiredVersion Version) VersionValidator {
@@ -243,7 +265,7 @@ func checkChunkState(version Version, chunkLen int, blockIndex uint64, isFinal b
 			panic(fmt.Sprintf("chunkLen=%d and isFinal=%t", chunkLen, isFinal))
 		}
 
-	case 2:
+	case 2, 3:
 		// TODO: Ideally, we'd have tests exercising this case.
 		if (chunkLen == 0) && (blockIndex != 0 || !isFinal) {
 			return ErrUnexpectedEmptyBlock
@@ -34,6 +34,17 @@ const MessageTypeDetachedSignature MessageType = 2
 // signcrypted message.
 const MessageTypeSigncryption MessageType = 3
 
+// MessageTypePublicKey, MessageTypeSecretKey, MessageTypeKeyring and
+// MessageTypeKeyBundle describe exported keys. They aren't packet types,
+// and never appear in message headers, but they tag serialized keys, and
+// pick the frame for armored ones.
+const (
+	MessageTypePublicKey MessageType = 16
+	MessageTypeSecretKey MessageType = 17
+	MessageTypeKeyring   MessageType = 18
+	MessageTypeKeyBundle MessageType = 19
+)
+
 // Version1 returns the Version for Saltpack V1.
 func Version1() Version {
 	return Version{Major: 1, Minor: 0}
@@ -50,11 +61,28 @@ func CurrentVersion() Version {
 	return Version2()
 }
 
+// Version3 returns the Version for the experimental Saltpack V3. It's
+// V2, except that each receiver's payload key box is sealed under a
+// hybrid of X25519 and ML-KEM-768, so that recording messages now and
+// breaking X25519 later isn't enough to read them. Its receivers must be
+// HybridBoxPublicKeys. V3 may change incompatibly, so it's not one of the
+// KnownVersions; it has to be asked for when opening a message, with a
+// VersionValidator like CheckKnownOrExperimentalMajorVersion.
+func Version3() Version {
+	return Version{Major: 3, Minor: 0}
+}
+
 // KnownVersions returns all known Saltpack versions.
 func KnownVersions() []Version {
 	return []Version{Version1(), Version2()}
 }
 
+// ExperimentalVersions returns the Saltpack versions that can be used,
+// but whose format isn't settled yet.
+func ExperimentalVersions() []Version {
+	return []Version{Version3()}
+}
+
 // encryptionBlockSize is by default 1MB and can't currently be tweaked.
 const encryptionBlockSize int = 1048576
 
@@ -67,6 +95,19 @@ const SignedArmorString = "SIGNED MESSAGE"
 // DetachedSignatureArmorString is included in armor headers for detached signatures.
 const DetachedSignatureArmorString = "DETACHED SIGNATURE"
 
+// PublicKeyArmorString is included in armor headers for exported public keys.
+const PublicKeyArmorString = "PUBLIC KEY"
+
+// SecretKeyArmorString is included in armor headers for exported secret keys.
+const SecretKeyArmorString = "SECRET KEY"
+
+// KeyringArmorString is included in armor headers for exported keyrings.
+const KeyringArmorString = "SECRET KEYRING"
+
+// KeyBundleArmorString is included in armor headers for signed key
+// bundles.
+const KeyBundleArmorString = "KEY BUNDLE"
+
 // FormatName is the publicly advertised name of the format, used in
 // the header of the message and also in Nonce creation.
 const FormatName = "saltpack"
@@ -82,6 +123,10 @@ const signatureAttachedString = "saltpack attached signature\x00"
 // a detached signature.
 const signatureDetachedString = "saltpack detached signature\x00"
 
+// keyBundleSignatureString is part of the data that is signed in a key
+// bundle.
+const keyBundleSignatureString = "saltpack key bundle signature\x00"
+
 // signatureEncryptedString is part of the data that is signed in
 // a signcryption signature.
 const signatureEncryptedString = "saltpack encrypted signature\x00"
@@ -94,6 +139,11 @@ const signcryptionSymmetricKeyContext = "saltpack signcryption derived symmetric
 // as an HMAC key, to make an opaque identifier
 const signcryptionBoxKeyIdentifierContext = "saltpack signcryption box key identifier"
 
+// hybridPayloadKeyBoxContext gets mixed in with the X25519 and ML-KEM
+// shared secrets as an HMAC key, to make the key for a V3 receiver's
+// payload key box
+const hybridPayloadKeyBoxContext = "saltpack hybrid payload key box"
+
 // We truncate HMAC512 to the same link that NaCl's crypto_auth function does.
 const cryptoAuthBytes = 32
 
@@ -109,6 +159,14 @@ func (m MessageType) String() string {
 		return "an attached signature"
 	case MessageTypeSigncryption:
 		return "a signed and encrypted message"
+	case MessageTypePublicKey:
+		return "a public key"
+	case MessageTypeSecretKey:
+		return "a secret key"
+	case MessageTypeKeyring:
+		return "a keyring"
+	case MessageTypeKeyBundle:
+		return "a key bundle"
 	default:
 		return "an unknown message type"
 	}
@@ -66,6 +66,13 @@ func (ds *decryptStream) getNextChunk() ([]byte, error) {
 	return chunk, nil
 }
 
+func (ds *decryptStream) wipe() {
+	ds.payloadKey.wipe()
+	clear(ds.macKey[:])
+}
+
+var _ secretChunker = (*decryptStream)(nil)
+
 func (ds *decryptStream) readHeader(_ io.Reader) error {
 	// Read the header bytes.
 	headerBytes := []byte{}
@@ -77,7 +84,7 @@ func (ds *decryptStream) readHeader(_ io.Reader) error {
 	ds.headerHash = sha512.Sum512(headerBytes)
 	// Parse the header bytes.
 	var header EncryptionHeader
-	err = decodeFromBytes(&header, headerBytes)
+	err = decodeHeader(headerBytes, &header)
 	if err != nil {
 		return err
 	}
@@ -98,7 +105,7 @@ func readEncryptionBlock(version Version, mps *msgpackStream) (ciphertext []byte
 		}
 
 		return ebV1.PayloadCiphertext, ebV1.HashAuthenticators, len(ebV1.PayloadCiphertext) == secretbox.Overhead, seqno, nil
-	case 2:
+	case 2, 3:
 		var ebV2 encryptionBlockV2
 		seqno, err := mps.Read(&ebV2)
 		if err != nil {
@@ -134,12 +141,21 @@ func (ds *decryptStream) tryVisibleReceivers(hdr *EncryptionHeader, ephemeralKey
 
 	//nolint:gosec // orig is a valid slice index, conversion is safe
 	nonce := nonceForPayloadKeyBox(hdr.Version, uint64(orig))
-	payloadKeySlice, err := sk.Unbox(ephemeralKey, nonce, hdr.Receivers[orig].PayloadKeyBox)
+	var payloadKeySlice []byte
+	var err error
+	if hdr.Version.Major == Version3().Major {
+		x25519Key := derivedEphemeralKeyFromBoxKeys(ephemeralKey, sk)
+		payloadKeySlice, err = openHybridPayloadKey(sk, ephemeralKey, x25519Key, nonce, hdr.Receivers[orig])
+		x25519Key.wipe()
+	} else {
+		payloadKeySlice, err = sk.Unbox(ephemeralKey, nonce, hdr.Receivers[orig].PayloadKeyBox)
+	}
 	if err != nil {
 		return nil, nil, -1, err
 	}
 
 	payloadKey, err := symmetricKeyFromSlice(payloadKeySlice)
+	clear(payloadKeySlice)
 	if err != nil {
 		return nil, nil, -1, err
 	}
@@ -148,36 +164,73 @@ func (ds *decryptStream) tryVisibleReceivers(hdr *EncryptionHeader, ephemeralKey
 }
 
 func (ds *decryptStream) tryHiddenReceivers(hdr *EncryptionHeader, ephemeralKey BoxPublicKey) (BoxSecretKey, *SymmetricKey, int, error) {
-	secretKeys := ds.ring.GetAllBoxSecretKeys()
-
-	for _, r := range hdr.Receivers {
+	var anonReceivers []int
+	for i, r := range hdr.Receivers {
 		if len(r.ReceiverKID) == 0 {
-			ds.mki.NumAnonReceivers++
+			anonReceivers = append(anonReceivers, i)
 		}
 	}
+	ds.mki.NumAnonReceivers = len(anonReceivers)
+	if len(anonReceivers) == 0 {
+		return nil, nil, -1, nil
+	}
 
-	for _, secretKey := range secretKeys {
-
-		shared := secretKey.Precompute(ephemeralKey)
-
-		for i, r := range hdr.Receivers {
-			if len(r.ReceiverKID) == 0 {
-				//nolint:gosec // i is a valid slice index, conversion is safe
-				nonce := nonceForPayloadKeyBox(hdr.Version, uint64(i))
-				payloadKeySlice, err := shared.Unbox(nonce, r.PayloadKeyBox)
-				if err != nil {
-					continue
-				}
-				payloadKey, err := symmetricKeyFromSlice(payloadKeySlice)
-				if err != nil {
-					return nil, nil, -1, err
-				}
-				return secretKey, payloadKey, i, nil
+	secretKeys := candidateBoxSecretKeys(ds.ring, ephemeralKey)
+
+	// Each key that's tried writes only its own entries, so the trials
+	// can run concurrently, if the keys allow it.
+	positions := make([]int, len(secretKeys))
+	payloadKeySlices := make([][]byte, len(secretKeys))
+	found := trialKeys(concurrentSecretKeys(ds.ring), len(secretKeys), func(k int) bool {
+		open, done := payloadKeyTrial(ds.ring, hdr.Version, secretKeys[k], ephemeralKey)
+		defer done()
+		for _, i := range anonReceivers {
+			//nolint:gosec // i is a valid slice index, conversion is safe
+			nonce := nonceForPayloadKeyBox(hdr.Version, uint64(i))
+			payloadKeySlice, err := open(nonce, hdr.Receivers[i])
+			if err != nil {
+				continue
 			}
+			positions[k] = i
+			payloadKeySlices[k] = payloadKeySlice
+			return true
 		}
+		return false
+	})
+	if found < 0 {
+		return nil, nil, -1, nil
 	}
 
-	return nil, nil, -1, nil
+	payloadKey, err := symmetricKeyFromSlice(payloadKeySlices[found])
+	clear(payloadKeySlices[found])
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	return secretKeys[found], payloadKey, positions[found], nil
+}
+
+// payloadKeyTrial returns a function that tries to open a receiver's
+// payload key box with secretKey, for trial decryption, and a function to
+// call when done with it.
+func payloadKeyTrial(ring Keyring, version Version, secretKey BoxSecretKey, ephemeralKey BoxPublicKey) (open func(Nonce, receiverKeys) ([]byte, error), done func()) {
+	if version.Major != Version3().Major {
+		shared := precomputeForTrial(ring, secretKey, ephemeralKey)
+		return func(nonce Nonce, r receiverKeys) ([]byte, error) {
+			return shared.Unbox(nonce, r.PayloadKeyBox)
+		}, func() {}
+	}
+
+	// Don't bother with the Diffie-Hellman for keys that can't be V3
+	// receivers.
+	if _, ok := secretKey.(HybridBoxSecretKey); !ok {
+		return func(Nonce, receiverKeys) ([]byte, error) {
+			return nil, ErrNotHybridKey
+		}, func() {}
+	}
+	x25519Key := derivedKeyForTrial(ring, secretKey, ephemeralKey)
+	return func(nonce Nonce, r receiverKeys) ([]byte, error) {
+		return openHybridPayloadKey(secretKey, ephemeralKey, x25519Key, nonce, r)
+	}, func() { wipeTrialKeys(ring, x25519Key) }
 }
 
 func (ds *decryptStream) processHeader(hdr *EncryptionHeader) error {
@@ -250,12 +303,12 @@ func computeMACKeyReceiver(version Version, index uint64, secret BoxSecretKey, p
 	case 1:
 		nonce := nonceForMACKeyBoxV1(headerHash)
 		return computeMACKeySingle(secret, public, nonce)
-	case 2:
+	case 2, 3:
 		nonce := nonceForMACKeyBoxV2(headerHash, false, index)
 		mac := computeMACKeySingle(secret, public, nonce)
 		eNonce := nonceForMACKeyBoxV2(headerHash, true, index)
 		eMAC := computeMACKeySingle(secret, ePublic, eNonce)
-		return sum512Truncate256(append(mac[:], eMAC[:]...))
+		return combineMACKeys(mac, eMAC)
 	default:
 		panic(ErrBadVersion{version})
 	}
@@ -308,6 +361,7 @@ func NewDecryptStream(versionValidator VersionValidator, r io.Reader, keyring Ke
 
 	err = ds.readHeader(r)
 	if err != nil {
+		ds.wipe()
 		return &ds.mki, nil, err
 	}
 
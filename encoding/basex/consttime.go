@@ -0,0 +1,187 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basex
+
+import (
+	"math/bits"
+)
+
+// ConstantTime returns a copy of enc whose block arithmetic and alphabet
+// lookups take time independent of the data, which makes it suitable for
+// encoding secret keys and other secret material. Only the length of the
+// input, and the positions of skipped characters, affect timing.
+//
+// The constant-time decoder is stricter than the regular one: a block
+// whose value doesn't fit in its decoded length, which no encoder
+// produces, is rejected with a CorruptInputError rather than truncated.
+//
+// ConstantTime panics if enc's blocks are too big for fixed-width
+// arithmetic. That's never the case for the standard encodings.
+func (enc *Encoding) ConstantTime() *Encoding {
+	if enc.fixed == nil {
+		panic("basex: block length too big for a constant-time encoding")
+	}
+	ret := *enc
+	ret.constantTime = true
+	return &ret
+}
+
+// IsConstantTime returns true if enc was made by ConstantTime.
+func (enc *Encoding) IsConstantTime() bool {
+	return enc.constantTime
+}
+
+// ctMask returns all ones if b is 1, and all zeros if b is 0.
+func ctMask(b uint64) uint64 {
+	return -b
+}
+
+// ctEq returns 1 if x == y, and 0 otherwise.
+func ctEq(x, y uint64) uint64 {
+	// x^y is zero iff x == y, and then so is the top bit of (x^y) | -(x^y).
+	d := x ^ y
+	return ((d | -d) >> 63) ^ 1
+}
+
+// ctDiv64 returns the quotient and remainder of (hi, lo) divided by d,
+// which must be greater than hi. Unlike bits.Div64, whose running time
+// depends on its inputs on most hardware, it does plain shift-and-subtract
+// long division, with no data-dependent branches.
+func ctDiv64(hi, lo, d uint64) (q, r uint64) {
+	r = hi
+	for i := 63; i >= 0; i-- {
+		// If the top bit of r is set, the shifted remainder has 65 bits,
+		// which is certainly at least d. Wrapping subtraction still gets
+		// the right answer, since the true remainder is less than 2d.
+		top := r >> 63
+		r = r<<1 | (lo>>uint(i))&1
+		diff, borrow := bits.Sub64(r, d, 0)
+		take := top | (borrow ^ 1)
+		r = r&^ctMask(take) | diff&ctMask(take)
+		q |= take << uint(i)
+	}
+	return q, r
+}
+
+// ctDivmod sets n to n / d and returns n % d, in constant time.
+func (n *fixedNum) ctDivmod(words int, d uint64) uint64 {
+	var r uint64
+	for i := words - 1; i >= 0; i-- {
+		n[i], r = ctDiv64(r, n[i], d)
+	}
+	return r
+}
+
+// ctEncodeDigit returns the character for digit d, scanning the whole
+// alphabet rather than indexing it.
+func (enc *Encoding) ctEncodeDigit(d uint64) byte {
+	var ret uint64
+	for i, c := range enc.encode {
+		ret |= uint64(c) & ctMask(ctEq(uint64(i), d))
+	}
+	return byte(ret)
+}
+
+// ctDecodeDigit returns the digit for character b, and 1 if b is in the
+// alphabet (or 0 otherwise), scanning the whole alphabet rather than
+// looking b up in decodeMap.
+func (enc *Encoding) ctDecodeDigit(b byte) (d uint64, ok uint64) {
+	for i, c := range enc.encode {
+		eq := ctEq(uint64(c), uint64(b))
+		d |= uint64(i) & ctMask(eq)
+		ok |= eq
+	}
+	return d, ok
+}
+
+// ctIsSkipByte returns true if b is one of the skip bytes. The skip bytes
+// are whitespace and the like, so whether a byte is one of them isn't a
+// secret, but we check in constant time, so that the secret bytes
+// around them don't leak either.
+func (enc *Encoding) ctIsSkipByte(b byte) bool {
+	var ok uint64
+	for i := 0; i < len(enc.skipBytes); i++ {
+		ok |= ctEq(uint64(enc.skipBytes[i]), uint64(b))
+	}
+	return ok == 1
+}
+
+// ctGetByteType is getByteType for constant-time encodings. An invalid
+// byte makes the whole decode fail, so we don't mind branching on it.
+func (enc *Encoding) ctGetByteType(b byte) byteType {
+	if _, ok := enc.ctDecodeDigit(b); ok == 1 {
+		return normalByteType
+	}
+	if enc.ctIsSkipByte(b) {
+		return skipByteType
+	}
+	return invalidByteType
+}
+
+// encodeBlockConstantTime is encodeBlock for constant-time encodings.
+func (enc *Encoding) encodeBlockConstantTime(dst, src []byte) {
+	c := enc.fixed
+	var n fixedNum
+	n.load(src)
+	p := enc.EncodedLen(len(src)) - 1
+	for p >= 0 {
+		r := n.ctDivmod(c.words, c.pow[c.chunkDigits])
+		for i := 0; i < c.chunkDigits && p >= 0; i++ {
+			var d uint64
+			r, d = ctDiv64(0, r, c.base)
+			dst[p] = enc.ctEncodeDigit(d)
+			p--
+		}
+	}
+}
+
+// decodeBlockConstantTime is decodeBlock for constant-time encodings.
+func (enc *Encoding) decodeBlockConstantTime(dst []byte, src []byte, baseOffset int) (int, int, error) {
+	si := 0 // source index
+	numGoodChars := 0
+	var acc fixedAccumulator
+
+	for i, b := range src {
+		d, ok := enc.ctDecodeDigit(b)
+		si++
+
+		if ok == 0 {
+			if enc.ctIsSkipByte(b) {
+				continue
+			}
+			return 0, 0, CorruptInputError(i + baseOffset)
+		}
+
+		numGoodChars++
+		enc.fixed.addDigit(&acc, d)
+
+		if numGoodChars == enc.baseXBlockLen {
+			break
+		}
+	}
+
+	if !enc.IsValidEncodingLength(numGoodChars) {
+		return 0, 0, ErrInvalidEncodingLength
+	}
+
+	paddedLen := enc.DecodedLen(numGoodChars)
+	if acc.nDigits > 0 {
+		acc.n.mulAdd(enc.fixed.words, enc.fixed.pow[acc.nDigits], acc.chunk)
+	}
+
+	// Write out the low paddedLen bytes, and check that the rest are zero.
+	var overflow uint64
+	for i := 0; i < enc.fixed.words*8; i++ {
+		b := byte(acc.n[i/8] >> (8 * (i % 8)))
+		if i < paddedLen {
+			dst[paddedLen-1-i] = b
+		} else {
+			overflow |= uint64(b)
+		}
+	}
+	if overflow != 0 {
+		return 0, 0, CorruptInputError(baseOffset)
+	}
+	return paddedLen, si, nil
+}
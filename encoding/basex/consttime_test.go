@@ -0,0 +1,115 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/bits"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCtDiv64(t *testing.T) {
+	buf := randomBytes(t, 24*1000)
+	for i := 0; i < len(buf); i += 24 {
+		hi := binary.BigEndian.Uint64(buf[i:])
+		lo := binary.BigEndian.Uint64(buf[i+8:])
+		d := binary.BigEndian.Uint64(buf[i+16:])
+		if i%48 == 0 {
+			// Small divisors, like the ones we actually use.
+			d = d%1000 + 1
+		}
+		if d == 0 {
+			d = 1
+		}
+		hi %= d
+		q, r := ctDiv64(hi, lo, d)
+		q2, r2 := bits.Div64(hi, lo, d)
+		require.Equal(t, q2, q)
+		require.Equal(t, r2, r)
+	}
+	q, r := ctDiv64(^uint64(0)-1, ^uint64(0), ^uint64(0))
+	require.Equal(t, ^uint64(0), q)
+	require.Equal(t, ^uint64(0)-1, r)
+}
+
+func TestConstantTimeMatches(t *testing.T) {
+	for _, enc := range fixedTestEncodings {
+		ct := enc.ConstantTime()
+		require.True(t, ct.IsConstantTime())
+		require.False(t, enc.IsConstantTime())
+		for n := 0; n <= 3*enc.base256BlockLen; n++ {
+			for _, src := range [][]byte{
+				make([]byte, n),
+				bytes.Repeat([]byte{0xff}, n),
+				randomBytes(t, n),
+			} {
+				s := ct.EncodeToString(src)
+				require.Equal(t, enc.EncodeToString(src), s)
+				dec, err := ct.DecodeString(s)
+				require.NoError(t, err)
+				require.Equal(t, src, dec)
+			}
+		}
+	}
+}
+
+func TestConstantTimeBadInput(t *testing.T) {
+	ct := Base62StdEncoding.ConstantTime()
+
+	// Skip bytes are still skipped, and everything else is corrupt.
+	src := randomBytes(t, 100)
+	s := ct.EncodeToString(src)
+	dec, err := ct.DecodeString(s[:10] + "\n  " + s[10:])
+	require.NoError(t, err)
+	require.Equal(t, src, dec)
+	_, err = ct.DecodeString(s[:10] + "!" + s[10:])
+	require.Equal(t, CorruptInputError(10), err)
+	require.False(t, ct.IsValidByte('!'))
+	require.True(t, ct.IsValidByte('\n'))
+	require.True(t, ct.IsValidByte('z'))
+
+	// A block that's too big for 32 bytes is rejected, in the second
+	// block here.
+	_, err = ct.DecodeString(s[:43] + string(bytes.Repeat([]byte{'z'}, 43)))
+	require.Equal(t, CorruptInputError(43), err)
+
+	_, err = ct.DecodeString(s[:44])
+	require.Equal(t, ErrInvalidEncodingLength, err)
+}
+
+func TestConstantTimeStream(t *testing.T) {
+	ct := Base62StdEncoding.ConstantTime()
+	src := randomBytes(t, 10000)
+	var buf bytes.Buffer
+	w := NewEncoder(ct, &buf)
+	_, err := w.Write(src)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, Base62StdEncoding.EncodeToString(src), buf.String())
+
+	dec, err := io.ReadAll(NewDecoder(ct, &buf))
+	require.NoError(t, err)
+	require.Equal(t, src, dec)
+}
+
+func TestConstantTimeTooBig(t *testing.T) {
+	require.Panics(t, func() {
+		NewEncoding(base62EncodeStd, 512, "").ConstantTime()
+	})
+}
+
+func BenchmarkEncodeBase62ConstantTime(b *testing.B) {
+	ct := Base62StdEncoding.ConstantTime()
+	src := randomBytes(b, 64*1024)
+	dst := make([]byte, ct.EncodedLen(len(src)))
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ct.Encode(dst, src)
+	}
+}
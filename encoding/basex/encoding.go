@@ -4,18 +4,18 @@
 package basex
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"math"
 	"math/big"
+	"slices"
 )
 
 // Encoding is a radix X encoding/decoding scheme, defined by X-length
 // character alphabet.
 type Encoding struct {
 	encode          []byte
-	decodeMap       [256](*big.Int)
+	decodeMap       [256]int16 // -1 for bytes that aren't in the alphabet
 	skipMap         [256]bool
 	base256BlockLen int
 	baseXBlockLen   int
@@ -23,6 +23,13 @@ type Encoding struct {
 	logOfBase       float64
 	baseBig         *big.Int
 	skipBytes       string
+
+	// fixed is set when a block fits in fixed-width arithmetic, in which
+	// case we don't need math/big at all.
+	fixed *fixedCodec
+
+	// constantTime is set on the copies that ConstantTime makes.
+	constantTime bool
 }
 
 // NewEncoding returns a new Encoding defined by the given alphabet,
@@ -58,9 +65,13 @@ func NewEncoding(encoder string, base256BlockLen int, skipBytes string) *Encodin
 	for _, c := range skipBytes {
 		e.skipMap[c] = true
 	}
+	for i := range e.decodeMap {
+		e.decodeMap[i] = -1
+	}
 	for i := 0; i < len(encoder); i++ {
-		e.decodeMap[encoder[i]] = big.NewInt(int64(i))
+		e.decodeMap[encoder[i]] = int16(i)
 	}
+	e.fixed = newFixedCodec(base, baseXBlockLen)
 	return e
 }
 
@@ -88,6 +99,15 @@ func (enc *Encoding) Encode(dst, src []byte) {
 	}
 }
 
+// AppendEncode appends the baseX encoding of src to dst and returns the
+// extended buffer.
+func (enc *Encoding) AppendEncode(dst, src []byte) []byte {
+	n := enc.EncodedLen(len(src))
+	dst = slices.Grow(dst, n)
+	enc.Encode(dst[len(dst):len(dst)+n], src)
+	return dst[:len(dst)+n]
+}
+
 type byteType int
 
 const (
@@ -97,7 +117,10 @@ const (
 )
 
 func (enc *Encoding) getByteType(b byte) byteType {
-	if enc.decodeMap[b] != nil {
+	if enc.constantTime {
+		return enc.ctGetByteType(b)
+	}
+	if enc.decodeMap[b] >= 0 {
 		return normalByteType
 	}
 	if enc.skipMap[b] {
@@ -114,7 +137,10 @@ func (enc *Encoding) hasSkipBytes() bool {
 // decoding. Can be either from the main alphabet or the skip
 // alphabet to be considered valid.
 func (enc *Encoding) IsValidByte(b byte) bool {
-	return enc.decodeMap[b] != nil || enc.skipMap[b]
+	if enc.constantTime {
+		return enc.ctGetByteType(b) != invalidByteType
+	}
+	return enc.decodeMap[b] >= 0 || enc.skipMap[b]
 }
 
 // encodeBlock fills the dst buffer with the encoding of src.
@@ -122,6 +148,19 @@ func (enc *Encoding) IsValidByte(b byte) bool {
 // bounds checks are performed.  In particular, the dst buffer will
 // be zero-padded from right to left in all remaining bytes.
 func (enc *Encoding) encodeBlock(dst, src []byte) {
+	if enc.constantTime {
+		enc.encodeBlockConstantTime(dst, src)
+		return
+	}
+	if enc.fixed != nil {
+		enc.fixed.encodeBlock(dst[:enc.EncodedLen(len(src))], src, enc.encode)
+		return
+	}
+	enc.encodeBlockBig(dst, src)
+}
+
+// encodeBlockBig is encodeBlock for blocks too big for fixedCodec.
+func (enc *Encoding) encodeBlockBig(dst, src []byte) {
 	// Interpret the block as a big-endian number (Go's default)
 	num := new(big.Int).SetBytes(src)
 	rem := new(big.Int)
@@ -167,6 +206,16 @@ func (enc *Encoding) Decode(dst, src []byte) (n int, err error) {
 	return enc.decode(dst, src)
 }
 
+// AppendDecode appends the baseX decoding of src to dst and returns the
+// extended buffer. If src contains invalid baseX data, it returns the
+// bytes successfully decoded along with the error, like Decode.
+func (enc *Encoding) AppendDecode(dst, src []byte) ([]byte, error) {
+	n := enc.DecodedLen(len(src))
+	dst = slices.Grow(dst, n)
+	m, err := enc.Decode(dst[len(dst):len(dst)+n], src)
+	return dst[:len(dst)+m], err
+}
+
 // CorruptInputError is returned when Decode() finds a non-alphabet character
 type CorruptInputError int
 
@@ -179,16 +228,62 @@ func (e CorruptInputError) Error() string {
 var ErrInvalidEncodingLength = errors.New("invalid encoding length; either truncated or has trailing garbage")
 
 func (enc *Encoding) decodeBlock(dst []byte, src []byte, baseOffset int) (int, int, error) {
+	if enc.constantTime {
+		return enc.decodeBlockConstantTime(dst, src, baseOffset)
+	}
+	if enc.fixed != nil {
+		return enc.decodeBlockFixed(dst, src, baseOffset)
+	}
+	return enc.decodeBlockBig(dst, src, baseOffset)
+}
+
+func (enc *Encoding) decodeBlockFixed(dst []byte, src []byte, baseOffset int) (int, int, error) {
+	si := 0 // source index
+	numGoodChars := 0
+	var acc fixedAccumulator
+
+	for i, b := range src {
+		v := enc.decodeMap[b]
+		si++
+
+		if v < 0 {
+			if enc.skipMap[b] {
+				continue
+			}
+			return 0, 0, CorruptInputError(i + baseOffset)
+		}
+
+		numGoodChars++
+		enc.fixed.addDigit(&acc, uint64(v))
+
+		if numGoodChars == enc.baseXBlockLen {
+			break
+		}
+	}
+
+	if !enc.IsValidEncodingLength(numGoodChars) {
+		return 0, 0, ErrInvalidEncodingLength
+	}
+
+	paddedLen := enc.DecodedLen(numGoodChars)
+	var buf [maxFixedWords * 8]byte
+	writeDecodedBlock(dst, enc.fixed.finish(&acc, &buf), paddedLen)
+	return paddedLen, si, nil
+}
+
+// decodeBlockBig is decodeBlock for blocks too big for fixedCodec.
+func (enc *Encoding) decodeBlockBig(dst []byte, src []byte, baseOffset int) (int, int, error) {
 	si := 0 // source index
 	numGoodChars := 0
 	res := new(big.Int)
 	res.SetUint64(0)
+	v := new(big.Int)
 
 	for i, b := range src {
-		v := enc.decodeMap[b]
+		d := enc.decodeMap[b]
 		si++
 
-		if v == nil {
+		if d < 0 {
 			if enc.skipMap[b] {
 				continue
 			}
@@ -197,7 +292,7 @@ func (enc *Encoding) decodeBlock(dst []byte, src []byte, baseOffset int) (int, i
 
 		numGoodChars++
 		res.Mul(res, enc.baseBig)
-		res.Add(res, v)
+		res.Add(res, v.SetInt64(int64(d)))
 
 		if numGoodChars == enc.baseXBlockLen {
 			break
@@ -211,14 +306,21 @@ func (enc *Encoding) decodeBlock(dst []byte, src []byte, baseOffset int) (int, i
 	paddedLen := enc.DecodedLen(numGoodChars)
 
 	// Use big-endian representation (the default with Go's library)
-	raw := res.Bytes()
+	writeDecodedBlock(dst, res.Bytes(), paddedLen)
+	return paddedLen, si, nil
+}
+
+// writeDecodedBlock writes the minimal big-endian representation raw of a
+// decoded block into the first paddedLen bytes of dst, zero-padding on the
+// left. If raw is too long, which only happens on a block that decodes to
+// more than paddedLen bytes, its leading bytes are kept.
+func writeDecodedBlock(dst []byte, raw []byte, paddedLen int) {
 	p := 0
 	if len(raw) < paddedLen {
 		p = paddedLen - len(raw)
-		copy(dst, bytes.Repeat([]byte{0}, p))
+		clear(dst[:p])
 	}
 	copy(dst[p:paddedLen], raw)
-	return paddedLen, si, nil
 }
 
 // EncodedLen returns the length in bytes of the baseX encoding
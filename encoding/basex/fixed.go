@@ -0,0 +1,146 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basex
+
+import (
+	"math"
+	"math/bits"
+)
+
+// maxFixedWords is the most 64-bit words we'll use for a block in
+// fixed-width arithmetic. It comfortably fits the standard encodings:
+// a 43-character base62 block needs 5 words, and a 26-character base58
+// block needs 3.
+const maxFixedWords = 8
+
+// fixedCodec does the block arithmetic of an Encoding with fixed-width
+// numbers, which are arrays of 64-bit words in little-endian order. To
+// cut down on the number of multi-word operations, it moves digits
+// in and out of the number in chunks of chunkDigits digits at a time,
+// which is as many as fit into one word.
+type fixedCodec struct {
+	base        uint64
+	words       int
+	chunkDigits int
+	// pow[i] is base^i, for i from 0 to chunkDigits.
+	pow []uint64
+}
+
+// newFixedCodec returns a fixedCodec for blocks of baseXBlockLen digits
+// in the given base, or nil if they don't fit in maxFixedWords words.
+func newFixedCodec(base int, baseXBlockLen int) *fixedCodec {
+	if base < 2 {
+		return nil
+	}
+	// The largest block is base^baseXBlockLen - 1. Allow a bit of slop
+	// for rounding.
+	nBits := float64(baseXBlockLen)*math.Log2(float64(base)) + 1
+	words := int(nBits)/64 + 1
+	if words > maxFixedWords {
+		return nil
+	}
+	c := &fixedCodec{
+		base:  uint64(base),
+		words: words,
+		pow:   []uint64{1},
+	}
+	for {
+		hi, lo := bits.Mul64(c.pow[len(c.pow)-1], c.base)
+		if hi != 0 {
+			break
+		}
+		c.pow = append(c.pow, lo)
+	}
+	c.chunkDigits = len(c.pow) - 1
+	return c
+}
+
+type fixedNum [maxFixedWords]uint64
+
+// load sets n to the big-endian number in src.
+func (n *fixedNum) load(src []byte) {
+	*n = fixedNum{}
+	for i := range src {
+		b := src[len(src)-1-i]
+		n[i/8] |= uint64(b) << (8 * (i % 8))
+	}
+}
+
+// store writes n in big-endian order into buf, and returns the minimal
+// representation, without leading zeros.
+func (n *fixedNum) store(buf *[maxFixedWords * 8]byte, words int) []byte {
+	for i := 0; i < words*8; i++ {
+		buf[len(buf)-1-i] = byte(n[i/8] >> (8 * (i % 8)))
+	}
+	start := len(buf) - words*8
+	for start < len(buf) && buf[start] == 0 {
+		start++
+	}
+	return buf[start:]
+}
+
+// divmod sets n to n / d and returns n % d.
+func (n *fixedNum) divmod(words int, d uint64) uint64 {
+	var r uint64
+	for i := words - 1; i >= 0; i-- {
+		n[i], r = bits.Div64(r, n[i], d)
+	}
+	return r
+}
+
+// mulAdd sets n to n * m + a, dropping any overflow past words words.
+func (n *fixedNum) mulAdd(words int, m uint64, a uint64) {
+	carry := a
+	for i := range words {
+		hi, lo := bits.Mul64(n[i], m)
+		var c uint64
+		n[i], c = bits.Add64(lo, carry, 0)
+		carry = hi + c
+	}
+}
+
+// encodeBlock writes len(dst) digits of the big-endian number in src into
+// dst, using the given alphabet. Like Encoding.encodeBlock, it zero-pads
+// dst on the left.
+func (c *fixedCodec) encodeBlock(dst, src []byte, alphabet []byte) {
+	var n fixedNum
+	n.load(src)
+	p := len(dst) - 1
+	for p >= 0 {
+		r := n.divmod(c.words, c.pow[c.chunkDigits])
+		for i := 0; i < c.chunkDigits && p >= 0; i++ {
+			dst[p] = alphabet[r%c.base]
+			r /= c.base
+			p--
+		}
+	}
+}
+
+// fixedAccumulator collects the digits of a block being decoded. Digits
+// are gathered in chunk, and only folded into the number when the chunk
+// fills up.
+type fixedAccumulator struct {
+	n       fixedNum
+	chunk   uint64
+	nDigits int
+}
+
+func (c *fixedCodec) addDigit(acc *fixedAccumulator, d uint64) {
+	acc.chunk = acc.chunk*c.base + d
+	acc.nDigits++
+	if acc.nDigits == c.chunkDigits {
+		acc.n.mulAdd(c.words, c.pow[c.chunkDigits], acc.chunk)
+		acc.chunk = 0
+		acc.nDigits = 0
+	}
+}
+
+// finish folds in the last partial chunk and returns the minimal
+// big-endian representation of the decoded number, using buf for storage.
+func (c *fixedCodec) finish(acc *fixedAccumulator, buf *[maxFixedWords * 8]byte) []byte {
+	if acc.nDigits > 0 {
+		acc.n.mulAdd(c.words, c.pow[acc.nDigits], acc.chunk)
+	}
+	return acc.n.store(buf, c.words)
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package basex
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var fixedTestEncodings = []*Encoding{
+	Base58StdEncoding,
+	Base58StdEncodingStrict,
+	Base62StdEncoding,
+	Base62StdEncodingStrict,
+}
+
+func TestFixedCodecUsed(t *testing.T) {
+	for _, enc := range fixedTestEncodings {
+		require.NotNil(t, enc.fixed)
+	}
+	require.Equal(t, 5, Base62StdEncoding.fixed.words)
+	require.Equal(t, 3, Base58StdEncoding.fixed.words)
+
+	// Something with enormous blocks falls back to math/big.
+	require.Nil(t, NewEncoding(base62EncodeStd, 512, "").fixed)
+}
+
+// TestFixedMatchesBig checks the fixed-width block arithmetic against the
+// math/big implementation, for every length of block.
+func TestFixedMatchesBig(t *testing.T) {
+	for _, enc := range fixedTestEncodings {
+		for n := 0; n <= enc.base256BlockLen; n++ {
+			for _, src := range [][]byte{
+				make([]byte, n),
+				bytes.Repeat([]byte{0xff}, n),
+				randomBytes(t, n),
+			} {
+				encLen := enc.EncodedLen(n)
+				fixed := make([]byte, encLen)
+				enc.encodeBlock(fixed, src)
+				big := make([]byte, encLen)
+				enc.encodeBlockBig(big, src)
+				require.Equal(t, big, fixed)
+
+				if !enc.IsValidEncodingLength(encLen) {
+					continue
+				}
+				decFixed := make([]byte, n)
+				nf, sf, err := enc.decodeBlockFixed(decFixed, fixed, 0)
+				require.NoError(t, err)
+				decBig := make([]byte, n)
+				nb, sb, err := enc.decodeBlockBig(decBig, fixed, 0)
+				require.NoError(t, err)
+				require.Equal(t, nb, nf)
+				require.Equal(t, sb, sf)
+				require.Equal(t, decBig, decFixed)
+				require.Equal(t, src, decFixed)
+			}
+		}
+	}
+}
+
+// TestFixedOverflowMatchesBig checks that a full block of the largest
+// digit, which doesn't fit in a decoded block, decodes the same way it
+// always has.
+func TestFixedOverflowMatchesBig(t *testing.T) {
+	for _, enc := range fixedTestEncodings {
+		src := bytes.Repeat(enc.encode[enc.base-1:], enc.baseXBlockLen)
+		decFixed := make([]byte, enc.base256BlockLen)
+		_, _, err := enc.decodeBlockFixed(decFixed, src, 0)
+		require.NoError(t, err)
+		decBig := make([]byte, enc.base256BlockLen)
+		_, _, err = enc.decodeBlockBig(decBig, src, 0)
+		require.NoError(t, err)
+		require.Equal(t, decBig, decFixed)
+	}
+}
+
+func randomBytes(t testing.TB, n int) []byte {
+	ret := make([]byte, n)
+	_, err := rand.Read(ret)
+	require.NoError(t, err)
+	return ret
+}
+
+func TestAppendEncodeDecode(t *testing.T) {
+	src := randomBytes(t, 1000)
+	prefix := []byte("prefix")
+	encoded := Base62StdEncoding.AppendEncode(append([]byte{}, prefix...), src)
+	require.Equal(t, prefix, encoded[:len(prefix)])
+	require.Equal(t, Base62StdEncoding.EncodeToString(src), string(encoded[len(prefix):]))
+
+	decoded, err := Base62StdEncoding.AppendDecode(append([]byte{}, prefix...), encoded[len(prefix):])
+	require.NoError(t, err)
+	require.Equal(t, prefix, decoded[:len(prefix)])
+	require.Equal(t, src, decoded[len(prefix):])
+
+	_, err = Base62StdEncoding.AppendDecode(nil, []byte("!!"))
+	require.IsType(t, CorruptInputError(0), err)
+}
+
+func TestStreamingAllocations(t *testing.T) {
+	src := randomBytes(t, 32*100)
+	encoded := Base62StdEncoding.EncodeToString(src)
+
+	enc := NewEncoder(Base62StdEncoding, io.Discard)
+	allocs := testing.AllocsPerRun(100, func() {
+		_, err := enc.Write(src)
+		require.NoError(t, err)
+	})
+	require.Zero(t, allocs)
+
+	r := strings.NewReader(encoded)
+	dec := NewDecoder(Base62StdEncoding, r)
+	buf := make([]byte, len(src))
+	allocs = testing.AllocsPerRun(100, func() {
+		r.Reset(encoded)
+		_, err := io.ReadFull(dec, buf)
+		require.NoError(t, err)
+	})
+	require.Zero(t, allocs)
+	require.Equal(t, src, buf)
+}
+
+func BenchmarkEncodeBase62(b *testing.B) {
+	src := randomBytes(b, 1024*1024)
+	dst := make([]byte, Base62StdEncoding.EncodedLen(len(src)))
+	b.SetBytes(int64(len(src)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Base62StdEncoding.Encode(dst, src)
+	}
+}
@@ -12,9 +12,7 @@ import (
 
 // EncodeToString returns the baseX encoding of src.
 func (enc *Encoding) EncodeToString(src []byte) string {
-	buf := make([]byte, enc.EncodedLen(len(src)))
-	enc.Encode(buf, src)
-	return string(buf)
+	return string(enc.AppendEncode(nil, src))
 }
 
 type encoder struct {
@@ -91,7 +89,8 @@ func (e *encoder) Close() error {
 // the returned writer will be encoded using enc and then written to w.
 // Encodings operate in enc.baseXBlockLen-byte blocks; when finished
 // writing, the caller must Close the returned encoder to flush any
-// partially written blocks.
+// partially written blocks. Buffers are allocated up front, so for
+// the standard encodings, Write and Close don't allocate.
 func NewEncoder(enc *Encoding, w io.Writer) io.WriteCloser {
 	return &encoder{
 		enc: enc,
@@ -104,9 +103,7 @@ func NewEncoder(enc *Encoding, w io.Writer) io.WriteCloser {
 // DecodeString returns the bytes represented by the baseX string s.
 // It uses the liberal decoding strategy, ignoring any non-baseX-characters
 func (enc *Encoding) DecodeString(s string) ([]byte, error) {
-	dbuf := make([]byte, enc.DecodedLen(len(s)))
-	n, err := enc.Decode(dbuf, []byte(s))
-	return dbuf[:n], err
+	return enc.AppendDecode(make([]byte, 0, enc.DecodedLen(len(s))), []byte(s))
 }
 
 type decoder struct {
@@ -229,7 +226,9 @@ func (r *filteringReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
-// NewDecoder constructs a new baseX stream decoder.
+// NewDecoder constructs a new baseX stream decoder. As with NewEncoder,
+// buffers are allocated up front, so for the standard encodings, Read
+// doesn't allocate.
 func NewDecoder(enc *Encoding, r io.Reader) io.Reader {
 	return newDecoder(enc, r)
 }
@@ -0,0 +1,58 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+// Package wordlist renders bytes as words that are easy to read aloud and
+// hard to mishear, using the English word list from BIP 39. Each word is
+// unique in its first four letters.
+package wordlist
+
+import (
+	_ "embed"
+	"strings"
+)
+
+// BitsPerWord is the number of bits that each word encodes.
+const BitsPerWord = 11
+
+//go:embed english.txt
+var english string
+
+// English is the BIP 39 English word list, in order. It has
+// 1 << BitsPerWord words.
+var English = strings.Split(strings.TrimSuffix(english, "\n"), "\n")
+
+var index = func() map[string]int {
+	m := make(map[string]int, len(English))
+	for i, w := range English {
+		m[w] = i
+	}
+	return m
+}()
+
+// Index returns the position of word in English, ignoring case and
+// surrounding space, and whether it's there at all.
+func Index(word string) (int, bool) {
+	i, ok := index[strings.ToLower(strings.TrimSpace(word))]
+	return i, ok
+}
+
+// Encode splits b into groups of BitsPerWord bits, most significant bit
+// first, and returns the word for each. If the bits of b don't divide
+// evenly into words, the last word is padded with zero bits.
+func Encode(b []byte) []string {
+	ret := make([]string, 0, (len(b)*8+BitsPerWord-1)/BitsPerWord)
+	var acc uint32
+	var bits uint
+	for _, c := range b {
+		acc = acc<<8 | uint32(c)
+		bits += 8
+		if bits >= BitsPerWord {
+			bits -= BitsPerWord
+			ret = append(ret, English[acc>>bits&(1<<BitsPerWord-1)])
+		}
+	}
+	if bits > 0 {
+		ret = append(ret, English[acc<<(BitsPerWord-bits)&(1<<BitsPerWord-1)])
+	}
+	return ret
+}
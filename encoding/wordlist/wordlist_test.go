@@ -0,0 +1,34 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package wordlist
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnglish(t *testing.T) {
+	// The SHA-256 of english.txt in the BIP 39 repository.
+	sum := sha256.Sum256([]byte(english))
+	require.Equal(t, "2f5eed53a4727b4bf8880d8f3f199efc90e58503646d9ff8eff3a2ed3b24dbda", hex.EncodeToString(sum[:]))
+	require.Len(t, English, 1<<BitsPerWord)
+
+	i, ok := Index(" Zoo ")
+	require.True(t, ok)
+	require.Equal(t, len(English)-1, i)
+	_, ok = Index("zzz")
+	require.False(t, ok)
+}
+
+func TestEncode(t *testing.T) {
+	require.Empty(t, Encode(nil))
+	// 0x00 0x20 is 00000000001 00000, so "ability" then "abandon".
+	require.Equal(t, []string{"ability", "abandon"}, Encode([]byte{0x00, 0x20}))
+	// The last ten bits are padded with a zero bit.
+	require.Equal(t, []string{"zoo", "zoo", "zone"}, Encode([]byte{0xff, 0xff, 0xff, 0xff}))
+	require.Len(t, Encode(make([]byte, 16)), 12)
+}
@@ -33,21 +33,33 @@ func (es *encryptStream) Write(plaintext []byte) (int, error) {
 		return 0, es.err
 	}
 
-	var ret int
-	if ret, es.err = es.buffer.Write(plaintext); es.err != nil {
-		return 0, es.err
-	}
+	writeSecret(&es.buffer, plaintext)
 
 	// If es.buffer.Len() == encryptionBlockSize, we don't want to
 	// write it out just yet, since for V2 we need to be sure this
 	// isn't the last block.
 	for es.buffer.Len() > encryptionBlockSize {
-		es.err = es.encryptBlock(false)
-		if es.err != nil {
-			return 0, es.err
+		if err := es.encryptBlock(false); err != nil {
+			return 0, es.fail(err)
 		}
 	}
-	return ret, nil
+	return len(plaintext), nil
+}
+
+// fail makes err the stream's terminal error, and wipes its secrets,
+// since it won't be used again.
+func (es *encryptStream) fail(err error) error {
+	es.err = err
+	es.wipe()
+	return err
+}
+
+func (es *encryptStream) wipe() {
+	es.payloadKey.wipe()
+	for i := range es.macKeys {
+		clear(es.macKeys[i][:])
+	}
+	wipeBuffer(&es.buffer)
 }
 
 func makeEncryptionBlock(version Version, ciphertext []byte, authenticators []payloadAuthenticator, isFinal bool) any {
@@ -58,7 +70,7 @@ func makeEncryptionBlock(version Version, ciphertext []byte, authenticators []pa
 	switch version {
 	case Version1():
 		return ebV1
-	case Version2():
+	case Version2(), Version3():
 		return encryptionBlockV2{
 			encryptionBlockV1: ebV1,
 			IsFinal:           isFinal,
@@ -92,7 +104,7 @@ func checkEncryptBlockRead(version Version, isFinal bool, blockSize, plaintextLe
 			die()
 		}
 
-	case Version2():
+	case Version2(), Version3():
 		// If isFinal, then plaintextLen can be any number,
 		// buf bufLen must be 0.
 		if isFinal && (bufLen != 0) {
@@ -137,8 +149,10 @@ func (es *encryptStream) encryptBlock(isFinal bool) error {
 	return nil
 }
 
+// checkKnownVersion checks that version is one that can be written,
+// which includes the experimental ones.
 func checkKnownVersion(version Version) error {
-	if slices.Contains(KnownVersions(), version) {
+	if slices.Contains(KnownVersions(), version) || slices.Contains(ExperimentalVersions(), version) {
 		return nil
 	}
 	return ErrBadVersion{version}
@@ -210,6 +224,7 @@ func (es *encryptStream) init(
 	if err != nil {
 		return err
 	}
+	defer wipeEphemeralKey(ephemeralKey)
 
 	// If we have a nil Sender key, then we really want the ephemeral key
 	// as the main encryption key.
@@ -229,17 +244,18 @@ func (es *encryptStream) init(
 		return err
 	}
 	es.payloadKey = *payloadKey
+	payloadKey.wipe()
 
 	nonce := nonceForSenderKeySecretBox()
 	eh.SenderSecretbox = secretbox.Seal([]byte{}, sender.GetPublicKey().ToKID(), (*[24]byte)(&nonce), (*[32]byte)(&es.payloadKey))
 
 	for i, receiver := range receivers {
-		sharedKey := ephemeralKey.Precompute(receiver)
 		//nolint:gosec // i is a valid slice index, conversion is safe
 		nonce := nonceForPayloadKeyBox(version, uint64(i))
-		payloadKeyBox := sharedKey.Box(nonce, es.payloadKey[:])
-
-		keys := receiverKeys{PayloadKeyBox: payloadKeyBox}
+		keys, err := makeEncryptReceiverKeys(version, ephemeralKey, receiver, nonce, &es.payloadKey)
+		if err != nil {
+			return err
+		}
 
 		// Don't specify the receivers if this public key wants to hide
 		if !receiver.HideIdentity() {
@@ -250,7 +266,7 @@ func (es *encryptStream) init(
 	}
 
 	// Encode the header to bytes, hash it, then double encode it.
-	headerBytes, err := encodeToBytes(eh)
+	headerBytes, err := encodeHeader(&eh)
 	if err != nil {
 		return err
 	}
@@ -269,6 +285,26 @@ func (es *encryptStream) init(
 	return nil
 }
 
+// makeEncryptReceiverKeys seals the payload key for receiver. In V3, it's
+// sealed under a hybrid of the X25519 and ML-KEM shared secrets, rather
+// than boxed with X25519 alone. Only the payload key box is hybrid; the
+// MAC keys, which authenticate the sender, are the same as in V2, since
+// forging them later doesn't help an attacker who recorded the message.
+func makeEncryptReceiverKeys(version Version, ephemeralKey BoxSecretKey, receiver BoxPublicKey, nonce Nonce, payloadKey *SymmetricKey) (receiverKeys, error) {
+	if version.Major != Version3().Major {
+		sharedKey := ephemeralKey.Precompute(receiver)
+		return receiverKeys{PayloadKeyBox: sharedKey.Box(nonce, payloadKey[:])}, nil
+	}
+
+	x25519Key := derivedEphemeralKeyFromBoxKeys(receiver, ephemeralKey)
+	defer x25519Key.wipe()
+	payloadKeyBox, mlkemCiphertext, err := sealHybridPayloadKey(ephemeralKey, receiver, x25519Key, nonce, payloadKey)
+	if err != nil {
+		return receiverKeys{}, err
+	}
+	return receiverKeys{PayloadKeyBox: payloadKeyBox, MLKEMCiphertext: mlkemCiphertext}, nil
+}
+
 func computeMACKeySender(version Version, index uint64, secret, eSecret BoxSecretKey, public BoxPublicKey, headerHash headerHash) macKey {
 	// Switch on the whole version (i.e., not just the major
 	// version) since we're writing.
@@ -276,12 +312,12 @@ func computeMACKeySender(version Version, index uint64, secret, eSecret BoxSecre
 	case Version1():
 		nonce := nonceForMACKeyBoxV1(headerHash)
 		return computeMACKeySingle(secret, public, nonce)
-	case Version2():
+	case Version2(), Version3():
 		nonce := nonceForMACKeyBoxV2(headerHash, false, index)
 		mac := computeMACKeySingle(secret, public, nonce)
 		eNonce := nonceForMACKeyBoxV2(headerHash, true, index)
 		eMAC := computeMACKeySingle(eSecret, public, eNonce)
-		return sum512Truncate256(append(mac[:], eMAC[:]...))
+		return combineMACKeys(mac, eMAC)
 	default:
 		panic(ErrBadVersion{version})
 	}
@@ -298,6 +334,17 @@ func computeMACKeysSender(version Version, sender, ephemeralKey BoxSecretKey, re
 }
 
 func (es *encryptStream) Close() error {
+	if es.err != nil {
+		return es.err
+	}
+	if err := es.writeLastBlocks(); err != nil {
+		return es.fail(err)
+	}
+	_ = es.fail(ErrStreamClosed)
+	return nil
+}
+
+func (es *encryptStream) writeLastBlocks() error {
 	switch es.version {
 	case Version1():
 		if es.buffer.Len() > 0 {
@@ -313,7 +360,7 @@ func (es *encryptStream) Close() error {
 
 		return es.encryptBlock(true)
 
-	case Version2():
+	case Version2(), Version3():
 		err := es.encryptBlock(true)
 		if err != nil {
 			return err
@@ -338,6 +385,7 @@ func newEncryptStream(version Version, ciphertext io.Writer, sender BoxSecretKey
 	}
 	err := es.init(version, sender, receivers, ephemeralKeyCreator, rng)
 	if err != nil {
+		es.wipe()
 		return nil, err
 	}
 	return es, nil
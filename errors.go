@@ -6,6 +6,7 @@ package saltpack
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
@@ -75,6 +76,28 @@ var (
 	// ErrNotASaltpackMessage is returned when the message given as input is not
 	// a valid  saltpack message
 	ErrNotASaltpackMessage = errors.New("not a saltpack message")
+
+	// ErrBadPassphrase is returned when something encrypted under a
+	// passphrase fails to decrypt, either because the passphrase is wrong
+	// or because the ciphertext was corrupted.
+	ErrBadPassphrase = errors.New("bad passphrase, or corrupted ciphertext")
+
+	// ErrStreamClosed is returned when writing to or closing a stream that
+	// has already been closed.
+	ErrStreamClosed = errors.New("stream already closed")
+
+	// ErrBadTypedKID is returned when a typed key ID is malformed, or is
+	// for an unknown type of key.
+	ErrBadTypedKID = errors.New("bad typed key ID")
+
+	// ErrNotHybridKey is returned when a V3 message is sealed for, or
+	// opened with, a box key that has no ML-KEM-768 half.
+	ErrNotHybridKey = errors.New("box key isn't an X25519+ML-KEM-768 hybrid key")
+
+	// ErrBadMLKEMCiphertext is returned when a receiver in a message header
+	// has an ML-KEM ciphertext of the wrong length, or one that its
+	// version doesn't allow.
+	ErrBadMLKEMCiphertext = errors.New("bad ML-KEM ciphertext in header")
 )
 
 // ErrNoSenderKey indicates that on decryption/verification we couldn't find a public key
@@ -83,6 +106,30 @@ type ErrNoSenderKey struct {
 	Sender []byte
 }
 
+// ErrRevokedKey indicates that on verification the sender's public key was
+// found, but it has been revoked. Since we can't tell when a message was
+// signed, a revoked key is never accepted.
+type ErrRevokedKey struct {
+	Sender []byte
+	// RevokedAt is when the key was revoked, if known.
+	RevokedAt time.Time
+	// Reason is why the key was revoked, if known.
+	Reason string
+}
+
+// ErrExpiredKey indicates that on verification the sender's public key was
+// found, but it isn't valid at the current time, either because it has
+// expired or because it isn't valid yet.
+type ErrExpiredKey struct {
+	Sender []byte
+	// NotBefore and NotAfter are the key's validity period. Either one
+	// can be the zero time, meaning there's no bound on that side.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// Now is the time the key was checked at.
+	Now time.Time
+}
+
 // ErrBadTag is generated when a payload hash doesn't match the hash
 // authenticator. It specifies which Packet sequence number the bad packet was
 // in.
@@ -96,6 +143,16 @@ type ErrBadCiphertext packetSeqno
 // unique.
 type ErrRepeatedKey []byte
 
+// ErrMissingArmorPart is produced when reassembling a multi-part armored
+// message that's missing a part. It specifies the (1-based) number of the
+// first missing part.
+type ErrMissingArmorPart int
+
+// ErrDuplicateArmorPart is produced when reassembling a multi-part armored
+// message that has the same part more than once. It specifies the (1-based)
+// number of the repeated part.
+type ErrDuplicateArmorPart int
+
 // ErrWrongMessageType is produced if one packet tag was expected, but a packet
 // of another tag was found.
 type ErrWrongMessageType struct {
@@ -103,6 +160,22 @@ type ErrWrongMessageType struct {
 	Received MessageType
 }
 
+// ErrWrongKeyType is produced if a key of one type was expected, but a
+// key of another type was found, like a signing key where a box key
+// should be.
+type ErrWrongKeyType struct {
+	Wanted   KeyType
+	Received KeyType
+}
+
+// ErrBadSerializedKey is produced when a serialized key or keyring can't
+// be parsed. It says what was wrong with it.
+type ErrBadSerializedKey string
+
+// ErrBadKeyBundle is produced when a key bundle can't be parsed, or is
+// inconsistent. It says what was wrong with it.
+type ErrBadKeyBundle string
+
 // ErrBadVersion is returned if a packet of an unsupported version is found.
 // Current, only Version1 is supported.
 type ErrBadVersion struct {
@@ -112,24 +185,90 @@ type ErrBadVersion struct {
 // ErrBadFrame shows up when the BEGIN or END frames have issues
 type ErrBadFrame struct {
 	msg string
+
+	// Section is the section of the armor whose frame was bad, if known.
+	Section ArmorSection
+	// Position is where the bad frame starts in the input, if known.
+	Position ArmorPosition
+	// Expected and Found are the frame that was wanted and the one that
+	// was actually there, when a frame was present but not the right one.
+	Expected string
+	Found    string
 }
 
 func (e ErrBadFrame) Error() string {
-	return fmt.Sprintf("Error in framing: %s", e.msg)
+	ret := fmt.Sprintf("Error in framing: %s", e.msg)
+	if len(e.Expected) > 0 {
+		ret += fmt.Sprintf(" (expected %q, found %q)", e.Expected, e.Found)
+	}
+	if e.Position.isSet() {
+		ret += fmt.Sprintf(" in %s at %s", e.Section, e.Position)
+	}
+	return ret
+}
+
+// ErrBadArmor wraps an error found while decoding armor with the section
+// of the armor and the position in the input where it was found. The
+// original error, such as a basex.CorruptInputError, ErrOverflow or
+// io.ErrUnexpectedEOF, is available through errors.Is and errors.As.
+// Those errors used to be returned bare, so callers that compare or
+// type-switch on them directly need to use errors.Is or errors.As instead.
+type ErrBadArmor struct {
+	Section  ArmorSection
+	Position ArmorPosition
+	Err      error
+}
+
+func (e ErrBadArmor) Error() string {
+	return fmt.Sprintf("Error in armor %s at %s: %v", e.Section, e.Position, e.Err)
+}
+
+func (e ErrBadArmor) Unwrap() error {
+	return e.Err
 }
 
 func makeErrBadFrame(format string, args ...any) error {
-	return ErrBadFrame{fmt.Sprintf(format, args...)}
+	return ErrBadFrame{msg: fmt.Sprintf(format, args...)}
 }
 
 func (e ErrNoSenderKey) Error() string {
 	return "no sender key found for message"
 }
 
+func (e ErrRevokedKey) Error() string {
+	ret := "sender key was revoked"
+	if !e.RevokedAt.IsZero() {
+		ret += " at " + e.RevokedAt.UTC().Format(time.RFC3339)
+	}
+	if len(e.Reason) > 0 {
+		ret += ": " + e.Reason
+	}
+	return ret
+}
+
+func (e ErrExpiredKey) Error() string {
+	if !e.NotBefore.IsZero() && e.Now.Before(e.NotBefore) {
+		return "sender key isn't valid until " + e.NotBefore.UTC().Format(time.RFC3339)
+	}
+	return "sender key expired at " + e.NotAfter.UTC().Format(time.RFC3339)
+}
+
 func (e ErrWrongMessageType) Error() string {
 	return fmt.Sprintf("Wrong saltpack message type: wanted %s, but got %s instead", e.Wanted, e.Received)
 }
 
+func (e ErrWrongKeyType) Error() string {
+	return fmt.Sprintf("Wrong key type: wanted %s, but got %s instead", e.Wanted, e.Received)
+}
+
+func (e ErrBadSerializedKey) Error() string {
+	return fmt.Sprintf("Bad serialized key: %s", string(e))
+}
+
+func (e ErrBadKeyBundle) Error() string {
+	return fmt.Sprintf("Bad key bundle: %s", string(e))
+}
+
 func (e ErrBadVersion) Error() string {
 	return fmt.Sprintf("Unsupported version (%s)", e.received)
 }
@@ -146,6 +285,14 @@ func (e ErrRepeatedKey) Error() string {
 	return fmt.Sprintf("Repeated recipient key: %x", []byte(e))
 }
 
+func (e ErrMissingArmorPart) Error() string {
+	return fmt.Sprintf("Missing part %d of armored message", int(e))
+}
+
+func (e ErrDuplicateArmorPart) Error() string {
+	return fmt.Sprintf("Part %d of armored message given more than once", int(e))
+}
+
 // ErrInvalidParameter signifies that a function was called with
 // an invalid parameter.
 type ErrInvalidParameter struct {
@@ -0,0 +1,119 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/keybase/saltpack/encoding/wordlist"
+)
+
+const (
+	fingerprintContext  = "saltpack key fingerprint\x00"
+	safetyNumberContext = "saltpack safety number\x00"
+
+	fingerprintLen = 16
+
+	safetyNumberGroups     = 12
+	safetyNumberGroupBytes = 5
+	safetyNumberGroupMod   = 100000
+)
+
+// Fingerprint is a short hash of a public key, for people to compare when
+// they want to make sure that they have the right key. It's the SHA-512
+// of the key's TypedKID, truncated to 128 bits, so a box key and a signing
+// key never have the same fingerprint, even if their bytes are equal.
+type Fingerprint [fingerprintLen]byte
+
+func fingerprint(typ KeyType, kid []byte) Fingerprint {
+	h := sha512.New()
+	h.Write([]byte(fingerprintContext))
+	h.Write(TypedKID(typ, kid))
+	var ret Fingerprint
+	copy(ret[:], h.Sum(nil))
+	return ret
+}
+
+// BoxPublicKeyFingerprint returns the fingerprint of a box public key.
+func BoxPublicKeyFingerprint(k BoxPublicKey) Fingerprint {
+	return fingerprint(KeyTypeCurve25519, k.ToKID())
+}
+
+// SigningPublicKeyFingerprint returns the fingerprint of a signing public
+// key, like the one that Verify or SigncryptOpen returns.
+func SigningPublicKeyFingerprint(k SigningPublicKey) Fingerprint {
+	return fingerprint(KeyTypeEd25519, k.ToKID())
+}
+
+// SenderFingerprint returns the fingerprint of the box key that a message
+// was encrypted by, and false if the sender was anonymous.
+func (mki *MessageKeyInfo) SenderFingerprint() (Fingerprint, bool) {
+	if mki.SenderIsAnon || mki.SenderKey == nil {
+		return Fingerprint{}, false
+	}
+	return BoxPublicKeyFingerprint(mki.SenderKey), true
+}
+
+// Hex returns f in lower-case hex.
+func (f Fingerprint) Hex() string {
+	return hex.EncodeToString(f[:])
+}
+
+// String returns f in hex, in space-separated groups of four digits.
+func (f Fingerprint) String() string {
+	h := f.Hex()
+	groups := make([]string, 0, len(h)/4)
+	for i := 0; i < len(h); i += 4 {
+		groups = append(groups, h[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// Words returns f as twelve words, which are easier to read over the phone
+// than hex. See package wordlist.
+func (f Fingerprint) Words() []string {
+	return wordlist.Encode(f[:])
+}
+
+// SafetyNumber is a number that two people compute from their own and the
+// other's fingerprint, and then compare, for example in person or over the
+// phone, to check that each has the other's right key. Both compute the
+// same number, whichever order the fingerprints are given in.
+type SafetyNumber [safetyNumberGroups]uint32
+
+// NewSafetyNumber returns the safety number for the pair of keys with the
+// given fingerprints.
+func NewSafetyNumber(a, b Fingerprint) SafetyNumber {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	h := sha512.New()
+	h.Write([]byte(safetyNumberContext))
+	h.Write(a[:])
+	h.Write(b[:])
+	sum := h.Sum(nil)
+
+	var ret SafetyNumber
+	for i := range ret {
+		var group [8]byte
+		copy(group[8-safetyNumberGroupBytes:], sum[i*safetyNumberGroupBytes:])
+		//nolint:gosec // the value is below safetyNumberGroupMod, conversion is safe
+		ret[i] = uint32(binary.BigEndian.Uint64(group[:]) % safetyNumberGroupMod)
+	}
+	return ret
+}
+
+// String returns n as twelve space-separated groups of five digits.
+func (n SafetyNumber) String() string {
+	groups := make([]string, len(n))
+	for i, g := range n {
+		groups[i] = fmt.Sprintf("%05d", g)
+	}
+	return strings.Join(groups, " ")
+}
@@ -0,0 +1,56 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprint(t *testing.T) {
+	var raw RawBoxKey
+	raw[0] = 1
+	boxKey := boxPublicKey{key: raw}
+	sigKey := newSigPubKey(raw[:])
+
+	f := BoxPublicKeyFingerprint(boxKey)
+	require.Equal(t, f, BoxPublicKeyFingerprint(boxKey))
+	require.NotEqual(t, f, SigningPublicKeyFingerprint(sigKey))
+
+	require.Len(t, f.Hex(), 32)
+	require.Equal(t, f.Hex(), strings.ReplaceAll(f.String(), " ", ""))
+	require.Regexp(t, regexp.MustCompile(`^([0-9a-f]{4} ){7}[0-9a-f]{4}$`), f.String())
+	require.Len(t, f.Words(), 12)
+
+	g := SigningPublicKeyFingerprint(sigKey)
+	n := NewSafetyNumber(f, g)
+	require.Equal(t, n, NewSafetyNumber(g, f))
+	require.NotEqual(t, n, NewSafetyNumber(f, f))
+	require.Regexp(t, regexp.MustCompile(`^([0-9]{5} ){11}[0-9]{5}$`), n.String())
+}
+
+func TestSenderFingerprint(t *testing.T) {
+	msg := []byte("hello")
+	sender := newBoxKey(t)
+	receivers := []BoxPublicKey{newBoxKey(t).GetPublicKey()}
+	vv := SingleVersionValidator(Version2())
+
+	ciphertext, err := Seal(Version2(), msg, sender, receivers)
+	require.NoError(t, err)
+	mki, _, err := Open(vv, ciphertext, kr)
+	require.NoError(t, err)
+	f, ok := mki.SenderFingerprint()
+	require.True(t, ok)
+	require.Equal(t, BoxPublicKeyFingerprint(sender.GetPublicKey()), f)
+
+	ciphertext, err = Seal(Version2(), msg, nil, receivers)
+	require.NoError(t, err)
+	mki, _, err = Open(vv, ciphertext, kr)
+	require.NoError(t, err)
+	_, ok = mki.SenderFingerprint()
+	require.False(t, ok)
+}
@@ -4,6 +4,7 @@
 package saltpack
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 )
@@ -61,14 +62,29 @@ func getStringForType(typ MessageType) string {
 		return SignedArmorString
 	case MessageTypeDetachedSignature:
 		return DetachedSignatureArmorString
+	case MessageTypePublicKey:
+		return PublicKeyArmorString
+	case MessageTypeSecretKey:
+		return SecretKeyArmorString
+	case MessageTypeKeyring:
+		return KeyringArmorString
+	case MessageTypeKeyBundle:
+		return KeyBundleArmorString
 	default:
 		return ""
 	}
 }
 
+func sectionForMarker(hof headerOrFooterMarker) ArmorSection {
+	if hof == footerMarker {
+		return ArmorSectionFooter
+	}
+	return ArmorSectionHeader
+}
+
 func parseFrame(m string, typ MessageType, hof headerOrFooterMarker) (brand string, err error) {
 	if len(m) > maxFrameLength {
-		err = makeErrBadFrame("Frame is too long")
+		err = ErrBadFrame{msg: "Frame is too long", Section: sectionForMarker(hof)}
 		return
 	}
 
@@ -83,14 +99,30 @@ func parseFrame(m string, typ MessageType, hof headerOrFooterMarker) (brand stri
 		return
 	}
 	v := strings.Split(s, " ")
+
+	// If we find a frame that isn't the one we want, say which one we
+	// wanted, guessing that the second of five words is the brand.
+	var brandGuess string
+	if len(v) == 5 {
+		brandGuess = v[1]
+	}
+	mismatch := func(format string, args ...any) error {
+		return ErrBadFrame{
+			msg:      fmt.Sprintf(format, args...),
+			Section:  sectionForMarker(hof),
+			Expected: makeFrame(hof, typ, brandGuess),
+			Found:    s,
+		}
+	}
+
 	if len(v) != 4 && len(v) != 5 {
-		err = makeErrBadFrame("wrong number of words (%d)", len(v))
+		err = mismatch("wrong number of words (%d)", len(v))
 		return
 	}
 
 	front := shift(&v, 1)
 	if front[0] != string(hof) {
-		err = makeErrBadFrame("Bad prefix: %s (wanted %s)", front[0], string(hof))
+		err = mismatch("Bad prefix: %s (wanted %s)", front[0], string(hof))
 		return
 	}
 
@@ -98,18 +130,18 @@ func parseFrame(m string, typ MessageType, hof headerOrFooterMarker) (brand stri
 	tmp := pop(&v, 2)
 	received := strings.Join(tmp, " ")
 	if received != expected {
-		err = makeErrBadFrame("wanted %q but got %q", expected, received)
+		err = mismatch("wanted %q but got %q", expected, received)
 		return
 	}
 	spfn := pop(&v, 1)
 	if spfn[0] != strings.ToUpper(FormatName) {
-		err = makeErrBadFrame("bad format name (%s)", spfn[0])
+		err = mismatch("bad format name (%s)", spfn[0])
 		return
 	}
 	if len(v) > 0 {
 		brand = v[0]
 		if len(brand) > maxBrandLength {
-			err = makeErrBadFrame("Brand is too long")
+			err = ErrBadFrame{msg: "Brand is too long", Section: sectionForMarker(hof)}
 			return
 		}
 	}
@@ -5,15 +5,17 @@ go 1.24.0
 toolchain go1.25.5
 
 require (
+	filippo.io/edwards25519 v1.1.0
 	github.com/keybase/go-codec v0.0.0-20180928230036-164397562123
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/crypto v0.46.0
 	golang.org/x/sync v0.19.0
+	golang.org/x/sys v0.39.0
+	golang.org/x/term v0.38.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
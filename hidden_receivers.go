@@ -0,0 +1,199 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// HiddenReceiverKeyring is an optional interface for a Keyring with lots of
+// secret keys, like a server's. When a message hides its receivers, every
+// secret key that might be one of them has to be tried against each hidden
+// receiver, which costs a Diffie-Hellman per key. If a Keyring implements
+// HiddenReceiverKeyring, CandidateBoxSecretKeys is used instead of
+// GetAllBoxSecretKeys to get the keys to try, so that it can narrow them
+// down, for example to the keys of the tenant the message was sent to.
+//
+// Either way, the keys are tried one at a time, unless the Keyring is also
+// a ConcurrentKeyring.
+type HiddenReceiverKeyring interface {
+	Keyring
+
+	// CandidateBoxSecretKeys returns the secret keys that might belong to
+	// a hidden receiver of the message with the given ephemeral key.
+	CandidateBoxSecretKeys(ephemeralPub BoxPublicKey) []BoxSecretKey
+}
+
+// ConcurrentKeyring is an optional interface for a Keyring whose box secret
+// keys are safe for concurrent use. When there are many keys to try
+// against the hidden receivers of a message, they're tried on several
+// goroutines at once if ConcurrentSecretKeys returns true, and one at a
+// time otherwise.
+type ConcurrentKeyring interface {
+	Keyring
+
+	// ConcurrentSecretKeys returns whether the box secret keys that the
+	// Keyring returns are safe for concurrent use.
+	ConcurrentSecretKeys() bool
+}
+
+func concurrentSecretKeys(ring Keyring) bool {
+	c, ok := ring.(ConcurrentKeyring)
+	return ok && c.ConcurrentSecretKeys()
+}
+
+func candidateBoxSecretKeys(ring Keyring, ephemeralPub BoxPublicKey) []BoxSecretKey {
+	if h, ok := ring.(HiddenReceiverKeyring); ok {
+		return h.CandidateBoxSecretKeys(ephemeralPub)
+	}
+	return ring.GetAllBoxSecretKeys()
+}
+
+// TrialKeyring wraps a Keyring to cache the Diffie-Hellman results of trial
+// decryption for each ephemeral key and secret key, so that opening the
+// same message again, like when a client retries, doesn't redo them. The
+// cache holds secret material, so it should be bounded with a TTL.
+//
+// For the cache to be used, the TrialKeyring must be the keyring that's
+// passed to Open or SigncryptOpen, and not be wrapped in another one.
+type TrialKeyring struct {
+	Keyring
+	shared  *lruCache[BoxPrecomputedSharedKey]
+	derived *lruCache[*SymmetricKey]
+}
+
+var _ HiddenReceiverKeyring = (*TrialKeyring)(nil)
+var _ ConcurrentKeyring = (*TrialKeyring)(nil)
+var _ SigncryptKeyring = (*TrialKeyring)(nil)
+
+// NewTrialKeyring returns a TrialKeyring that wraps k, with a cache bounded
+// by opts. Misses are never cached, so opts.NegativeTTL is ignored.
+func NewTrialKeyring(k Keyring, opts CacheOptions) *TrialKeyring {
+	return &TrialKeyring{
+		Keyring: k,
+		shared:  newLRUCache[BoxPrecomputedSharedKey](opts),
+		derived: newLRUCache[*SymmetricKey](opts),
+	}
+}
+
+// CandidateBoxSecretKeys returns the wrapped keyring's candidates, if it's a
+// HiddenReceiverKeyring, or else all its secret keys.
+func (k *TrialKeyring) CandidateBoxSecretKeys(ephemeralPub BoxPublicKey) []BoxSecretKey {
+	return candidateBoxSecretKeys(k.Keyring, ephemeralPub)
+}
+
+// ConcurrentSecretKeys returns whether the wrapped keyring's secret keys
+// are safe for concurrent use. The cache itself is.
+func (k *TrialKeyring) ConcurrentSecretKeys() bool {
+	return concurrentSecretKeys(k.Keyring)
+}
+
+// LookupSigningPublicKey passes the lookup on to the wrapped keyring, if
+// it's a SigKeyring, so that a TrialKeyring can be used for signcryption.
+func (k *TrialKeyring) LookupSigningPublicKey(kid []byte) SigningPublicKey {
+	if sk, ok := k.Keyring.(SigKeyring); ok {
+		return sk.LookupSigningPublicKey(kid)
+	}
+	return nil
+}
+
+// LookupSigningPublicKeyWithStatus passes the lookup on to the wrapped
+// keyring, if it's a SigKeyring.
+func (k *TrialKeyring) LookupSigningPublicKeyWithStatus(kid []byte) (SigningPublicKey, KeyStatus) {
+	if sk, ok := k.Keyring.(SigKeyring); ok {
+		return lookupSigningPublicKeyWithStatus(sk, kid)
+	}
+	return nil, KeyStatus{}
+}
+
+func trialCacheKey(ephemeralPub BoxPublicKey, secretKey BoxSecretKey) string {
+	return string(ephemeralPub.ToKID()) + string(secretKey.GetPublicKey().ToKID())
+}
+
+// precomputeForTrial is secretKey.Precompute(ephemeralPub), cached if ring
+// is a TrialKeyring.
+func precomputeForTrial(ring Keyring, secretKey BoxSecretKey, ephemeralPub BoxPublicKey) BoxPrecomputedSharedKey {
+	t, ok := ring.(*TrialKeyring)
+	if !ok {
+		return secretKey.Precompute(ephemeralPub)
+	}
+	key := trialCacheKey(ephemeralPub, secretKey)
+	if shared, _, ok := t.shared.get(key); ok {
+		return shared
+	}
+	shared := secretKey.Precompute(ephemeralPub)
+	t.shared.put(key, shared, false)
+	return shared
+}
+
+// derivedKeyForTrial is derivedEphemeralKeyFromBoxKeys, cached if ring is
+// a TrialKeyring.
+func derivedKeyForTrial(ring Keyring, secretKey BoxSecretKey, ephemeralPub BoxPublicKey) *SymmetricKey {
+	t, ok := ring.(*TrialKeyring)
+	if !ok {
+		return derivedEphemeralKeyFromBoxKeys(ephemeralPub, secretKey)
+	}
+	key := trialCacheKey(ephemeralPub, secretKey)
+	if derived, _, ok := t.derived.get(key); ok {
+		return derived
+	}
+	derived := derivedEphemeralKeyFromBoxKeys(ephemeralPub, secretKey)
+	t.derived.put(key, derived, false)
+	return derived
+}
+
+// wipeTrialKeys wipes a key derived by trial decryption once it's no
+// longer needed, unless ring is a TrialKeyring that's caching it. Shared
+// keys from Precompute aren't wiped, since they belong to the secret key
+// that returned them, which might reuse them.
+func wipeTrialKeys(ring Keyring, derived *SymmetricKey) {
+	if _, ok := ring.(*TrialKeyring); ok {
+		return
+	}
+	derived.wipe()
+}
+
+// parallelTrialMinKeys is the fewest keys that we try in parallel. For
+// fewer, starting the goroutines costs more than it saves.
+const parallelTrialMinKeys = 16
+
+// trialKeys calls try with the indices 0 to n-1 until it returns true for
+// one of them. It returns that index, or -1 if try never returned true. If
+// concurrent is set, try is called on as many goroutines as there are
+// cores, and must be safe to call concurrently with different indices.
+func trialKeys(concurrent bool, n int, try func(i int) bool) int {
+	workers := min(runtime.GOMAXPROCS(0), n)
+	if !concurrent || n < parallelTrialMinKeys || workers < 2 {
+		for i := range n {
+			if try(i) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var next, found atomic.Int64
+	found.Store(-1)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for found.Load() < 0 {
+				i := next.Add(1) - 1
+				if i >= int64(n) {
+					return
+				}
+				if try(int(i)) {
+					found.CompareAndSwap(-1, i)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return int(found.Load())
+}
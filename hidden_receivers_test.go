@@ -0,0 +1,244 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingBoxSecretKey counts the Diffie-Hellmans done with it.
+type countingBoxSecretKey struct {
+	BoxSecretKey
+	dh *atomic.Int64
+}
+
+func (k countingBoxSecretKey) Precompute(peer BoxPublicKey) BoxPrecomputedSharedKey {
+	k.dh.Add(1)
+	return k.BoxSecretKey.Precompute(peer)
+}
+
+func (k countingBoxSecretKey) Box(receiver BoxPublicKey, nonce Nonce, msg []byte) []byte {
+	k.dh.Add(1)
+	return k.BoxSecretKey.Box(receiver, nonce, msg)
+}
+
+// candidateKeyring narrows down the candidates for hidden receivers to a
+// fixed set.
+type candidateKeyring struct {
+	*keyring
+	candidates []BoxSecretKey
+}
+
+func (r candidateKeyring) CandidateBoxSecretKeys(BoxPublicKey) []BoxSecretKey {
+	return r.candidates
+}
+
+// makeKeyringWithManyKeys returns a keyring with n hidden keys, which
+// count their Diffie-Hellmans in dh.
+func makeKeyringWithManyKeys(t *testing.T, n int, dh *atomic.Int64) (*keyring, []BoxSecretKey) {
+	kr := makeEmptyKeyring()
+	var keys []BoxSecretKey
+	for range n {
+		k, err := createEphemeralKey(true)
+		require.NoError(t, err)
+		counted := countingBoxSecretKey{BoxSecretKey: k, dh: dh}
+		kr.insert(counted)
+		keys = append(keys, counted)
+	}
+	return kr, keys
+}
+
+// concurrentKeyring opts a keyring's secret keys in to being tried
+// concurrently.
+type concurrentKeyring struct {
+	*keyring
+}
+
+func (concurrentKeyring) ConcurrentSecretKeys() bool { return true }
+
+// serialBoxSecretKey records the most goroutines that have used its
+// Precompute at once.
+type serialBoxSecretKey struct {
+	BoxSecretKey
+	inUse, maxInUse *atomic.Int64
+}
+
+func (k serialBoxSecretKey) Precompute(peer BoxPublicKey) BoxPrecomputedSharedKey {
+	n := k.inUse.Add(1)
+	defer k.inUse.Add(-1)
+	for {
+		m := k.maxInUse.Load()
+		if n <= m || k.maxInUse.CompareAndSwap(m, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return k.BoxSecretKey.Precompute(peer)
+}
+
+func TestTrialKeys(t *testing.T) {
+	for _, concurrent := range []bool{false, true} {
+		for _, n := range []int{0, 1, parallelTrialMinKeys - 1, 1000} {
+			var calls atomic.Int64
+			require.Equal(t, -1, trialKeys(concurrent, n, func(int) bool {
+				calls.Add(1)
+				return false
+			}))
+			require.Equal(t, int64(n), calls.Load())
+			if n == 0 {
+				continue
+			}
+			require.Equal(t, n-1, trialKeys(concurrent, n, func(i int) bool { return i == n-1 }))
+		}
+	}
+}
+
+func TestTrialKeysSerialByDefault(t *testing.T) {
+	// Make sure there'd be several workers if the keys were tried
+	// concurrently.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	var inUse, maxInUse atomic.Int64
+	kr := makeEmptyKeyring()
+	for range 2 * parallelTrialMinKeys {
+		k, err := createEphemeralKey(true)
+		require.NoError(t, err)
+		kr.insert(serialBoxSecretKey{BoxSecretKey: k, inUse: &inUse, maxInUse: &maxInUse})
+	}
+	sealed, err := Seal(Version2(), []byte("hello"), nil, []BoxPublicKey{newHiddenBoxKeyNoInsert(t).GetPublicKey()})
+	require.NoError(t, err)
+
+	// Keys that haven't opted in are never used concurrently, even through
+	// a TrialKeyring.
+	_, _, err = Open(SingleVersionValidator(Version2()), sealed, kr)
+	require.Equal(t, ErrNoDecryptionKey, err)
+	_, _, err = Open(SingleVersionValidator(Version2()), sealed, NewTrialKeyring(kr, CacheOptions{}))
+	require.Equal(t, ErrNoDecryptionKey, err)
+	require.Equal(t, int64(1), maxInUse.Load())
+}
+
+func TestHiddenReceiversManyKeys(t *testing.T) {
+	msg := []byte("hello world")
+	var dh atomic.Int64
+	kr, keys := makeKeyringWithManyKeys(t, 100, &dh)
+	receiver := keys[57]
+	sender := makeSigningKey(t, kr)
+
+	receivers := []BoxPublicKey{newHiddenBoxKeyNoInsert(t).GetPublicKey(), receiver.GetPublicKey()}
+	sealed, err := Seal(Version2(), msg, newHiddenBoxKeyNoInsert(t), receivers)
+	require.NoError(t, err)
+	signcrypted, err := SigncryptSeal(msg, ephemeralKeyCreator{}, sender, receivers, nil)
+	require.NoError(t, err)
+
+	for _, ring := range []SigncryptKeyring{kr, concurrentKeyring{kr}} {
+		mki, opened, err := Open(SingleVersionValidator(Version2()), sealed, ring)
+		require.NoError(t, err)
+		require.Equal(t, msg, opened)
+		require.True(t, mki.ReceiverIsAnon)
+		require.Equal(t, 2, mki.NumAnonReceivers)
+		require.True(t, PublicKeyEqual(receiver.GetPublicKey(), mki.ReceiverKey.GetPublicKey()))
+		_, opened, err = SigncryptOpen(signcrypted, ring, nil)
+		require.NoError(t, err)
+		require.Equal(t, msg, opened)
+	}
+
+	// A keyring that narrows down the candidates only tries those.
+	dh.Store(0)
+	narrowed := candidateKeyring{keyring: kr, candidates: []BoxSecretKey{keys[0], receiver}}
+	_, opened, err := Open(SingleVersionValidator(Version2()), sealed, narrowed)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	_, opened, err = SigncryptOpen(signcrypted, narrowed, nil)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	// One trial for each candidate, and the rest are for the MAC keys
+	// once the receiver is found.
+	require.LessOrEqual(t, dh.Load(), int64(8))
+
+	narrowed.candidates = keys[:1]
+	_, _, err = Open(SingleVersionValidator(Version2()), sealed, narrowed)
+	require.Equal(t, ErrNoDecryptionKey, err)
+	_, _, err = SigncryptOpen(signcrypted, narrowed, nil)
+	require.Equal(t, ErrNoDecryptionKey, err)
+}
+
+func TestTrialKeyringCache(t *testing.T) {
+	msg := []byte("hello world")
+	var dh atomic.Int64
+	kr, keys := makeKeyringWithManyKeys(t, 50, &dh)
+	sender := makeSigningKey(t, kr)
+	receivers := []BoxPublicKey{keys[len(keys)-1].GetPublicKey()}
+	sealed, err := Seal(Version2(), msg, newHiddenBoxKeyNoInsert(t), receivers)
+	require.NoError(t, err)
+	signcrypted, err := SigncryptSeal(msg, ephemeralKeyCreator{}, sender, receivers, nil)
+	require.NoError(t, err)
+
+	// Fix the order the keys are tried in, with the receiver last, so that
+	// the first open tries them all.
+	trial := NewTrialKeyring(candidateKeyring{keyring: kr, candidates: keys}, CacheOptions{})
+	open := func() int64 {
+		dh.Store(0)
+		_, opened, err := Open(SingleVersionValidator(Version2()), sealed, trial)
+		require.NoError(t, err)
+		require.Equal(t, msg, opened)
+		_, opened, err = SigncryptOpen(signcrypted, trial, nil)
+		require.NoError(t, err)
+		require.Equal(t, msg, opened)
+		return dh.Load()
+	}
+
+	require.GreaterOrEqual(t, open(), int64(2*len(keys)))
+	// The second time, only the MAC keys are computed again.
+	require.LessOrEqual(t, open(), int64(2))
+}
+
+// wipeableSharedKey is a shared key that records whether it's been wiped.
+type wipeableSharedKey struct {
+	boxPrecomputedSharedKey
+	wiped bool
+}
+
+func (k *wipeableSharedKey) Wipe() { k.wiped = true }
+
+// cachingBoxSecretKey returns the same shared key from each Precompute
+// with a given peer.
+type cachingBoxSecretKey struct {
+	BoxSecretKey
+	shared map[RawBoxKey]*wipeableSharedKey
+}
+
+func (k cachingBoxSecretKey) Precompute(peer BoxPublicKey) BoxPrecomputedSharedKey {
+	shared, ok := k.shared[*peer.ToRawBoxKeyPointer()]
+	if !ok {
+		shared = &wipeableSharedKey{boxPrecomputedSharedKey: k.BoxSecretKey.Precompute(peer).(boxPrecomputedSharedKey)}
+		k.shared[*peer.ToRawBoxKeyPointer()] = shared
+	}
+	return shared
+}
+
+func TestTrialDoesNotWipeSharedKeys(t *testing.T) {
+	receiver, err := createEphemeralKey(true)
+	require.NoError(t, err)
+	sender := newBoxKey(t)
+	msg := []byte("hello")
+	sealed, err := Seal(Version2(), msg, sender, []BoxPublicKey{receiver.GetPublicKey()})
+	require.NoError(t, err)
+
+	// The keyring owns the shared keys it returns, so they mustn't be
+	// wiped by trial decryption.
+	caching := cachingBoxSecretKey{BoxSecretKey: receiver, shared: make(map[RawBoxKey]*wipeableSharedKey)}
+	kr := makeEmptyKeyring()
+	kr.insert(caching)
+	_, opened, err := Open(SingleVersionValidator(Version2()), sealed, kr)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	require.NotEmpty(t, caching.shared)
+	for _, shared := range caching.shared {
+		require.False(t, shared.wiped)
+	}
+}
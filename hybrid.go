@@ -0,0 +1,120 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"crypto/hmac"
+	"crypto/mlkem"
+	"crypto/sha512"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// HybridBoxPublicKey is a BoxPublicKey that has an ML-KEM-768
+// encapsulation key alongside its X25519 key. The box key receivers of a
+// V3 message have to be HybridBoxPublicKeys. ToKID still returns the key
+// ID of the X25519 half, which is what goes in message headers.
+type HybridBoxPublicKey interface {
+	BoxPublicKey
+
+	// MLKEMEncapsulationKey returns the ML-KEM-768 encapsulation key, in
+	// its 1184-byte encoding.
+	MLKEMEncapsulationKey() []byte
+}
+
+// HybridBoxSecretKey is the secret key corresponding to a
+// HybridBoxPublicKey. To open a V3 message, a Keyring has to return
+// HybridBoxSecretKeys, and their GetPublicKey should return
+// HybridBoxPublicKeys.
+type HybridBoxSecretKey interface {
+	BoxSecretKey
+
+	// DecapsulateMLKEM returns the ML-KEM-768 shared secret for a
+	// ciphertext sent to this key.
+	DecapsulateMLKEM(ciphertext []byte) ([]byte, error)
+}
+
+// hybridKey combines x25519Key, derived from the X25519 shared secret by
+// derivedEphemeralKeyFromBoxKeys, with an ML-KEM-768 shared secret into
+// the key for a V3 payload key box, which is safe as long as either of
+// them is. Like X-Wing, it also hashes in the ML-KEM ciphertext and both
+// X25519 public keys, to bind the key to the receiver and the message.
+func hybridKey(x25519Key *SymmetricKey, mlkemShared, mlkemCiphertext []byte, ephemeralPub, receiverPub BoxPublicKey) *SymmetricKey {
+	digest := hmac.New(sha512.New, []byte(hybridPayloadKeyBoxContext))
+	_, _ = digest.Write(mlkemShared)
+	_, _ = digest.Write(x25519Key[:])
+	_, _ = digest.Write(mlkemCiphertext)
+	_, _ = digest.Write(ephemeralPub.ToRawBoxKeyPointer()[:])
+	_, _ = digest.Write(receiverPub.ToRawBoxKeyPointer()[:])
+	sum := digest.Sum(nil)
+	defer clear(sum)
+	key, err := symmetricKeyFromSlice(sum[:32])
+	if err != nil {
+		panic(err) // should be statically impossible, if the slice above is the right length
+	}
+	return key
+}
+
+// sealHybridPayloadKey seals payloadKey for a V3 receiver, given the key
+// derived from the X25519 shared secret of the receiver and the ephemeral
+// key. It returns the payload key box, and the ML-KEM ciphertext to send
+// along with it.
+func sealHybridPayloadKey(ephemeralKey BoxSecretKey, receiver BoxPublicKey, x25519Key *SymmetricKey, nonce Nonce, payloadKey *SymmetricKey) (payloadKeyBox, mlkemCiphertext []byte, err error) {
+	hybrid, ok := receiver.(HybridBoxPublicKey)
+	if !ok {
+		return nil, nil, ErrNotHybridKey
+	}
+	ek, err := mlkem.NewEncapsulationKey768(hybrid.MLKEMEncapsulationKey())
+	if err != nil {
+		return nil, nil, err
+	}
+	mlkemShared, mlkemCiphertext := ek.Encapsulate()
+	defer clear(mlkemShared)
+
+	key := hybridKey(x25519Key, mlkemShared, mlkemCiphertext, ephemeralKey.GetPublicKey(), receiver)
+	defer key.wipe()
+	payloadKeyBox = secretbox.Seal(nil, payloadKey[:], (*[24]byte)(&nonce), (*[32]byte)(key))
+	return payloadKeyBox, mlkemCiphertext, nil
+}
+
+// openHybridPayloadKey opens a V3 receiver's payload key box with
+// secretKey, given the key derived from the X25519 shared secret of
+// secretKey and the ephemeral key.
+func openHybridPayloadKey(secretKey BoxSecretKey, ephemeralPub BoxPublicKey, x25519Key *SymmetricKey, nonce Nonce, receiver receiverKeys) ([]byte, error) {
+	hybrid, ok := secretKey.(HybridBoxSecretKey)
+	if !ok {
+		return nil, ErrNotHybridKey
+	}
+	if len(receiver.MLKEMCiphertext) == 0 {
+		return nil, ErrBadMLKEMCiphertext
+	}
+	mlkemShared, err := hybrid.DecapsulateMLKEM(receiver.MLKEMCiphertext)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(mlkemShared)
+
+	key := hybridKey(x25519Key, mlkemShared, receiver.MLKEMCiphertext, ephemeralPub, secretKey.GetPublicKey())
+	defer key.wipe()
+	payloadKey, ok := secretbox.Open(nil, receiver.PayloadKeyBox, (*[24]byte)(&nonce), (*[32]byte)(key))
+	if !ok {
+		return nil, ErrDecryptionFailed
+	}
+	return payloadKey, nil
+}
+
+// checkMLKEMCiphertexts checks that the ML-KEM ciphertexts of V3
+// receivers are the right length. They're missing for the symmetric key
+// receivers of signcrypted messages, and ignored in earlier versions.
+func checkMLKEMCiphertexts(version Version, receivers []receiverKeys) error {
+	if version.Major != Version3().Major {
+		return nil
+	}
+	for _, r := range receivers {
+		if len(r.MLKEMCiphertext) != 0 && len(r.MLKEMCiphertext) != mlkem.CiphertextSize768 {
+			return ErrBadMLKEMCiphertext
+		}
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"crypto/mlkem"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type hybridBoxPublicKey struct {
+	boxPublicKey
+	ek []byte
+}
+
+func (k hybridBoxPublicKey) MLKEMEncapsulationKey() []byte { return k.ek }
+
+type hybridBoxSecretKey struct {
+	*boxSecretKey
+	dk *mlkem.DecapsulationKey768
+}
+
+func (k hybridBoxSecretKey) GetPublicKey() BoxPublicKey {
+	return hybridBoxPublicKey{
+		boxPublicKey: k.boxSecretKey.GetPublicKey().(boxPublicKey),
+		ek:           k.dk.EncapsulationKey().Bytes(),
+	}
+}
+
+func (k hybridBoxSecretKey) DecapsulateMLKEM(ciphertext []byte) ([]byte, error) {
+	return k.dk.Decapsulate(ciphertext)
+}
+
+var _ HybridBoxSecretKey = hybridBoxSecretKey{}
+
+func newHybridBoxKeyNoInsert(t *testing.T, hide bool) hybridBoxSecretKey {
+	sk, err := createEphemeralKey(hide)
+	require.NoError(t, err)
+	dk, err := mlkem.GenerateKey768()
+	require.NoError(t, err)
+	return hybridBoxSecretKey{boxSecretKey: sk.(*boxSecretKey), dk: dk}
+}
+
+func newHybridBoxKey(t *testing.T, hide bool) hybridBoxSecretKey {
+	ret := newHybridBoxKeyNoInsert(t, hide)
+	kr.insert(ret)
+	return ret
+}
+
+// splitV3Header splits a V3 message into its header and the rest. Unlike
+// earlier headers, which getHeaderLen handles, V3 ones are too big for
+// MessagePack's bin8 type, so they're bin16.
+func splitV3Header(t *testing.T, sealed []byte) (header, rest []byte) {
+	require.Equal(t, byte(0xc5), sealed[0])
+	headerLen := int(binary.BigEndian.Uint16(sealed[1:3])) + 3
+	return sealed[3:headerLen], sealed[headerLen:]
+}
+
+func decodeV3Header(t *testing.T, sealed []byte) EncryptionHeader {
+	headerBytes, _ := splitV3Header(t, sealed)
+	var header EncryptionHeader
+	require.NoError(t, decodeHeader(headerBytes, &header))
+	return header
+}
+
+func messWithV3Header(t *testing.T, sealed []byte, messFunc func(*EncryptionHeader)) []byte {
+	header := decodeV3Header(t, sealed)
+	_, rest := splitV3Header(t, sealed)
+	messFunc(&header)
+	newHeader, err := encodeHeader(&header)
+	require.NoError(t, err)
+	newHeaderBytes, err := encodeToBytes(&newHeader)
+	require.NoError(t, err)
+	return append(newHeaderBytes, rest...)
+}
+
+func TestHybridRoundTrip(t *testing.T) {
+	sender := newBoxKey(t)
+	visible := newHybridBoxKey(t, false)
+	hidden := newHybridBoxKey(t, true)
+	other := newHybridBoxKeyNoInsert(t, false)
+	msg := randomMsg(t, 3*encryptionBlockSize/2)
+
+	sealed, err := Seal(Version3(), msg, sender, []BoxPublicKey{other.GetPublicKey(), visible.GetPublicKey(), hidden.GetPublicKey()})
+	require.NoError(t, err)
+
+	header := decodeV3Header(t, sealed)
+	require.Equal(t, Version3(), header.Version)
+	for _, r := range header.Receivers {
+		require.Len(t, r.MLKEMCiphertext, mlkem.CiphertextSize768)
+	}
+
+	mki, opened, err := Open(CheckKnownOrExperimentalMajorVersion, sealed, kr)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	require.Equal(t, visible.GetPublicKey().ToKID(), mki.ReceiverKey.GetPublicKey().ToKID())
+	require.False(t, mki.ReceiverIsAnon)
+
+	// The hidden receiver can only be found by trial decryption.
+	hiddenOnly := makeEmptyKeyring()
+	hiddenOnly.insert(hidden)
+	mki, opened, err = Open(CheckKnownOrExperimentalMajorVersion, sealed, hiddenOnly)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	require.True(t, mki.ReceiverIsAnon)
+
+	// V3 isn't a known version, so it has to be asked for.
+	_, _, err = Open(CheckKnownMajorVersion, sealed, kr)
+	require.Equal(t, ErrBadVersion{Version3()}, err)
+}
+
+func TestHybridNotHybridKey(t *testing.T) {
+	sender := newBoxKey(t)
+	classic := newBoxKey(t)
+	_, err := Seal(Version3(), []byte("hello"), sender, []BoxPublicKey{classic.GetPublicKey()})
+	require.Equal(t, ErrNotHybridKey, err)
+
+	// A keyring with only the X25519 half of a hybrid key can't open V3
+	// messages sent to it.
+	hybrid := newHybridBoxKeyNoInsert(t, false)
+	sealed, err := Seal(Version3(), []byte("hello"), sender, []BoxPublicKey{hybrid.GetPublicKey()})
+	require.NoError(t, err)
+	x25519Only := makeEmptyKeyring()
+	x25519Only.insert(hybrid.boxSecretKey)
+	_, _, err = Open(CheckKnownOrExperimentalMajorVersion, sealed, x25519Only)
+	require.Equal(t, ErrNotHybridKey, err)
+}
+
+func TestHybridBadMLKEMCiphertext(t *testing.T) {
+	sender := newBoxKey(t)
+	receiver := newHybridBoxKey(t, false)
+	sealed, err := Seal(Version3(), []byte("hello"), sender, []BoxPublicKey{receiver.GetPublicKey()})
+	require.NoError(t, err)
+
+	// A different ML-KEM ciphertext gives a different shared secret, so
+	// the payload key box doesn't open.
+	tampered := messWithV3Header(t, sealed, func(hdr *EncryptionHeader) {
+		hdr.Receivers[0].MLKEMCiphertext[0] ^= 1
+	})
+	_, _, err = Open(CheckKnownOrExperimentalMajorVersion, tampered, kr)
+	require.Equal(t, ErrDecryptionFailed, err)
+
+	truncated := messWithV3Header(t, sealed, func(hdr *EncryptionHeader) {
+		hdr.Receivers[0].MLKEMCiphertext = hdr.Receivers[0].MLKEMCiphertext[1:]
+	})
+	_, _, err = Open(CheckKnownOrExperimentalMajorVersion, truncated, kr)
+	require.Equal(t, ErrBadMLKEMCiphertext, err)
+}
+
+func TestReceiverKeysEncoding(t *testing.T) {
+	// V1 and V2 receivers are still two-element arrays.
+	for _, version := range KnownVersions() {
+		sealed, err := Seal(version, []byte("hello"), newBoxKey(t), []BoxPublicKey{newBoxKey(t).GetPublicKey()})
+		require.NoError(t, err)
+		headerLen := getHeaderLen(t, sealed)
+		var header []any
+		require.NoError(t, decodeFromBytes(&header, sealed[2:headerLen]))
+		receivers := header[len(header)-1].([]any)
+		require.Len(t, receivers[0], 2, "version %s", version)
+	}
+
+	// V3 receivers have the ML-KEM ciphertext as well.
+	sealed, err := Seal(Version3(), []byte("hello"), newBoxKey(t), []BoxPublicKey{newHybridBoxKeyNoInsert(t, false).GetPublicKey()})
+	require.NoError(t, err)
+	headerBytes, _ := splitV3Header(t, sealed)
+	var header []any
+	require.NoError(t, decodeFromBytes(&header, headerBytes))
+	receivers := header[len(header)-1].([]any)
+	require.Len(t, receivers[0], 3)
+}
+
+func TestHybridSigncryption(t *testing.T) {
+	keyring := makeEmptyKeyring()
+	receiver := newHybridBoxKeyNoInsert(t, false)
+	keyring.insert(receiver)
+	sender := makeSigningKey(t, keyring)
+	resolver, symmetricReceivers := makeResolverWithOneKey()
+	msg := []byte("hello world")
+
+	sealed, err := SigncryptSealWithVersion(Version3(), msg, ephemeralKeyCreator{}, sender, []BoxPublicKey{receiver.GetPublicKey()}, symmetricReceivers)
+	require.NoError(t, err)
+
+	senderPub, opened, err := SigncryptOpenWithVersionValidator(CheckKnownOrExperimentalMajorVersion, sealed, keyring, nil)
+	require.NoError(t, err)
+	require.Equal(t, sender.GetPublicKey(), senderPub)
+	require.Equal(t, msg, opened)
+
+	// Symmetric key receivers are the same as in V2.
+	senderOnly := makeEmptyKeyring()
+	senderOnly.insertSigningKey(sender)
+	_, opened, err = SigncryptOpenWithVersionValidator(CheckKnownOrExperimentalMajorVersion, sealed, senderOnly, resolver)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+
+	_, _, err = SigncryptOpen(sealed, keyring, nil)
+	require.Equal(t, ErrBadVersion{Version3()}, err)
+
+	_, err = SigncryptSealWithVersion(Version1(), msg, ephemeralKeyCreator{}, sender, []BoxPublicKey{receiver.GetPublicKey()}, nil)
+	require.Equal(t, ErrBadVersion{Version1()}, err)
+}
+
+func TestHybridTypedKID(t *testing.T) {
+	kid := newHybridBoxKeyNoInsert(t, false).GetPublicKey().ToKID()
+	typ, untyped, err := ParseTypedKID(TypedKID(KeyTypeX25519MLKEM768, kid))
+	require.NoError(t, err)
+	require.Equal(t, KeyTypeX25519MLKEM768, typ)
+	require.Equal(t, kid, untyped)
+	require.Equal(t, 32+mlkem.EncapsulationKeySize768, KeyTypeX25519MLKEM768.PublicKeyLen())
+}
@@ -5,6 +5,7 @@ package saltpack
 
 import (
 	"crypto/hmac"
+	"time"
 )
 
 // RawBoxKey is the raw byte-representation of what a box key should
@@ -16,7 +17,7 @@ func rawBoxKeyFromSlice(slice []byte) (*RawBoxKey, error) {
 	if len(slice) != len(result) {
 		return nil, ErrBadBoxKey
 	}
-	result = sliceToByte32(slice)
+	copy(result[:], slice)
 	return &result, nil
 }
 
@@ -38,7 +39,7 @@ func symmetricKeyFromSlice(slice []byte) (*SymmetricKey, error) {
 	if len(slice) != len(result) {
 		return nil, ErrBadSymmetricKey
 	}
-	result = sliceToByte32(slice)
+	copy(result[:], slice)
 	return &result, nil
 }
 
@@ -147,6 +148,70 @@ type SigKeyring interface {
 	LookupSigningPublicKey(kid []byte) SigningPublicKey
 }
 
+// KeyStatus says when a key may be used, and whether it has been revoked.
+type KeyStatus struct {
+	// NotBefore and NotAfter bound the key's validity period. The zero
+	// time means there's no bound on that side.
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Revoked is set if the key has been revoked, in which case RevokedAt
+	// and Reason say when and why, if known.
+	Revoked   bool
+	RevokedAt time.Time
+	Reason    string
+}
+
+// Check returns nil if a key with this status may be used at time now, or
+// an ErrRevokedKey or ErrExpiredKey for the key with the given ID if not.
+func (s KeyStatus) Check(kid []byte, now time.Time) error {
+	if s.Revoked {
+		return ErrRevokedKey{Sender: kid, RevokedAt: s.RevokedAt, Reason: s.Reason}
+	}
+	if (!s.NotBefore.IsZero() && now.Before(s.NotBefore)) || (!s.NotAfter.IsZero() && !now.Before(s.NotAfter)) {
+		return ErrExpiredKey{Sender: kid, NotBefore: s.NotBefore, NotAfter: s.NotAfter, Now: now}
+	}
+	return nil
+}
+
+// SigKeyringWithStatus is a SigKeyring that also knows about keys that
+// can't be used anymore. If a keyring passed to Verify, VerifyDetached or
+// SigncryptOpen implements it, a sender key that's been revoked or has
+// expired fails with an ErrRevokedKey or ErrExpiredKey, rather than with
+// an ErrNoSenderKey.
+type SigKeyringWithStatus interface {
+	SigKeyring
+
+	// LookupSigningPublicKeyWithStatus returns a public signing key for
+	// the specified key ID, along with its status. Unlike
+	// LookupSigningPublicKey, which should return nil for keys that
+	// can't be used, it returns revoked and expired keys too.
+	LookupSigningPublicKeyWithStatus(kid []byte) (SigningPublicKey, KeyStatus)
+}
+
+// lookupSigningPublicKeyWithStatus uses the keyring's
+// LookupSigningPublicKeyWithStatus if it has one, or else treats every key
+// it finds as valid.
+func lookupSigningPublicKeyWithStatus(keyring SigKeyring, kid []byte) (SigningPublicKey, KeyStatus) {
+	if k, ok := keyring.(SigKeyringWithStatus); ok {
+		return k.LookupSigningPublicKeyWithStatus(kid)
+	}
+	return keyring.LookupSigningPublicKey(kid), KeyStatus{}
+}
+
+// lookupSenderSigningKey finds the sender's public signing key, and
+// checks that it may be used now.
+func lookupSenderSigningKey(keyring SigKeyring, kid []byte) (SigningPublicKey, error) {
+	pk, status := lookupSigningPublicKeyWithStatus(keyring, kid)
+	if pk == nil {
+		return nil, ErrNoSenderKey{Sender: kid}
+	}
+	if err := status.Check(kid, time.Now()); err != nil {
+		return nil, err
+	}
+	return pk, nil
+}
+
 // PublicKeyEqual returns true if the two public keys are equal.
 func PublicKeyEqual(k1, k2 BasePublicKey) bool {
 	return hmac.Equal(k1.ToKID(), k2.ToKID())
@@ -0,0 +1,308 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"fmt"
+	"time"
+)
+
+// keyBundleFormatVersion is the version of the serialization format for
+// key bundles.
+func keyBundleFormatVersion() Version {
+	return Version{Major: 1, Minor: 0}
+}
+
+// BundledBoxKey is a box key in a KeyBundle. It may be encrypted to from
+// NotBefore until NotAfter. The zero time means there's no bound on that
+// side.
+type BundledBoxKey struct {
+	Key       BoxPublicKey
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// BundledSigningKey is a signing key in a KeyBundle. Its signatures are
+// valid from NotBefore until NotAfter. The zero time means there's no
+// bound on that side.
+type BundledSigningKey struct {
+	Key       SigningPublicKey
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Status returns the validity window of k as a KeyStatus.
+func (k BundledSigningKey) Status() KeyStatus {
+	return KeyStatus{NotBefore: k.NotBefore, NotAfter: k.NotAfter}
+}
+
+// KeyBundle is a set of box and signing public keys that belong to the
+// same owner. A signed key bundle is signed by every one of its signing
+// keys, which binds the box keys to the owner's signing identity, and
+// shows that whoever made the bundle holds all the signing keys.
+type KeyBundle struct {
+	Created     time.Time
+	BoxKeys     []BundledBoxKey
+	SigningKeys []BundledSigningKey
+}
+
+// KeyBundleKeyring turns the key IDs in a key bundle back into keys. A
+// Keyring that's also a SigKeyring, like basic.Keyring, is one.
+type KeyBundleKeyring interface {
+	SigKeyring
+	LookupBoxPublicKey(kid []byte) BoxPublicKey
+}
+
+// keyBundleBody is the msgpack form of a key bundle, which is what its
+// keys sign.
+type keyBundleBody struct {
+	_struct    bool          `codec:",toarray"` //nolint
+	FormatName string        `codec:"format_name"`
+	Version    Version       `codec:"vers"`
+	Type       MessageType   `codec:"type"`
+	Created    int64         `codec:"created"`
+	Keys       []bundledKeyV `codec:"keys"`
+}
+
+// bundledKeyV is a single key in a keyBundleBody. Times are in Unix
+// seconds, and 0 means unbounded.
+type bundledKeyV struct {
+	_struct   bool    `codec:",toarray"` //nolint
+	Type      KeyType `codec:"type"`
+	KID       []byte  `codec:"kid"`
+	NotBefore int64   `codec:"not_before"`
+	NotAfter  int64   `codec:"not_after"`
+}
+
+// signedKeyBundle is the msgpack form of a signed key bundle. Sigs has
+// one signature for each signing key in Body, in order.
+type signedKeyBundle struct {
+	_struct bool     `codec:",toarray"` //nolint
+	Body    []byte   `codec:"body"`
+	Sigs    [][]byte `codec:"sigs"`
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(secs int64) time.Time {
+	if secs == 0 {
+		return time.Time{}
+	}
+	return time.Unix(secs, 0)
+}
+
+func checkWindow(notBefore, notAfter int64) error {
+	if notAfter != 0 && notAfter <= notBefore {
+		return ErrBadKeyBundle(fmt.Sprintf("key expires at %d, before it's valid at %d", notAfter, notBefore))
+	}
+	return nil
+}
+
+func keyBundleSignatureInput(body []byte) []byte {
+	sum := sha512.Sum512(body)
+	return append([]byte(keyBundleSignatureString), sum[:]...)
+}
+
+func (b KeyBundle) body() keyBundleBody {
+	body := keyBundleBody{
+		FormatName: FormatName,
+		Version:    keyBundleFormatVersion(),
+		Type:       MessageTypeKeyBundle,
+		Created:    unixOrZero(b.Created),
+	}
+	for _, k := range b.BoxKeys {
+		body.Keys = append(body.Keys, bundledKeyV{
+			Type: KeyTypeCurve25519, KID: k.Key.ToKID(),
+			NotBefore: unixOrZero(k.NotBefore), NotAfter: unixOrZero(k.NotAfter),
+		})
+	}
+	for _, k := range b.SigningKeys {
+		body.Keys = append(body.Keys, bundledKeyV{
+			Type: KeyTypeEd25519, KID: k.Key.ToKID(),
+			NotBefore: unixOrZero(k.NotBefore), NotAfter: unixOrZero(k.NotAfter),
+		})
+	}
+	return body
+}
+
+// SignKeyBundle serializes and signs b. signers must hold the secret key
+// for each of b's signing keys, in any order. It returns an
+// ErrInvalidParameter if b has no signing keys, or one of them has no
+// signer.
+func SignKeyBundle(b KeyBundle, signers []SigningSecretKey) ([]byte, error) {
+	if len(b.SigningKeys) == 0 {
+		return nil, ErrInvalidParameter{message: "a key bundle needs at least one signing key"}
+	}
+	body := b.body()
+	for _, k := range body.Keys {
+		if err := checkWindow(k.NotBefore, k.NotAfter); err != nil {
+			return nil, err
+		}
+	}
+	bodyBytes, err := encodeToBytes(body)
+	if err != nil {
+		return nil, err
+	}
+
+	input := keyBundleSignatureInput(bodyBytes)
+	signed := signedKeyBundle{Body: bodyBytes}
+	for _, k := range b.SigningKeys {
+		var signer SigningSecretKey
+		for _, s := range signers {
+			if PublicKeyEqual(s.GetPublicKey(), k.Key) {
+				signer = s
+				break
+			}
+		}
+		if signer == nil {
+			return nil, ErrInvalidParameter{message: fmt.Sprintf("no signer for key bundle signing key %x", k.Key.ToKID())}
+		}
+		sig, err := signer.Sign(input)
+		if err != nil {
+			return nil, err
+		}
+		signed.Sigs = append(signed.Sigs, sig)
+	}
+	return encodeToBytes(signed)
+}
+
+// SignKeyBundleArmor62 is SignKeyBundle, armored in a "BEGIN SALTPACK KEY
+// BUNDLE" frame with an optional brand.
+func SignKeyBundleArmor62(b KeyBundle, signers []SigningSecretKey, brand string) (string, error) {
+	signed, err := SignKeyBundle(b, signers)
+	if err != nil {
+		return "", err
+	}
+	return Armor62Seal(signed, MessageTypeKeyBundle, brand)
+}
+
+// VerifyKeyBundle parses a key bundle made by SignKeyBundle, and checks
+// that each of its signing keys signed it. It returns the bundle only if
+// all of the signatures are good. keyring turns the key IDs in the bundle
+// into keys; if it can't find one of the signing keys, an ErrNoSenderKey
+// is returned.
+//
+// The keys' validity windows aren't checked against the current time. A
+// caller that uses the keys should honor them, for instance by loading
+// the signing keys through KeyBundle.SigKeyring.
+func VerifyKeyBundle(signed []byte, keyring KeyBundleKeyring) (*KeyBundle, error) {
+	var sb signedKeyBundle
+	if err := decodeFromBytes(&sb, signed); err != nil {
+		return nil, ErrBadKeyBundle(err.Error())
+	}
+	var body keyBundleBody
+	if err := decodeFromBytes(&body, sb.Body); err != nil {
+		return nil, ErrBadKeyBundle(err.Error())
+	}
+	if body.FormatName != FormatName {
+		return nil, ErrWrongMessageType{Wanted: MessageTypeKeyBundle, Received: MessageTypeUnknown}
+	}
+	if body.Version.Major != keyBundleFormatVersion().Major {
+		return nil, ErrBadVersion{received: body.Version}
+	}
+	if body.Type != MessageTypeKeyBundle {
+		return nil, ErrWrongMessageType{Wanted: MessageTypeKeyBundle, Received: body.Type}
+	}
+
+	b := &KeyBundle{Created: timeOrZero(body.Created)}
+	for _, k := range body.Keys {
+		if err := checkWindow(k.NotBefore, k.NotAfter); err != nil {
+			return nil, err
+		}
+		if n := k.Type.PublicKeyLen(); n == 0 || n != len(k.KID) {
+			return nil, ErrBadKeyBundle(fmt.Sprintf("bad key ID %x for %s", k.KID, k.Type))
+		}
+		notBefore, notAfter := timeOrZero(k.NotBefore), timeOrZero(k.NotAfter)
+		switch k.Type {
+		case KeyTypeCurve25519:
+			pk := keyring.LookupBoxPublicKey(k.KID)
+			if pk == nil {
+				return nil, ErrBadKeyBundle(fmt.Sprintf("unusable box key %x", k.KID))
+			}
+			b.BoxKeys = append(b.BoxKeys, BundledBoxKey{Key: pk, NotBefore: notBefore, NotAfter: notAfter})
+		case KeyTypeEd25519:
+			pk := keyring.LookupSigningPublicKey(k.KID)
+			if pk == nil {
+				return nil, ErrNoSenderKey{Sender: k.KID}
+			}
+			b.SigningKeys = append(b.SigningKeys, BundledSigningKey{Key: pk, NotBefore: notBefore, NotAfter: notAfter})
+		}
+	}
+	if len(b.SigningKeys) == 0 {
+		return nil, ErrBadKeyBundle("no signing keys")
+	}
+	if len(sb.Sigs) != len(b.SigningKeys) {
+		return nil, ErrBadKeyBundle(fmt.Sprintf("%d signatures for %d signing keys", len(sb.Sigs), len(b.SigningKeys)))
+	}
+
+	input := keyBundleSignatureInput(sb.Body)
+	for i, k := range b.SigningKeys {
+		if err := k.Key.Verify(input, sb.Sigs[i]); err != nil {
+			return nil, ErrBadSignature
+		}
+	}
+	return b, nil
+}
+
+// Dearmor62VerifyKeyBundle is VerifyKeyBundle for the output of
+// SignKeyBundleArmor62. It also returns the brand, if any.
+func Dearmor62VerifyKeyBundle(armored string, keyring KeyBundleKeyring) (*KeyBundle, string, error) {
+	signed, _, header, footer, err := Armor62OpenWithValidation(armored, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	brand, err := CheckArmor62(header, footer, MessageTypeKeyBundle)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := VerifyKeyBundle(signed, keyring)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, brand, nil
+}
+
+// BoxPublicKeysAt returns the bundle's box keys that may be encrypted to
+// at the given time.
+func (b *KeyBundle) BoxPublicKeysAt(now time.Time) []BoxPublicKey {
+	var ret []BoxPublicKey
+	for _, k := range b.BoxKeys {
+		if (k.NotBefore.IsZero() || !now.Before(k.NotBefore)) && (k.NotAfter.IsZero() || now.Before(k.NotAfter)) {
+			ret = append(ret, k.Key)
+		}
+	}
+	return ret
+}
+
+// SigKeyring returns a SigKeyring that holds the bundle's signing keys,
+// with their validity windows as their statuses, so that messages signed
+// outside a key's window fail to verify with an ErrExpiredKey. It can be
+// chained with other keyrings with ChainSigKeyrings.
+func (b *KeyBundle) SigKeyring() SigKeyringWithStatus {
+	return keyBundleSigKeyring{b}
+}
+
+type keyBundleSigKeyring struct {
+	b *KeyBundle
+}
+
+func (k keyBundleSigKeyring) LookupSigningPublicKeyWithStatus(kid []byte) (SigningPublicKey, KeyStatus) {
+	for _, sk := range k.b.SigningKeys {
+		if bytes.Equal(sk.Key.ToKID(), kid) {
+			return sk.Key, sk.Status()
+		}
+	}
+	return nil, KeyStatus{}
+}
+
+func (k keyBundleSigKeyring) LookupSigningPublicKey(kid []byte) SigningPublicKey {
+	return usableSigningPublicKey(k, kid)
+}
@@ -0,0 +1,153 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func makeTestKeyBundle(t *testing.T) (KeyBundle, []SigningSecretKey) {
+	now := time.Unix(time.Now().Unix(), 0)
+	sk1, sk2 := makeSigningKey(t, kr), makeSigningKey(t, kr)
+	b := KeyBundle{
+		Created: now,
+		BoxKeys: []BundledBoxKey{
+			{Key: newBoxKey(t).GetPublicKey()},
+			{Key: newBoxKey(t).GetPublicKey(), NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+		},
+		SigningKeys: []BundledSigningKey{
+			{Key: sk1.GetPublicKey()},
+			{Key: sk2.GetPublicKey(), NotAfter: now.Add(-time.Minute)},
+		},
+	}
+	return b, []SigningSecretKey{sk2, sk1}
+}
+
+func requireSameKeyBundle(t *testing.T, expected KeyBundle, actual *KeyBundle) {
+	require.True(t, expected.Created.Equal(actual.Created))
+	require.Len(t, actual.BoxKeys, len(expected.BoxKeys))
+	for i, k := range expected.BoxKeys {
+		require.True(t, PublicKeyEqual(k.Key, actual.BoxKeys[i].Key))
+		require.True(t, k.NotBefore.Equal(actual.BoxKeys[i].NotBefore))
+		require.True(t, k.NotAfter.Equal(actual.BoxKeys[i].NotAfter))
+	}
+	require.Len(t, actual.SigningKeys, len(expected.SigningKeys))
+	for i, k := range expected.SigningKeys {
+		require.True(t, PublicKeyEqual(k.Key, actual.SigningKeys[i].Key))
+		require.True(t, k.NotBefore.Equal(actual.SigningKeys[i].NotBefore))
+		require.True(t, k.NotAfter.Equal(actual.SigningKeys[i].NotAfter))
+	}
+}
+
+func TestKeyBundleRoundTrip(t *testing.T) {
+	b, signers := makeTestKeyBundle(t)
+	signed, err := SignKeyBundle(b, signers)
+	require.NoError(t, err)
+
+	verified, err := VerifyKeyBundle(signed, kr)
+	require.NoError(t, err)
+	requireSameKeyBundle(t, b, verified)
+
+	boxKeys := verified.BoxPublicKeysAt(b.Created)
+	require.Len(t, boxKeys, 2)
+	boxKeys = verified.BoxPublicKeysAt(b.Created.Add(2 * time.Hour))
+	require.Len(t, boxKeys, 1)
+	require.True(t, PublicKeyEqual(b.BoxKeys[0].Key, boxKeys[0]))
+
+	// The second signing key has expired, so it can't verify anything.
+	sigKeyring := verified.SigKeyring()
+	require.NotNil(t, sigKeyring.LookupSigningPublicKey(b.SigningKeys[0].Key.ToKID()))
+	require.Nil(t, sigKeyring.LookupSigningPublicKey(b.SigningKeys[1].Key.ToKID()))
+	_, status := sigKeyring.LookupSigningPublicKeyWithStatus(b.SigningKeys[1].Key.ToKID())
+	require.IsType(t, ErrExpiredKey{}, status.Check(nil, time.Now()))
+
+	msg := randomMsg(t, 128)
+	sig, err := Sign(Version2(), msg, signers[1])
+	require.NoError(t, err)
+	_, _, err = Verify(SingleVersionValidator(Version2()), sig, sigKeyring)
+	require.NoError(t, err)
+	sig, err = Sign(Version2(), msg, signers[0])
+	require.NoError(t, err)
+	_, _, err = Verify(SingleVersionValidator(Version2()), sig, sigKeyring)
+	require.IsType(t, ErrExpiredKey{}, err)
+}
+
+func TestKeyBundleArmor62(t *testing.T) {
+	b, signers := makeTestKeyBundle(t)
+	armored, err := SignKeyBundleArmor62(b, signers, "ACME")
+	require.NoError(t, err)
+	require.Contains(t, armored, "BEGIN ACME SALTPACK KEY BUNDLE.")
+
+	verified, brand, err := Dearmor62VerifyKeyBundle(armored, kr)
+	require.NoError(t, err)
+	require.Equal(t, "ACME", brand)
+	requireSameKeyBundle(t, b, verified)
+
+	sig, err := SignArmor62(Version2(), randomMsg(t, 10), signers[0], "")
+	require.NoError(t, err)
+	_, _, err = Dearmor62VerifyKeyBundle(sig, kr)
+	require.Error(t, err)
+}
+
+func TestKeyBundleSignErrors(t *testing.T) {
+	b, signers := makeTestKeyBundle(t)
+
+	_, err := SignKeyBundle(b, signers[:1])
+	require.IsType(t, ErrInvalidParameter{}, err)
+
+	_, err = SignKeyBundle(KeyBundle{BoxKeys: b.BoxKeys}, nil)
+	require.IsType(t, ErrInvalidParameter{}, err)
+
+	bad := b
+	bad.BoxKeys = []BundledBoxKey{{Key: b.BoxKeys[0].Key, NotBefore: b.Created, NotAfter: b.Created}}
+	_, err = SignKeyBundle(bad, signers)
+	require.IsType(t, ErrBadKeyBundle(""), err)
+}
+
+func TestKeyBundleVerifyErrors(t *testing.T) {
+	b, signers := makeTestKeyBundle(t)
+	signed, err := SignKeyBundle(b, signers)
+	require.NoError(t, err)
+
+	var sb signedKeyBundle
+	require.NoError(t, decodeFromBytes(&sb, signed))
+	reencode := func(sb signedKeyBundle) []byte {
+		out, err := encodeToBytes(sb)
+		require.NoError(t, err)
+		return out
+	}
+
+	// A tampered body fails the signatures.
+	var body keyBundleBody
+	require.NoError(t, decodeFromBytes(&body, sb.Body))
+	body.Keys[0].KID = newBoxKey(t).GetPublicKey().ToKID()
+	tampered := sb
+	tampered.Body, err = encodeToBytes(body)
+	require.NoError(t, err)
+	_, err = VerifyKeyBundle(reencode(tampered), kr)
+	require.Equal(t, ErrBadSignature, err)
+
+	// So does a tampered signature.
+	tampered = sb
+	tampered.Sigs = [][]byte{sb.Sigs[0], append([]byte(nil), sb.Sigs[1]...)}
+	tampered.Sigs[1][0] ^= 1
+	_, err = VerifyKeyBundle(reencode(tampered), kr)
+	require.Equal(t, ErrBadSignature, err)
+
+	// Every signing key has to sign.
+	tampered = sb
+	tampered.Sigs = sb.Sigs[:1]
+	_, err = VerifyKeyBundle(reencode(tampered), kr)
+	require.IsType(t, ErrBadKeyBundle(""), err)
+
+	// An unknown signing key fails.
+	_, err = VerifyKeyBundle(signed, newKeyring())
+	require.IsType(t, ErrNoSenderKey{}, err)
+
+	_, err = VerifyKeyBundle([]byte("not a key bundle"), kr)
+	require.IsType(t, ErrBadKeyBundle(""), err)
+}
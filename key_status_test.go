@@ -0,0 +1,97 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// statusKeyring is a test keyring that also knows about key statuses.
+type statusKeyring struct {
+	*keyring
+	status map[string]KeyStatus
+}
+
+func (r statusKeyring) LookupSigningPublicKeyWithStatus(kid []byte) (SigningPublicKey, KeyStatus) {
+	return r.keyring.LookupSigningPublicKey(kid), r.status[hex.EncodeToString(kid)]
+}
+
+var _ SigKeyringWithStatus = statusKeyring{}
+
+func TestKeyStatusCheck(t *testing.T) {
+	now := time.Now()
+	kid := []byte("kid")
+	require.NoError(t, KeyStatus{}.Check(kid, now))
+	require.NoError(t, KeyStatus{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)}.Check(kid, now))
+
+	err := KeyStatus{NotAfter: now}.Check(kid, now)
+	require.Equal(t, ErrExpiredKey{Sender: kid, NotAfter: now, Now: now}, err)
+	require.Contains(t, err.Error(), "expired")
+	err = KeyStatus{NotBefore: now.Add(time.Hour)}.Check(kid, now)
+	require.IsType(t, ErrExpiredKey{}, err)
+	require.Contains(t, err.Error(), "isn't valid until")
+
+	// Revocation trumps everything else.
+	err = KeyStatus{NotAfter: now, Revoked: true, Reason: "compromised"}.Check(kid, now)
+	require.Equal(t, ErrRevokedKey{Sender: kid, Reason: "compromised"}, err)
+	require.Equal(t, "sender key was revoked: compromised", err.Error())
+}
+
+func TestVerifyKeyStatus(t *testing.T) {
+	msg := []byte("hello world")
+	kr := statusKeyring{keyring: makeEmptyKeyring(), status: make(map[string]KeyStatus)}
+	sender := makeSigningKey(t, kr.keyring)
+	receiverKeyring, receiverBoxKeys := makeKeyringWithOneKey(t)
+	receiverKeyring.insertSigningKey(sender)
+	kid := sender.GetPublicKey().ToKID()
+
+	signed, err := Sign(Version2(), msg, sender)
+	require.NoError(t, err)
+	detached, err := SignDetached(Version2(), msg, sender)
+	require.NoError(t, err)
+	sealed, err := SigncryptSeal(msg, ephemeralKeyCreator{}, sender, receiverBoxKeys, nil)
+	require.NoError(t, err)
+	open := statusKeyring{keyring: receiverKeyring, status: kr.status}
+
+	check := func(expected error) {
+		_, _, err := Verify(SingleVersionValidator(Version2()), signed, kr)
+		require.Equal(t, expected, err)
+		_, err = VerifyDetached(SingleVersionValidator(Version2()), msg, detached, kr)
+		require.Equal(t, expected, err)
+		_, _, err = SigncryptOpen(sealed, open, nil)
+		require.Equal(t, expected, err)
+	}
+
+	check(nil)
+
+	expired := KeyStatus{NotAfter: time.Now().Add(-time.Hour)}
+	kr.status[hex.EncodeToString(kid)] = expired
+	_, _, err = Verify(SingleVersionValidator(Version2()), signed, kr)
+	require.IsType(t, ErrExpiredKey{}, err)
+	require.Equal(t, expired.NotAfter, err.(ErrExpiredKey).NotAfter)
+
+	revoked := KeyStatus{Revoked: true, Reason: "lost laptop"}
+	kr.status[hex.EncodeToString(kid)] = revoked
+	check(ErrRevokedKey{Sender: kid, Reason: "lost laptop"})
+
+	// The combinators pass statuses through, and don't return unusable
+	// keys from LookupSigningPublicKey.
+	for _, sk := range []SigKeyring{
+		ChainSigKeyrings(kr, makeEmptyKeyring()),
+		FilterSigKeyring(kr, DenyKIDs()),
+		CacheSigKeyring(kr, CacheOptions{}),
+	} {
+		_, _, err = Verify(SingleVersionValidator(Version2()), signed, sk)
+		require.Equal(t, ErrRevokedKey{Sender: kid, Reason: "lost laptop"}, err)
+		require.Nil(t, sk.LookupSigningPublicKey(kid))
+	}
+
+	// A key that was never found is still just missing.
+	_, _, err = Verify(SingleVersionValidator(Version2()), signed, statusKeyring{keyring: makeEmptyKeyring()})
+	require.Equal(t, ErrNoSenderKey{Sender: kid}, err)
+}
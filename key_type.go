@@ -0,0 +1,94 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"crypto/mlkem"
+	"fmt"
+)
+
+// KeyType says which algorithm a key is for. The values are the ones
+// Keybase uses in its key IDs.
+type KeyType byte
+
+const (
+	// KeyTypeEd25519 is for Ed25519 signing keys.
+	KeyTypeEd25519 KeyType = 0x20
+	// KeyTypeCurve25519 is for Curve25519 box keys.
+	KeyTypeCurve25519 KeyType = 0x21
+	// KeyTypeX25519MLKEM768 is for hybrid box keys, made of a Curve25519
+	// key and an ML-KEM-768 key, for V3 receivers. It's experimental,
+	// and isn't a Keybase key type.
+	KeyTypeX25519MLKEM768 KeyType = 0x30
+)
+
+func (t KeyType) String() string {
+	switch t {
+	case KeyTypeEd25519:
+		return "an Ed25519 signing key"
+	case KeyTypeCurve25519:
+		return "a Curve25519 box key"
+	case KeyTypeX25519MLKEM768:
+		return "an X25519+ML-KEM-768 hybrid box key"
+	default:
+		return fmt.Sprintf("an unknown key type (0x%02x)", byte(t))
+	}
+}
+
+// PublicKeyLen returns the length of the public keys of this type, or 0
+// if the type is unknown. A hybrid public key is its Curve25519 key
+// followed by its ML-KEM-768 encapsulation key.
+func (t KeyType) PublicKeyLen() int {
+	switch t {
+	case KeyTypeEd25519, KeyTypeCurve25519:
+		return 32
+	case KeyTypeX25519MLKEM768:
+		return 32 + mlkem.EncapsulationKeySize768
+	default:
+		return 0
+	}
+}
+
+// kidLen returns the length of the untyped key IDs of this type, or 0 if
+// the type is unknown. The key ID of a hybrid key is that of its
+// Curve25519 key.
+func (t KeyType) kidLen() int {
+	switch t {
+	case KeyTypeEd25519, KeyTypeCurve25519, KeyTypeX25519MLKEM768:
+		return 32
+	default:
+		return 0
+	}
+}
+
+const (
+	typedKIDVersion = 0x01
+	typedKIDSuffix  = 0x0a
+)
+
+// TypedKID returns a key ID that says which type of key it's for. kid is
+// the output of ToKID, which has to stay as it is, since it goes into
+// message headers. The typed key ID is the version byte 0x01, the key
+// type, kid, and then the byte 0x0a, which is the layout Keybase uses.
+func TypedKID(typ KeyType, kid []byte) []byte {
+	ret := make([]byte, 0, len(kid)+3)
+	ret = append(ret, typedKIDVersion, byte(typ))
+	ret = append(ret, kid...)
+	return append(ret, typedKIDSuffix)
+}
+
+// ParseTypedKID splits a key ID made by TypedKID back into the key type
+// and the key ID that ToKID returned. It returns ErrBadTypedKID if the
+// key ID is malformed, or for an unknown type of key.
+func ParseTypedKID(tkid []byte) (typ KeyType, kid []byte, err error) {
+	if len(tkid) < 3 || tkid[0] != typedKIDVersion || tkid[len(tkid)-1] != typedKIDSuffix {
+		return 0, nil, ErrBadTypedKID
+	}
+	typ = KeyType(tkid[1])
+	kid = tkid[2 : len(tkid)-1]
+	if n := typ.kidLen(); n == 0 || n != len(kid) {
+		return 0, nil, ErrBadTypedKID
+	}
+	return typ, kid, nil
+}
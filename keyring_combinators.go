@@ -0,0 +1,539 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Keyring combinators, for putting together keyrings from different
+// places, like a device keyring, a team keyring and a remote key
+// directory, without having to wrap every method by hand.
+
+type keyringChain []Keyring
+
+// ChainKeyrings returns a Keyring that asks each of the given keyrings in
+// turn, and uses the first one that has an answer. GetAllBoxSecretKeys
+// returns the keys from all of them, with duplicates removed. New
+// ephemeral keys come from the first keyring.
+func ChainKeyrings(keyrings ...Keyring) Keyring {
+	return keyringChain(keyrings)
+}
+
+func (c keyringChain) CreateEphemeralKey() (BoxSecretKey, error) {
+	if len(c) == 0 {
+		return nil, ErrInvalidParameter{message: "no keyrings in chain"}
+	}
+	return c[0].CreateEphemeralKey()
+}
+
+func (c keyringChain) LookupBoxSecretKey(kids [][]byte) (int, BoxSecretKey) {
+	for _, k := range c {
+		if i, sk := k.LookupBoxSecretKey(kids); sk != nil {
+			return i, sk
+		}
+	}
+	return -1, nil
+}
+
+func (c keyringChain) LookupBoxPublicKey(kid []byte) BoxPublicKey {
+	for _, k := range c {
+		if pk := k.LookupBoxPublicKey(kid); pk != nil {
+			return pk
+		}
+	}
+	return nil
+}
+
+func (c keyringChain) GetAllBoxSecretKeys() []BoxSecretKey {
+	var ret []BoxSecretKey
+	seen := make(map[string]bool)
+	for _, k := range c {
+		for _, sk := range k.GetAllBoxSecretKeys() {
+			kid := string(sk.GetPublicKey().ToKID())
+			if !seen[kid] {
+				seen[kid] = true
+				ret = append(ret, sk)
+			}
+		}
+	}
+	return ret
+}
+
+func (c keyringChain) ImportBoxEphemeralKey(kid []byte) BoxPublicKey {
+	for _, k := range c {
+		if pk := k.ImportBoxEphemeralKey(kid); pk != nil {
+			return pk
+		}
+	}
+	return nil
+}
+
+type sigKeyringChain []SigKeyring
+
+// ChainSigKeyrings returns a SigKeyring that asks each of the given
+// keyrings in turn, and uses the first one that has an answer. That
+// includes an answer that the key has been revoked or has expired, for
+// keyrings that are SigKeyringWithStatus.
+func ChainSigKeyrings(keyrings ...SigKeyring) SigKeyring {
+	return sigKeyringChain(keyrings)
+}
+
+func (c sigKeyringChain) LookupSigningPublicKey(kid []byte) SigningPublicKey {
+	return usableSigningPublicKey(c, kid)
+}
+
+func (c sigKeyringChain) LookupSigningPublicKeyWithStatus(kid []byte) (SigningPublicKey, KeyStatus) {
+	for _, k := range c {
+		if pk, status := lookupSigningPublicKeyWithStatus(k, kid); pk != nil {
+			return pk, status
+		}
+	}
+	return nil, KeyStatus{}
+}
+
+// usableSigningPublicKey implements LookupSigningPublicKey in terms of
+// LookupSigningPublicKeyWithStatus, for the combinators, so that the two
+// always agree.
+func usableSigningPublicKey(k SigKeyringWithStatus, kid []byte) SigningPublicKey {
+	pk, status := k.LookupSigningPublicKeyWithStatus(kid)
+	if pk == nil || status.Check(kid, time.Now()) != nil {
+		return nil
+	}
+	return pk
+}
+
+type signcryptKeyring struct {
+	Keyring
+	SigKeyringWithStatus
+}
+
+// ChainSigncryptKeyrings is ChainKeyrings and ChainSigKeyrings together.
+func ChainSigncryptKeyrings(keyrings ...SigncryptKeyring) SigncryptKeyring {
+	c := make(keyringChain, len(keyrings))
+	sc := make(sigKeyringChain, len(keyrings))
+	for i, k := range keyrings {
+		c[i] = k
+		sc[i] = k
+	}
+	return signcryptKeyring{c, sc}
+}
+
+type resolverChain []SymmetricKeyResolver
+
+// ChainSymmetricKeyResolvers returns a SymmetricKeyResolver that asks each
+// of the given resolvers in turn about the identifiers that the ones
+// before it couldn't resolve.
+func ChainSymmetricKeyResolvers(resolvers ...SymmetricKeyResolver) SymmetricKeyResolver {
+	return resolverChain(resolvers)
+}
+
+func (c resolverChain) ResolveKeys(identifiers [][]byte) ([]*SymmetricKey, error) {
+	ret := make([]*SymmetricKey, len(identifiers))
+	indices := make([]int, len(identifiers))
+	for i := range indices {
+		indices[i] = i
+	}
+	for _, r := range c {
+		if len(indices) == 0 {
+			break
+		}
+		ids := make([][]byte, len(indices))
+		for j, i := range indices {
+			ids[j] = identifiers[i]
+		}
+		keys, err := r.ResolveKeys(ids)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) != len(ids) {
+			return nil, ErrWrongNumberOfKeys
+		}
+		var unresolved []int
+		for j, i := range indices {
+			if keys[j] != nil {
+				ret[i] = keys[j]
+			} else {
+				unresolved = append(unresolved, i)
+			}
+		}
+		indices = unresolved
+	}
+	return ret, nil
+}
+
+// KIDFilter says whether the key with the given key ID may be used.
+type KIDFilter func(kid []byte) bool
+
+// AllowKIDs returns a KIDFilter that allows only the given key IDs.
+func AllowKIDs(kids ...[]byte) KIDFilter {
+	set := kidSet(kids)
+	return func(kid []byte) bool { return set[string(kid)] }
+}
+
+// DenyKIDs returns a KIDFilter that allows every key ID but the given
+// ones.
+func DenyKIDs(kids ...[]byte) KIDFilter {
+	set := kidSet(kids)
+	return func(kid []byte) bool { return !set[string(kid)] }
+}
+
+func kidSet(kids [][]byte) map[string]bool {
+	set := make(map[string]bool, len(kids))
+	for _, kid := range kids {
+		set[string(kid)] = true
+	}
+	return set
+}
+
+type filteredKeyring struct {
+	Keyring
+	allow KIDFilter
+}
+
+// FilterKeyring returns a Keyring that only finds the keys whose key IDs
+// pass the filter. Ephemeral keys aren't filtered.
+func FilterKeyring(k Keyring, allow KIDFilter) Keyring {
+	return filteredKeyring{k, allow}
+}
+
+func (f filteredKeyring) LookupBoxSecretKey(kids [][]byte) (int, BoxSecretKey) {
+	var allowed [][]byte
+	var indices []int
+	for i, kid := range kids {
+		if f.allow(kid) {
+			allowed = append(allowed, kid)
+			indices = append(indices, i)
+		}
+	}
+	if len(allowed) == 0 {
+		return -1, nil
+	}
+	i, sk := f.Keyring.LookupBoxSecretKey(allowed)
+	if sk == nil || i < 0 || i >= len(indices) {
+		return -1, nil
+	}
+	return indices[i], sk
+}
+
+func (f filteredKeyring) LookupBoxPublicKey(kid []byte) BoxPublicKey {
+	if !f.allow(kid) {
+		return nil
+	}
+	return f.Keyring.LookupBoxPublicKey(kid)
+}
+
+func (f filteredKeyring) GetAllBoxSecretKeys() []BoxSecretKey {
+	var ret []BoxSecretKey
+	for _, sk := range f.Keyring.GetAllBoxSecretKeys() {
+		if f.allow(sk.GetPublicKey().ToKID()) {
+			ret = append(ret, sk)
+		}
+	}
+	return ret
+}
+
+type filteredSigKeyring struct {
+	k     SigKeyring
+	allow KIDFilter
+}
+
+// FilterSigKeyring returns a SigKeyring that only finds the keys whose
+// key IDs pass the filter.
+func FilterSigKeyring(k SigKeyring, allow KIDFilter) SigKeyring {
+	return filteredSigKeyring{k, allow}
+}
+
+func (f filteredSigKeyring) LookupSigningPublicKey(kid []byte) SigningPublicKey {
+	return usableSigningPublicKey(f, kid)
+}
+
+func (f filteredSigKeyring) LookupSigningPublicKeyWithStatus(kid []byte) (SigningPublicKey, KeyStatus) {
+	if !f.allow(kid) {
+		return nil, KeyStatus{}
+	}
+	return lookupSigningPublicKeyWithStatus(f.k, kid)
+}
+
+// FilterSigncryptKeyring is FilterKeyring and FilterSigKeyring together.
+func FilterSigncryptKeyring(k SigncryptKeyring, allow KIDFilter) SigncryptKeyring {
+	return signcryptKeyring{FilterKeyring(k, allow), filteredSigKeyring{k, allow}}
+}
+
+type filteredResolver struct {
+	r     SymmetricKeyResolver
+	allow KIDFilter
+}
+
+// FilterSymmetricKeyResolver returns a SymmetricKeyResolver that only
+// resolves the identifiers that pass the filter.
+func FilterSymmetricKeyResolver(r SymmetricKeyResolver, allow KIDFilter) SymmetricKeyResolver {
+	return filteredResolver{r, allow}
+}
+
+func (f filteredResolver) ResolveKeys(identifiers [][]byte) ([]*SymmetricKey, error) {
+	var allowed [][]byte
+	var indices []int
+	for i, id := range identifiers {
+		if f.allow(id) {
+			allowed = append(allowed, id)
+			indices = append(indices, i)
+		}
+	}
+	ret := make([]*SymmetricKey, len(identifiers))
+	if len(allowed) == 0 {
+		return ret, nil
+	}
+	keys, err := f.r.ResolveKeys(allowed)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != len(allowed) {
+		return nil, ErrWrongNumberOfKeys
+	}
+	for j, i := range indices {
+		ret[i] = keys[j]
+	}
+	return ret, nil
+}
+
+// CacheOptions bound the caches made by CacheKeyring and friends.
+type CacheOptions struct {
+	// TTL is how long a key that was found stays cached. Zero means
+	// forever.
+	TTL time.Duration
+	// NegativeTTL is how long a miss stays cached. Zero means misses
+	// aren't cached.
+	NegativeTTL time.Duration
+	// MaxEntries is the most entries the cache holds, after which the
+	// least recently used are evicted. Zero means 1024.
+	MaxEntries int
+}
+
+const defaultCacheMaxEntries = 1024
+
+type cacheEntry[V any] struct {
+	key     string
+	val     V
+	miss    bool
+	expires time.Time // zero for never
+}
+
+// lruCache is a size-bounded LRU cache whose entries expire.
+type lruCache[V any] struct {
+	opts CacheOptions
+	now  func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // of *cacheEntry[V], most recently used first
+	entries map[string]*list.Element
+}
+
+func newLRUCache[V any](opts CacheOptions) *lruCache[V] {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = defaultCacheMaxEntries
+	}
+	return &lruCache[V]{
+		opts:    opts,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached value, whether it's a cached miss, and whether
+// anything was cached at all.
+func (c *lruCache[V]) get(key string) (val V, miss bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return val, false, false
+	}
+	e := el.Value.(*cacheEntry[V])
+	if !e.expires.IsZero() && !c.now().Before(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return val, false, false
+	}
+	c.order.MoveToFront(el)
+	return e.val, e.miss, true
+}
+
+func (c *lruCache[V]) put(key string, val V, miss bool) {
+	ttl := c.opts.TTL
+	if miss {
+		if c.opts.NegativeTTL <= 0 {
+			return
+		}
+		ttl = c.opts.NegativeTTL
+	}
+	e := &cacheEntry[V]{key: key, val: val, miss: miss}
+	if ttl > 0 {
+		e.expires = c.now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.opts.MaxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry[V]).key)
+	}
+}
+
+type cachedKeyring struct {
+	Keyring
+	secretKeys *lruCache[BoxSecretKey]
+	publicKeys *lruCache[BoxPublicKey]
+	allKeys    *lruCache[[]BoxSecretKey]
+}
+
+// CacheKeyring returns a Keyring that caches the answers of k, which is
+// presumably slow, like a remote key directory. Ephemeral keys aren't
+// cached, since they're only ever seen once. Since a Keyring can return any
+// of the keys it finds, misses are only cached when none of the key IDs
+// asked about has a key.
+func CacheKeyring(k Keyring, opts CacheOptions) Keyring {
+	return &cachedKeyring{
+		Keyring:    k,
+		secretKeys: newLRUCache[BoxSecretKey](opts),
+		publicKeys: newLRUCache[BoxPublicKey](opts),
+		allKeys:    newLRUCache[[]BoxSecretKey](opts),
+	}
+}
+
+func (c *cachedKeyring) LookupBoxSecretKey(kids [][]byte) (int, BoxSecretKey) {
+	// Answer from the cache for as long as we know about every key ID in
+	// order, since the first one that has a key wins.
+	start := len(kids)
+	for i, kid := range kids {
+		sk, miss, ok := c.secretKeys.get(string(kid))
+		if !ok {
+			start = i
+			break
+		}
+		if !miss {
+			return i, sk
+		}
+	}
+	if start == len(kids) {
+		return -1, nil
+	}
+
+	i, sk := c.Keyring.LookupBoxSecretKey(kids[start:])
+	if sk == nil || i < 0 || i >= len(kids)-start {
+		for _, kid := range kids[start:] {
+			c.secretKeys.put(string(kid), nil, true)
+		}
+		return -1, nil
+	}
+	c.secretKeys.put(string(kids[start+i]), sk, false)
+	return start + i, sk
+}
+
+func (c *cachedKeyring) LookupBoxPublicKey(kid []byte) BoxPublicKey {
+	if pk, _, ok := c.publicKeys.get(string(kid)); ok {
+		return pk
+	}
+	pk := c.Keyring.LookupBoxPublicKey(kid)
+	c.publicKeys.put(string(kid), pk, pk == nil)
+	return pk
+}
+
+func (c *cachedKeyring) GetAllBoxSecretKeys() []BoxSecretKey {
+	if keys, _, ok := c.allKeys.get(""); ok {
+		return keys
+	}
+	keys := c.Keyring.GetAllBoxSecretKeys()
+	c.allKeys.put("", keys, false)
+	return keys
+}
+
+type signingKeyWithStatus struct {
+	pk     SigningPublicKey
+	status KeyStatus
+}
+
+type cachedSigKeyring struct {
+	k          SigKeyring
+	publicKeys *lruCache[signingKeyWithStatus]
+}
+
+// CacheSigKeyring returns a SigKeyring that caches the answers of k. Key
+// statuses are cached along with the keys, so a revocation takes up to
+// opts.TTL to be noticed.
+func CacheSigKeyring(k SigKeyring, opts CacheOptions) SigKeyring {
+	return newCachedSigKeyring(k, opts)
+}
+
+func newCachedSigKeyring(k SigKeyring, opts CacheOptions) *cachedSigKeyring {
+	return &cachedSigKeyring{k: k, publicKeys: newLRUCache[signingKeyWithStatus](opts)}
+}
+
+func (c *cachedSigKeyring) LookupSigningPublicKey(kid []byte) SigningPublicKey {
+	return usableSigningPublicKey(c, kid)
+}
+
+func (c *cachedSigKeyring) LookupSigningPublicKeyWithStatus(kid []byte) (SigningPublicKey, KeyStatus) {
+	if e, _, ok := c.publicKeys.get(string(kid)); ok {
+		return e.pk, e.status
+	}
+	pk, status := lookupSigningPublicKeyWithStatus(c.k, kid)
+	c.publicKeys.put(string(kid), signingKeyWithStatus{pk, status}, pk == nil)
+	return pk, status
+}
+
+// CacheSigncryptKeyring is CacheKeyring and CacheSigKeyring together.
+func CacheSigncryptKeyring(k SigncryptKeyring, opts CacheOptions) SigncryptKeyring {
+	return signcryptKeyring{CacheKeyring(k, opts), newCachedSigKeyring(k, opts)}
+}
+
+type cachedResolver struct {
+	r    SymmetricKeyResolver
+	keys *lruCache[*SymmetricKey]
+}
+
+// CacheSymmetricKeyResolver returns a SymmetricKeyResolver that caches the
+// answers of r, and only asks it about identifiers it doesn't know.
+func CacheSymmetricKeyResolver(r SymmetricKeyResolver, opts CacheOptions) SymmetricKeyResolver {
+	return &cachedResolver{r: r, keys: newLRUCache[*SymmetricKey](opts)}
+}
+
+func (c *cachedResolver) ResolveKeys(identifiers [][]byte) ([]*SymmetricKey, error) {
+	ret := make([]*SymmetricKey, len(identifiers))
+	var unknown [][]byte
+	var indices []int
+	for i, id := range identifiers {
+		if key, _, ok := c.keys.get(string(id)); ok {
+			ret[i] = key
+			continue
+		}
+		unknown = append(unknown, id)
+		indices = append(indices, i)
+	}
+	if len(unknown) == 0 {
+		return ret, nil
+	}
+	keys, err := c.r.ResolveKeys(unknown)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != len(unknown) {
+		return nil, ErrWrongNumberOfKeys
+	}
+	for j, i := range indices {
+		ret[i] = keys[j]
+		c.keys.put(string(unknown[j]), keys[j], keys[j] == nil)
+	}
+	return ret, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingKeyring counts the lookups that reach it, so that we can tell
+// whether a cache answered them.
+type countingKeyring struct {
+	*keyring
+	secretLookups, publicLookups, sigLookups, allLookups int
+}
+
+func (c *countingKeyring) LookupBoxSecretKey(kids [][]byte) (int, BoxSecretKey) {
+	c.secretLookups++
+	return c.keyring.LookupBoxSecretKey(kids)
+}
+
+func (c *countingKeyring) LookupBoxPublicKey(kid []byte) BoxPublicKey {
+	c.publicLookups++
+	return c.keyring.LookupBoxPublicKey(kid)
+}
+
+func (c *countingKeyring) LookupSigningPublicKey(kid []byte) SigningPublicKey {
+	c.sigLookups++
+	return c.keyring.LookupSigningPublicKey(kid)
+}
+
+func (c *countingKeyring) GetAllBoxSecretKeys() []BoxSecretKey {
+	c.allLookups++
+	return c.keyring.GetAllBoxSecretKeys()
+}
+
+type countingResolver struct {
+	SymmetricKeyResolver
+	resolved int
+}
+
+func (c *countingResolver) ResolveKeys(identifiers [][]byte) ([]*SymmetricKey, error) {
+	c.resolved += len(identifiers)
+	return c.SymmetricKeyResolver.ResolveKeys(identifiers)
+}
+
+func TestChainKeyrings(t *testing.T) {
+	msg := []byte("hello world")
+	first, firstKeys := makeKeyringWithOneKey(t)
+	second, secondKeys := makeKeyringWithOneKey(t)
+	sender := makeSigningKey(t, second)
+
+	chain := ChainSigncryptKeyrings(first, second)
+	i, sk := chain.LookupBoxSecretKey([][]byte{[]byte("missing"), secondKeys[0].ToKID()})
+	require.Equal(t, 1, i)
+	require.Equal(t, secondKeys[0].ToKID(), sk.GetPublicKey().ToKID())
+	i, sk = chain.LookupBoxSecretKey([][]byte{[]byte("missing")})
+	require.Equal(t, -1, i)
+	require.Nil(t, sk)
+	require.Len(t, chain.GetAllBoxSecretKeys(), 2)
+	require.Len(t, ChainKeyrings(first, first).GetAllBoxSecretKeys(), 1)
+	require.NotNil(t, chain.LookupSigningPublicKey(sender.GetPublicKey().ToKID()))
+
+	// Hidden receivers are found by trial decryption over the merged keys.
+	hidden, err := createEphemeralKey(true)
+	require.NoError(t, err)
+	second.insert(hidden)
+	receivers := []BoxPublicKey{firstKeys[0], hidden.GetPublicKey()}
+	sealed, err := SigncryptSeal(msg, ephemeralKeyCreator{}, sender, receivers, nil)
+	require.NoError(t, err)
+	_, opened, err := SigncryptOpen(sealed, ChainSigncryptKeyrings(makeEmptyKeyring(), second), nil)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+
+	_, err = ChainKeyrings().CreateEphemeralKey()
+	require.IsType(t, ErrInvalidParameter{}, err)
+}
+
+func TestChainSymmetricKeyResolvers(t *testing.T) {
+	r1, keys1 := makeResolverWithOneKey()
+	keys2 := []ReceiverSymmetricKey{{Key: SymmetricKey{1}, Identifier: []byte("other identifier")}}
+	r2 := &testConstResolver{hardcodedReceivers: keys2}
+	counted := &countingResolver{SymmetricKeyResolver: r2}
+	chain := ChainSymmetricKeyResolvers(r1, counted)
+
+	ids := [][]byte{keys2[0].Identifier, []byte("missing"), keys1[0].Identifier}
+	keys, err := chain.ResolveKeys(ids)
+	require.NoError(t, err)
+	require.Equal(t, []*SymmetricKey{&keys2[0].Key, nil, &keys1[0].Key}, keys)
+	// The second resolver only hears about what the first couldn't
+	// resolve.
+	require.Equal(t, 2, counted.resolved)
+}
+
+func TestFilterKeyring(t *testing.T) {
+	kr, boxKeys := makeKeyringWithOneKey(t)
+	other, err := createEphemeralKey(false)
+	require.NoError(t, err)
+	kr.insert(other)
+	sender := makeSigningKey(t, kr)
+
+	kid, otherKID := boxKeys[0].ToKID(), other.GetPublicKey().ToKID()
+	allowed := FilterSigncryptKeyring(kr, AllowKIDs(otherKID))
+	i, sk := allowed.LookupBoxSecretKey([][]byte{kid, otherKID})
+	require.Equal(t, 1, i)
+	require.Equal(t, otherKID, sk.GetPublicKey().ToKID())
+	i, sk = allowed.LookupBoxSecretKey([][]byte{kid})
+	require.Equal(t, -1, i)
+	require.Nil(t, sk)
+	require.Len(t, allowed.GetAllBoxSecretKeys(), 1)
+	require.Nil(t, allowed.LookupBoxPublicKey(kid))
+	require.Nil(t, allowed.LookupSigningPublicKey(sender.GetPublicKey().ToKID()))
+
+	denied := FilterKeyring(kr, DenyKIDs(otherKID))
+	i, sk = denied.LookupBoxSecretKey([][]byte{otherKID, kid})
+	require.Equal(t, 1, i)
+	require.Equal(t, kid, sk.GetPublicKey().ToKID())
+	require.Len(t, denied.GetAllBoxSecretKeys(), 1)
+
+	r, keys := makeResolverWithOneKey()
+	resolved, err := FilterSymmetricKeyResolver(r, DenyKIDs(keys[0].Identifier)).ResolveKeys([][]byte{keys[0].Identifier})
+	require.NoError(t, err)
+	require.Equal(t, []*SymmetricKey{nil}, resolved)
+}
+
+func TestCacheKeyring(t *testing.T) {
+	kr, boxKeys := makeKeyringWithOneKey(t)
+	sender := makeSigningKey(t, kr)
+	counted := &countingKeyring{keyring: kr}
+	opts := CacheOptions{TTL: time.Minute, NegativeTTL: time.Second, MaxEntries: 2}
+	cached := CacheKeyring(counted, opts).(*cachedKeyring)
+	now := time.Now()
+	clock := func() time.Time { return now }
+	cached.secretKeys.now = clock
+	cached.publicKeys.now = clock
+
+	kid, missing := boxKeys[0].ToKID(), []byte("missing")
+	for range 3 {
+		i, sk := cached.LookupBoxSecretKey([][]byte{kid})
+		require.Equal(t, 0, i)
+		require.NotNil(t, sk)
+	}
+	require.Equal(t, 1, counted.secretLookups)
+
+	// Misses are cached too, but not for as long.
+	i, sk := cached.LookupBoxSecretKey([][]byte{missing})
+	require.Equal(t, -1, i)
+	require.Nil(t, sk)
+	require.Equal(t, 2, counted.secretLookups)
+	cached.LookupBoxSecretKey([][]byte{missing})
+	require.Equal(t, 2, counted.secretLookups)
+	now = now.Add(2 * time.Second)
+	cached.LookupBoxSecretKey([][]byte{missing})
+	require.Equal(t, 3, counted.secretLookups)
+	cached.LookupBoxSecretKey([][]byte{kid})
+	require.Equal(t, 3, counted.secretLookups)
+	now = now.Add(time.Minute)
+	cached.LookupBoxSecretKey([][]byte{kid})
+	require.Equal(t, 4, counted.secretLookups)
+
+	// A cache holds no more than MaxEntries.
+	for _, k := range [][]byte{[]byte("a"), []byte("b"), []byte("a")} {
+		cached.LookupBoxSecretKey([][]byte{k})
+	}
+	require.Equal(t, 6, counted.secretLookups)
+	require.Equal(t, 2, cached.secretKeys.order.Len())
+	cached.LookupBoxSecretKey([][]byte{kid})
+	require.Equal(t, 7, counted.secretLookups)
+
+	require.NotNil(t, cached.LookupBoxPublicKey(kid))
+	require.NotNil(t, cached.LookupBoxPublicKey(kid))
+	require.Equal(t, 1, counted.publicLookups)
+
+	cached.GetAllBoxSecretKeys()
+	cached.GetAllBoxSecretKeys()
+	require.Equal(t, 1, counted.allLookups)
+
+	// A cached signcryption keyring still opens messages.
+	msg := []byte("hello world")
+	sealed, err := SigncryptSeal(msg, ephemeralKeyCreator{}, sender, boxKeys, nil)
+	require.NoError(t, err)
+	cachedSigncrypt := CacheSigncryptKeyring(counted, opts)
+	for range 2 {
+		_, opened, err := SigncryptOpen(sealed, cachedSigncrypt, nil)
+		require.NoError(t, err)
+		require.Equal(t, msg, opened)
+	}
+	require.Equal(t, 1, counted.sigLookups)
+}
+
+func TestCacheSymmetricKeyResolver(t *testing.T) {
+	r, keys := makeResolverWithOneKey()
+	counted := &countingResolver{SymmetricKeyResolver: r}
+	cached := CacheSymmetricKeyResolver(counted, CacheOptions{})
+
+	ids := [][]byte{keys[0].Identifier, []byte("missing")}
+	for range 2 {
+		resolved, err := cached.ResolveKeys(ids)
+		require.NoError(t, err)
+		require.Equal(t, []*SymmetricKey{&keys[0].Key, nil}, resolved)
+	}
+	// Without a NegativeTTL, only the miss is asked about again.
+	require.Equal(t, 3, counted.resolved)
+}
@@ -29,7 +29,7 @@ func nonceForPayloadKeyBox(version Version, recip uint64) Nonce {
 	switch version.Major {
 	case 1:
 		return stringToByte24("saltpack_payload_key_box")
-	case 2:
+	case 2, 3:
 		return nonceForPayloadKeyBoxV2(recip)
 	default:
 		// Let caller be responsible for filtering out unknown
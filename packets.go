@@ -13,6 +13,19 @@ type receiverKeys struct {
 	_struct       bool   `codec:",toarray"` //nolint
 	ReceiverKID   []byte `codec:"receiver_key_id"`
 	PayloadKeyBox []byte `codec:"payloadkey"`
+	// MLKEMCiphertext is only in V3 headers, where it's the ML-KEM-768
+	// half of the hybrid key that PayloadKeyBox is sealed under. It's
+	// encoded through receiverKeysV3.
+	MLKEMCiphertext []byte `codec:"-"`
+}
+
+// receiverKeysV3 is receiverKeys, as it's encoded in V3 headers, with the
+// ML-KEM ciphertext as a third element.
+type receiverKeysV3 struct {
+	_struct         bool   `codec:",toarray"` //nolint
+	ReceiverKID     []byte `codec:"receiver_key_id"`
+	PayloadKeyBox   []byte `codec:"payloadkey"`
+	MLKEMCiphertext []byte `codec:"mlkem_ciphertext"`
 }
 
 // Version is a major.minor pair that shows the version of the whole file
@@ -81,11 +94,73 @@ func (b *encryptionBlockV2) CodecDecodeSelf(d *codec.Decoder) {
 	})
 }
 
+// encryptionHeaderV3 is EncryptionHeader, as it's encoded in V3 headers,
+// with receiverKeysV3 receivers.
+type encryptionHeaderV3 struct {
+	_struct         bool             `codec:",toarray"` //nolint
+	FormatName      string           `codec:"format_name"`
+	Version         Version          `codec:"vers"`
+	Type            MessageType      `codec:"type"`
+	Ephemeral       []byte           `codec:"ephemeral"`
+	SenderSecretbox []byte           `codec:"sendersecretbox"`
+	Receivers       []receiverKeysV3 `codec:"rcvrs"`
+}
+
+// encodeHeader encodes an encryption or signcryption header, with
+// receivers of the form its version calls for.
+func encodeHeader(h *EncryptionHeader) ([]byte, error) {
+	if h.Version.Major != Version3().Major {
+		return encodeToBytes(h)
+	}
+	hV3 := encryptionHeaderV3{
+		FormatName:      h.FormatName,
+		Version:         h.Version,
+		Type:            h.Type,
+		Ephemeral:       h.Ephemeral,
+		SenderSecretbox: h.SenderSecretbox,
+		Receivers:       make([]receiverKeysV3, 0, len(h.Receivers)),
+	}
+	for _, r := range h.Receivers {
+		hV3.Receivers = append(hV3.Receivers, receiverKeysV3{
+			ReceiverKID:     r.ReceiverKID,
+			PayloadKeyBox:   r.PayloadKeyBox,
+			MLKEMCiphertext: r.MLKEMCiphertext,
+		})
+	}
+	return encodeToBytes(hV3)
+}
+
+// decodeHeader decodes the output of encodeHeader. V1 and V2 headers are
+// decoded directly; V3 ones are decoded again as an encryptionHeaderV3 to
+// get their ML-KEM ciphertexts.
+func decodeHeader(b []byte, h *EncryptionHeader) error {
+	if err := decodeFromBytes(h, b); err != nil {
+		return err
+	}
+	if h.Version.Major != Version3().Major {
+		return nil
+	}
+	var hV3 encryptionHeaderV3
+	if err := decodeFromBytes(&hV3, b); err != nil {
+		return err
+	}
+	if len(hV3.Receivers) != len(h.Receivers) {
+		return ErrBadReceivers
+	}
+	for i, r := range hV3.Receivers {
+		h.Receivers[i].MLKEMCiphertext = r.MLKEMCiphertext
+	}
+	return nil
+}
+
 func (h *EncryptionHeader) validate(versionValidator func(Version) error) error {
 	if h.Type != MessageTypeEncryption {
 		return ErrWrongMessageType{MessageTypeEncryption, h.Type}
 	}
-	return versionValidator(h.Version)
+	if err := versionValidator(h.Version); err != nil {
+		return err
+	}
+	return checkMLKEMCiphertexts(h.Version, h.Receivers)
 }
 
 // The SigncryptionHeader has exactly the same structure as the
@@ -99,14 +174,18 @@ type signcryptionBlock struct {
 	IsFinal           bool   `codec:"final"`
 }
 
-func (h *SigncryptionHeader) validate() error {
+func (h *SigncryptionHeader) validate(versionValidator VersionValidator) error {
 	if h.Type != MessageTypeSigncryption {
 		return ErrWrongMessageType{MessageTypeSigncryption, h.Type}
 	}
-	if h.Version.Major != Version2().Major {
+	// There's no V1 of signcryption.
+	if h.Version.Major != Version2().Major && h.Version.Major != Version3().Major {
 		return ErrBadVersion{h.Version}
 	}
-	return nil
+	if err := versionValidator(h.Version); err != nil {
+		return err
+	}
+	return checkMLKEMCiphertexts(h.Version, h.Receivers)
 }
 
 // SignatureHeader is the first packet in a signed message.
@@ -0,0 +1,195 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KDFParams are the parameters for Argon2id, which derives keys from
+// passphrases. Whatever they are, they're stored alongside what they
+// protect, so they only matter when encrypting.
+type KDFParams struct {
+	// Time is the number of passes over the memory.
+	Time uint32
+	// Memory is the amount of memory used, in KiB.
+	Memory uint32
+	// Threads is the degree of parallelism.
+	Threads uint8
+}
+
+// DefaultKDFParams returns the parameters we use when none are given,
+// which are the ones RFC 9106 recommends for when 64 MiB of memory is
+// all that can be spared.
+func DefaultKDFParams() KDFParams {
+	return KDFParams{Time: 3, Memory: 64 * 1024, Threads: 4}
+}
+
+// Limits on the parameters we'll accept from a message or a key file, so
+// that a malicious one can't make us use unbounded time or memory. A
+// message can ask for up to maxPassphraseReceivers derivations before
+// anything is authenticated, so these are kept to a few times
+// DefaultKDFParams.
+const (
+	maxKDFTime   = 16
+	maxKDFMemory = 1024 * 1024 // 1 GiB
+)
+
+// Validate returns an ErrInvalidParameter if the parameters are unusable,
+// or big enough that we'd refuse to use them for decryption.
+func (p KDFParams) Validate() error {
+	if p.Time < 1 || p.Time > maxKDFTime || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) || p.Memory > maxKDFMemory {
+		return ErrInvalidParameter{message: fmt.Sprintf("bad KDF parameters %+v", p)}
+	}
+	return nil
+}
+
+// DeriveKey derives a symmetric key from the passphrase and salt.
+func (p KDFParams) DeriveKey(passphrase []byte, salt []byte) *SymmetricKey {
+	var key SymmetricKey
+	copy(key[:], argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Threads, uint32(len(key))))
+	return &key
+}
+
+// passphraseIdentifierFormatName marks receiver identifiers made by
+// NewPassphraseReceiver.
+const passphraseIdentifierFormatName = FormatName + " passphrase"
+
+const passphraseSaltLen = 16
+
+// passphraseIdentifier is the msgpack form of the identifier of a
+// passphrase receiver. It holds everything but the passphrase itself that
+// we need to derive the receiver's key.
+type passphraseIdentifier struct {
+	_struct    bool   `codec:",toarray"` //nolint
+	FormatName string `codec:"format_name"`
+	Version    int    `codec:"vers"`
+	Salt       []byte `codec:"salt"`
+	Time       uint32 `codec:"time"`
+	Memory     uint32 `codec:"memory"`
+	Threads    uint8  `codec:"threads"`
+}
+
+func (id passphraseIdentifier) params() KDFParams {
+	return KDFParams{Time: id.Time, Memory: id.Memory, Threads: id.Threads}
+}
+
+// parsePassphraseIdentifier returns the parsed identifier, and true, if
+// the identifier was made by NewPassphraseReceiver with parameters we're
+// willing to use.
+func parsePassphraseIdentifier(identifier []byte) (id passphraseIdentifier, ok bool) {
+	if err := decodeFromBytes(&id, identifier); err != nil {
+		return id, false
+	}
+	if id.FormatName != passphraseIdentifierFormatName || id.Version != 1 || len(id.Salt) != passphraseSaltLen {
+		return id, false
+	}
+	if id.params().Validate() != nil {
+		return id, false
+	}
+	return id, true
+}
+
+// NewPassphraseReceiver makes a signcryption receiver that can be opened
+// with the given passphrase, like gpg --symmetric. The key is derived with
+// Argon2id, and a random salt and the parameters go into the identifier,
+// so that a resolver from NewPassphraseResolver can derive it again. If
+// params is nil, DefaultKDFParams are used.
+//
+// Passphrase receivers can be mixed with box key receivers, so that, for
+// example, a message can be opened either by a device key or by a
+// recovery passphrase.
+func NewPassphraseReceiver(passphrase []byte, params *KDFParams) (ReceiverSymmetricKey, error) {
+	p := DefaultKDFParams()
+	if params != nil {
+		p = *params
+	}
+	if err := p.Validate(); err != nil {
+		return ReceiverSymmetricKey{}, err
+	}
+	id := passphraseIdentifier{
+		FormatName: passphraseIdentifierFormatName,
+		Version:    1,
+		Salt:       make([]byte, passphraseSaltLen),
+		Time:       p.Time,
+		Memory:     p.Memory,
+		Threads:    p.Threads,
+	}
+	if err := csprngRead(id.Salt); err != nil {
+		return ReceiverSymmetricKey{}, err
+	}
+	identifier, err := encodeToBytes(id)
+	if err != nil {
+		return ReceiverSymmetricKey{}, err
+	}
+	key := p.DeriveKey(passphrase, id.Salt)
+	return ReceiverSymmetricKey{Key: *key, Identifier: identifier}, nil
+}
+
+// PassphrasePrompter returns the passphrase for a message. It's only
+// called for messages that have passphrase receivers. The passphrase is
+// zeroed once the keys are derived.
+type PassphrasePrompter func() ([]byte, error)
+
+type passphraseResolver struct {
+	prompt PassphrasePrompter
+	next   SymmetricKeyResolver
+}
+
+var _ SymmetricKeyResolver = passphraseResolver{}
+
+// maxPassphraseReceivers is the most passphrase receivers we'll derive
+// keys for in one message. Each derivation is expensive on purpose, so
+// we don't want a message to be able to ask for lots of them.
+const maxPassphraseReceivers = 4
+
+// NewPassphraseResolver returns a SymmetricKeyResolver that resolves the
+// receivers made by NewPassphraseReceiver. If a message has any, it calls
+// prompt once for the passphrase. Any other identifiers are passed on to
+// next, which can be nil.
+func NewPassphraseResolver(prompt PassphrasePrompter, next SymmetricKeyResolver) SymmetricKeyResolver {
+	return passphraseResolver{prompt: prompt, next: next}
+}
+
+func (r passphraseResolver) ResolveKeys(identifiers [][]byte) ([]*SymmetricKey, error) {
+	ret := make([]*SymmetricKey, len(identifiers))
+	ids := make(map[int]passphraseIdentifier)
+	var others [][]byte
+	var otherIndices []int
+	for i, identifier := range identifiers {
+		if id, ok := parsePassphraseIdentifier(identifier); ok && len(ids) < maxPassphraseReceivers {
+			ids[i] = id
+			continue
+		}
+		others = append(others, identifier)
+		otherIndices = append(otherIndices, i)
+	}
+
+	if len(ids) > 0 {
+		passphrase, err := r.prompt()
+		if err != nil {
+			return nil, err
+		}
+		defer clear(passphrase)
+		for i, id := range ids {
+			ret[i] = id.params().DeriveKey(passphrase, id.Salt)
+		}
+	}
+
+	if r.next != nil && len(others) > 0 {
+		keys, err := r.next.ResolveKeys(others)
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) != len(others) {
+			return nil, ErrWrongNumberOfKeys
+		}
+		for j, i := range otherIndices {
+			ret[i] = keys[j]
+		}
+	}
+	return ret, nil
+}
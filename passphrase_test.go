@@ -0,0 +1,166 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testKDFParams are cheap, so that tests run quickly.
+var testKDFParams = &KDFParams{Time: 1, Memory: 64, Threads: 1}
+
+func constPrompter(passphrase string, calls *int) PassphrasePrompter {
+	return func() ([]byte, error) {
+		*calls++
+		return []byte(passphrase), nil
+	}
+}
+
+func TestPassphraseReceiverMixed(t *testing.T) {
+	msg := []byte("hello world")
+	keyring, receiverBoxKeys := makeKeyringWithOneKey(t)
+	senderSigningPrivKey := makeSigningKey(t, keyring)
+
+	receiver, err := NewPassphraseReceiver([]byte("recovery passphrase"), testKDFParams)
+	require.NoError(t, err)
+	sealed, err := SigncryptSeal(msg, ephemeralKeyCreator{}, senderSigningPrivKey, receiverBoxKeys, []ReceiverSymmetricKey{receiver})
+	require.NoError(t, err)
+
+	// The device key works, without prompting.
+	calls := 0
+	_, opened, err := SigncryptOpen(sealed, keyring, NewPassphraseResolver(constPrompter("wrong", &calls), nil))
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	require.Equal(t, 0, calls)
+
+	// So does the passphrase, with a keyring that has only the sender's
+	// signing key.
+	recoveryKeyring := makeEmptyKeyring()
+	recoveryKeyring.insertSigningKey(senderSigningPrivKey)
+	_, opened, err = SigncryptOpen(sealed, recoveryKeyring, NewPassphraseResolver(constPrompter("recovery passphrase", &calls), nil))
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	require.Equal(t, 1, calls)
+
+	_, _, err = SigncryptOpen(sealed, recoveryKeyring, NewPassphraseResolver(constPrompter("wrong", &calls), nil))
+	require.Equal(t, ErrDecryptionFailed, err)
+}
+
+func TestPassphraseReceiverSeveral(t *testing.T) {
+	msg := []byte("hello world")
+	keyring := makeEmptyKeyring()
+	senderSigningPrivKey := makeSigningKey(t, keyring)
+
+	var receivers []ReceiverSymmetricKey
+	for _, p := range []string{"alice", "bob"} {
+		receiver, err := NewPassphraseReceiver([]byte(p), testKDFParams)
+		require.NoError(t, err)
+		receivers = append(receivers, receiver)
+	}
+	_, others := makeResolverWithOneKey()
+	receivers = append(receivers, others...)
+	sealed, err := SigncryptSeal(msg, ephemeralKeyCreator{}, senderSigningPrivKey, nil, receivers)
+	require.NoError(t, err)
+
+	// Either passphrase works, and other identifiers go to the next
+	// resolver.
+	calls := 0
+	for _, p := range []string{"alice", "bob"} {
+		_, opened, err := SigncryptOpen(sealed, keyring, NewPassphraseResolver(constPrompter(p, &calls), &NilResolver{}))
+		require.NoError(t, err)
+		require.Equal(t, msg, opened)
+	}
+	require.Equal(t, 2, calls)
+
+	next := &testConstResolver{hardcodedReceivers: others}
+	_, opened, err := SigncryptOpen(sealed, keyring, NewPassphraseResolver(constPrompter("carol", &calls), next))
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+}
+
+func TestPassphraseResolverErrors(t *testing.T) {
+	msg := []byte("hello world")
+	keyring := makeEmptyKeyring()
+	senderSigningPrivKey := makeSigningKey(t, keyring)
+	receiver, err := NewPassphraseReceiver([]byte("pass"), testKDFParams)
+	require.NoError(t, err)
+	sealed, err := SigncryptSeal(msg, ephemeralKeyCreator{}, senderSigningPrivKey, nil, []ReceiverSymmetricKey{receiver})
+	require.NoError(t, err)
+
+	errCanceled := errors.New("canceled")
+	prompt := func() ([]byte, error) { return nil, errCanceled }
+	_, _, err = SigncryptOpen(sealed, keyring, NewPassphraseResolver(prompt, nil))
+	require.Equal(t, errCanceled, err)
+
+	_, err = NewPassphraseReceiver([]byte("pass"), &KDFParams{Time: 1, Memory: 64, Threads: 0})
+	require.IsType(t, ErrInvalidParameter{}, err)
+}
+
+func TestParsePassphraseIdentifier(t *testing.T) {
+	receiver, err := NewPassphraseReceiver([]byte("pass"), testKDFParams)
+	require.NoError(t, err)
+	id, ok := parsePassphraseIdentifier(receiver.Identifier)
+	require.True(t, ok)
+	require.Equal(t, *testKDFParams, id.params())
+	require.Equal(t, receiver.Key, *id.params().DeriveKey([]byte("pass"), id.Salt))
+
+	for _, bad := range [][]byte{
+		nil,
+		[]byte("dummy identifier"),
+		make([]byte, 32),
+		receiver.Identifier[:len(receiver.Identifier)-1],
+	} {
+		_, ok = parsePassphraseIdentifier(bad)
+		require.False(t, ok)
+	}
+
+	// Parameters that are too expensive aren't ours to derive.
+	big := passphraseIdentifier{
+		FormatName: passphraseIdentifierFormatName,
+		Version:    1,
+		Salt:       make([]byte, passphraseSaltLen),
+		Time:       1,
+		Memory:     maxKDFMemory + 1,
+		Threads:    1,
+	}
+	b, err := encodeToBytes(big)
+	require.NoError(t, err)
+	_, ok = parsePassphraseIdentifier(b)
+	require.False(t, ok)
+}
+
+func TestPassphraseResolverSkipsExpensiveParams(t *testing.T) {
+	// Parameters like these would cost several GiB and minutes of CPU per
+	// derivation.
+	for _, p := range []KDFParams{
+		{Time: 64, Memory: 4 * 1024 * 1024, Threads: 4},
+		{Time: maxKDFTime + 1, Memory: 64, Threads: 1},
+		{Time: 1, Memory: maxKDFMemory + 1, Threads: 1},
+	} {
+		require.IsType(t, ErrInvalidParameter{}, p.Validate())
+		id := passphraseIdentifier{
+			FormatName: passphraseIdentifierFormatName,
+			Version:    1,
+			Salt:       make([]byte, passphraseSaltLen),
+			Time:       p.Time,
+			Memory:     p.Memory,
+			Threads:    p.Threads,
+		}
+		b, err := encodeToBytes(id)
+		require.NoError(t, err)
+
+		// The identifier isn't treated as a passphrase receiver, so there's
+		// no prompt, and no key derived for it.
+		prompt := func() ([]byte, error) {
+			t.Fatal("prompted for an over-limit identifier")
+			return nil, nil
+		}
+		keys, err := NewPassphraseResolver(prompt, nil).ResolveKeys([][]byte{b})
+		require.NoError(t, err)
+		require.Equal(t, []*SymmetricKey{nil}, keys)
+	}
+}
@@ -99,6 +99,8 @@ func (p *punctuatedReader) Read(out []byte) (n int, err error) {
 // ReadUntilPunctuation reads from the stream until it find a desired
 // punctuation byte. If it wasn't found before EOF, it will return io.ErrUnexpectedEOF.
 // If it wasn't found before lim bytes are consumed, then it will return ErrOverflow.
+// On error, res holds whatever was consumed before the error, so that the
+// caller can tell where in the stream it happened.
 func (p *punctuatedReader) ReadUntilPunctuation(lim int) (res []byte, err error) {
 	for {
 		var n int
@@ -111,17 +113,17 @@ func (p *punctuatedReader) ReadUntilPunctuation(lim int) (res []byte, err error)
 				return res, err
 			}
 			if len(res) >= lim {
-				return nil, ErrOverflow
+				return res, ErrOverflow
 			}
 		case errors.Is(err, io.EOF):
 			err = io.ErrUnexpectedEOF
 			fallthrough
 		default:
-			return nil, err
+			return append(res, p.buf[0:n]...), err
 		}
 
 		if n == 0 {
-			return nil, io.ErrUnexpectedEOF
+			return res, io.ErrUnexpectedEOF
 		}
 	}
 }
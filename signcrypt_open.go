@@ -15,6 +15,7 @@ import (
 )
 
 type signcryptOpenStream struct {
+	versionValidator VersionValidator
 	mps              *msgpackStream
 	payloadKey       *SymmetricKey
 	signingPublicKey SigningPublicKey
@@ -51,6 +52,12 @@ func (sos *signcryptOpenStream) getNextChunk() ([]byte, error) {
 	return chunk, nil
 }
 
+func (sos *signcryptOpenStream) wipe() {
+	sos.payloadKey.wipe()
+}
+
+var _ secretChunker = (*signcryptOpenStream)(nil)
+
 func (sos *signcryptOpenStream) readHeader() error {
 	// Read the header bytes.
 	headerBytes := []byte{}
@@ -62,7 +69,7 @@ func (sos *signcryptOpenStream) readHeader() error {
 	sos.headerHash = sha512.Sum512(headerBytes)
 	// Parse the header bytes.
 	var header SigncryptionHeader
-	err = decodeFromBytes(&header, headerBytes)
+	err = decodeHeader(headerBytes, (*EncryptionHeader)(&header))
 	if err != nil {
 		return err
 	}
@@ -74,39 +81,55 @@ func (sos *signcryptOpenStream) readHeader() error {
 }
 
 func (sos *signcryptOpenStream) tryBoxSecretKeys(hdr *SigncryptionHeader, ephemeralPub BoxPublicKey) (*SymmetricKey, error) {
-	derivedKeys := []*SymmetricKey{}
-	for _, receiverBoxSecretKey := range sos.keyring.GetAllBoxSecretKeys() {
-		derivedKey := derivedEphemeralKeyFromBoxKeys(ephemeralPub, receiverBoxSecretKey)
-		derivedKeys = append(derivedKeys, derivedKey)
-	}
+	secretKeys := candidateBoxSecretKeys(sos.keyring, ephemeralPub)
 
 	// Try each of the box secret keys against each of the receiver pairs in
-	// the message header. The actual expected number of box secret keys is
-	// one, so this shouldn't be as quadratic as it looks.
-	for receiverIndex, receiver := range hdr.Receivers {
-		for _, derivedKey := range derivedKeys {
+	// the message header. The Diffie-Hellman for each key is what's
+	// expensive, so with lots of keys, they're tried concurrently if the
+	// keys allow it. Each key that's tried writes only its own entries.
+	derivedKeys := make([]*SymmetricKey, len(secretKeys))
+	receiverIndices := make([]int, len(secretKeys))
+	found := trialKeys(concurrentSecretKeys(sos.keyring), len(secretKeys), func(k int) bool {
+		derivedKey := derivedKeyForTrial(sos.keyring, secretKeys[k], ephemeralPub)
+		for receiverIndex, receiver := range hdr.Receivers {
 			//nolint:gosec // receiverIndex is a valid slice index, conversion is safe
 			identifier := keyIdentifierFromDerivedKey(derivedKey, uint64(receiverIndex))
 			if hmac.Equal(identifier, receiver.ReceiverKID) {
-				// This is the right key! Open the sender secretbox and return the sender key.
-				//nolint:gosec // receiverIndex is a valid slice index, conversion is safe
-				nonce := nonceForPayloadKeyBoxV2(uint64(receiverIndex))
-				payloadKey, isValid := secretbox.Open(
-					nil,
-					receiver.PayloadKeyBox,
-					(*[24]byte)(&nonce),
-					(*[32]byte)(derivedKey),
-				)
-				if !isValid {
-					return nil, ErrDecryptionFailed
-				}
-				return symmetricKeyFromSlice(payloadKey)
+				derivedKeys[k] = derivedKey
+				receiverIndices[k] = receiverIndex
+				return true
 			}
 		}
+		wipeTrialKeys(sos.keyring, derivedKey)
+		return false
+	})
+	if found < 0 {
+		// None of the box keys worked. We'll fall back to the secretbox keys.
+		return nil, nil
 	}
 
-	// None of the box keys worked. We'll fall back to the secretbox keys.
-	return nil, nil
+	// This is the right key! Open the payload key secretbox.
+	receiverIndex := receiverIndices[found]
+	//nolint:gosec // receiverIndex is a valid slice index, conversion is safe
+	nonce := nonceForPayloadKeyBoxV2(uint64(receiverIndex))
+	defer wipeTrialKeys(sos.keyring, derivedKeys[found])
+	if hdr.Version.Major == Version3().Major {
+		payloadKey, err := openHybridPayloadKey(secretKeys[found], ephemeralPub, derivedKeys[found], nonce, hdr.Receivers[receiverIndex])
+		if err != nil {
+			return nil, err
+		}
+		return symmetricKeyFromSecretSlice(payloadKey)
+	}
+	payloadKey, isValid := secretbox.Open(
+		nil,
+		hdr.Receivers[receiverIndex].PayloadKeyBox,
+		(*[24]byte)(&nonce),
+		(*[32]byte)(derivedKeys[found]),
+	)
+	if !isValid {
+		return nil, ErrDecryptionFailed
+	}
+	return symmetricKeyFromSecretSlice(payloadKey)
 }
 
 func (sos *signcryptOpenStream) trySharedSymmetricKeys(hdr *SigncryptionHeader, ephemeralPub BoxPublicKey) (*SymmetricKey, error) {
@@ -127,6 +150,10 @@ func (sos *signcryptOpenStream) trySharedSymmetricKeys(hdr *SigncryptionHeader,
 		return nil, ErrWrongNumberOfKeys
 	}
 
+	// A resolver might return keys for several receivers, like a
+	// passphrase that the user typed, which we try against every
+	// passphrase receiver. Only fail if none of them work.
+	failed := false
 	for index, resolved := range resolvedKeys {
 		if resolved == nil {
 			// This key didn't resolve.
@@ -134,19 +161,7 @@ func (sos *signcryptOpenStream) trySharedSymmetricKeys(hdr *SigncryptionHeader,
 		}
 
 		// We got a key. It should decrypt the corresponding receiver secretbox.
-		derivedKeyDigest := hmac.New(sha512.New, []byte(signcryptionSymmetricKeyContext))
-		_, err = derivedKeyDigest.Write(ephemeralPub.ToKID())
-		if err != nil {
-			return nil, err
-		}
-		_, err = derivedKeyDigest.Write(resolved[:])
-		if err != nil {
-			return nil, err
-		}
-		derivedKey, err := rawBoxKeyFromSlice(derivedKeyDigest.Sum(nil)[0:32])
-		if err != nil {
-			panic(err) // should be statically impossible, if the slice above is the right length
-		}
+		derivedKey := derivedKeyFromSymmetricKey(ephemeralPub, resolved)
 
 		//nolint:gosec // index is a valid slice index, conversion is safe
 		nonce := nonceForPayloadKeyBoxV2(uint64(index))
@@ -156,18 +171,23 @@ func (sos *signcryptOpenStream) trySharedSymmetricKeys(hdr *SigncryptionHeader,
 			(*[24]byte)(&nonce),
 			(*[32]byte)(derivedKey),
 		)
+		derivedKey.wipe()
 		if !isValid {
-			return nil, ErrDecryptionFailed
+			failed = true
+			continue
 		}
-		return symmetricKeyFromSlice(payloadKey)
+		return symmetricKeyFromSecretSlice(payloadKey)
 	}
 
+	if failed {
+		return nil, ErrDecryptionFailed
+	}
 	// If we get out of the loop, all the resolved keys were nil (failed to resolve).
 	return nil, nil
 }
 
 func (sos *signcryptOpenStream) processHeader(hdr *SigncryptionHeader) error {
-	if err := hdr.validate(); err != nil {
+	if err := hdr.validate(sos.versionValidator); err != nil {
 		return err
 	}
 
@@ -200,9 +220,9 @@ func (sos *signcryptOpenStream) processHeader(hdr *SigncryptionHeader) error {
 		sos.senderAnonymous = true
 	} else {
 		// regular mode, with a real signing public key
-		spk := sos.keyring.LookupSigningPublicKey(senderKeySlice)
-		if spk == nil {
-			return ErrNoSenderKey{Sender: senderKeySlice}
+		spk, err := lookupSenderSigningKey(sos.keyring, senderKeySlice)
+		if err != nil {
+			return err
 		}
 		sos.signingPublicKey = spk
 	}
@@ -252,14 +272,24 @@ func (sos *signcryptOpenStream) processBlock(payloadCiphertext []byte, isFinal b
 // Note that the caller has an opportunity not to ingest the plaintext if he
 // doesn't trust the sender revealed in the MessageKeyInfo.
 func NewSigncryptOpenStream(r io.Reader, keyring SigncryptKeyring, resolver SymmetricKeyResolver) (senderPub SigningPublicKey, plaintext io.Reader, err error) {
+	return NewSigncryptOpenStreamWithVersionValidator(CheckKnownMajorVersion, r, keyring, resolver)
+}
+
+// NewSigncryptOpenStreamWithVersionValidator is NewSigncryptOpenStream,
+// but only opens messages whose versions pass versionValidator, which
+// NewSigncryptOpenStream sets to CheckKnownMajorVersion. To open V3
+// messages, pass CheckKnownOrExperimentalMajorVersion.
+func NewSigncryptOpenStreamWithVersionValidator(versionValidator VersionValidator, r io.Reader, keyring SigncryptKeyring, resolver SymmetricKeyResolver) (senderPub SigningPublicKey, plaintext io.Reader, err error) {
 	sos := &signcryptOpenStream{
-		mps:      newMsgpackStream(r),
-		keyring:  keyring,
-		resolver: resolver,
+		versionValidator: versionValidator,
+		mps:              newMsgpackStream(r),
+		keyring:          keyring,
+		resolver:         resolver,
 	}
 
 	err = sos.readHeader()
 	if err != nil {
+		sos.wipe()
 		return nil, nil, err
 	}
 
@@ -275,8 +305,15 @@ type SymmetricKeyResolver interface {
 // It returns a plaintext on success, and an error on failure. It returns the header's
 // MessageKeyInfo in either case.
 func SigncryptOpen(ciphertext []byte, keyring SigncryptKeyring, resolver SymmetricKeyResolver) (senderPub SigningPublicKey, plaintext []byte, err error) {
+	return SigncryptOpenWithVersionValidator(CheckKnownMajorVersion, ciphertext, keyring, resolver)
+}
+
+// SigncryptOpenWithVersionValidator is SigncryptOpen, but only opens
+// messages whose versions pass versionValidator. See
+// NewSigncryptOpenStreamWithVersionValidator.
+func SigncryptOpenWithVersionValidator(versionValidator VersionValidator, ciphertext []byte, keyring SigncryptKeyring, resolver SymmetricKeyResolver) (senderPub SigningPublicKey, plaintext []byte, err error) {
 	buf := bytes.NewBuffer(ciphertext)
-	senderPub, plaintextStream, err := NewSigncryptOpenStream(buf, keyring, resolver)
+	senderPub, plaintextStream, err := NewSigncryptOpenStreamWithVersionValidator(versionValidator, buf, keyring, resolver)
 	if err != nil {
 		return senderPub, nil, err
 	}
@@ -34,17 +34,26 @@ func (sss *signcryptSealStream) Write(plaintext []byte) (int, error) {
 		return 0, sss.err
 	}
 
-	var ret int
-	if ret, sss.err = sss.buffer.Write(plaintext); sss.err != nil {
-		return 0, sss.err
-	}
+	writeSecret(&sss.buffer, plaintext)
 	for sss.buffer.Len() > encryptionBlockSize {
-		sss.err = sss.signcryptBlock(false)
-		if sss.err != nil {
-			return 0, sss.err
+		if err := sss.signcryptBlock(false); err != nil {
+			return 0, sss.fail(err)
 		}
 	}
-	return ret, nil
+	return len(plaintext), nil
+}
+
+// fail makes err the stream's terminal error, and wipes its secrets,
+// since it won't be used again.
+func (sss *signcryptSealStream) fail(err error) error {
+	sss.err = err
+	sss.wipe()
+	return err
+}
+
+func (sss *signcryptSealStream) wipe() {
+	sss.encryptionKey.wipe()
+	wipeBuffer(&sss.buffer)
 }
 
 func (sss *signcryptSealStream) signcryptBlock(isFinal bool) error {
@@ -80,6 +89,7 @@ func (sss *signcryptSealStream) signcryptBlock(isFinal bool) error {
 	attachedSig = append(attachedSig, plaintext...)
 
 	ciphertext := secretbox.Seal([]byte{}, attachedSig, (*[24]byte)(&nonce), (*[32]byte)(&sss.encryptionKey))
+	clear(attachedSig)
 
 	assertEncodedChunkState(sss.version, ciphertext, secretbox.Overhead, uint64(sss.numBlocks), isFinal)
 
@@ -104,6 +114,7 @@ func (sss *signcryptSealStream) signcryptBlock(isFinal bool) error {
 // the same for two different recipients if they claim the same public key.
 func derivedEphemeralKeyFromBoxKeys(public BoxPublicKey, private BoxSecretKey) *SymmetricKey {
 	sharedSecretBox := private.Box(public, nonceForDerivedSharedKey(), make([]byte, 32))
+	defer clear(sharedSecretBox)
 	derivedKey, err := symmetricKeyFromSlice(sharedSecretBox[len(sharedSecretBox)-32:])
 	if err != nil {
 		panic(err) // should be statically impossible, if the slice above is the right length
@@ -111,6 +122,22 @@ func derivedEphemeralKeyFromBoxKeys(public BoxPublicKey, private BoxSecretKey) *
 	return derivedKey
 }
 
+// Derive a message-specific shared secret by hashing the symmetric key and
+// the ephemeral public key together. This lets us use nonces that are
+// simple counters.
+func derivedKeyFromSymmetricKey(ephemeralPub BoxPublicKey, key *SymmetricKey) *SymmetricKey {
+	derivedKeyDigest := hmac.New(sha512.New, []byte(signcryptionSymmetricKeyContext))
+	_, _ = derivedKeyDigest.Write(ephemeralPub.ToKID())
+	_, _ = derivedKeyDigest.Write(key[:])
+	sum := derivedKeyDigest.Sum(nil)
+	defer clear(sum)
+	derivedKey, err := symmetricKeyFromSlice(sum[0:32])
+	if err != nil {
+		panic(err) // should be statically impossible, if the slice above is the right length
+	}
+	return derivedKey
+}
+
 // Compute the visible identifier that the recipient will use to find the right
 // recipient entry. Include the entry index, so that this identifier is unique
 // even if two recipients claim the same public key (though unfortunately that
@@ -130,18 +157,35 @@ func keyIdentifierFromDerivedKey(derivedKey *SymmetricKey, recipientIndex uint64
 // A receiverKeysMaker is either a (wrapped) BoxPublicKey or a
 // ReceiverSymmetricKey.
 type receiverKeysMaker interface {
-	makeReceiverKeys(ephemeralPriv BoxSecretKey, payloadKey SymmetricKey, index uint64) receiverKeys
+	makeReceiverKeys(version Version, ephemeralPriv BoxSecretKey, payloadKey SymmetricKey, index uint64) (receiverKeys, error)
 }
 
 type receiverBoxKey struct {
 	pk BoxPublicKey
 }
 
-func (r receiverBoxKey) makeReceiverKeys(ephemeralPriv BoxSecretKey, payloadKey SymmetricKey, index uint64) receiverKeys {
+// In V3, the payload key box is sealed under a hybrid of derivedKey and an
+// ML-KEM shared secret, rather than under derivedKey alone. The identifier
+// is still made from derivedKey, since it only has to be unique.
+func (r receiverBoxKey) makeReceiverKeys(version Version, ephemeralPriv BoxSecretKey, payloadKey SymmetricKey, index uint64) (receiverKeys, error) {
+	defer clear(payloadKey[:])
 	derivedKey := derivedEphemeralKeyFromBoxKeys(r.pk, ephemeralPriv)
+	defer derivedKey.wipe()
 	identifier := keyIdentifierFromDerivedKey(derivedKey, index)
 
 	nonce := nonceForPayloadKeyBoxV2(index)
+	if version.Major == Version3().Major {
+		payloadKeyBox, mlkemCiphertext, err := sealHybridPayloadKey(ephemeralPriv, r.pk, derivedKey, nonce, &payloadKey)
+		if err != nil {
+			return receiverKeys{}, err
+		}
+		return receiverKeys{
+			ReceiverKID:     identifier,
+			PayloadKeyBox:   payloadKeyBox,
+			MLKEMCiphertext: mlkemCiphertext,
+		}, nil
+	}
+
 	payloadKeyBox := secretbox.Seal(
 		nil,
 		payloadKey[:],
@@ -152,7 +196,7 @@ func (r receiverBoxKey) makeReceiverKeys(ephemeralPriv BoxSecretKey, payloadKey
 	return receiverKeys{
 		ReceiverKID:   identifier,
 		PayloadKeyBox: payloadKeyBox,
-	}
+	}, nil
 }
 
 // ReceiverSymmetricKey is a symmetric key paired with an identifier.
@@ -163,17 +207,12 @@ type ReceiverSymmetricKey struct {
 	Identifier []byte
 }
 
-func (r ReceiverSymmetricKey) makeReceiverKeys(ephemeralPriv BoxSecretKey, payloadKey SymmetricKey, index uint64) receiverKeys {
-	// Derive a message-specific shared secret by hashing the symmetric key and
-	// the ephemeral public key together. This lets us use nonces that are
-	// simple counters.
-	derivedKeyDigest := hmac.New(sha512.New, []byte(signcryptionSymmetricKeyContext))
-	_, _ = derivedKeyDigest.Write(ephemeralPriv.GetPublicKey().ToKID())
-	_, _ = derivedKeyDigest.Write(r.Key[:])
-	derivedKey, err := rawBoxKeyFromSlice(derivedKeyDigest.Sum(nil)[0:32])
-	if err != nil {
-		panic(err) // should be statically impossible, if the slice above is the right length
-	}
+// Symmetric keys aren't threatened by quantum computers, so they're the
+// same in V3, where they stand out for not having ML-KEM ciphertexts.
+func (r ReceiverSymmetricKey) makeReceiverKeys(_ Version, ephemeralPriv BoxSecretKey, payloadKey SymmetricKey, index uint64) (receiverKeys, error) {
+	defer clear(payloadKey[:])
+	derivedKey := derivedKeyFromSymmetricKey(ephemeralPriv.GetPublicKey(), &r.Key)
+	defer derivedKey.wipe()
 
 	nonce := nonceForPayloadKeyBoxV2(index)
 	payloadKeyBox := secretbox.Seal(
@@ -188,7 +227,7 @@ func (r ReceiverSymmetricKey) makeReceiverKeys(ephemeralPriv BoxSecretKey, paylo
 	return receiverKeys{
 		ReceiverKID:   r.Identifier,
 		PayloadKeyBox: payloadKeyBox,
-	}
+	}, nil
 }
 
 func checkSigncryptReceiverCount(receiverBoxKeyCount, receiverSymmetricKeyCount int) error {
@@ -299,6 +338,7 @@ func (sss *signcryptSealStream) init(
 	if err != nil {
 		return err
 	}
+	defer wipeEphemeralKey(ephemeralKey)
 
 	eh := SigncryptionHeader{
 		FormatName: FormatName,
@@ -311,6 +351,7 @@ func (sss *signcryptSealStream) init(
 		return err
 	}
 	sss.encryptionKey = *encryptionKey
+	encryptionKey.wipe()
 
 	// Prepare the secretbox that contains the sender's public key. If the
 	// sender is anonymous, use an all-zeros key, so that the anonymity bit
@@ -332,11 +373,15 @@ func (sss *signcryptSealStream) init(
 	// all of them.
 	for i, r := range receivers {
 		//nolint:gosec // i is a valid slice index, conversion is safe
-		eh.Receivers = append(eh.Receivers, r.makeReceiverKeys(ephemeralKey, sss.encryptionKey, uint64(i)))
+		keys, err := r.makeReceiverKeys(sss.version, ephemeralKey, sss.encryptionKey, uint64(i))
+		if err != nil {
+			return err
+		}
+		eh.Receivers = append(eh.Receivers, keys)
 	}
 
 	// Encode the header to bytes, hash it, then double encode it.
-	headerBytes, err := encodeToBytes(eh)
+	headerBytes, err := encodeHeader((*EncryptionHeader)(&eh))
 	if err != nil {
 		return err
 	}
@@ -350,27 +395,44 @@ func (sss *signcryptSealStream) init(
 }
 
 func (sss *signcryptSealStream) Close() error {
+	if sss.err != nil {
+		return sss.err
+	}
 	err := sss.signcryptBlock(true)
 	if err != nil {
-		return err
+		return sss.fail(err)
 	}
 
 	if sss.buffer.Len() > 0 {
 		panic(fmt.Sprintf("sss.buffer.Len()=%d > 0", sss.buffer.Len()))
 	}
 
+	_ = sss.fail(ErrStreamClosed)
 	return nil
 }
 
-func newSigncryptSealStream(ciphertext io.Writer, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey, ephemeralKeyCreator EphemeralKeyCreator, rng signcryptRNG) (io.WriteCloser, error) {
+// checkSigncryptVersion checks that signcrypted messages can be written in
+// version. There's no V1 of signcryption.
+func checkSigncryptVersion(version Version) error {
+	if version != Version2() && version != Version3() {
+		return ErrBadVersion{version}
+	}
+	return nil
+}
+
+func newSigncryptSealStream(version Version, ciphertext io.Writer, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey, ephemeralKeyCreator EphemeralKeyCreator, rng signcryptRNG) (io.WriteCloser, error) {
+	if err := checkSigncryptVersion(version); err != nil {
+		return nil, err
+	}
 	sss := &signcryptSealStream{
-		version:    Version2(),
+		version:    version,
 		output:     ciphertext,
 		encoder:    newEncoder(ciphertext),
 		signingKey: sender,
 	}
 	err := sss.init(receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, rng)
 	if err != nil {
+		sss.wipe()
 		return nil, err
 	}
 	return sss, nil
@@ -398,12 +460,19 @@ func (defaultSigncryptRNG) shuffleReceivers(receiverBoxKeys []BoxPublicKey, rece
 // plaintext data to be encrypted and a nil error. Otherwise, returns
 // nil and the initialization error.
 func NewSigncryptSealStream(ciphertext io.Writer, ephemeralKeyCreator EphemeralKeyCreator, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey) (io.WriteCloser, error) {
-	return newSigncryptSealStream(ciphertext, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, defaultSigncryptRNG{})
+	return newSigncryptSealStream(Version2(), ciphertext, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, defaultSigncryptRNG{})
 }
 
-func signcryptSeal(plaintext []byte, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey, ephemeralKeyCreator EphemeralKeyCreator, rng signcryptRNG) (out []byte, err error) {
+// NewSigncryptSealStreamWithVersion is NewSigncryptSealStream, but writes
+// the given version, which is Version2 for NewSigncryptSealStream. For
+// Version3, the receiver box keys must be HybridBoxPublicKeys.
+func NewSigncryptSealStreamWithVersion(version Version, ciphertext io.Writer, ephemeralKeyCreator EphemeralKeyCreator, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey) (io.WriteCloser, error) {
+	return newSigncryptSealStream(version, ciphertext, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, defaultSigncryptRNG{})
+}
+
+func signcryptSeal(version Version, plaintext []byte, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey, ephemeralKeyCreator EphemeralKeyCreator, rng signcryptRNG) (out []byte, err error) {
 	var buf bytes.Buffer
-	sss, err := newSigncryptSealStream(&buf, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, rng)
+	sss, err := newSigncryptSealStream(version, &buf, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, rng)
 	if err != nil {
 		return nil, err
 	}
@@ -423,5 +492,11 @@ func signcryptSeal(plaintext []byte, sender SigningSecretKey, receiverBoxKeys []
 // ephemeralKeyCreator should be the last argument; it's the 2nd one
 // to preserve the public API.
 func SigncryptSeal(plaintext []byte, ephemeralKeyCreator EphemeralKeyCreator, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey) (out []byte, err error) {
-	return signcryptSeal(plaintext, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, defaultSigncryptRNG{})
+	return signcryptSeal(Version2(), plaintext, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, defaultSigncryptRNG{})
+}
+
+// SigncryptSealWithVersion is SigncryptSeal, but writes the given version.
+// See NewSigncryptSealStreamWithVersion.
+func SigncryptSealWithVersion(version Version, plaintext []byte, ephemeralKeyCreator EphemeralKeyCreator, sender SigningSecretKey, receiverBoxKeys []BoxPublicKey, receiverSymmetricKeys []ReceiverSymmetricKey) (out []byte, err error) {
+	return signcryptSeal(version, plaintext, sender, receiverBoxKeys, receiverSymmetricKeys, ephemeralKeyCreator, defaultSigncryptRNG{})
 }
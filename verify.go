@@ -18,9 +18,9 @@ func NewVerifyStream(versionValidator VersionValidator, r io.Reader, keyring Sig
 	if err != nil {
 		return nil, nil, err
 	}
-	skey = keyring.LookupSigningPublicKey(s.header.SenderPublic)
-	if skey == nil {
-		return nil, nil, ErrNoSenderKey{Sender: s.header.SenderPublic}
+	skey, err = lookupSenderSigningKey(keyring, s.header.SenderPublic)
+	if err != nil {
+		return nil, nil, err
 	}
 	s.publicKey = skey
 	return skey, newChunkReader(s), nil
@@ -61,9 +61,9 @@ func VerifyDetachedReader(versionValidator VersionValidator, message io.Reader,
 	}
 
 	// Get the public key.
-	skey = keyring.LookupSigningPublicKey(s.header.SenderPublic)
-	if skey == nil {
-		return nil, ErrNoSenderKey{Sender: s.header.SenderPublic}
+	skey, err = lookupSenderSigningKey(keyring, s.header.SenderPublic)
+	if err != nil {
+		return nil, err
 	}
 
 	// Compute the signed text hash, without requiring us to copy the whole
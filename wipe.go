@@ -0,0 +1,66 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"bytes"
+)
+
+// Wiper is implemented by secret keys that can overwrite themselves with
+// zeros. Once a stream is done with an ephemeral key it created, it wipes
+// the key, if the key implements Wiper, so ephemeral key creators must
+// return a fresh key each time.
+type Wiper interface {
+	Wipe()
+}
+
+func wipeEphemeralKey(k BoxSecretKey) {
+	if w, ok := k.(Wiper); ok {
+		w.Wipe()
+	}
+}
+
+func (k *SymmetricKey) wipe() {
+	if k != nil {
+		clear(k[:])
+	}
+}
+
+// symmetricKeyFromSecretSlice is symmetricKeyFromSlice, but wipes the
+// slice afterwards.
+func symmetricKeyFromSecretSlice(slice []byte) (*SymmetricKey, error) {
+	defer clear(slice)
+	return symmetricKeyFromSlice(slice)
+}
+
+// wipeBuffer overwrites all of buf's storage with zeros, including the
+// bytes that have already been read, and empties it.
+func wipeBuffer(buf *bytes.Buffer) {
+	buf.Reset()
+	b := buf.Bytes()
+	clear(b[:cap(b)])
+}
+
+// writeSecret writes p to buf, like buf.Write, but makes sure that if buf
+// has to move to bigger storage, the old storage is wiped rather than left
+// for the garbage collector.
+func writeSecret(buf *bytes.Buffer, p []byte) {
+	if buf.Available() < len(p) {
+		unread := buf.Bytes()
+		buf.Reset()
+		old := buf.Bytes()
+		old = old[:cap(old)]
+		if cap(old) >= len(unread)+len(p) {
+			// There's room if the unread bytes are moved to the
+			// front, which doesn't need new storage.
+			_, _ = buf.Write(unread)
+		} else {
+			*buf = bytes.Buffer{}
+			buf.Grow(2 * (len(unread) + len(p)))
+			_, _ = buf.Write(unread)
+			clear(old)
+		}
+	}
+	_, _ = buf.Write(p)
+}
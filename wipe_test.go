@@ -0,0 +1,111 @@
+// Copyright 2015 Keybase, Inc. All rights reserved. Use of
+// this source code is governed by the included BSD license.
+
+package saltpack
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func requireZero(t *testing.T, b []byte) {
+	require.Equal(t, make([]byte, len(b)), b)
+}
+
+func TestWriteSecret(t *testing.T) {
+	var buf bytes.Buffer
+	writeSecret(&buf, []byte("secret"))
+	old := buf.Bytes()[:cap(buf.Bytes())]
+	big := bytes.Repeat([]byte{'x'}, 2*cap(old))
+	writeSecret(&buf, big)
+	require.Equal(t, append([]byte("secret"), big...), buf.Bytes())
+	requireZero(t, old)
+
+	// Once some has been read, there may be room to slide the rest back
+	// instead.
+	buf.Next(len(big))
+	storage := buf.Bytes()[:cap(buf.Bytes())]
+	writeSecret(&buf, big)
+	require.Equal(t, append(big[len(big)-6:], big...), buf.Bytes())
+	require.Equal(t, &storage[0], &buf.Bytes()[0])
+
+	wipeBuffer(&buf)
+	require.Zero(t, buf.Len())
+	requireZero(t, storage)
+}
+
+func TestEncryptStreamWipe(t *testing.T) {
+	msg := []byte("the secret plaintext")
+	sender := newBoxKey(t)
+	receivers := []BoxPublicKey{newBoxKey(t).GetPublicKey()}
+
+	for _, version := range []Version{Version1(), Version2()} {
+		var ciphertext bytes.Buffer
+		strm, err := NewEncryptStream(version, &ciphertext, sender, receivers)
+		require.NoError(t, err)
+		es := strm.(*encryptStream)
+		_, err = strm.Write(msg)
+		require.NoError(t, err)
+		buffered := es.buffer.Bytes()
+		require.NoError(t, strm.Close())
+
+		requireZero(t, es.payloadKey[:])
+		for _, macKey := range es.macKeys {
+			requireZero(t, macKey[:])
+		}
+		requireZero(t, buffered[:cap(buffered)])
+
+		_, err = strm.Write(msg)
+		require.Equal(t, ErrStreamClosed, err)
+		require.Equal(t, ErrStreamClosed, strm.Close())
+
+		// The decrypt stream wipes its keys and the plaintext it's
+		// returned once it's read to the end.
+		_, plaintext, err := NewDecryptStream(SingleVersionValidator(version), &ciphertext, kr)
+		require.NoError(t, err)
+		cr := plaintext.(*chunkReader)
+		var p [4]byte
+		_, err = io.ReadFull(cr, p[:])
+		require.NoError(t, err)
+		chunk := cr.chunk
+		require.Equal(t, msg, chunk)
+		rest, err := io.ReadAll(cr)
+		require.NoError(t, err)
+		require.Equal(t, msg, append(p[:], rest...))
+		requireZero(t, chunk)
+		ds := cr.chunker.(*decryptStream)
+		requireZero(t, ds.payloadKey[:])
+	}
+}
+
+func TestSigncryptStreamWipe(t *testing.T) {
+	msg := []byte("the secret plaintext")
+	keyring, receiverBoxKeys := makeKeyringWithOneKey(t)
+	sender := makeSigningKey(t, keyring)
+
+	var ciphertext bytes.Buffer
+	strm, err := NewSigncryptSealStream(&ciphertext, ephemeralKeyCreator{}, sender, receiverBoxKeys, nil)
+	require.NoError(t, err)
+	sss := strm.(*signcryptSealStream)
+	_, err = strm.Write(msg)
+	require.NoError(t, err)
+	buffered := sss.buffer.Bytes()
+	require.NoError(t, strm.Close())
+
+	requireZero(t, sss.encryptionKey[:])
+	requireZero(t, buffered[:cap(buffered)])
+	_, err = strm.Write(msg)
+	require.Equal(t, ErrStreamClosed, err)
+
+	_, plaintext, err := NewSigncryptOpenStream(&ciphertext, keyring, nil)
+	require.NoError(t, err)
+	cr := plaintext.(*chunkReader)
+	opened, err := io.ReadAll(cr)
+	require.NoError(t, err)
+	require.Equal(t, msg, opened)
+	sos := cr.chunker.(*signcryptOpenStream)
+	requireZero(t, sos.payloadKey[:])
+}